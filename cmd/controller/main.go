@@ -36,6 +36,7 @@ import (
 	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
 	informers "github.com/knative/eventing/pkg/client/informers/externalversions"
 	"github.com/knative/eventing/pkg/controller"
+	"github.com/knative/eventing/pkg/controller/bridge"
 	"github.com/knative/eventing/pkg/controller/bus"
 	"github.com/knative/eventing/pkg/controller/channel"
 	"github.com/knative/eventing/pkg/controller/clusterbus"
@@ -99,6 +100,7 @@ func main() {
 		bus.NewController,
 		clusterbus.NewController,
 		channel.NewController,
+		bridge.NewController,
 	}
 
 	// Build all of our controllers, with the clients constructed above.
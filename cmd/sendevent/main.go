@@ -18,6 +18,8 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	ctx "context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -35,13 +37,20 @@ var (
 	context event.EventContext
 	webhook string
 	data    string
+	binary  bool
+	timeout time.Duration
+	retries int
 )
 
 func init() {
 	flag.StringVar(&context.EventID, "event-id", "", "Event ID to use. Defaults to a generated UUID")
 	flag.StringVar(&context.EventType, "event-type", "google.events.action.demo", "The Event Type to use.")
 	flag.StringVar(&context.Source, "source", "", "Source URI to use. Defaults to the current machine's hostname")
+	flag.StringVar(&context.CloudEventsVersion, "spec-version", event.CloudEventsVersion, "The CloudEvents spec version to send, one of 0.1, 0.2 or 1.0")
 	flag.StringVar(&data, "data", `{"hello": "world!"}`, "Event data")
+	flag.BoolVar(&binary, "binary", false, "Send the event in binary instead of structured encoding")
+	flag.DurationVar(&timeout, "timeout", event.DefaultTimeout, "Timeout of each attempt to send the event")
+	flag.IntVar(&retries, "retries", 0, "Number of times to retry sending the event after a 5xx or 429 response")
 }
 
 func main() {
@@ -61,26 +70,40 @@ func main() {
 	}
 
 	fillEventContext(&context)
-	req, err := event.NewRequest(webhook, untyped, context)
-	if err != nil {
-		fmt.Printf("Failed to create request: %s", err)
-		os.Exit(1)
+
+	client := event.NewClient()
+	client.Encoding = event.Structured
+	if binary {
+		client.Encoding = event.Binary
 	}
+	client.Timeout = timeout
+	client.Retries = retries
+	client.Middleware = []event.ClientMiddleware{printResponse}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
+	if err := client.Send(ctx.Background(), webhook, context, untyped); err != nil {
 		fmt.Printf("Failed to send event to %s: %s\n", webhook, err)
 		os.Exit(1)
 	}
-	fmt.Printf("Got response from %s\n%s\n", webhook, res.Status)
-	if res.Header.Get("Content-Length") != "" {
-		bytes, _ := ioutil.ReadAll(res.Body)
-		fmt.Println(string(bytes))
+}
+
+// printResponse prints the response to each attempt to send the event.
+func printResponse(next event.SendFunc) event.SendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		res, err := next(req)
+		if err != nil {
+			return res, err
+		}
+		fmt.Printf("Got response from %s\n%s\n", req.URL, res.Status)
+		if res.Header.Get("Content-Length") != "" {
+			b, _ := ioutil.ReadAll(res.Body)
+			fmt.Println(string(b))
+			res.Body = ioutil.NopCloser(bytes.NewReader(b))
+		}
+		return res, nil
 	}
 }
 
 func fillEventContext(ctx *event.EventContext) {
-	ctx.CloudEventsVersion = "0.1"
 	ctx.EventTime = time.Now().UTC()
 
 	if ctx.EventID == "" {
@@ -19,6 +19,7 @@ import (
 	"flag"
 
 	"github.com/golang/glog"
+	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
 	"github.com/knative/eventing/pkg/system"
 	"github.com/knative/eventing/pkg/signals"
 	"github.com/knative/eventing/pkg/webhook"
@@ -41,11 +42,16 @@ func main() {
 		glog.Fatal("Failed to get in cluster config", err)
 	}
 
-	clientset, err := kubernetes.NewForConfig(clusterConfig)
+	kubeClient, err := kubernetes.NewForConfig(clusterConfig)
 	if err != nil {
 		glog.Fatal("Failed to get the client set", err)
 	}
 
+	channelsClient, err := clientset.NewForConfig(clusterConfig)
+	if err != nil {
+		glog.Fatal("Failed to get the channels client set", err)
+	}
+
 	options := webhook.ControllerOptions{
 		ServiceName:      "eventing-webhook",
 		ServiceNamespace: system.Namespace,
@@ -53,7 +59,7 @@ func main() {
 		SecretName:       "eventing-webhook-certs",
 		WebhookName:      "webhook.eventing.knative.dev",
 	}
-	controller, err := webhook.NewAdmissionController(clientset, options)
+	controller, err := webhook.NewAdmissionController(kubeClient, channelsClient, options)
 	if err != nil {
 		glog.Fatal("Failed to create the admission controller", err)
 	}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"encoding/json"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:defaulter-gen=true
+
+// Bridge republishes the events of a Channel to a Channel on another bus or in
+// another cluster and corresponds to the bridges.channels.knative.dev CRD.
+type Bridge struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata"`
+	Spec               BridgeSpec   `json:"spec"`
+	Status             BridgeStatus `json:"status,omitempty"`
+}
+
+// BridgeSpec specifies the source Channel and the target of the Bridge.
+type BridgeSpec struct {
+	// Channel is the name of the channel in the Bridge's namespace whose
+	// events are republished.
+	Channel string `json:"channel"`
+
+	// Target is where the events of the Channel are republished to.
+	Target BridgeTarget `json:"target"`
+
+	// MaxHops is the number of bridges an event may cross (optional). Events
+	// that already crossed MaxHops bridges are dropped, which stops events
+	// looping between two channels that are bridged both ways. Defaults to 1.
+	MaxHops *int32 `json:"maxHops,omitempty"`
+}
+
+// BridgeTarget is the Channel events are republished to. Exactly one of
+// Channel and URL must be set.
+type BridgeTarget struct {
+	// Channel is a reference to a Channel in this cluster. The referenced
+	// Channel may be backed by a different Bus than the source Channel. If the
+	// namespace is omitted, the Bridge's namespace is used.
+	Channel *v1.ObjectReference `json:"channel,omitempty"`
+
+	// URL is the HTTPS address of a Channel's ingress in a remote cluster.
+	URL string `json:"url,omitempty"`
+
+	// CredentialsSecret is a reference to a Secret in the Bridge's namespace
+	// whose "token" key is presented to the remote ingress as a bearer token
+	// (optional).
+	CredentialsSecret *v1.LocalObjectReference `json:"credentialsSecret,omitempty"`
+}
+
+type BridgeConditionType string
+
+const (
+	// Ready is set when all other conditions are met and the bridge is
+	// republishing events.
+	BridgeReady BridgeConditionType = "Ready"
+
+	// Subscribed means the subscription of the bridge to its channel exists.
+	BridgeSubscribed BridgeConditionType = "Subscribed"
+
+	// Dispatching means the deployment republishing events exists.
+	BridgeDispatching BridgeConditionType = "Dispatching"
+)
+
+// BridgeCondition describes the state of a bridge at a point in time.
+type BridgeCondition struct {
+	// Type of bridge condition.
+	Type BridgeConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status v1.ConditionStatus `json:"status"`
+	// The last time this condition was updated.
+	LastUpdateTime meta_v1.Time `json:"lastUpdateTime,omitempty"`
+	// Last time the condition transitioned from one status to another.
+	LastTransitionTime meta_v1.Time `json:"lastTransitionTime,omitempty"`
+	// The reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+	// A human readable message indicating details about the transition.
+	Message string `json:"message,omitempty"`
+}
+
+// BridgeStatus (computed) for a bridge
+type BridgeStatus struct {
+
+	// Represents the latest available observations of a bridge's current state.
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []BridgeCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+func (bs *BridgeStatus) GetCondition(t BridgeConditionType) *BridgeCondition {
+	for _, cond := range bs.Conditions {
+		if cond.Type == t {
+			return &cond
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) GetSpecJSON() ([]byte, error) {
+	return json.Marshal(b.Spec)
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BridgeList returned in list operations
+type BridgeList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata"`
+	Items            []Bridge `json:"items"`
+}
@@ -55,12 +55,31 @@ type BusSpec struct {
 	// could be creating a messaging topic that backs a channel.
 	Provisioner *kapi.Container `json:"provisioner,omitempty"`
 
+	// ProvisionerReplicas is the number of provisioner replicas to run,
+	// defaults to 1. Replicas elect a leader which does the provisioning, the
+	// others stand by to take over.
+	ProvisionerReplicas *int32 `json:"provisionerReplicas,omitempty"`
+
 	// Dispatcher defines how the dispatcher container for this bus should be
 	// run. Dispatchers are responsible for performing two types of event
 	// dispatch: dispatching incoming events to the Bus' Channels and
 	// dispatching events in the Channel to the Channel's Subscriptions.
 	Dispatcher kapi.Container `json:"dispatcher"`
 
+	// DispatcherReplicas is the number of dispatcher replicas to run,
+	// defaults to 1. It is ignored when DispatcherAutoscaling is set. Buses
+	// whose replicas can each deliver any Subscription, like Pub/Sub, assign
+	// each Subscription to one replica by consistent hashing and reassign
+	// them as replicas come and go. Kafka replicas all subscribe, each
+	// Subscription's consumer group shares the topic's partitions between
+	// them. Stub replicas all subscribe too, each only delivers the events
+	// it receives.
+	DispatcherReplicas *int32 `json:"dispatcherReplicas,omitempty"`
+
+	// DispatcherAutoscaling scales the dispatcher replicas with a
+	// HorizontalPodAutoscaler.
+	DispatcherAutoscaling *BusAutoscaling `json:"dispatcherAutoscaling,omitempty"`
+
 	// Volumes to be mounted inside the provisioner or dispatcher containers
 	Volumes *[]kapi.Volume `json:"volumes,omitempty"`
 }
@@ -75,6 +94,21 @@ type BusParameters struct {
 	Subscription *[]Parameter `json:"subscription,omitempty"`
 }
 
+// BusAutoscaling is the policy for scaling a bus component's replicas with a
+// HorizontalPodAutoscaler.
+type BusAutoscaling struct {
+	// MinReplicas is the lower limit of replicas, defaults to 1.
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit of replicas, it may not be less than
+	// MinReplicas.
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage is the average CPU utilization, as a
+	// percentage of the requested CPU, to scale for. Defaults to 80.
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+}
+
 type BusConditionType string
 
 const (
@@ -126,6 +160,10 @@ func (b *Bus) GetSpec() *BusSpec {
 	return &b.Spec
 }
 
+func (b *Bus) GetStatus() *BusStatus {
+	return &b.Status
+}
+
 func (b *Bus) GetSpecJSON() ([]byte, error) {
 	return json.Marshal(b.Spec)
 }
@@ -145,4 +183,5 @@ type GenericBus interface {
 	meta_v1.ObjectMetaAccessor
 	BacksChannel(channel *Channel) bool
 	GetSpec() *BusSpec
+	GetStatus() *BusStatus
 }
@@ -56,8 +56,55 @@ type ChannelSpec struct {
 	// Parameter without a default value that does not have an Argument will
 	// result in an error setting up the Channel.
 	Arguments *[]Argument `json:"arguments,omitempty"`
+
+	// IngressPolicy restricts which publishers may send events to the Channel
+	// (optional). When omitted, any client that can reach the Channel's
+	// hostname may publish to it.
+	IngressPolicy *ChannelIngressPolicy `json:"ingressPolicy,omitempty"`
+}
+
+// ChannelIngressPolicy lists the publishers that are allowed to send events to
+// a Channel. Publishers present a bearer token in the Authorization header; the
+// request is admitted if the token matches any of the API key Secrets or
+// authenticates as any of the ServiceAccounts.
+type ChannelIngressPolicy struct {
+	// ServiceAccounts that may publish to the Channel. Tokens are verified
+	// with the TokenReview API and must be projected service account tokens
+	// issued for the "channels.knative.dev" audience. The audience is only
+	// enforced by API servers that support token audiences (Kubernetes 1.13
+	// and later), older ones accept any token of the ServiceAccounts.
+	ServiceAccounts []ServiceAccountReference `json:"serviceAccounts,omitempty"`
+
+	// APIKeySecrets are keys of Secrets in the Channel's namespace, each
+	// holding a static API key that may publish to the Channel. The bus
+	// dispatcher reads the Secrets with its bus's service account, which
+	// must be allowed to get them, for example with a Role in the Channel's
+	// namespace restricted to their resourceNames.
+	APIKeySecrets []v1.SecretKeySelector `json:"apiKeySecrets,omitempty"`
 }
 
+// ServiceAccountReference identifies a ServiceAccount that is allowed to
+// publish to a Channel.
+type ServiceAccountReference struct {
+	// Namespace of the ServiceAccount. Defaults to the Channel's namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the ServiceAccount.
+	Name string `json:"name"`
+}
+
+// ChannelKind is the Kind of Channel resources, used by references to
+// Channels from other resources.
+const ChannelKind = "Channel"
+
+// ChannelProvisionerFinalizer is the finalizer a bus provisioner adds to the
+// Channels it provisions, so a Channel is only deleted once its backing
+// resource is unprovisioned. The channel controller removes it from a Channel
+// being deleted whose Bus or ClusterBus no longer exists. Removing it by hand
+// deletes a Channel whose provisioner is not running, leaving its backing
+// resource behind.
+const ChannelProvisionerFinalizer = "channels.knative.dev/provisioner"
+
 type ChannelConditionType string
 
 const (
@@ -74,6 +121,9 @@ const (
 
 	// Provisioned means the channel backing construct on the bus middleware has been set up.
 	ChannelProvisioned ChannelConditionType = "Provisioned"
+
+	// BusReady means the Bus or ClusterBus backing the channel is ready.
+	ChannelBusReady ChannelConditionType = "BusReady"
 )
 
 // ChannelCondition describes the state of a channel at a point in time.
@@ -40,8 +40,7 @@ type ClusterBus struct {
 type ClusterBusSpec = BusSpec
 
 // ClusterBusStatus (computed) for a clusterbus
-type ClusterBusStatus struct {
-}
+type ClusterBusStatus = BusStatus
 
 func (b *ClusterBus) BacksChannel(channel *Channel) bool {
 	return len(b.Namespace) == 0 && b.Name == channel.Spec.ClusterBus
@@ -51,6 +50,10 @@ func (b *ClusterBus) GetSpec() *BusSpec {
 	return &b.Spec
 }
 
+func (b *ClusterBus) GetStatus() *BusStatus {
+	return &b.Status
+}
+
 func (b *ClusterBus) GetSpecJSON() ([]byte, error) {
 	return json.Marshal(b.Spec)
 }
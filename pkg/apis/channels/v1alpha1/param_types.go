@@ -16,6 +16,13 @@
 
 package v1alpha1
 
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
 // inspired by the parameter/argument from buildtemplate/build
 
 // Parameter represents a named configuration parameter that must be supplied to
@@ -31,8 +38,48 @@ type Parameter struct {
 	// Default is the value to use if an Argument for this Parameter is not
 	// explicitly set.
 	Default *string `json:"default,omitempty"`
+
+	// Type is the type of the Parameter's values. Defaults to string.
+	Type ParameterType `json:"type,omitempty"`
+
+	// AllowedValues is the list of values an Argument may have. It is required
+	// for enum Parameters and optional for string Parameters.
+	AllowedValues []string `json:"allowedValues,omitempty"`
+
+	// Pattern is a regular expression the values of a string Parameter must
+	// match (optional).
+	Pattern string `json:"pattern,omitempty"`
+
+	// Minimum is the smallest value of an int or duration Parameter
+	// (optional).
+	Minimum *string `json:"minimum,omitempty"`
+
+	// Maximum is the largest value of an int or duration Parameter
+	// (optional).
+	Maximum *string `json:"maximum,omitempty"`
 }
 
+// ParameterType is the type of the values of a Parameter.
+type ParameterType string
+
+const (
+	// ParameterTypeString accepts any value.
+	ParameterTypeString ParameterType = "string"
+
+	// ParameterTypeInt accepts base 10 integers.
+	ParameterTypeInt ParameterType = "int"
+
+	// ParameterTypeDuration accepts durations like 1m30s, as parsed by Go's
+	// time.ParseDuration.
+	ParameterTypeDuration ParameterType = "duration"
+
+	// ParameterTypeBool accepts true and false.
+	ParameterTypeBool ParameterType = "bool"
+
+	// ParameterTypeEnum accepts one of the Parameter's AllowedValues.
+	ParameterTypeEnum ParameterType = "enum"
+)
+
 // Argument represents a value for a named parameter.
 type Argument struct {
 	// Name is the name of the Parameter this Argument is for.
@@ -41,3 +88,128 @@ type Argument struct {
 	// Value is the value for the Parameter.
 	Value string `json:"value"`
 }
+
+// GetType returns the type of the Parameter, defaulting to string.
+func (p *Parameter) GetType() ParameterType {
+	if len(p.Type) == 0 {
+		return ParameterTypeString
+	}
+	return p.Type
+}
+
+// ValidateSchema returns an error if the Parameter's rules are inconsistent
+// with its type or with each other, or if its default value breaks them.
+func (p *Parameter) ValidateSchema() error {
+	t := p.GetType()
+	switch t {
+	case ParameterTypeString, ParameterTypeInt, ParameterTypeDuration, ParameterTypeBool, ParameterTypeEnum:
+	default:
+		return fmt.Errorf("unsupported type %q", t)
+	}
+	if t == ParameterTypeEnum && len(p.AllowedValues) == 0 {
+		return fmt.Errorf("an enum requires allowedValues")
+	}
+	if len(p.AllowedValues) != 0 && t != ParameterTypeString && t != ParameterTypeEnum {
+		return fmt.Errorf("allowedValues are not supported for type %q", t)
+	}
+	if len(p.Pattern) != 0 {
+		if t != ParameterTypeString {
+			return fmt.Errorf("a pattern is not supported for type %q", t)
+		}
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	if p.Minimum != nil || p.Maximum != nil {
+		if t != ParameterTypeInt && t != ParameterTypeDuration {
+			return fmt.Errorf("minimum and maximum are not supported for type %q", t)
+		}
+		var min, max int64
+		var err error
+		if p.Minimum != nil {
+			if min, err = p.parse(*p.Minimum); err != nil {
+				return fmt.Errorf("invalid minimum: %v", err)
+			}
+		}
+		if p.Maximum != nil {
+			if max, err = p.parse(*p.Maximum); err != nil {
+				return fmt.Errorf("invalid maximum: %v", err)
+			}
+		}
+		if p.Minimum != nil && p.Maximum != nil && min > max {
+			return fmt.Errorf("minimum %s is larger than maximum %s", *p.Minimum, *p.Maximum)
+		}
+	}
+	if p.Default != nil {
+		if err := p.Validate(*p.Default); err != nil {
+			return fmt.Errorf("invalid default: %v", err)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the value breaks the Parameter's rules. The
+// rules are assumed to be valid, see ValidateSchema.
+func (p *Parameter) Validate(value string) error {
+	t := p.GetType()
+	switch t {
+	case ParameterTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("value %q is not a bool", value)
+		}
+	case ParameterTypeInt, ParameterTypeDuration:
+		n, err := p.parse(value)
+		if err != nil {
+			return err
+		}
+		if p.Minimum != nil {
+			if min, err := p.parse(*p.Minimum); err == nil && n < min {
+				return fmt.Errorf("value %q is less than the minimum %s", value, *p.Minimum)
+			}
+		}
+		if p.Maximum != nil {
+			if max, err := p.parse(*p.Maximum); err == nil && n > max {
+				return fmt.Errorf("value %q is greater than the maximum %s", value, *p.Maximum)
+			}
+		}
+	}
+	if len(p.AllowedValues) != 0 && !containsString(p.AllowedValues, value) {
+		return fmt.Errorf("value %q is not one of %v", value, p.AllowedValues)
+	}
+	if len(p.Pattern) != 0 {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("value %q does not match %q", value, p.Pattern)
+		}
+	}
+	return nil
+}
+
+// parse converts the value of an int or duration Parameter to a number, so
+// values can be compared with the minimum and maximum.
+func (p *Parameter) parse(value string) (int64, error) {
+	if p.GetType() == ParameterTypeDuration {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("value %q is not a duration", value)
+		}
+		return int64(d), nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value %q is not an int", value)
+	}
+	return n, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"testing"
+)
+
+func TestParameterValidate(t *testing.T) {
+	one, ten := "1", "10"
+	second, minute := "1s", "1m"
+	for _, test := range []struct {
+		name    string
+		param   Parameter
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:  "untyped",
+			param: Parameter{},
+			valid: []string{"", "foo", "42"},
+		},
+		{
+			name:    "string with pattern",
+			param:   Parameter{Type: ParameterTypeString, Pattern: "^[a-z]+$"},
+			valid:   []string{"foo"},
+			invalid: []string{"", "Foo", "42"},
+		},
+		{
+			name:    "string with allowed values",
+			param:   Parameter{AllowedValues: []string{"a", "b"}},
+			valid:   []string{"a", "b"},
+			invalid: []string{"", "c"},
+		},
+		{
+			name:    "int",
+			param:   Parameter{Type: ParameterTypeInt},
+			valid:   []string{"0", "-3", "42"},
+			invalid: []string{"", "foo", "1.5"},
+		},
+		{
+			name:    "int with range",
+			param:   Parameter{Type: ParameterTypeInt, Minimum: &one, Maximum: &ten},
+			valid:   []string{"1", "5", "10"},
+			invalid: []string{"0", "11"},
+		},
+		{
+			name:    "duration with range",
+			param:   Parameter{Type: ParameterTypeDuration, Minimum: &second, Maximum: &minute},
+			valid:   []string{"1s", "1500ms", "1m"},
+			invalid: []string{"999ms", "61s", "10", "foo"},
+		},
+		{
+			name:    "bool",
+			param:   Parameter{Type: ParameterTypeBool},
+			valid:   []string{"true", "false"},
+			invalid: []string{"", "yes"},
+		},
+		{
+			name:    "enum",
+			param:   Parameter{Type: ParameterTypeEnum, AllowedValues: []string{"Oldest", "Newest"}},
+			valid:   []string{"Oldest", "Newest"},
+			invalid: []string{"", "foo", "oldest"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.param.ValidateSchema(); err != nil {
+				t.Fatalf("Unexpected schema error: %v", err)
+			}
+			for _, value := range test.valid {
+				if err := test.param.Validate(value); err != nil {
+					t.Errorf("Expected %q to be valid, got %v", value, err)
+				}
+			}
+			for _, value := range test.invalid {
+				if err := test.param.Validate(value); err == nil {
+					t.Errorf("Expected %q to be invalid", value)
+				}
+			}
+		})
+	}
+}
+
+func TestParameterValidateSchema(t *testing.T) {
+	one, ten, foo := "1", "10", "foo"
+	for _, test := range []struct {
+		name  string
+		param Parameter
+	}{
+		{
+			name:  "unsupported type",
+			param: Parameter{Type: "float"},
+		},
+		{
+			name:  "enum without allowed values",
+			param: Parameter{Type: ParameterTypeEnum},
+		},
+		{
+			name:  "allowed values for int",
+			param: Parameter{Type: ParameterTypeInt, AllowedValues: []string{"1"}},
+		},
+		{
+			name:  "pattern for bool",
+			param: Parameter{Type: ParameterTypeBool, Pattern: "true"},
+		},
+		{
+			name:  "invalid pattern",
+			param: Parameter{Pattern: "("},
+		},
+		{
+			name:  "minimum for string",
+			param: Parameter{Minimum: &one},
+		},
+		{
+			name:  "invalid minimum",
+			param: Parameter{Type: ParameterTypeInt, Minimum: &foo},
+		},
+		{
+			name:  "minimum larger than maximum",
+			param: Parameter{Type: ParameterTypeInt, Minimum: &ten, Maximum: &one},
+		},
+		{
+			name:  "invalid default",
+			param: Parameter{Type: ParameterTypeInt, Default: &foo},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.param.ValidateSchema(); err == nil {
+				t.Errorf("Expected schema error for %+v", test.param)
+			}
+		})
+	}
+}
@@ -46,6 +46,26 @@ type SubscriptionSpec struct {
 	// Subscriber is the name of the subscriber service DNS name.
 	Subscriber string `json:"subscriber"`
 
+	// SubscriberRef is a reference to a Channel that events are forwarded to
+	// (mutually exclusive with Subscriber). The referenced Channel may be
+	// backed by a different Bus, which allows fan-out across buses without
+	// relay services. It must be in the Subscription's namespace and must not
+	// have an ingress policy, as forwarded events carry no publisher
+	// credentials.
+	SubscriberRef *v1.ObjectReference `json:"subscriberRef,omitempty"`
+
+	// DeliveryDelay is a fixed delay applied to every event before it is
+	// delivered to the subscriber (optional). The delay is measured from the
+	// time the event was received by the bus. Publishers may further delay an
+	// individual event with the CloudEvents "deliverat" extension. Buses
+	// may limit how long an event can be delayed for.
+	DeliveryDelay *meta_v1.Duration `json:"deliveryDelay,omitempty"`
+
+	// Batching accumulates events into batches which are delivered to the
+	// subscriber in one request (optional). Events are delivered one at a
+	// time if it is not set.
+	Batching *SubscriptionBatching `json:"batching,omitempty"`
+
 	// Arguments is a list of configuration arguments for the Subscription. The
 	// Arguments for a channel must contain values for each of the Parameters
 	// specified by the Bus' spec.parameters.Subscriptions field except the
@@ -56,11 +76,28 @@ type SubscriptionSpec struct {
 	Arguments *[]Argument `json:"arguments,omitempty"`
 }
 
+// SubscriptionBatching configures how events are accumulated into batches for
+// a subscriber. A batch is delivered as a JSON array of CloudEvents with the
+// "application/cloudevents-batch+json" content type once it holds MaxEvents
+// events or its first event has waited for MaxDelay.
+type SubscriptionBatching struct {
+	// MaxEvents is the maximum number of events in a batch, 100 if not set.
+	MaxEvents int32 `json:"maxEvents,omitempty"`
+
+	// MaxDelay is the maximum time an event waits for a batch to fill, one
+	// second if not set.
+	MaxDelay *meta_v1.Duration `json:"maxDelay,omitempty"`
+}
+
 type SubscriptionConditionType string
 
 const (
 	// Dispatching means the subscription is actively listening for incoming events on its channel and dispatching them.
 	SubscriptionDispatching SubscriptionConditionType = "Dispatching"
+
+	// SubscriberResolved means the object referenced by the subscriber was found
+	// and resolved to an address.
+	SubscriptionSubscriberResolved SubscriptionConditionType = "SubscriberResolved"
 )
 
 // SubscriptionCondition describes the state of a subscription at a point in time.
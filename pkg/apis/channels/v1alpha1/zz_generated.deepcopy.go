@@ -22,6 +22,7 @@ package v1alpha1
 
 import (
 	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -41,6 +42,168 @@ func (in *Argument) DeepCopy() *Argument {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Bridge) DeepCopyInto(out *Bridge) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Bridge.
+func (in *Bridge) DeepCopy() *Bridge {
+	if in == nil {
+		return nil
+	}
+	out := new(Bridge)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Bridge) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BridgeCondition) DeepCopyInto(out *BridgeCondition) {
+	*out = *in
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BridgeCondition.
+func (in *BridgeCondition) DeepCopy() *BridgeCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(BridgeCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BridgeList) DeepCopyInto(out *BridgeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Bridge, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BridgeList.
+func (in *BridgeList) DeepCopy() *BridgeList {
+	if in == nil {
+		return nil
+	}
+	out := new(BridgeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *BridgeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BridgeSpec) DeepCopyInto(out *BridgeSpec) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+	if in.MaxHops != nil {
+		in, out := &in.MaxHops, &out.MaxHops
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BridgeSpec.
+func (in *BridgeSpec) DeepCopy() *BridgeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BridgeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BridgeStatus) DeepCopyInto(out *BridgeStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]BridgeCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BridgeStatus.
+func (in *BridgeStatus) DeepCopy() *BridgeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BridgeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BridgeTarget) DeepCopyInto(out *BridgeTarget) {
+	*out = *in
+	if in.Channel != nil {
+		in, out := &in.Channel, &out.Channel
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.ObjectReference)
+			**out = **in
+		}
+	}
+	if in.CredentialsSecret != nil {
+		in, out := &in.CredentialsSecret, &out.CredentialsSecret
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.LocalObjectReference)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BridgeTarget.
+func (in *BridgeTarget) DeepCopy() *BridgeTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(BridgeTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Bus) DeepCopyInto(out *Bus) {
 	*out = *in
@@ -69,6 +232,40 @@ func (in *Bus) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BusAutoscaling) DeepCopyInto(out *BusAutoscaling) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	if in.TargetCPUUtilizationPercentage != nil {
+		in, out := &in.TargetCPUUtilizationPercentage, &out.TargetCPUUtilizationPercentage
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BusAutoscaling.
+func (in *BusAutoscaling) DeepCopy() *BusAutoscaling {
+	if in == nil {
+		return nil
+	}
+	out := new(BusAutoscaling)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BusCondition) DeepCopyInto(out *BusCondition) {
 	*out = *in
@@ -187,7 +384,34 @@ func (in *BusSpec) DeepCopyInto(out *BusSpec) {
 			(*in).DeepCopyInto(*out)
 		}
 	}
+	if in.ProvisionerReplicas != nil {
+		in, out := &in.ProvisionerReplicas, &out.ProvisionerReplicas
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
 	in.Dispatcher.DeepCopyInto(&out.Dispatcher)
+	if in.DispatcherReplicas != nil {
+		in, out := &in.DispatcherReplicas, &out.DispatcherReplicas
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	if in.DispatcherAutoscaling != nil {
+		in, out := &in.DispatcherAutoscaling, &out.DispatcherAutoscaling
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(BusAutoscaling)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	if in.Volumes != nil {
 		in, out := &in.Volumes, &out.Volumes
 		if *in == nil {
@@ -294,6 +518,34 @@ func (in *ChannelCondition) DeepCopy() *ChannelCondition {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChannelIngressPolicy) DeepCopyInto(out *ChannelIngressPolicy) {
+	*out = *in
+	if in.ServiceAccounts != nil {
+		in, out := &in.ServiceAccounts, &out.ServiceAccounts
+		*out = make([]ServiceAccountReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.APIKeySecrets != nil {
+		in, out := &in.APIKeySecrets, &out.APIKeySecrets
+		*out = make([]v1.SecretKeySelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChannelIngressPolicy.
+func (in *ChannelIngressPolicy) DeepCopy() *ChannelIngressPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ChannelIngressPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChannelList) DeepCopyInto(out *ChannelList) {
 	*out = *in
@@ -343,6 +595,15 @@ func (in *ChannelSpec) DeepCopyInto(out *ChannelSpec) {
 			}
 		}
 	}
+	if in.IngressPolicy != nil {
+		in, out := &in.IngressPolicy, &out.IngressPolicy
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChannelIngressPolicy)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -403,7 +664,7 @@ func (in *ClusterBus) DeepCopyInto(out *ClusterBus) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -458,22 +719,6 @@ func (in *ClusterBusList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ClusterBusStatus) DeepCopyInto(out *ClusterBusStatus) {
-	*out = *in
-	return
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterBusStatus.
-func (in *ClusterBusStatus) DeepCopy() *ClusterBusStatus {
-	if in == nil {
-		return nil
-	}
-	out := new(ClusterBusStatus)
-	in.DeepCopyInto(out)
-	return out
-}
-
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Parameter) DeepCopyInto(out *Parameter) {
 	*out = *in
@@ -486,6 +731,29 @@ func (in *Parameter) DeepCopyInto(out *Parameter) {
 			**out = **in
 		}
 	}
+	if in.AllowedValues != nil {
+		in, out := &in.AllowedValues, &out.AllowedValues
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Minimum != nil {
+		in, out := &in.Minimum, &out.Minimum
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.Maximum != nil {
+		in, out := &in.Maximum, &out.Maximum
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -499,6 +767,22 @@ func (in *Parameter) DeepCopy() *Parameter {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAccountReference) DeepCopyInto(out *ServiceAccountReference) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAccountReference.
+func (in *ServiceAccountReference) DeepCopy() *ServiceAccountReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAccountReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Subscription) DeepCopyInto(out *Subscription) {
 	*out = *in
@@ -527,6 +811,31 @@ func (in *Subscription) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubscriptionBatching) DeepCopyInto(out *SubscriptionBatching) {
+	*out = *in
+	if in.MaxDelay != nil {
+		in, out := &in.MaxDelay, &out.MaxDelay
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(meta_v1.Duration)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubscriptionBatching.
+func (in *SubscriptionBatching) DeepCopy() *SubscriptionBatching {
+	if in == nil {
+		return nil
+	}
+	out := new(SubscriptionBatching)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SubscriptionCondition) DeepCopyInto(out *SubscriptionCondition) {
 	*out = *in
@@ -581,6 +890,33 @@ func (in *SubscriptionList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SubscriptionSpec) DeepCopyInto(out *SubscriptionSpec) {
 	*out = *in
+	if in.SubscriberRef != nil {
+		in, out := &in.SubscriberRef, &out.SubscriberRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.ObjectReference)
+			**out = **in
+		}
+	}
+	if in.DeliveryDelay != nil {
+		in, out := &in.DeliveryDelay, &out.DeliveryDelay
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(meta_v1.Duration)
+			**out = **in
+		}
+	}
+	if in.Batching != nil {
+		in, out := &in.Batching, &out.Batching
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(SubscriptionBatching)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	if in.Arguments != nil {
 		in, out := &in.Arguments, &out.Arguments
 		if *in == nil {
@@ -0,0 +1,292 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/knative/eventing/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ExtensionHops is the CloudEvents extension counting the bridges an
+	// event has crossed. As an extension of the event it is carried by every
+	// bus, in either encoding.
+	ExtensionHops = "bridgehops"
+
+	// HeaderHops is the header counting the bridges a message which is not a
+	// CloudEvent has crossed.
+	HeaderHops = "ce-bridgehops"
+
+	// DefaultMaxHops is the number of bridges a message may cross by default.
+	// A message published to a channel is republished by the channel's
+	// bridges, but not by the bridges of the channels it is republished to.
+	DefaultMaxHops = 1
+
+	// EnvTarget is the environment variable holding the address messages are
+	// republished to.
+	EnvTarget = "BRIDGE_TARGET"
+
+	// EnvMaxHops is the environment variable holding the number of bridges a
+	// message may cross.
+	EnvMaxHops = "BRIDGE_MAX_HOPS"
+
+	// EnvToken is the environment variable holding the bearer token presented
+	// to the target (optional).
+	EnvToken = "BRIDGE_TOKEN"
+)
+
+var messagesBridged = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "knative",
+		Subsystem: "bridge",
+		Name:      "messages_total",
+		Help:      "Number of messages received by the bridge.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(messagesBridged)
+}
+
+// Bridge receives the messages of a Channel's subscription and republishes
+// them to a target, typically the ingress of a Channel on another bus or in
+// another cluster. Headers are preserved. Messages that crossed the maximum
+// number of bridges are dropped to prevent loops between bridged channels.
+type Bridge struct {
+	target     string
+	maxHops    int
+	dispatcher *buses.MessageDispatcher
+	receiver   *buses.MessageReceiver
+}
+
+// New creates a bridge republishing messages to the target address.
+func New(target string, maxHops int) *Bridge {
+	b := &Bridge{
+		target:     target,
+		maxHops:    maxHops,
+		dispatcher: buses.NewMessageDispatcher(),
+	}
+	b.receiver = buses.NewMessageReceiver(b.forward)
+	return b
+}
+
+// NewFromEnv creates a bridge configured by the BRIDGE_ environment variables.
+func NewFromEnv() (*Bridge, error) {
+	target := os.Getenv(EnvTarget)
+	if len(target) == 0 {
+		return nil, fmt.Errorf("%s must be set", EnvTarget)
+	}
+	maxHops := DefaultMaxHops
+	if hops := os.Getenv(EnvMaxHops); len(hops) > 0 {
+		n, err := strconv.Atoi(hops)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s %q, must be a positive integer", EnvMaxHops, hops)
+		}
+		maxHops = n
+	}
+	b := New(target, maxHops)
+	if token := os.Getenv(EnvToken); len(token) > 0 {
+		b.SetBearerToken(token)
+	}
+	return b, nil
+}
+
+// SetBearerToken sets a token presented to the target in the Authorization
+// header.
+func (b *Bridge) SetBearerToken(token string) {
+	b.dispatcher.SetBearerToken(token)
+}
+
+// Run receives messages and republishes them until the stop channel is
+// closed.
+func (b *Bridge) Run(stopCh <-chan struct{}) {
+	glog.Infof("Bridging messages to %q", b.target)
+	b.receiver.Run(stopCh)
+}
+
+// forward republishes a message to the target. The message's channel is the
+// bridge's own service and is ignored.
+func (b *Bridge) forward(_ *buses.ChannelReference, message *buses.Message) error {
+	hops, err := hopCount(message)
+	if err != nil {
+		// a malformed count must not let the message loop forever
+		glog.Warningf("Dropping message with %v", err)
+		messagesBridged.WithLabelValues("dropped").Inc()
+		return nil
+	}
+	if hops >= b.maxHops {
+		glog.V(4).Infof("Dropping message that crossed %d bridges", hops)
+		messagesBridged.WithLabelValues("dropped").Inc()
+		return nil
+	}
+
+	bridged, err := withHopCount(message, hops+1)
+	if err != nil {
+		glog.Errorf("Unable to count the hop of message: %v", err)
+		messagesBridged.WithLabelValues("failed").Inc()
+		return err
+	}
+	if err := b.dispatcher.DispatchMessage(b.target, "", bridged); err != nil {
+		glog.Errorf("Unable to republish message to %q: %v", b.target, err)
+		messagesBridged.WithLabelValues("failed").Inc()
+		return err
+	}
+	messagesBridged.WithLabelValues("forwarded").Inc()
+	return nil
+}
+
+// hopCount returns the number of bridges the message has crossed, from the
+// ExtensionHops extension of its event, or from the HeaderHops header if it
+// is not a CloudEvent.
+func hopCount(message *buses.Message) (int, error) {
+	context, err := event.FromRequest(nil, messageRequest(message))
+	if err != nil {
+		for name, value := range message.Headers {
+			if strings.EqualFold(name, HeaderHops) {
+				return parseHops(value)
+			}
+		}
+		return 0, nil
+	}
+	for name, value := range context.Extensions {
+		// binary 0.1 extension names keep the case of their header
+		if strings.EqualFold(name, ExtensionHops) {
+			return parseHops(value)
+		}
+	}
+	return 0, nil
+}
+
+// parseHops parses a hop count, which is a string in binary events and may be
+// a JSON number in structured events.
+func parseHops(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case string:
+		if hops, err := strconv.Atoi(v); err == nil && hops >= 0 {
+			return hops, nil
+		}
+	case float64:
+		if v >= 0 && v == math.Trunc(v) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid %s %v", ExtensionHops, value)
+}
+
+// withHopCount returns a copy of the message counting the hops. The count of
+// a CloudEvent is set in its context, and the event is encoded again in the
+// encoding and version it was received in. Headers which are not part of the
+// event, such as tracing headers, are kept as is.
+func withHopCount(message *buses.Message, hops int) (*buses.Message, error) {
+	var data []byte
+	context, err := event.FromRequest(&data, messageRequest(message))
+	if err != nil {
+		bridged := &buses.Message{
+			Headers: make(map[string]string, len(message.Headers)+1),
+			Payload: message.Payload,
+		}
+		for name, value := range message.Headers {
+			if !strings.EqualFold(name, HeaderHops) {
+				bridged.Headers[name] = value
+			}
+		}
+		bridged.Headers[HeaderHops] = strconv.Itoa(hops)
+		return bridged, nil
+	}
+
+	extensions := make(map[string]interface{}, len(context.Extensions)+1)
+	for name, value := range context.Extensions {
+		if !strings.EqualFold(name, ExtensionHops) {
+			extensions[name] = value
+		}
+	}
+	extensions[ExtensionHops] = strconv.Itoa(hops)
+	context.Extensions = extensions
+
+	var encoding event.HTTPMarshaller = event.Binary
+	if isStructured(message) {
+		encoding = event.Structured
+	}
+	req, err := encoding.NewRequest("", data, *context)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	bridged := &buses.Message{
+		Headers: make(map[string]string, len(message.Headers)+len(req.Header)),
+		Payload: payload,
+	}
+	for name, value := range message.Headers {
+		if !isEventHeader(name) {
+			bridged.Headers[name] = value
+		}
+	}
+	// binary 0.1 extension headers are not in canonical form
+	for name, values := range req.Header {
+		if len(values) > 0 {
+			bridged.Headers[strings.ToLower(name)] = values[0]
+		}
+	}
+	return bridged, nil
+}
+
+// messageRequest returns a request carrying the message, to be read as a
+// CloudEvent.
+func messageRequest(message *buses.Message) *http.Request {
+	req := &http.Request{
+		Header: http.Header{},
+		Body:   ioutil.NopCloser(bytes.NewReader(message.Payload)),
+	}
+	for name, value := range message.Headers {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+// isStructured returns true if the message holds an event in the structured
+// encoding.
+func isStructured(message *buses.Message) bool {
+	for name, value := range message.Headers {
+		if strings.EqualFold(name, event.HeaderContentType) {
+			return strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), event.ContentTypeStructuredJSON)
+		}
+	}
+	return false
+}
+
+// isEventHeader returns true if the header is part of the encoding of an
+// event, its content type or one of its attributes.
+func isEventHeader(name string) bool {
+	name = strings.ToLower(name)
+	return name == "content-type" || strings.HasPrefix(name, "ce-")
+}
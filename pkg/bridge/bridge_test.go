@@ -0,0 +1,281 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bridge
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+type recordedRequest struct {
+	header http.Header
+	body   string
+}
+
+func newTarget(status int) (*httptest.Server, chan recordedRequest) {
+	requests := make(chan recordedRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests <- recordedRequest{header: req.Header, body: string(body)}
+		res.WriteHeader(status)
+	}))
+	return server, requests
+}
+
+func send(b *Bridge, headers map[string]string) int {
+	req := httptest.NewRequest(http.MethodPost, "http://example-bridge.default.svc.cluster.local/", strings.NewReader("hello"))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	res := httptest.NewRecorder()
+	b.receiver.HandleRequest(res, req)
+	return res.Code
+}
+
+func TestBridgeForwards(t *testing.T) {
+	target, requests := newTarget(http.StatusAccepted)
+	defer target.Close()
+	b := New(target.URL, DefaultMaxHops)
+	b.SetBearerToken("secret")
+
+	code := send(b, map[string]string{
+		"Content-Type":    "text/plain",
+		"CE-EventID":      "1234",
+		"CE-X-Custom":     "custom",
+		"X-Forwarded-For": "10.0.0.1",
+	})
+	if code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, code)
+	}
+
+	req := <-requests
+	if req.body != "hello" {
+		t.Errorf("Expected body %q, got %q", "hello", req.body)
+	}
+	for name, expected := range map[string]string{
+		"Content-Type":    "text/plain",
+		"Ce-Eventid":      "1234",
+		"Ce-X-Custom":     "custom",
+		"Ce-Bridgehops":   "1",
+		"Authorization":   "Bearer secret",
+		"X-Forwarded-For": "",
+	} {
+		if actual := req.header.Get(name); actual != expected {
+			t.Errorf("Expected header %s %q, got %q", name, expected, actual)
+		}
+	}
+}
+
+func TestBridgeHops(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		hops     string
+		maxHops  int
+		expected string
+	}{
+		{name: "first hop", hops: "", maxHops: 1, expected: "1"},
+		{name: "looping", hops: "1", maxHops: 1, expected: ""},
+		{name: "second hop", hops: "1", maxHops: 2, expected: "2"},
+		{name: "too many hops", hops: "3", maxHops: 2, expected: ""},
+		{name: "malformed", hops: "many", maxHops: 2, expected: ""},
+		{name: "negative", hops: "-1", maxHops: 2, expected: ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			target, requests := newTarget(http.StatusAccepted)
+			defer target.Close()
+			b := New(target.URL, test.maxHops)
+
+			headers := map[string]string{}
+			if test.hops != "" {
+				headers[HeaderHops] = test.hops
+			}
+			if code := send(b, headers); code != http.StatusAccepted {
+				t.Fatalf("Expected status %d, got %d", http.StatusAccepted, code)
+			}
+
+			select {
+			case req := <-requests:
+				if test.expected == "" {
+					t.Fatalf("Expected message to be dropped, got %v", req.header)
+				}
+				if actual := req.header.Get(HeaderHops); actual != test.expected {
+					t.Errorf("Expected %s %q, got %q", HeaderHops, test.expected, actual)
+				}
+			default:
+				if test.expected != "" {
+					t.Fatalf("Expected message to be forwarded")
+				}
+			}
+		})
+	}
+}
+
+// sendEvent sends an event to the bridge in the encoding.
+func sendEvent(t *testing.T, b *Bridge, encoding event.HTTPMarshaller, data interface{}, context event.EventContext) int {
+	t.Helper()
+	req, err := encoding.NewRequest("http://example-bridge.default.svc.cluster.local/", data, context)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	req.Host = req.URL.Host
+	res := httptest.NewRecorder()
+	b.receiver.HandleRequest(res, req)
+	return res.Code
+}
+
+// readEvent reads the event of a request the target received.
+func readEvent(t *testing.T, req recordedRequest) (*event.EventContext, map[string]string) {
+	t.Helper()
+	var data map[string]string
+	context, err := event.FromRequest(&data, &http.Request{
+		Header: req.header,
+		Body:   ioutil.NopCloser(strings.NewReader(req.body)),
+	})
+	if err != nil {
+		t.Fatalf("Unable to read forwarded event: %v", err)
+	}
+	return context, data
+}
+
+// hopsExtension returns the hop count extension of an event, binary 0.1
+// extension names keep the case of their header.
+func hopsExtension(context *event.EventContext) (string, interface{}) {
+	for name, value := range context.Extensions {
+		if strings.EqualFold(name, ExtensionHops) {
+			return name, value
+		}
+	}
+	return "", nil
+}
+
+func TestBridgeHopsExtension(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		encoding event.HTTPMarshaller
+		version  string
+	}{
+		{name: "binary 0.1", encoding: event.Binary, version: event.CloudEventsVersion01},
+		{name: "binary 1.0", encoding: event.Binary, version: event.CloudEventsVersion10},
+		{name: "structured 0.1", encoding: event.Structured, version: event.CloudEventsVersion01},
+		{name: "structured 1.0", encoding: event.Structured, version: event.CloudEventsVersion10},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			target, requests := newTarget(http.StatusAccepted)
+			defer target.Close()
+			b := New(target.URL, 2)
+
+			context := event.EventContext{
+				CloudEventsVersion: test.version,
+				EventID:            "1234",
+				EventType:          "dev.knative.test",
+				Source:             "tests://bridge",
+				ContentType:        "application/json",
+			}
+			if code := sendEvent(t, b, test.encoding, map[string]string{"hello": "world"}, context); code != http.StatusAccepted {
+				t.Fatalf("Expected status %d, got %d", http.StatusAccepted, code)
+			}
+			forwarded, data := readEvent(t, <-requests)
+			if forwarded.EventID != "1234" || forwarded.CloudEventsVersion != test.version || data["hello"] != "world" {
+				t.Errorf("Unexpected forwarded event %+v with data %v", forwarded, data)
+			}
+			name, hops := hopsExtension(forwarded)
+			if hops != "1" {
+				t.Errorf("Expected %s %q, got %v", ExtensionHops, "1", hops)
+			}
+
+			// the count is read back from the event
+			forwarded.Extensions[name] = "2"
+			if code := sendEvent(t, b, test.encoding, data, *forwarded); code != http.StatusAccepted {
+				t.Fatalf("Expected status %d, got %d", http.StatusAccepted, code)
+			}
+			select {
+			case req := <-requests:
+				t.Fatalf("Expected event to be dropped, got %v", req.header)
+			default:
+			}
+		})
+	}
+}
+
+// TestBridgeKafkaRoundTrip bridges a structured event to a channel of a Kafka
+// bus, which writes it in the structured mode of the Kafka binding, and back
+// through a second bridge, which must drop it.
+func TestBridgeKafkaRoundTrip(t *testing.T) {
+	target, requests := newTarget(http.StatusAccepted)
+	defer target.Close()
+	toKafka := New(target.URL, DefaultMaxHops)
+	fromKafka := New(target.URL, DefaultMaxHops)
+
+	context := event.EventContext{
+		CloudEventsVersion: event.CloudEventsVersion10,
+		EventID:            "1234",
+		EventType:          "dev.knative.test",
+		Source:             "tests://bridge",
+		ContentType:        "application/json",
+	}
+	if code := sendEvent(t, toKafka, event.Structured, map[string]string{"hello": "world"}, context); code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, code)
+	}
+	req := <-requests
+
+	// the Kafka bus keeps the event and drops other ce- headers
+	var data []byte
+	forwarded, err := event.FromRequest(&data, &http.Request{
+		Header: req.header,
+		Body:   ioutil.NopCloser(strings.NewReader(req.body)),
+	})
+	if err != nil {
+		t.Fatalf("Unable to read forwarded event: %v", err)
+	}
+	record, err := event.NewKafkaRecord(event.StructuredMode, data, *forwarded)
+	if err != nil {
+		t.Fatalf("Unable to write Kafka record: %v", err)
+	}
+	var consumed []byte
+	consumedContext, err := event.FromKafkaRecord(&consumed, record)
+	if err != nil {
+		t.Fatalf("Unable to read Kafka record: %v", err)
+	}
+	if _, hops := hopsExtension(consumedContext); hops != "1" {
+		t.Fatalf("Expected %s %q after Kafka, got %v", ExtensionHops, "1", hops)
+	}
+
+	if code := sendEvent(t, fromKafka, event.Structured, consumed, *consumedContext); code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, code)
+	}
+	select {
+	case req := <-requests:
+		t.Fatalf("Expected the event to be dropped instead of looping, got %v", req.header)
+	default:
+	}
+}
+
+func TestBridgeTargetFailure(t *testing.T) {
+	target, requests := newTarget(http.StatusServiceUnavailable)
+	defer target.Close()
+	b := New(target.URL, DefaultMaxHops)
+
+	if code := send(b, nil); code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, code)
+	}
+	<-requests
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+)
+
+const (
+	// DefaultBatchMaxEvents is the maximum number of messages in a batch if a
+	// Subscription's batching does not set one.
+	DefaultBatchMaxEvents = 100
+
+	// DefaultBatchMaxDelay is how long a message waits for its batch to fill
+	// if a Subscription's batching does not set a delay.
+	DefaultBatchMaxDelay = time.Second
+)
+
+// BatchingPolicy describes how the messages of a Subscription are accumulated
+// into batches.
+type BatchingPolicy struct {
+	// MaxEvents is the number of messages which fill a batch.
+	MaxEvents int
+
+	// MaxDelay is the longest time the first message of a batch waits for
+	// the batch to fill.
+	MaxDelay time.Duration
+}
+
+// BatchingPolicyForSubscription reads the batching policy from the
+// Subscription's spec, applying the defaults. It returns nil if messages are
+// delivered one at a time.
+func BatchingPolicyForSubscription(subscription channelsv1alpha1.SubscriptionSpec) *BatchingPolicy {
+	if subscription.Batching == nil {
+		return nil
+	}
+	policy := &BatchingPolicy{
+		MaxEvents: int(subscription.Batching.MaxEvents),
+		MaxDelay:  DefaultBatchMaxDelay,
+	}
+	if policy.MaxEvents <= 0 {
+		policy.MaxEvents = DefaultBatchMaxEvents
+	}
+	if delay := subscription.Batching.MaxDelay; delay != nil && delay.Duration > 0 {
+		policy.MaxDelay = delay.Duration
+	}
+	return policy
+}
+
+// batcher accumulates messages into batches, which are delivered once full or
+// once the first message has waited for the policy's delay.
+type batcher struct {
+	policy  BatchingPolicy
+	deliver func([]*Message) error
+
+	pending *batch
+	mutex   sync.Mutex
+}
+
+// batch is a set of messages delivered together. done is closed once the
+// batch is delivered, err holds the result of the delivery.
+type batch struct {
+	messages []*Message
+	timer    *time.Timer
+	done     chan struct{}
+	err      error
+}
+
+func newBatcher(policy BatchingPolicy, deliver func([]*Message) error) *batcher {
+	return &batcher{
+		policy:  policy,
+		deliver: deliver,
+	}
+}
+
+// add adds a message to the pending batch. It blocks until the batch is
+// delivered and returns the result of the delivery.
+func (b *batcher) add(message *Message) error {
+	b.mutex.Lock()
+	p := b.pending
+	if p == nil {
+		p = &batch{done: make(chan struct{})}
+		p.timer = time.AfterFunc(b.policy.MaxDelay, func() { b.flush(p) })
+		b.pending = p
+	}
+	p.messages = append(p.messages, message)
+	full := len(p.messages) >= b.policy.MaxEvents
+	b.mutex.Unlock()
+
+	if full {
+		p.timer.Stop()
+		b.flush(p)
+	}
+	<-p.done
+	return p.err
+}
+
+// flush delivers a batch, unless it was already delivered.
+func (b *batcher) flush(p *batch) {
+	b.mutex.Lock()
+	if b.pending != p {
+		b.mutex.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mutex.Unlock()
+
+	p.err = b.deliver(p.messages)
+	close(p.done)
+}
+
+// toEvent reads the CloudEvent held by a message in the binary or structured
+// encoding. The data is kept raw.
+func toEvent(message *Message) (event.Event, error) {
+	var data []byte
+	eventContext, err := event.FromRequest(&data, eventRequest(message))
+	if err != nil {
+		return event.Event{}, err
+	}
+	// JSON data is embedded in the batch as is, so it must be valid.
+	if isJSON(eventContext.ContentType) && len(data) > 0 && !json.Valid(data) {
+		return event.Event{}, fmt.Errorf("invalid JSON data for content type %q", eventContext.ContentType)
+	}
+	return event.Event{Context: *eventContext, Data: data}, nil
+}
+
+// toEventContext reads the context of the CloudEvent held by a message in the
+// binary or structured encoding, in any version of the spec, without decoding
+// its data.
+func toEventContext(message *Message) (*event.EventContext, error) {
+	return event.FromRequest(nil, eventRequest(message))
+}
+
+// eventRequest returns a request carrying the message, to be read as a
+// CloudEvent.
+func eventRequest(message *Message) *http.Request {
+	req := &http.Request{
+		Header: http.Header{},
+		Body:   ioutil.NopCloser(bytes.NewReader(message.Payload)),
+	}
+	for name, value := range message.Headers {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+// isJSON returns true if event data of the content type is JSON, which is
+// assumed for events without a content type.
+func isJSON(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	return contentType == "" || contentType == "application/json" || contentType == "text/json"
+}
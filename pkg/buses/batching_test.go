@@ -0,0 +1,246 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+	"github.com/knative/eventing/pkg/event/eventtest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBatchingPolicyForSubscription(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		batching *channelsv1alpha1.SubscriptionBatching
+		want     *BatchingPolicy
+	}{
+		{
+			name: "not batched",
+		},
+		{
+			name:     "defaults",
+			batching: &channelsv1alpha1.SubscriptionBatching{},
+			want:     &BatchingPolicy{MaxEvents: DefaultBatchMaxEvents, MaxDelay: DefaultBatchMaxDelay},
+		},
+		{
+			name: "limits",
+			batching: &channelsv1alpha1.SubscriptionBatching{
+				MaxEvents: 10,
+				MaxDelay:  &metav1.Duration{Duration: time.Minute},
+			},
+			want: &BatchingPolicy{MaxEvents: 10, MaxDelay: time.Minute},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := BatchingPolicyForSubscription(channelsv1alpha1.SubscriptionSpec{Batching: test.batching})
+			if !reflect.DeepEqual(test.want, got) {
+				t.Errorf("Unexpected policy. want %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+// batchRecorder records the event IDs of each request it receives, a request
+// which is not a batch is recorded as a batch of its single event.
+type batchRecorder struct {
+	batches [][]string
+	mutex   sync.Mutex
+}
+
+func (r *batchRecorder) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	it, err := event.NewIterator(req)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var ids []string
+	for {
+		eventContext, err := it.Next(nil)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ids = append(ids, "invalid")
+			break
+		}
+		ids = append(ids, eventContext.EventID)
+	}
+	r.mutex.Lock()
+	r.batches = append(r.batches, ids)
+	r.mutex.Unlock()
+}
+
+func batchMessage(id string) *Message {
+	return &Message{
+		Headers: map[string]string{
+			"ce-cloudeventsversion": "0.1",
+			"ce-eventid":            id,
+			"ce-eventtype":          "dev.knative.test",
+			"ce-source":             "tests://batch",
+			"content-type":          "application/json",
+		},
+		Payload: []byte(fmt.Sprintf(`{"id":%q}`, id)),
+	}
+}
+
+func TestRunnerDispatchBatch(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		batching *channelsv1alpha1.SubscriptionBatching
+		messages []*Message
+		want     [][]string
+	}{
+		{
+			name:     "full",
+			batching: &channelsv1alpha1.SubscriptionBatching{MaxEvents: 3, MaxDelay: &metav1.Duration{Duration: time.Hour}},
+			messages: []*Message{batchMessage("a"), batchMessage("b"), batchMessage("c")},
+			want:     [][]string{{"a", "b", "c"}},
+		},
+		{
+			name:     "delayed",
+			batching: &channelsv1alpha1.SubscriptionBatching{MaxEvents: 10, MaxDelay: &metav1.Duration{Duration: 50 * time.Millisecond}},
+			messages: []*Message{batchMessage("a"), batchMessage("b")},
+			want:     [][]string{{"a", "b"}},
+		},
+		{
+			name:     "not a cloudevent",
+			batching: &channelsv1alpha1.SubscriptionBatching{MaxEvents: 2, MaxDelay: &metav1.Duration{Duration: time.Hour}},
+			messages: []*Message{{Payload: []byte("opaque")}, batchMessage("a"), batchMessage("b")},
+			want:     [][]string{{"invalid"}, {"a", "b"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &batchRecorder{}
+			server := httptest.NewServer(recorder)
+			defer server.Close()
+
+			bus := &fakeBus{}
+			r := NewRunner(bus, Dispatcher)
+			subscription := testSubscription(server.URL)
+			subscription.Spec.Batching = test.batching
+			if err := r.subscribe(subscription, nil); err != nil {
+				t.Fatalf("Unexpected subscribe error: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, len(test.messages))
+			pending := 0
+			for i, message := range test.messages {
+				if _, err := toEvent(message); err != nil {
+					// Messages which are not CloudEvents are
+					// delivered on their own, right away.
+					errs <- bus.subscribers[0].Dispatch(message)
+					continue
+				}
+				pending++
+				wg.Add(1)
+				go func(message *Message) {
+					defer wg.Done()
+					errs <- bus.subscribers[0].Dispatch(message)
+				}(message)
+				// Each message joins the batch in order, the last may
+				// fill it.
+				if i < len(test.messages)-1 {
+					waitForPending(t, r, subscription, pending)
+				}
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Errorf("Unexpected dispatch error: %v", err)
+				}
+			}
+			if !reflect.DeepEqual(test.want, recorder.batches) {
+				t.Errorf("Unexpected batches. want %v, got %v", test.want, recorder.batches)
+			}
+		})
+	}
+}
+
+// waitForPending waits until the pending batch of a subscription holds n
+// messages.
+func waitForPending(t *testing.T, r *Runner, subscription *channelsv1alpha1.Subscription, n int) {
+	r.mutex.Lock()
+	b := r.subscriptions[makeSubscriptionKeyFromSubscription(subscription)].batcher
+	r.mutex.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b.mutex.Lock()
+		count := 0
+		if b.pending != nil {
+			count = len(b.pending.messages)
+		}
+		b.mutex.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d pending messages", n)
+}
+
+func TestDispatchBatch(t *testing.T) {
+	sink := eventtest.NewSink()
+	defer sink.Close()
+
+	dispatcher := NewMessageDispatcher()
+	if err := dispatcher.DispatchBatch(sink.URL, "ns", []*Message{batchMessage("a"), batchMessage("b")}); err != nil {
+		t.Fatalf("Unexpected dispatch error: %v", err)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		e := sink.AssertReceived(t, eventtest.HasID(id), eventtest.HasType("dev.knative.test"), eventtest.HasSource("tests://batch"))
+		var data struct {
+			ID string `json:"id"`
+		}
+		if err := e.DecodeData(&data); err != nil {
+			t.Fatalf("Unexpected error decoding data of %q: %v", id, err)
+		}
+		if data.ID != id || !e.Structured {
+			t.Errorf("Unexpected event. want data of %q in a batch, got %+v (structured %v)", id, data, e.Structured)
+		}
+	}
+	if got := len(sink.Events()); got != 2 {
+		t.Errorf("Unexpected number of events. want 2, got %d", got)
+	}
+}
+
+func TestDispatchBatchRejectsMixedMessages(t *testing.T) {
+	sink := eventtest.NewSink()
+	defer sink.Close()
+
+	dispatcher := NewMessageDispatcher()
+	messages := []*Message{batchMessage("a"), {Payload: []byte("opaque")}, batchMessage("b")}
+	if err := dispatcher.DispatchBatch(sink.URL, "ns", messages); err == nil {
+		t.Errorf("Expected a batch holding a message which is not a CloudEvent to be rejected")
+	}
+	if got := len(sink.Events()); got != 0 {
+		t.Errorf("Unexpected number of events. want 0, got %d", got)
+	}
+}
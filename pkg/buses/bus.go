@@ -0,0 +1,154 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+)
+
+// Bus is implemented by each bus to connect Channels and Subscriptions to its
+// backing middleware. Buses are run with Run, which watches the Bus' Channels
+// and Subscriptions, receives messages from publishers and dispatches messages
+// to subscribers, calling the Bus as needed.
+//
+// The provisioner role calls Provision and Unprovision, the dispatcher role
+// calls Publish and Subscribe. Methods may be called concurrently.
+type Bus interface {
+	// Provision creates or updates the backing resources for a Channel. It
+	// must be idempotent.
+	Provision(channel *channelsv1alpha1.Channel, parameters ResolvedParameters) error
+
+	// Unprovision removes the backing resources for a Channel. It must
+	// succeed if the resources have already been removed.
+	Unprovision(channel *channelsv1alpha1.Channel) error
+
+	// Publish sends a message received for a Channel to the middleware.
+	Publish(channel *channelsv1alpha1.Channel, message *Message) error
+
+	// Subscribe starts delivering the messages of the Subscription's Channel
+	// to the subscriber until the returned handle is closed. The Subscription's
+	// Spec.Subscriber holds the resolved address of the subscriber.
+	Subscribe(subscription *channelsv1alpha1.Subscription, parameters ResolvedParameters, subscriber Subscriber) (SubscriptionHandle, error)
+}
+
+// SubscriptionProvisioner is optionally implemented by a Bus that manages
+// backing resources for each Subscription in the provisioner role.
+type SubscriptionProvisioner interface {
+	// ProvisionSubscription creates or updates the backing resources for a
+	// Subscription. It must be idempotent.
+	ProvisionSubscription(subscription *channelsv1alpha1.Subscription, parameters ResolvedParameters) error
+
+	// UnprovisionSubscription removes the backing resources for a
+	// Subscription. It must succeed if the resources have already been
+	// removed.
+	UnprovisionSubscription(subscription *channelsv1alpha1.Subscription) error
+}
+
+// ChannelResourceCollector is optionally implemented by a Bus that can list
+// the backing resources of its Channels. The provisioner periodically deletes
+// the resources of Channels that no longer exist, which leak when a Channel is
+// deleted while the provisioner is not running. Only resources the
+// provisioner recorded when provisioning one of the bus' Channels are
+// deleted, so resources of other buses or applications sharing the
+// middleware are left alone. Unrecorded resources without a Channel are
+// reported in a dry run, they may have leaked before being recorded.
+type ChannelResourceCollector interface {
+	// ChannelResourceName returns the name of the backing resource of the
+	// Channel.
+	ChannelResourceName(namespace, name string) string
+
+	// ChannelResources lists the names of the backing resources named as
+	// ChannelResourceName names them.
+	ChannelResources() ([]string, error)
+
+	// DeleteChannelResource deletes the backing resource of a Channel that no
+	// longer exists. It must succeed if the resource has already been removed.
+	DeleteChannelResource(name string) error
+}
+
+// SubscriptionSharder is optionally implemented by a Bus whose dispatcher
+// replicas can each deliver the messages of any Subscription, whichever
+// replica they were published through, as with Pub/Sub subscriptions. The
+// Subscriptions of such a Bus are sharded between the replicas, each
+// Subscription is subscribed to by one replica. Every replica subscribes to
+// every Subscription of other buses, as a bus that only delivers a message
+// to local subscribers, like the stub bus, would otherwise miss the
+// Subscriptions of the other replicas.
+type SubscriptionSharder interface {
+	// ShardsSubscriptions returns true if a Subscription may be subscribed to
+	// by a single replica.
+	ShardsSubscriptions() bool
+
+	// SetSubscriptionOwner is called before the bus is subscribed to any
+	// Subscription when its Subscriptions are sharded. The owns func returns
+	// true if this replica subscribes to the named Subscription.
+	SetSubscriptionOwner(owns func(namespace, name string) bool)
+}
+
+// DeliveryScheduler is optionally implemented by a Bus that durably stores the
+// messages of a Subscription which are not due for delivery yet, so a delayed
+// message does not hold up the messages after it. Run holds the messages of a
+// Bus implementing neither DeliveryScheduler nor DeliveryDelayLimiter in
+// memory, where they are lost if the dispatcher exits.
+type DeliveryScheduler interface {
+	// ScheduleDelivery stores a message of the Subscription and dispatches it
+	// to the Subscription's Subscriber once the delivery time has passed. The
+	// message must be stored before ScheduleDelivery returns.
+	ScheduleDelivery(subscription *channelsv1alpha1.Subscription, message *Message, at time.Time) error
+}
+
+// DeliveryDelayLimiter is optionally implemented by a Bus whose middleware
+// keeps a message outstanding while Dispatch holds it until its delivery time,
+// for up to a limit. Run rejects messages and Subscriptions delayed for longer.
+type DeliveryDelayLimiter interface {
+	// MaxDeliveryDelay returns the longest a message may be held for.
+	MaxDeliveryDelay() time.Duration
+}
+
+// Subscriber receives the messages of a subscription from a Bus. It is
+// provided by Run, which applies delivery delays, expiry, retries and metrics.
+type Subscriber interface {
+	// Dispatch delivers a message to the subscriber. It blocks until the
+	// message is delivered, or scheduled for delivery if it is not due, or
+	// returns an error if the message could not be delivered and should be
+	// redelivered by the Bus. The messages of a
+	// batched subscription are delivered in batches, a Bus must dispatch them
+	// concurrently for a batch to fill before its delay passes.
+	Dispatch(message *Message) error
+
+	// Failed reports that the Bus can no longer deliver messages for the
+	// subscription. The handle is closed and the subscription is set up again
+	// after a backoff. It must not be called before Subscribe returns.
+	Failed(err error)
+}
+
+// SubscriptionHandle stops the delivery of messages for a subscription.
+type SubscriptionHandle interface {
+	// Close stops delivering messages to the subscriber. Messages being
+	// dispatched may still complete.
+	Close() error
+}
+
+// SubscriptionHandleFunc adapts a func to a SubscriptionHandle.
+type SubscriptionHandleFunc func() error
+
+// Close calls the func.
+func (f SubscriptionHandleFunc) Close() error {
+	return f()
+}
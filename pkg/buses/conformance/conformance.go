@@ -0,0 +1,501 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package conformance is a test suite that checks a Bus honors the Monitor
+// contract and the delivery semantics expected of every bus. Bus
+// implementations run it from their own tests with RunTests.
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/knative/eventing/pkg/client/clientset/versioned/fake"
+	informers "github.com/knative/eventing/pkg/client/informers/externalversions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	busName = "conformance"
+
+	defaultTimeout = 30 * time.Second
+	// quietPeriod is how long to wait to be confident a message is not
+	// delivered.
+	quietPeriod = 2 * time.Second
+)
+
+// Config configures the conformance suite for a bus.
+type Config struct {
+	// Parameters are the parameters of the Bus resource the bus runs for.
+	// Parameters required by the bus must have defaults.
+	Parameters *channelsv1alpha1.BusParameters
+
+	// Timeout is how long to wait for the bus to act, it defaults to 30
+	// seconds.
+	Timeout time.Duration
+
+	// NoRedelivery is set for buses that drop a message once its dispatch
+	// failed, the redelivery test is skipped.
+	NoRedelivery bool
+}
+
+// RunTests runs the conformance suite against the bus. The bus is run in both
+// the provisioner and dispatcher roles by Runners whose Monitors watch fake
+// clientsets. Resources are created in a new namespace so the suite may be
+// run repeatedly against the same middleware.
+func RunTests(t *testing.T, bus buses.Bus, config Config) {
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	h := newHarness(t, bus, config)
+	defer h.close()
+
+	channel := h.createChannel("channel")
+	h.awaitCall("provision " + h.key(channel.Name))
+
+	subscriberA := newSubscriber()
+	defer subscriberA.close()
+	subscriberB := newSubscriber()
+	defer subscriberB.close()
+	subscriptionA := h.createSubscription("subscription-a", channel.Name, subscriberA.url())
+	subscriptionB := h.createSubscription("subscription-b", channel.Name, subscriberB.url())
+	h.awaitCall("subscribe " + h.key(subscriptionA.Name))
+	h.awaitCall("subscribe " + h.key(subscriptionB.Name))
+
+	t.Run("unknown channel", func(t *testing.T) {
+		if code := h.publish("missing", "unknown-1"); code != http.StatusNotFound {
+			t.Errorf("Unexpected status publishing to an unknown channel. want %d, got %d", http.StatusNotFound, code)
+		}
+	})
+
+	t.Run("headers round trip", func(t *testing.T) {
+		h.mustPublish(channel.Name, "headers-1")
+		req := subscriberA.await(t, "headers-1", config.Timeout)
+
+		for name, value := range publishHeaders("headers-1") {
+			lower := strings.ToLower(name)
+			forwarded := strings.HasPrefix(lower, "ce-") || lower == "content-type"
+			got := req.header.Get(name)
+			if forwarded && got != value {
+				t.Errorf("Unexpected value for header %q. want %q, got %q", name, value, got)
+			}
+			if !forwarded && got != "" {
+				t.Errorf("Unexpected header %q forwarded to subscriber", name)
+			}
+		}
+		if !bytes.Equal(req.body, publishBody("headers-1")) {
+			t.Errorf("Unexpected payload. want %q, got %q", publishBody("headers-1"), req.body)
+		}
+	})
+
+	t.Run("fan-out", func(t *testing.T) {
+		h.mustPublish(channel.Name, "fanout-1")
+		subscriberA.await(t, "fanout-1", config.Timeout)
+		subscriberB.await(t, "fanout-1", config.Timeout)
+	})
+
+	t.Run("redelivery after failure", func(t *testing.T) {
+		if config.NoRedelivery {
+			t.Skip("the bus does not redeliver messages")
+		}
+		// the Runner retries a failing subscriber itself, fail until its
+		// retries are exhausted and the bus is asked to redeliver
+		failed := "dispatch error " + h.key(subscriptionA.Name)
+		subscriberA.failUntil(func() bool {
+			return h.bus.called(failed)
+		})
+		defer subscriberA.failUntil(nil)
+		h.mustPublish(channel.Name, "redelivery-1")
+		subscriberA.await(t, "redelivery-1", config.Timeout)
+		if !h.bus.called(failed) {
+			t.Errorf("Expected the bus to be asked to redeliver")
+		}
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		h.deleteSubscription(subscriptionB.Name)
+		h.awaitCall("close " + h.key(subscriptionB.Name))
+
+		h.mustPublish(channel.Name, "unsubscribed-1")
+		subscriberA.await(t, "unsubscribed-1", config.Timeout)
+		time.Sleep(quietPeriod)
+		if attempts := subscriberB.attempts("unsubscribed-1"); attempts != 0 {
+			t.Errorf("Unexpected delivery to a removed subscription")
+		}
+	})
+
+	t.Run("unprovision", func(t *testing.T) {
+		h.deleteSubscription(subscriptionA.Name)
+		h.awaitCall("close " + h.key(subscriptionA.Name))
+		h.deleteChannel(channel.Name)
+		h.awaitCall("unprovision " + h.key(channel.Name))
+
+		h.await("publishing to a removed channel to fail", func() bool {
+			return h.publish(channel.Name, "unprovisioned-1") == http.StatusNotFound
+		})
+	})
+}
+
+// harness runs a bus against fake clientsets.
+type harness struct {
+	t         *testing.T
+	config    Config
+	namespace string
+	bus       *recordingBus
+	client    *fake.Clientset
+
+	runners  []*buses.Runner
+	receiver *httptest.Server
+	stopCh   chan struct{}
+}
+
+func newHarness(t *testing.T, bus buses.Bus, config Config) *harness {
+	h := &harness{
+		t:         t,
+		config:    config,
+		namespace: fmt.Sprintf("conformance-%d", time.Now().UnixNano()),
+		bus:       newRecordingBus(bus),
+		stopCh:    make(chan struct{}),
+	}
+
+	h.client = fake.NewSimpleClientset(&channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: h.namespace, Name: busName},
+		Spec: channelsv1alpha1.BusSpec{
+			Parameters: config.Parameters,
+		},
+	})
+	kubeclient := kubefake.NewSimpleClientset()
+
+	for _, role := range []string{buses.Provisioner, buses.Dispatcher} {
+		runner := buses.NewRunner(h.bus, role)
+		runner.Start(h.stopCh)
+		informerFactory := informers.NewSharedInformerFactory(h.client, 0)
+		monitor := buses.NewMonitorFromClients(busName+"-"+role, kubeclient, h.client, informerFactory, runner.HandlerFuncs())
+		runner.SetMonitor(monitor)
+		go func() {
+			if err := monitor.Run(h.namespace, busName, 1, h.stopCh); err != nil {
+				t.Errorf("Error running monitor: %v", err)
+			}
+		}()
+		if err := monitor.WaitForCacheSync(h.stopCh); err != nil {
+			t.Fatalf("Error waiting for monitor: %v", err)
+		}
+		if role == buses.Dispatcher {
+			h.receiver = httptest.NewServer(http.HandlerFunc(runner.Receiver().HandleRequest))
+		}
+		h.runners = append(h.runners, runner)
+	}
+	return h
+}
+
+func (h *harness) close() {
+	close(h.stopCh)
+	h.receiver.Close()
+	for _, runner := range h.runners {
+		runner.Close()
+	}
+}
+
+func (h *harness) key(name string) string {
+	return h.namespace + "/" + name
+}
+
+func (h *harness) createChannel(name string) *channelsv1alpha1.Channel {
+	channel, err := h.client.ChannelsV1alpha1().Channels(h.namespace).Create(&channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: h.namespace, Name: name},
+		Spec: channelsv1alpha1.ChannelSpec{
+			Bus: busName,
+		},
+	})
+	if err != nil {
+		h.t.Fatalf("Unable to create channel %q: %v", name, err)
+	}
+	return channel
+}
+
+func (h *harness) deleteChannel(name string) {
+	if err := h.client.ChannelsV1alpha1().Channels(h.namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+		h.t.Fatalf("Unable to delete channel %q: %v", name, err)
+	}
+}
+
+func (h *harness) createSubscription(name, channel, subscriber string) *channelsv1alpha1.Subscription {
+	subscription, err := h.client.ChannelsV1alpha1().Subscriptions(h.namespace).Create(&channelsv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: h.namespace, Name: name},
+		Spec: channelsv1alpha1.SubscriptionSpec{
+			Channel:    channel,
+			Subscriber: subscriber,
+		},
+	})
+	if err != nil {
+		h.t.Fatalf("Unable to create subscription %q: %v", name, err)
+	}
+	return subscription
+}
+
+func (h *harness) deleteSubscription(name string) {
+	if err := h.client.ChannelsV1alpha1().Subscriptions(h.namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+		h.t.Fatalf("Unable to delete subscription %q: %v", name, err)
+	}
+}
+
+// publish sends an event to the channel through the dispatcher's receiver
+// and returns the response status code.
+func (h *harness) publish(channel, id string) int {
+	req, err := http.NewRequest(http.MethodPost, h.receiver.URL, bytes.NewReader(publishBody(id)))
+	if err != nil {
+		h.t.Fatalf("Unable to create request: %v", err)
+	}
+	// the receiver routes by host, as requests are addressed to the channel
+	req.Host = fmt.Sprintf("%s.%s.channels.cluster.local", channel, h.namespace)
+	for name, value := range publishHeaders(id) {
+		req.Header.Set(name, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("Unable to publish: %v", err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func (h *harness) mustPublish(channel, id string) {
+	if code := h.publish(channel, id); code != http.StatusAccepted {
+		h.t.Fatalf("Unexpected status publishing %q. want %d, got %d", id, http.StatusAccepted, code)
+	}
+}
+
+// awaitCall waits for the Runners to make a call to the bus.
+func (h *harness) awaitCall(call string) {
+	h.await(call, func() bool {
+		return h.bus.called(call)
+	})
+}
+
+func (h *harness) await(what string, condition func() bool) {
+	deadline := time.Now().Add(h.config.Timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func publishHeaders(id string) map[string]string {
+	return map[string]string{
+		"CE-CloudEventsVersion": "0.1",
+		"CE-EventID":            id,
+		"CE-EventType":          "dev.knative.conformance",
+		"CE-Source":             "/conformance",
+		"CE-X-Conformance":      "\"value\"",
+		"Content-Type":          "application/json",
+		"X-Private":             "not forwarded",
+	}
+}
+
+func publishBody(id string) []byte {
+	return []byte(fmt.Sprintf(`{"id":%q}`, id))
+}
+
+// recordingBus records the calls made to a Bus.
+type recordingBus struct {
+	bus   buses.Bus
+	calls map[string]bool
+	mutex *sync.Mutex
+}
+
+func newRecordingBus(bus buses.Bus) *recordingBus {
+	return &recordingBus{
+		bus:   bus,
+		calls: make(map[string]bool),
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (b *recordingBus) record(call string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.calls[call] = true
+}
+
+func (b *recordingBus) called(call string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.calls[call]
+}
+
+func (b *recordingBus) Provision(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+	err := b.bus.Provision(channel, parameters)
+	if err == nil {
+		b.record(fmt.Sprintf("provision %s/%s", channel.Namespace, channel.Name))
+	}
+	return err
+}
+
+func (b *recordingBus) Unprovision(channel *channelsv1alpha1.Channel) error {
+	err := b.bus.Unprovision(channel)
+	if err == nil {
+		b.record(fmt.Sprintf("unprovision %s/%s", channel.Namespace, channel.Name))
+	}
+	return err
+}
+
+func (b *recordingBus) ProvisionSubscription(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
+	if provisioner, ok := b.bus.(buses.SubscriptionProvisioner); ok {
+		return provisioner.ProvisionSubscription(subscription, parameters)
+	}
+	return nil
+}
+
+func (b *recordingBus) UnprovisionSubscription(subscription *channelsv1alpha1.Subscription) error {
+	if provisioner, ok := b.bus.(buses.SubscriptionProvisioner); ok {
+		return provisioner.UnprovisionSubscription(subscription)
+	}
+	return nil
+}
+
+func (b *recordingBus) Publish(channel *channelsv1alpha1.Channel, message *buses.Message) error {
+	return b.bus.Publish(channel, message)
+}
+
+func (b *recordingBus) Subscribe(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters, subscriber buses.Subscriber) (buses.SubscriptionHandle, error) {
+	key := fmt.Sprintf("%s/%s", subscription.Namespace, subscription.Name)
+	handle, err := b.bus.Subscribe(subscription, parameters, &recordingSubscriber{
+		Subscriber: subscriber,
+		bus:        b,
+		key:        key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.record("subscribe " + key)
+	return buses.SubscriptionHandleFunc(func() error {
+		err := handle.Close()
+		b.record("close " + key)
+		return err
+	}), nil
+}
+
+// recordingSubscriber records the dispatches that fail, which the bus is to
+// redeliver.
+type recordingSubscriber struct {
+	buses.Subscriber
+	bus *recordingBus
+	key string
+}
+
+func (s *recordingSubscriber) Dispatch(message *buses.Message) error {
+	err := s.Subscriber.Dispatch(message)
+	if err != nil && err != buses.ErrSubscriptionClosed {
+		s.bus.record("dispatch error " + s.key)
+	}
+	return err
+}
+
+// subscriber is a local HTTP subscriber that records the events it receives.
+type subscriber struct {
+	server   *httptest.Server
+	received map[string]*receivedRequest
+	tries    map[string]int
+	// failing fails requests while it returns true
+	failing func() bool
+	mutex   *sync.Mutex
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newSubscriber() *subscriber {
+	s := &subscriber{
+		received: make(map[string]*receivedRequest),
+		tries:    make(map[string]int),
+		mutex:    &sync.Mutex{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *subscriber) handle(res http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	id := req.Header.Get("CE-EventID")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tries[id]++
+	if s.failing != nil && s.failing() {
+		res.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	s.received[id] = &receivedRequest{header: req.Header, body: body}
+}
+
+func (s *subscriber) url() string {
+	return s.server.URL
+}
+
+func (s *subscriber) close() {
+	s.server.Close()
+}
+
+// failUntil fails requests to the subscriber until the condition is met. A
+// nil condition stops failing requests.
+func (s *subscriber) failUntil(condition func() bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if condition == nil {
+		s.failing = nil
+		return
+	}
+	s.failing = func() bool {
+		return !condition()
+	}
+}
+
+func (s *subscriber) attempts(id string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.tries[id]
+}
+
+// await waits for the event to be received.
+func (s *subscriber) await(t *testing.T, id string, timeout time.Duration) *receivedRequest {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mutex.Lock()
+		req, ok := s.received[id]
+		s.mutex.Unlock()
+		if ok {
+			return req
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for event %q at subscriber", id)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
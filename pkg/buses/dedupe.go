@@ -0,0 +1,327 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"container/list"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ArgumentDedupeWindow is the Channel argument that enables deduplication
+	// of received events. Events with the same key received within the window,
+	// as a Go duration string, are dropped before they reach the bus.
+	ArgumentDedupeWindow = "dedupeWindow"
+
+	// ArgumentDedupeKeys is the Channel argument listing the comma separated
+	// keys that identify an event for deduplication. Each key is either one of
+	// the DedupeKey attributes or a message header. It defaults to the
+	// CloudEvents source and ID.
+	ArgumentDedupeKeys = "dedupeKeys"
+
+	// DedupeKeySource is the dedupe key for the source of the CloudEvent.
+	DedupeKeySource = "source"
+
+	// DedupeKeyID is the dedupe key for the ID of the CloudEvent.
+	DedupeKeyID = "id"
+
+	// DedupeKeyType is the dedupe key for the type of the CloudEvent.
+	DedupeKeyType = "type"
+
+	// EnvDedupeRedisAddress is the environment variable holding the host:port
+	// of a Redis server that dispatcher replicas share dedupe state through.
+	// Without it each replica remembers keys in memory on its own.
+	EnvDedupeRedisAddress = "BUS_DEDUPE_REDIS_ADDRESS"
+
+	// EnvDedupeRedisPassword is the environment variable holding the password
+	// dispatchers authenticate to the Redis server with (optional).
+	EnvDedupeRedisPassword = "BUS_DEDUPE_REDIS_PASSWORD"
+
+	// EnvDedupeRedisTLS is the environment variable holding whether
+	// dispatchers connect to the Redis server with TLS. It defaults to false.
+	EnvDedupeRedisTLS = "BUS_DEDUPE_REDIS_TLS"
+)
+
+// DefaultDedupeStoreSize is the number of keys remembered by the in-memory
+// dedupe store used by the buses.
+const DefaultDedupeStoreSize = 10000
+
+// redisDedupePrefix prefixes the keys the buses write to Redis.
+const redisDedupePrefix = "knative-bus-dedupe:"
+
+var defaultDedupeKeys = []string{DedupeKeySource, DedupeKeyID}
+
+var messagesDuplicate = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "knative",
+		Subsystem: "bus",
+		Name:      "messages_duplicate_total",
+		Help:      "Number of duplicate messages dropped by the receiver.",
+	},
+	[]string{"namespace", "channel"},
+)
+
+func init() {
+	prometheus.MustRegister(messagesDuplicate)
+}
+
+// DedupePolicy describes how received events are deduplicated for a Channel.
+type DedupePolicy struct {
+	// Window is how long an event key is remembered.
+	Window time.Duration
+
+	// Keys are the CloudEvent attributes, named by the DedupeKey constants,
+	// and lower case message headers that identify an event.
+	Keys []string
+}
+
+// DedupePolicySource provides the dedupe policy for a channel. A nil policy
+// means events on the channel are not deduplicated.
+type DedupePolicySource interface {
+	DedupePolicy(channel *ChannelReference) *DedupePolicy
+}
+
+// DedupePolicyForChannel reads the dedupe policy from the Channel's arguments.
+// It returns nil if the Channel does not set a dedupe window.
+func DedupePolicyForChannel(channel *channelsv1alpha1.Channel) (*DedupePolicy, error) {
+	if channel.Spec.Arguments == nil {
+		return nil, nil
+	}
+	var policy *DedupePolicy
+	keys := defaultDedupeKeys
+	for _, arg := range *channel.Spec.Arguments {
+		switch arg.Name {
+		case ArgumentDedupeWindow:
+			window, err := time.ParseDuration(arg.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s argument %q: %v", ArgumentDedupeWindow, arg.Value, err)
+			}
+			if window <= 0 {
+				return nil, fmt.Errorf("invalid %s argument %q: must be positive", ArgumentDedupeWindow, arg.Value)
+			}
+			policy = &DedupePolicy{Window: window}
+		case ArgumentDedupeKeys:
+			keys = nil
+			for _, key := range strings.Split(arg.Value, ",") {
+				if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+					keys = append(keys, key)
+				}
+			}
+			if len(keys) == 0 {
+				return nil, fmt.Errorf("invalid %s argument %q: no keys", ArgumentDedupeKeys, arg.Value)
+			}
+		}
+	}
+	if policy != nil {
+		policy.Keys = keys
+	}
+	return policy, nil
+}
+
+// Key returns the dedupe key of a message received on the channel. CloudEvent
+// attributes are read from the parsed event, so events of every spec version
+// in binary and structured mode are keyed alike. An error is returned if the
+// message is not a CloudEvent or is missing any of the keys.
+func (p *DedupePolicy) Key(channel *ChannelReference, message *Message) (string, error) {
+	var context *event.EventContext
+	parts := []string{channel.Namespace, channel.Name}
+	for _, name := range p.Keys {
+		var value string
+		switch name {
+		case DedupeKeySource, DedupeKeyID, DedupeKeyType:
+			if context == nil {
+				var err error
+				if context, err = toEventContext(message); err != nil {
+					return "", fmt.Errorf("unable to read the event %s: %v", name, err)
+				}
+			}
+			value = dedupeAttribute(context, name)
+		default:
+			value, _ = message.header(name)
+		}
+		if value == "" {
+			return "", fmt.Errorf("the event has no %s", name)
+		}
+		parts = append(parts, value)
+	}
+	// quote the parts so values containing the separator can't collide
+	return fmt.Sprintf("%q", parts), nil
+}
+
+// dedupeAttribute returns the attribute of the event context named by a
+// DedupeKey constant.
+func dedupeAttribute(context *event.EventContext, name string) string {
+	switch name {
+	case DedupeKeySource:
+		return context.Source
+	case DedupeKeyID:
+		return context.EventID
+	case DedupeKeyType:
+		return context.EventType
+	}
+	return ""
+}
+
+// DedupeStore remembers event keys for a window of time. Implementations must
+// be safe for concurrent use.
+type DedupeStore interface {
+	// SeenBefore atomically records the key and reports whether it was
+	// already recorded within the window.
+	SeenBefore(key string, window time.Duration) (bool, error)
+
+	// Forget removes the key so a redelivery of the event is accepted. It is
+	// called when an event could not be received by the bus.
+	Forget(key string) error
+}
+
+// lruDedupeStore is an in-memory DedupeStore that holds at most size keys,
+// evicting the least recently seen key when full. Keys are not shared between
+// replicas.
+type lruDedupeStore struct {
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	mutex   *sync.Mutex
+}
+
+type lruDedupeEntry struct {
+	key     string
+	expires time.Time
+}
+
+// NewLRUDedupeStore creates an in-memory DedupeStore remembering up to size
+// keys. It is suitable for buses with a single receiver, like the stub bus.
+// Buses running several dispatcher replicas need a shared store, see
+// NewDedupeStoreFromEnv.
+func NewLRUDedupeStore(size int) DedupeStore {
+	return &lruDedupeStore{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+func (s *lruDedupeStore) SeenBefore(key string, window time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*lruDedupeEntry)
+		if now.Before(entry.expires) {
+			s.order.MoveToFront(element)
+			return true, nil
+		}
+		entry.expires = now.Add(window)
+		s.order.MoveToFront(element)
+		return false, nil
+	}
+
+	s.entries[key] = s.order.PushFront(&lruDedupeEntry{key: key, expires: now.Add(window)})
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruDedupeEntry).key)
+	}
+	return false, nil
+}
+
+func (s *lruDedupeStore) Forget(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.order.Remove(element)
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// NewDedupeStoreFromEnv creates the DedupeStore for a bus dispatcher. It is
+// backed by the Redis server in BUS_DEDUPE_REDIS_ADDRESS if set, so replicas
+// share dedupe state, and is in-memory otherwise. The server is connected to
+// as configured by BUS_DEDUPE_REDIS_PASSWORD and BUS_DEDUPE_REDIS_TLS.
+func NewDedupeStoreFromEnv() (DedupeStore, error) {
+	address := os.Getenv(EnvDedupeRedisAddress)
+	if len(address) == 0 {
+		return NewLRUDedupeStore(DefaultDedupeStoreSize), nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %v", EnvDedupeRedisAddress, address, err)
+	}
+	options := RedisOptions{Password: os.Getenv(EnvDedupeRedisPassword)}
+	if useTLS := os.Getenv(EnvDedupeRedisTLS); len(useTLS) > 0 {
+		b, err := strconv.ParseBool(useTLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q, must be true or false", EnvDedupeRedisTLS, useTLS)
+		}
+		if b {
+			options.TLSConfig = &tls.Config{}
+		}
+	}
+	return NewRedisDedupeStore(NewRedisClient(address, options), redisDedupePrefix), nil
+}
+
+// RedisClient is the subset of Redis commands used by the Redis DedupeStore.
+// Adapters for Redis client libraries, or Redis compatible stores, implement
+// it to share dedupe state between dispatcher replicas.
+type RedisClient interface {
+	// SetNX sets the key to the value with an expiration if the key does not
+	// exist, returning true if the key was set (SET key value NX PX ms).
+	SetNX(key string, value string, expiration time.Duration) (bool, error)
+
+	// Del removes the key.
+	Del(key string) error
+}
+
+type redisDedupeStore struct {
+	client RedisClient
+	prefix string
+}
+
+// NewRedisDedupeStore creates a DedupeStore backed by Redis. Keys are written
+// with the given prefix.
+func NewRedisDedupeStore(client RedisClient, prefix string) DedupeStore {
+	return &redisDedupeStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (s *redisDedupeStore) SeenBefore(key string, window time.Duration) (bool, error) {
+	set, err := s.client.SetNX(s.prefix+key, "1", window)
+	if err != nil {
+		return false, err
+	}
+	return !set, nil
+}
+
+func (s *redisDedupeStore) Forget(key string) error {
+	return s.client.Del(s.prefix + key)
+}
@@ -0,0 +1,313 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+)
+
+func TestDedupePolicyForChannel(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		arguments *[]channelsv1alpha1.Argument
+		want      *DedupePolicy
+		wantErr   bool
+	}{
+		{
+			name: "no arguments",
+		},
+		{
+			name:      "window",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentDedupeWindow, Value: "10m"}},
+			want:      &DedupePolicy{Window: 10 * time.Minute, Keys: []string{DedupeKeySource, DedupeKeyID}},
+		},
+		{
+			name: "custom keys",
+			arguments: &[]channelsv1alpha1.Argument{
+				{Name: ArgumentDedupeKeys, Value: "ID, x-delivery"},
+				{Name: ArgumentDedupeWindow, Value: "1m"},
+			},
+			want: &DedupePolicy{Window: time.Minute, Keys: []string{DedupeKeyID, "x-delivery"}},
+		},
+		{
+			name:      "invalid window",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentDedupeWindow, Value: "forever"}},
+			wantErr:   true,
+		},
+		{
+			name: "empty keys",
+			arguments: &[]channelsv1alpha1.Argument{
+				{Name: ArgumentDedupeWindow, Value: "1m"},
+				{Name: ArgumentDedupeKeys, Value: " , "},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			channel := &channelsv1alpha1.Channel{
+				Spec: channelsv1alpha1.ChannelSpec{Arguments: test.arguments},
+			}
+			got, err := DedupePolicyForChannel(channel)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error. want error %v, got %v", test.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Unexpected dedupe policy. want %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+// identifiedMessage returns a message holding a CloudEvent of a version of the
+// spec with a source and ID, in an encoding.
+func identifiedMessage(t *testing.T, encoding event.HTTPMarshaller, version, source, id string) *Message {
+	req, err := encoding.NewRequest("http://subscriber/", map[string]string{"hello": "world"}, event.EventContext{
+		CloudEventsVersion: version,
+		EventID:            id,
+		EventType:          "dev.knative.test",
+		Source:             source,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating event: %v", err)
+	}
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error reading event: %v", err)
+	}
+	message := &Message{Headers: map[string]string{}, Payload: payload}
+	for name := range req.Header {
+		message.Headers[name] = req.Header.Get(name)
+	}
+	return message
+}
+
+func TestDedupePolicyKey(t *testing.T) {
+	channel := &ChannelReference{Namespace: "ns", Name: "channel"}
+	policy := &DedupePolicy{Window: time.Minute, Keys: defaultDedupeKeys}
+	want := `["ns" "channel" "tests://dedupe" "1234"]`
+
+	for _, test := range []struct {
+		name    string
+		policy  *DedupePolicy
+		message *Message
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "binary 0.1 event",
+			message: identifiedMessage(t, event.Binary, event.CloudEventsVersion01, "tests://dedupe", "1234"),
+			want:    want,
+		},
+		{
+			name:    "binary 0.2 event",
+			message: identifiedMessage(t, event.Binary, event.CloudEventsVersion02, "tests://dedupe", "1234"),
+			want:    want,
+		},
+		{
+			name:    "binary 1.0 event",
+			message: identifiedMessage(t, event.Binary, event.CloudEventsVersion10, "tests://dedupe", "1234"),
+			want:    want,
+		},
+		{
+			name:    "structured 1.0 event",
+			message: identifiedMessage(t, event.Structured, event.CloudEventsVersion10, "tests://dedupe", "1234"),
+			want:    want,
+		},
+		{
+			name:    "structured 0.1 event",
+			message: identifiedMessage(t, event.Structured, event.CloudEventsVersion01, "tests://dedupe", "1234"),
+			want:    want,
+		},
+		{
+			name:    "not a CloudEvent",
+			message: &Message{Headers: map[string]string{}, Payload: []byte("hello")},
+			wantErr: true,
+		},
+		{
+			name:   "header key",
+			policy: &DedupePolicy{Window: time.Minute, Keys: []string{DedupeKeyID, "x-delivery"}},
+			message: func() *Message {
+				message := identifiedMessage(t, event.Binary, event.CloudEventsVersion10, "tests://dedupe", "1234")
+				message.Headers["X-Delivery"] = "7"
+				return message
+			}(),
+			want: `["ns" "channel" "1234" "7"]`,
+		},
+		{
+			name:    "missing header key",
+			policy:  &DedupePolicy{Window: time.Minute, Keys: []string{DedupeKeyID, "x-delivery"}},
+			message: identifiedMessage(t, event.Binary, event.CloudEventsVersion10, "tests://dedupe", "1234"),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := test.policy
+			if p == nil {
+				p = policy
+			}
+			got, err := p.Key(channel, test.message)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error. want error %v, got %v", test.wantErr, err)
+			}
+			if got != test.want {
+				t.Errorf("Unexpected dedupe key. want %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLRUDedupeStore(t *testing.T) {
+	store := NewLRUDedupeStore(2)
+
+	for _, step := range []struct {
+		key    string
+		window time.Duration
+		want   bool
+	}{
+		{key: "a", window: time.Hour, want: false},
+		{key: "a", window: time.Hour, want: true},
+		{key: "b", window: time.Hour, want: false},
+		// a was seen more recently than b, so b is evicted
+		{key: "a", window: time.Hour, want: true},
+		{key: "c", window: time.Hour, want: false},
+		{key: "b", window: time.Hour, want: false},
+		// expired keys are not duplicates
+		{key: "d", window: -time.Second, want: false},
+		{key: "d", window: time.Hour, want: false},
+	} {
+		got, err := store.SeenBefore(step.key, step.window)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != step.want {
+			t.Errorf("Unexpected result for %q. want %v, got %v", step.key, step.want, got)
+		}
+	}
+
+	store.Forget("d")
+	if seen, _ := store.SeenBefore("d", time.Hour); seen {
+		t.Errorf("Expected forgotten key not to be seen")
+	}
+}
+
+type fakeRedisClient struct {
+	keys map[string]string
+}
+
+func (c *fakeRedisClient) SetNX(key string, value string, expiration time.Duration) (bool, error) {
+	if _, ok := c.keys[key]; ok {
+		return false, nil
+	}
+	c.keys[key] = value
+	return true, nil
+}
+
+func (c *fakeRedisClient) Del(key string) error {
+	delete(c.keys, key)
+	return nil
+}
+
+func TestRedisDedupeStore(t *testing.T) {
+	client := &fakeRedisClient{keys: map[string]string{}}
+	store := NewRedisDedupeStore(client, "dedupe:")
+
+	if seen, _ := store.SeenBefore("a", time.Minute); seen {
+		t.Errorf("Expected first key not to be seen")
+	}
+	if _, ok := client.keys["dedupe:a"]; !ok {
+		t.Errorf("Expected prefixed key to be set, got %v", client.keys)
+	}
+	if seen, _ := store.SeenBefore("a", time.Minute); !seen {
+		t.Errorf("Expected repeated key to be seen")
+	}
+	store.Forget("a")
+	if seen, _ := store.SeenBefore("a", time.Minute); seen {
+		t.Errorf("Expected forgotten key not to be seen")
+	}
+}
+
+type staticDedupePolicySource struct {
+	policy *DedupePolicy
+}
+
+func (s staticDedupePolicySource) DedupePolicy(*ChannelReference) *DedupePolicy {
+	return s.policy
+}
+
+func TestMessageReceiverDedupe(t *testing.T) {
+	received := 0
+	fail := false
+	receiver := NewMessageReceiver(func(*ChannelReference, *Message) error {
+		if fail {
+			return errors.New("backing store unavailable")
+		}
+		received++
+		return nil
+	})
+	receiver.SetDeduplicator(
+		staticDedupePolicySource{policy: &DedupePolicy{Window: time.Minute, Keys: defaultDedupeKeys}},
+		NewLRUDedupeStore(DefaultDedupeStoreSize),
+	)
+
+	send := func(message *Message) int {
+		req := httptest.NewRequest(http.MethodPost, "http://channel.ns.channels.cluster.local/", bytes.NewReader(message.Payload))
+		for name, value := range message.Headers {
+			req.Header.Set(name, value)
+		}
+		res := httptest.NewRecorder()
+		receiver.HandleRequest(res, req)
+		return res.Code
+	}
+	binary := func(source, id string) *Message {
+		return identifiedMessage(t, event.Binary, event.CloudEventsVersion10, source, id)
+	}
+	structured := func(source, id string) *Message {
+		return identifiedMessage(t, event.Structured, event.CloudEventsVersion10, source, id)
+	}
+	opaque := &Message{Headers: map[string]string{}, Payload: []byte("hello")}
+
+	send(binary("github", "1"))
+	send(binary("github", "1"))
+	send(structured("github", "1"))
+	send(binary("gcppubsub", "1"))
+	send(structured("github", "2"))
+	send(opaque)
+	send(opaque)
+	if received != 5 {
+		t.Errorf("Unexpected number of received messages. want 5, got %d", received)
+	}
+
+	fail = true
+	if code := send(binary("github", "4")); code != http.StatusInternalServerError {
+		t.Errorf("Unexpected status code. want %d, got %d", http.StatusInternalServerError, code)
+	}
+	fail = false
+	send(binary("github", "4"))
+	if received != 6 {
+		t.Errorf("Expected redelivery after a failure to be received. want 6, got %d", received)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+)
+
+// DeliveryTime returns the earliest time a message may be delivered to a
+// subscriber. It is the later of the time requested by the publisher with the
+// "deliverat" extension and the time the message was received plus the
+// Subscription's delivery delay. A zero time means the message may be
+// delivered immediately.
+func DeliveryTime(message *Message, subscription channelsv1alpha1.SubscriptionSpec) time.Time {
+	var at time.Time
+	if deliverAt, ok := message.deliverAt(); ok {
+		at = deliverAt
+	}
+	if subscription.DeliveryDelay != nil && subscription.DeliveryDelay.Duration > 0 {
+		received, ok := message.timeHeader(HeaderIngestionTime)
+		if !ok {
+			received = time.Now()
+		}
+		if delayed := received.Add(subscription.DeliveryDelay.Duration); delayed.After(at) {
+			at = delayed
+		}
+	}
+	return at
+}
+
+// checkDeliveryDelay returns an error if the Bus can not hold messages for the
+// Subscription's delivery delay.
+func checkDeliveryDelay(bus Bus, subscription channelsv1alpha1.SubscriptionSpec) error {
+	limiter, ok := bus.(DeliveryDelayLimiter)
+	if !ok || subscription.DeliveryDelay == nil {
+		return nil
+	}
+	if max := limiter.MaxDeliveryDelay(); subscription.DeliveryDelay.Duration > max {
+		return fmt.Errorf("delivery delay %v is longer than the bus allows, %v", subscription.DeliveryDelay.Duration, max)
+	}
+	return nil
+}
+
+// checkDeliverAt returns an *InvalidEventError if the message requests a
+// delivery time further away than the Bus can hold messages for.
+func checkDeliverAt(bus Bus, message *Message) error {
+	limiter, ok := bus.(DeliveryDelayLimiter)
+	if !ok {
+		return nil
+	}
+	at, ok := message.deliverAt()
+	if !ok {
+		return nil
+	}
+	if max := limiter.MaxDeliveryDelay(); time.Until(at) > max {
+		return &InvalidEventError{Reason: fmt.Sprintf("%s %v is more than %v away", ExtensionDeliverAt, at, max)}
+	}
+	return nil
+}
+
+// WaitForDelivery blocks until the delivery time has passed. It returns false
+// if the stop channel is closed first, in which case the message should not
+// be delivered.
+func WaitForDelivery(at time.Time, stopCh <-chan struct{}) bool {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
+
+// deliverAt returns the time requested with the "deliverat" extension of the
+// event carried by the message. Messages that are not CloudEvents may set the
+// HeaderDeliverAt header. The time is parsed once per message.
+func (m *Message) deliverAt() (time.Time, bool) {
+	m.deliverAtOnce.Do(func() {
+		m.deliverAtTime, m.deliverAtSet = m.parseDeliverAt()
+	})
+	return m.deliverAtTime, m.deliverAtSet
+}
+
+// parseDeliverAt reads the delivery time requested by the message from the
+// context of its event, or from its HeaderDeliverAt header.
+func (m *Message) parseDeliverAt() (time.Time, bool) {
+	context, err := toEventContext(m)
+	if err != nil {
+		return m.timeHeader(HeaderDeliverAt)
+	}
+	for name, value := range context.Extensions {
+		// binary 0.1 extension names keep the case of their header
+		if !strings.EqualFold(name, ExtensionDeliverAt) {
+			continue
+		}
+		if s, ok := value.(string); ok {
+			return parseTime(ExtensionDeliverAt, s)
+		}
+		glog.Warningf("Ignoring %s extension that is not a string: %v", ExtensionDeliverAt, value)
+		return time.Time{}, false
+	}
+	return time.Time{}, false
+}
+
+// timeHeader parses a timestamp header of the message. CloudEvents extension
+// headers may be JSON encoded, so a quoted value is accepted as well.
+func (m *Message) timeHeader(name string) (time.Time, bool) {
+	value, ok := m.header(name)
+	if !ok || len(value) == 0 {
+		return time.Time{}, false
+	}
+	var unquoted string
+	if err := json.Unmarshal([]byte(value), &unquoted); err == nil {
+		value = unquoted
+	}
+	return parseTime(name, value)
+}
+
+// parseTime parses the RFC 3339 value of the named header or attribute.
+func parseTime(name, value string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		glog.Warningf("Ignoring invalid %s %q: %v", name, value, err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// maxScheduledDeliveries is the number of messages a memoryScheduler holds
+// before it rejects more.
+const maxScheduledDeliveries = 10000
+
+// ErrTooManyScheduled is returned by Subscriber.Dispatch when a message that
+// is not due yet can not be held, as too many messages are already waiting for
+// their delivery time.
+var ErrTooManyScheduled = errors.New("too many messages waiting for their delivery time")
+
+// memoryScheduler holds messages in memory until their delivery time. Run uses
+// it for buses that can not hold messages themselves, like the stub bus. It
+// holds up to limit messages. Scheduled deliveries are lost if the process
+// exits.
+type memoryScheduler struct {
+	index  deliveryIndex
+	limit  int
+	mutex  *sync.Mutex
+	wakeCh chan struct{}
+}
+
+func newMemoryScheduler(limit int) *memoryScheduler {
+	return &memoryScheduler{
+		limit:  limit,
+		mutex:  &sync.Mutex{},
+		wakeCh: make(chan struct{}, 1),
+	}
+}
+
+// schedule runs the deliver func once the delivery time has passed. Messages
+// that are already due are delivered immediately on a new goroutine.
+// ErrTooManyScheduled is returned if the scheduler is full.
+func (s *memoryScheduler) schedule(at time.Time, deliver func()) error {
+	if !at.After(time.Now()) {
+		go deliver()
+		return nil
+	}
+
+	s.mutex.Lock()
+	if s.index.Len() >= s.limit {
+		s.mutex.Unlock()
+		return ErrTooManyScheduled
+	}
+	heap.Push(&s.index, &scheduledDelivery{at: at, deliver: deliver})
+	s.mutex.Unlock()
+
+	// wake the scheduler in case this delivery is due before the current one
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run delivers scheduled messages as they become due. This method will block
+// until the stop channel is closed; pending deliveries are dropped.
+func (s *memoryScheduler) run(stopCh <-chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		next := s.deliverDue()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
+		select {
+		case <-timer.C:
+		case <-s.wakeCh:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// pending returns the number of messages waiting for their delivery time.
+func (s *memoryScheduler) pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.index.Len()
+}
+
+// deliverDue starts delivery of every message that is due and returns how
+// long to wait for the next one.
+func (s *memoryScheduler) deliverDue() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for s.index.Len() > 0 {
+		next := s.index[0]
+		if next.at.After(now) {
+			return next.at.Sub(now)
+		}
+		heap.Pop(&s.index)
+		go next.deliver()
+	}
+	return time.Hour
+}
+
+type scheduledDelivery struct {
+	at      time.Time
+	deliver func()
+}
+
+// deliveryIndex is a min-heap of scheduled deliveries ordered by delivery
+// time.
+type deliveryIndex []*scheduledDelivery
+
+func (i deliveryIndex) Len() int            { return len(i) }
+func (i deliveryIndex) Less(a, b int) bool  { return i[a].at.Before(i[b].at) }
+func (i deliveryIndex) Swap(a, b int)       { i[a], i[b] = i[b], i[a] }
+func (i *deliveryIndex) Push(x interface{}) { *i = append(*i, x.(*scheduledDelivery)) }
+func (i *deliveryIndex) Pop() interface{} {
+	old := *i
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*i = old[:n-1]
+	return item
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeliveryTime(t *testing.T) {
+	received := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name    string
+		headers map[string]string
+		delay   time.Duration
+		want    time.Time
+	}{
+		{
+			name:    "immediate",
+			headers: map[string]string{HeaderIngestionTime: received.Format(time.RFC3339Nano)},
+		},
+		{
+			name:    "subscription delay",
+			headers: map[string]string{HeaderIngestionTime: received.Format(time.RFC3339Nano)},
+			delay:   time.Minute,
+			want:    received.Add(time.Minute),
+		},
+		{
+			name: "deliver at",
+			headers: map[string]string{
+				"CE-X-DeliverAt":    received.Add(time.Hour).Format(time.RFC3339),
+				HeaderIngestionTime: received.Format(time.RFC3339Nano),
+			},
+			want: received.Add(time.Hour),
+		},
+		{
+			name: "quoted deliver at",
+			headers: map[string]string{
+				HeaderDeliverAt: `"` + received.Add(time.Hour).Format(time.RFC3339) + `"`,
+			},
+			want: received.Add(time.Hour),
+		},
+		{
+			name: "later of deliver at and delay",
+			headers: map[string]string{
+				HeaderDeliverAt:     received.Add(time.Second).Format(time.RFC3339),
+				HeaderIngestionTime: received.Format(time.RFC3339Nano),
+			},
+			delay: time.Minute,
+			want:  received.Add(time.Minute),
+		},
+		{
+			name: "invalid deliver at",
+			headers: map[string]string{
+				HeaderDeliverAt: "tomorrow",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			message := &Message{Headers: test.headers}
+			subscription := channelsv1alpha1.SubscriptionSpec{}
+			if test.delay != 0 {
+				subscription.DeliveryDelay = &metav1.Duration{Duration: test.delay}
+			}
+			if got := DeliveryTime(message, subscription); !got.Equal(test.want) {
+				t.Errorf("Unexpected delivery time. want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+// deliverAtEvent returns a message carrying a CloudEvent whose "deliverat"
+// extension is at.
+func deliverAtEvent(t *testing.T, encoding event.HTTPMarshaller, version string, at time.Time) *Message {
+	req, err := encoding.NewRequest("http://subscriber/", map[string]string{"hello": "world"}, event.EventContext{
+		CloudEventsVersion: version,
+		EventID:            "1234",
+		EventType:          "dev.knative.test",
+		Source:             "tests://delivery",
+		Extensions:         map[string]interface{}{ExtensionDeliverAt: at.Format(time.RFC3339)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating event: %v", err)
+	}
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error reading event: %v", err)
+	}
+	message := &Message{Headers: map[string]string{}, Payload: payload}
+	for name, values := range req.Header {
+		// 0.1 extension headers are not in canonical form
+		message.Headers[name] = values[0]
+	}
+	return message
+}
+
+func TestDeliveryTimeEvent(t *testing.T) {
+	at := time.Date(2018, 7, 1, 13, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name     string
+		encoding event.HTTPMarshaller
+		version  string
+	}{
+		{"binary 0.1 event", event.Binary, event.CloudEventsVersion01},
+		{"binary 0.2 event", event.Binary, event.CloudEventsVersion02},
+		{"binary 1.0 event", event.Binary, event.CloudEventsVersion10},
+		{"structured 0.1 event", event.Structured, event.CloudEventsVersion01},
+		{"structured 1.0 event", event.Structured, event.CloudEventsVersion10},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			message := deliverAtEvent(t, test.encoding, test.version, at)
+			if got := DeliveryTime(message, channelsv1alpha1.SubscriptionSpec{}); !got.Equal(at) {
+				t.Errorf("Unexpected delivery time. want %v, got %v", at, got)
+			}
+		})
+	}
+}
+
+func TestCheckDeliverAt(t *testing.T) {
+	deliverAt := func(d time.Duration) *Message {
+		return &Message{Headers: map[string]string{
+			HeaderDeliverAt: time.Now().Add(d).Format(time.RFC3339Nano),
+		}}
+	}
+
+	if err := checkDeliverAt(&limitingBus{}, deliverAt(time.Minute)); err != nil {
+		t.Errorf("Unexpected error for a delivery time within the limit: %v", err)
+	}
+	if err := checkDeliverAt(&limitingBus{}, deliverAt(2*time.Hour)); err == nil {
+		t.Errorf("Expected a delivery time beyond the limit to be rejected")
+	} else if _, ok := err.(*InvalidEventError); !ok {
+		t.Errorf("Unexpected error type. want *InvalidEventError, got %T", err)
+	}
+	if err := checkDeliverAt(&fakeBus{}, deliverAt(2*time.Hour)); err != nil {
+		t.Errorf("Unexpected error for a bus without a limit: %v", err)
+	}
+	structured := deliverAtEvent(t, event.Structured, event.CloudEventsVersion10, time.Now().Add(2*time.Hour))
+	if err := checkDeliverAt(&limitingBus{}, structured); err == nil {
+		t.Errorf("Expected a structured event delivered beyond the limit to be rejected")
+	}
+}
+
+func TestDeliverAtParsedOnce(t *testing.T) {
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	message := &Message{Headers: map[string]string{
+		HeaderDeliverAt: at.Format(time.RFC3339Nano),
+	}}
+	if got, ok := message.deliverAt(); !ok || !got.Equal(at) {
+		t.Fatalf("Unexpected delivery time. want %v, got %v (%v)", at, got, ok)
+	}
+
+	// the time is not parsed again, subscribers share the first result
+	message.Headers[HeaderDeliverAt] = "invalid"
+	if got, ok := message.deliverAt(); !ok || !got.Equal(at) {
+		t.Errorf("Unexpected delivery time after the first parse. want %v, got %v (%v)", at, got, ok)
+	}
+}
+
+func TestMemoryScheduler(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	scheduler := newMemoryScheduler(maxScheduledDeliveries)
+	go scheduler.run(stopCh)
+
+	var mutex sync.Mutex
+	var delivered []int
+	done := make(chan struct{}, 3)
+	deliver := func(i int) func() {
+		return func() {
+			mutex.Lock()
+			delivered = append(delivered, i)
+			mutex.Unlock()
+			done <- struct{}{}
+		}
+	}
+
+	now := time.Now()
+	scheduler.schedule(now.Add(200*time.Millisecond), deliver(2))
+	scheduler.schedule(now.Add(100*time.Millisecond), deliver(1))
+	scheduler.schedule(time.Time{}, deliver(0))
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for scheduled deliveries, got %v", delivered)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i, got := range delivered {
+		if got != i {
+			t.Fatalf("Unexpected delivery order. want [0 1 2], got %v", delivered)
+		}
+	}
+	if pending := scheduler.pending(); pending != 0 {
+		t.Errorf("Unexpected pending deliveries. want 0, got %d", pending)
+	}
+}
+
+func TestMemorySchedulerLimit(t *testing.T) {
+	scheduler := newMemoryScheduler(2)
+	later := time.Now().Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := scheduler.schedule(later, func() {}); err != nil {
+			t.Fatalf("Unexpected error scheduling delivery %d: %v", i, err)
+		}
+	}
+	if err := scheduler.schedule(later, func() {}); err != ErrTooManyScheduled {
+		t.Errorf("Unexpected error scheduling beyond the limit. want %v, got %v", ErrTooManyScheduled, err)
+	}
+	// due messages are not held, so they are not limited
+	done := make(chan struct{})
+	if err := scheduler.schedule(time.Time{}, func() { close(done) }); err != nil {
+		t.Errorf("Unexpected error scheduling a due delivery: %v", err)
+	}
+	<-done
+	if pending := scheduler.pending(); pending != 2 {
+		t.Errorf("Unexpected pending deliveries. want 2, got %d", pending)
+	}
+}
+
+func TestWaitForDeliveryStopped(t *testing.T) {
+	stopCh := make(chan struct{})
+	close(stopCh)
+	if WaitForDelivery(time.Now().Add(time.Hour), stopCh) {
+		t.Errorf("Expected delivery to be abandoned when stopped")
+	}
+	if !WaitForDelivery(time.Time{}, stopCh) {
+		t.Errorf("Expected due delivery to proceed")
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"fmt"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ArgumentMessageTTL is the Channel argument that sets how long after the
+	// event time a message may still be delivered, as a Go duration string.
+	// It is handled by every bus and does not need to be declared as a Bus
+	// parameter.
+	ArgumentMessageTTL = "messageTTL"
+
+	// ArgumentDeadLetterTarget is the Channel argument naming where expired
+	// messages are sent instead of being dropped. It is resolved like a
+	// subscriber.
+	ArgumentDeadLetterTarget = "deadLetterTarget"
+)
+
+var messagesExpired = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "knative",
+		Subsystem: "bus",
+		Name:      "messages_expired_total",
+		Help:      "Number of messages that exceeded their Channel's TTL before dispatch.",
+	},
+	[]string{"namespace", "channel", "action"},
+)
+
+func init() {
+	prometheus.MustRegister(messagesExpired)
+}
+
+// ExpiryPolicy describes how long messages on a Channel remain deliverable and
+// what happens to them once they expire.
+type ExpiryPolicy struct {
+	// TTL is the maximum age of a message at dispatch time.
+	TTL time.Duration
+
+	// DeadLetterTarget receives expired messages. Expired messages are
+	// dropped if it is empty.
+	DeadLetterTarget string
+}
+
+// ExpiryPolicySource provides the expiry policy for a channel. A nil policy
+// means messages on the channel never expire.
+type ExpiryPolicySource interface {
+	ExpiryPolicy(channel *ChannelReference) *ExpiryPolicy
+}
+
+// ExpiryPolicyForChannel reads the expiry policy from the Channel's arguments.
+// It returns nil if the Channel does not set a message TTL.
+func ExpiryPolicyForChannel(channel *channelsv1alpha1.Channel) (*ExpiryPolicy, error) {
+	if channel.Spec.Arguments == nil {
+		return nil, nil
+	}
+	var policy *ExpiryPolicy
+	var deadLetterTarget string
+	for _, arg := range *channel.Spec.Arguments {
+		switch arg.Name {
+		case ArgumentMessageTTL:
+			ttl, err := time.ParseDuration(arg.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s argument %q: %v", ArgumentMessageTTL, arg.Value, err)
+			}
+			if ttl <= 0 {
+				return nil, fmt.Errorf("invalid %s argument %q: must be positive", ArgumentMessageTTL, arg.Value)
+			}
+			policy = &ExpiryPolicy{TTL: ttl}
+		case ArgumentDeadLetterTarget:
+			deadLetterTarget = arg.Value
+		}
+	}
+	if policy != nil {
+		policy.DeadLetterTarget = deadLetterTarget
+	}
+	return policy, nil
+}
+
+// Expired returns true if the message is older than the TTL. The age is
+// measured from the CloudEvent's event time, in any version of the spec and
+// either encoding, falling back to the time the message was received by the
+// bus. Messages without either are never expired.
+func (p *ExpiryPolicy) Expired(message *Message, now time.Time) bool {
+	if p == nil {
+		return false
+	}
+	since, ok := eventTime(message)
+	if !ok {
+		since, ok = message.timeHeader(HeaderIngestionTime)
+	}
+	if !ok {
+		return false
+	}
+	return now.Sub(since) > p.TTL
+}
+
+// eventTime returns the time of the CloudEvent held by the message, if the
+// message is a CloudEvent with a time.
+func eventTime(message *Message) (time.Time, bool) {
+	eventContext, err := toEventContext(message)
+	if err != nil || eventContext.EventTime.IsZero() {
+		return time.Time{}, false
+	}
+	return eventContext.EventTime, true
+}
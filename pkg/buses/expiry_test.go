@@ -0,0 +1,258 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+)
+
+func TestExpiryPolicyForChannel(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		arguments *[]channelsv1alpha1.Argument
+		want      *ExpiryPolicy
+		wantErr   bool
+	}{
+		{
+			name: "no arguments",
+		},
+		{
+			name:      "ttl",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentMessageTTL, Value: "1h"}},
+			want:      &ExpiryPolicy{TTL: time.Hour},
+		},
+		{
+			name: "ttl with dead-letter target",
+			arguments: &[]channelsv1alpha1.Argument{
+				{Name: ArgumentDeadLetterTarget, Value: "expired"},
+				{Name: ArgumentMessageTTL, Value: "30s"},
+			},
+			want: &ExpiryPolicy{TTL: 30 * time.Second, DeadLetterTarget: "expired"},
+		},
+		{
+			name:      "dead-letter target without ttl",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentDeadLetterTarget, Value: "expired"}},
+		},
+		{
+			name:      "invalid ttl",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentMessageTTL, Value: "an hour"}},
+			wantErr:   true,
+		},
+		{
+			name:      "negative ttl",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentMessageTTL, Value: "-1h"}},
+			wantErr:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			channel := &channelsv1alpha1.Channel{
+				Spec: channelsv1alpha1.ChannelSpec{Arguments: test.arguments},
+			}
+			got, err := ExpiryPolicyForChannel(channel)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error. want error %v, got %v", test.wantErr, err)
+			}
+			if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+				t.Errorf("Unexpected expiry policy. want %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+// timedMessage returns a message holding a CloudEvent of a version of the spec
+// which occurred at a time, in an encoding.
+func timedMessage(t *testing.T, encoding event.HTTPMarshaller, version string, eventTime time.Time) *Message {
+	req, err := encoding.NewRequest("http://subscriber/", map[string]string{"hello": "world"}, event.EventContext{
+		CloudEventsVersion: version,
+		EventID:            "1234",
+		EventTime:          eventTime,
+		EventType:          "dev.knative.test",
+		Source:             "tests://expiry",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating event: %v", err)
+	}
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error reading event: %v", err)
+	}
+	message := &Message{Headers: map[string]string{}, Payload: payload}
+	for name := range req.Header {
+		message.Headers[name] = req.Header.Get(name)
+	}
+	return message
+}
+
+func TestExpiryPolicyExpired(t *testing.T) {
+	now := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+	policy := &ExpiryPolicy{TTL: time.Minute}
+	stale := now.Add(-time.Hour)
+	fresh := now.Add(-time.Second)
+
+	withIngestionTime := func(message *Message, ingested time.Time) *Message {
+		message.Headers[HeaderIngestionTime] = ingested.Format(time.RFC3339Nano)
+		return message
+	}
+
+	for _, test := range []struct {
+		name    string
+		policy  *ExpiryPolicy
+		message *Message
+		want    bool
+	}{
+		{
+			name:    "no policy",
+			message: timedMessage(t, event.Binary, event.CloudEventsVersion01, stale),
+		},
+		{
+			name:    "fresh event",
+			policy:  policy,
+			message: timedMessage(t, event.Binary, event.CloudEventsVersion01, fresh),
+		},
+		{
+			name:    "stale event",
+			policy:  policy,
+			message: timedMessage(t, event.Binary, event.CloudEventsVersion01, stale),
+			want:    true,
+		},
+		{
+			name:    "stale 0.2 event",
+			policy:  policy,
+			message: timedMessage(t, event.Binary, event.CloudEventsVersion02, stale),
+			want:    true,
+		},
+		{
+			name:    "stale 1.0 event",
+			policy:  policy,
+			message: timedMessage(t, event.Binary, event.CloudEventsVersion10, stale),
+			want:    true,
+		},
+		{
+			name:    "stale structured event",
+			policy:  policy,
+			message: timedMessage(t, event.Structured, event.CloudEventsVersion10, stale),
+			want:    true,
+		},
+		{
+			name:    "fresh structured event",
+			policy:  policy,
+			message: withIngestionTime(timedMessage(t, event.Structured, event.CloudEventsVersion10, fresh), stale),
+		},
+		{
+			name:    "stale ingestion time",
+			policy:  policy,
+			message: &Message{Headers: map[string]string{HeaderIngestionTime: stale.Format(time.RFC3339Nano)}},
+			want:    true,
+		},
+		{
+			name:    "event time takes precedence",
+			policy:  policy,
+			message: withIngestionTime(timedMessage(t, event.Binary, event.CloudEventsVersion02, fresh), stale),
+		},
+		{
+			name:    "no timestamp",
+			policy:  policy,
+			message: &Message{},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Expired(test.message, now); got != test.want {
+				t.Errorf("Unexpected expiry. want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+type staticExpiryPolicySource struct {
+	policy *ExpiryPolicy
+}
+
+func (s staticExpiryPolicySource) ExpiryPolicy(*ChannelReference) *ExpiryPolicy {
+	return s.policy
+}
+
+func TestDispatchChannelMessageExpiry(t *testing.T) {
+	stale := timedMessage(t, event.Binary, event.CloudEventsVersion10, time.Now().Add(-time.Hour))
+	fresh := timedMessage(t, event.Binary, event.CloudEventsVersion10, time.Now())
+
+	for _, test := range []struct {
+		name           string
+		policy         *ExpiryPolicy
+		message        *Message
+		wantSubscriber bool
+		wantDeadLetter bool
+	}{
+		{
+			name:           "no policy",
+			message:        stale,
+			wantSubscriber: true,
+		},
+		{
+			name:           "fresh",
+			policy:         &ExpiryPolicy{TTL: time.Minute},
+			message:        fresh,
+			wantSubscriber: true,
+		},
+		{
+			name:    "expired and dropped",
+			policy:  &ExpiryPolicy{TTL: time.Minute},
+			message: stale,
+		},
+		{
+			name:           "expired and dead-lettered",
+			policy:         &ExpiryPolicy{TTL: time.Minute, DeadLetterTarget: "dead-letter"},
+			message:        stale,
+			wantDeadLetter: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var gotSubscriber, gotDeadLetter bool
+			subscriber := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				gotSubscriber = true
+			}))
+			defer subscriber.Close()
+			deadLetter := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				gotDeadLetter = true
+			}))
+			defer deadLetter.Close()
+
+			if test.policy != nil && test.policy.DeadLetterTarget != "" {
+				test.policy.DeadLetterTarget = deadLetter.URL
+			}
+			dispatcher := NewMessageDispatcher()
+			dispatcher.SetExpiryPolicySource(staticExpiryPolicySource{policy: test.policy})
+
+			channel := &ChannelReference{Namespace: "ns", Name: "channel"}
+			if err := dispatcher.DispatchChannelMessage(channel, subscriber.URL, "ns", test.message); err != nil {
+				t.Fatalf("Unexpected dispatch error: %v", err)
+			}
+			if gotSubscriber != test.wantSubscriber {
+				t.Errorf("Unexpected delivery to subscriber. want %v, got %v", test.wantSubscriber, gotSubscriber)
+			}
+			if gotDeadLetter != test.wantDeadLetter {
+				t.Errorf("Unexpected delivery to dead-letter target. want %v, got %v", test.wantDeadLetter, gotDeadLetter)
+			}
+		})
+	}
+}
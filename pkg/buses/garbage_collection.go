@@ -0,0 +1,417 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// EnvGarbageCollectionInterval is the environment variable holding how
+	// often the provisioner looks for orphaned Channel resources, as a
+	// duration. Zero disables garbage collection.
+	EnvGarbageCollectionInterval = "BUS_GC_INTERVAL"
+
+	// EnvGarbageCollectionDryRun is the environment variable holding whether
+	// orphaned Channel resources are only reported rather than deleted. It
+	// defaults to true.
+	EnvGarbageCollectionDryRun = "BUS_GC_DRY_RUN"
+
+	// DefaultGarbageCollectionInterval is how often orphaned Channel
+	// resources are looked for by default.
+	DefaultGarbageCollectionInterval = 10 * time.Minute
+
+	// ChannelFinalizer is the finalizer the provisioner adds to the Channels
+	// it provisions. Deleting a Channel waits for the provisioner to
+	// unprovision it and remove the finalizer, or for the Bus to be deleted.
+	ChannelFinalizer = channelsv1alpha1.ChannelProvisionerFinalizer
+
+	// channelResourcesKey is the key of the ledger ConfigMap's data holding
+	// the recorded resources, one per line.
+	channelResourcesKey = "resources"
+)
+
+var orphanedResources = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "knative",
+		Subsystem: "bus",
+		Name:      "orphaned_resources_total",
+		Help:      "Number of orphaned Channel resources found by garbage collection.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(orphanedResources)
+}
+
+// GarbageCollectionConfig configures the periodic sweep for orphaned Channel
+// resources.
+type GarbageCollectionConfig struct {
+	// Interval is how often to sweep.
+	Interval time.Duration
+
+	// DryRun reports orphaned resources without deleting them.
+	DryRun bool
+
+	// Namespace and Name identify the ConfigMap recording the resources the
+	// provisioner provisioned. It is created if it doesn't exist.
+	Namespace string
+	Name      string
+}
+
+// NewGarbageCollectionConfigFromEnv creates a garbage collection configuration
+// for the bus from the BUS_GC_INTERVAL and BUS_GC_DRY_RUN environment
+// variables. Resources are recorded on a ConfigMap in the POD_NAMESPACE
+// namespace, or the Bus' namespace.
+func NewGarbageCollectionConfigFromEnv(bus *BusReference) (GarbageCollectionConfig, error) {
+	namespace, name, err := busConfigMap(bus, "resources")
+	if err != nil {
+		return GarbageCollectionConfig{}, err
+	}
+	config := GarbageCollectionConfig{
+		Interval:  DefaultGarbageCollectionInterval,
+		DryRun:    true,
+		Namespace: namespace,
+		Name:      name,
+	}
+	if interval := os.Getenv(EnvGarbageCollectionInterval); len(interval) > 0 {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < 0 {
+			return config, fmt.Errorf("invalid %s %q, must be a positive duration", EnvGarbageCollectionInterval, interval)
+		}
+		config.Interval = d
+	}
+	if dryRun := os.Getenv(EnvGarbageCollectionDryRun); len(dryRun) > 0 {
+		b, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s %q, must be true or false", EnvGarbageCollectionDryRun, dryRun)
+		}
+		config.DryRun = b
+	}
+	return config, nil
+}
+
+// garbageCollector holds the configuration of a Monitor's sweep for orphaned
+// Channel resources.
+type garbageCollector struct {
+	collector ChannelResourceCollector
+	config    GarbageCollectionConfig
+	ledger    *channelResourceLedger
+}
+
+// SetGarbageCollection makes the monitor periodically look for backing
+// resources of Channels that no longer exist, and delete them unless the
+// config is a dry run. Only the leader collects garbage. It must be called
+// before Run.
+func (m *Monitor) SetGarbageCollection(collector ChannelResourceCollector, config GarbageCollectionConfig) {
+	m.gc = &garbageCollector{
+		collector: collector,
+		config:    config,
+		ledger: &channelResourceLedger{
+			client:    m.kubeclientset,
+			namespace: config.Namespace,
+			name:      config.Name,
+			mutex:     &sync.Mutex{},
+		},
+	}
+}
+
+// recordChannelResource records the backing resource of a Channel the bus is
+// about to provision, so it may be collected once the Channel is gone.
+func (m *Monitor) recordChannelResource(channel *channelsv1alpha1.Channel) error {
+	if m.gc == nil {
+		return nil
+	}
+	return m.gc.ledger.record(m.gc.collector.ChannelResourceName(channel.Namespace, channel.Name))
+}
+
+// recordChannelResources records the backing resources of every Channel of
+// the bus, including those provisioned before resources were recorded, so
+// they may be collected should the Channels be deleted while the provisioner
+// is not running.
+func (m *Monitor) recordChannelResources() {
+	if m.gc == nil {
+		return
+	}
+	channels, err := m.channelsLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Unable to list channels to record their resources: %v", err)
+		return
+	}
+	var resources []string
+	for _, channel := range channels {
+		if m.bus.BacksChannel(channel) && channel.DeletionTimestamp == nil {
+			resources = append(resources, m.gc.collector.ChannelResourceName(channel.Namespace, channel.Name))
+		}
+	}
+	if err := m.gc.ledger.record(resources...); err != nil {
+		glog.Errorf("Unable to record channel resources: %v", err)
+	}
+}
+
+// collectGarbage deletes, or reports, the recorded backing resources that
+// don't belong to a Channel. Resources are listed and the ledger is read
+// before Channels, so a resource provisioned during the sweep always has its
+// Channel listed. Resources that are not recorded may belong to other buses or
+// applications and are never deleted, a dry run reports them as they may also
+// have leaked before the bus recorded its resources.
+func (m *Monitor) collectGarbage() {
+	if !m.IsLeader() {
+		return
+	}
+
+	resources, err := m.gc.collector.ChannelResources()
+	if err != nil {
+		glog.Errorf("Unable to list channel resources: %v", err)
+		return
+	}
+	recorded, err := m.gc.ledger.resources()
+	if err != nil {
+		glog.Errorf("Unable to read recorded channel resources: %v", err)
+		return
+	}
+	// the API server is asked directly, a lagging cache must not make a
+	// resource look orphaned
+	channels, err := m.clientset.ChannelsV1alpha1().Channels(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Unable to list channels: %v", err)
+		return
+	}
+	owned := make(map[string]bool, len(channels.Items))
+	for _, channel := range channels.Items {
+		owned[m.gc.collector.ChannelResourceName(channel.Namespace, channel.Name)] = true
+	}
+
+	// recorded resources that are gone, or are deleted now, are forgotten
+	var forget []string
+	listed := make(map[string]bool, len(resources))
+	for _, resource := range resources {
+		listed[resource] = true
+	}
+	for resource := range recorded {
+		if !listed[resource] && !owned[resource] {
+			forget = append(forget, resource)
+		}
+	}
+
+	for _, resource := range resources {
+		if owned[resource] {
+			continue
+		}
+		if !recorded[resource] {
+			if m.gc.config.DryRun {
+				glog.Warningf("Found channel resource %q without a channel, it was not recorded as provisioned by the bus and is never deleted", resource)
+				orphanedResources.WithLabelValues("unrecorded").Inc()
+			} else {
+				glog.V(4).Infof("Ignoring channel resource %q not provisioned by the bus", resource)
+			}
+			continue
+		}
+		if m.gc.config.DryRun {
+			glog.Warningf("Found orphaned channel resource %q, not deleting it in a dry run", resource)
+			orphanedResources.WithLabelValues("reported").Inc()
+			continue
+		}
+		glog.Infof("Deleting orphaned channel resource %q", resource)
+		if err := m.gc.collector.DeleteChannelResource(resource); err != nil {
+			glog.Errorf("Unable to delete orphaned channel resource %q: %v", resource, err)
+			orphanedResources.WithLabelValues("error").Inc()
+			continue
+		}
+		orphanedResources.WithLabelValues("deleted").Inc()
+		forget = append(forget, resource)
+	}
+
+	if err := m.gc.ledger.forget(forget); err != nil {
+		glog.Errorf("Unable to forget collected channel resources: %v", err)
+	}
+}
+
+// channelResourceLedger records the backing resources the bus provisioned on
+// a ConfigMap, so garbage collection only deletes resources of the bus' own
+// Channels.
+type channelResourceLedger struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	// recorded holds the resources last read from or written to the
+	// ConfigMap, so recording a known resource doesn't cause API requests.
+	recorded map[string]bool
+	mutex    *sync.Mutex
+}
+
+// record adds the resources to the ledger.
+func (l *channelResourceLedger) record(resources ...string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.known(resources) {
+		return nil
+	}
+	configMap, recorded, err := l.read()
+	if err != nil {
+		return err
+	}
+	l.recorded = recorded
+	if l.known(resources) {
+		return nil
+	}
+	for _, resource := range resources {
+		recorded[resource] = true
+	}
+	return l.write(configMap, recorded)
+}
+
+// known returns whether the resources were last seen on the ledger.
+func (l *channelResourceLedger) known(resources []string) bool {
+	for _, resource := range resources {
+		if !l.recorded[resource] {
+			return false
+		}
+	}
+	return true
+}
+
+// resources returns the resources on the ledger.
+func (l *channelResourceLedger) resources() (map[string]bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, recorded, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	l.recorded = recorded
+	copied := make(map[string]bool, len(recorded))
+	for resource := range recorded {
+		copied[resource] = true
+	}
+	return copied, nil
+}
+
+// forget removes the resources from the ledger.
+func (l *channelResourceLedger) forget(resources []string) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	configMap, recorded, err := l.read()
+	if err != nil {
+		return err
+	}
+	for _, resource := range resources {
+		delete(recorded, resource)
+	}
+	return l.write(configMap, recorded)
+}
+
+// read returns the ledger's ConfigMap, nil if it doesn't exist yet, and the
+// resources recorded on it.
+func (l *channelResourceLedger) read() (*corev1.ConfigMap, map[string]bool, error) {
+	configMap, err := l.client.CoreV1().ConfigMaps(l.namespace).Get(l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, map[string]bool{}, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	recorded := map[string]bool{}
+	for _, resource := range strings.Split(configMap.Data[channelResourcesKey], "\n") {
+		if len(resource) > 0 {
+			recorded[resource] = true
+		}
+	}
+	return configMap, recorded, nil
+}
+
+// write records the resources on the ledger's ConfigMap, creating it if it is
+// nil. Concurrent writes are arbitrated by the ConfigMap's resource version.
+func (l *channelResourceLedger) write(configMap *corev1.ConfigMap, recorded map[string]bool) error {
+	resources := make([]string, 0, len(recorded))
+	for resource := range recorded {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	data := strings.Join(resources, "\n")
+
+	var err error
+	if configMap == nil {
+		_, err = l.client.CoreV1().ConfigMaps(l.namespace).Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: l.namespace,
+				Name:      l.name,
+			},
+			Data: map[string]string{channelResourcesKey: data},
+		})
+	} else {
+		configMap = configMap.DeepCopy()
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[channelResourcesKey] = data
+		_, err = l.client.CoreV1().ConfigMaps(l.namespace).Update(configMap)
+	}
+	if err != nil {
+		// the ConfigMap is read again on the next write
+		l.recorded = nil
+		return err
+	}
+	l.recorded = recorded
+	return nil
+}
+
+func hasChannelFinalizer(channel *channelsv1alpha1.Channel) bool {
+	for _, finalizer := range channel.Finalizers {
+		if finalizer == ChannelFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func addChannelFinalizer(channel *channelsv1alpha1.Channel) {
+	if !hasChannelFinalizer(channel) {
+		channel.Finalizers = append(channel.Finalizers, ChannelFinalizer)
+	}
+}
+
+func removeChannelFinalizer(channel *channelsv1alpha1.Channel) {
+	finalizers := []string{}
+	for _, finalizer := range channel.Finalizers {
+		if finalizer != ChannelFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	channel.Finalizers = finalizers
+}
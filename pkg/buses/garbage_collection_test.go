@@ -0,0 +1,293 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/client/clientset/versioned/fake"
+	listers "github.com/knative/eventing/pkg/client/listers/channels/v1alpha1"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+type fakeCollector struct {
+	resources []string
+	deleted   []string
+	deleteErr error
+}
+
+func (c *fakeCollector) ChannelResourceName(namespace, name string) string {
+	return fmt.Sprintf("%s.%s", namespace, name)
+}
+
+func (c *fakeCollector) ChannelResources() ([]string, error) {
+	return c.resources, nil
+}
+
+func (c *fakeCollector) DeleteChannelResource(name string) error {
+	c.deleted = append(c.deleted, name)
+	return c.deleteErr
+}
+
+// newCollectingMonitor creates a monitor collecting garbage with the recorded
+// resources on its ledger.
+func newCollectingMonitor(collector ChannelResourceCollector, dryRun bool, recorded ...string) *Monitor {
+	client := fake.NewSimpleClientset(
+		&channelsv1alpha1.Channel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "channel"}},
+		&channelsv1alpha1.Channel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-2", Name: "channel"}},
+	)
+	m := &Monitor{clientset: client, kubeclientset: kubefake.NewSimpleClientset()}
+	m.SetGarbageCollection(collector, GarbageCollectionConfig{
+		Interval:  time.Minute,
+		DryRun:    dryRun,
+		Namespace: "knative-eventing",
+		Name:      "bus-test-resources",
+	})
+	for _, resource := range recorded {
+		if err := m.gc.ledger.record(resource); err != nil {
+			panic(err)
+		}
+	}
+	return m
+}
+
+func TestCollectGarbage(t *testing.T) {
+	collector := &fakeCollector{
+		resources: []string{"ns-1.channel", "ns-1.deleted", "ns-2.channel", "ns-3.channel"},
+	}
+	m := newCollectingMonitor(collector, false, "ns-1.channel", "ns-1.deleted", "ns-3.channel", "ns-4.gone")
+
+	m.collectGarbage()
+
+	sort.Strings(collector.deleted)
+	if want := []string{"ns-1.deleted", "ns-3.channel"}; !reflect.DeepEqual(want, collector.deleted) {
+		t.Errorf("Unexpected deleted resources. want %v, got %v", want, collector.deleted)
+	}
+	// deleted resources, and resources that are gone, are forgotten
+	recorded, err := m.gc.ledger.resources()
+	if err != nil {
+		t.Fatalf("Unexpected error reading the ledger: %v", err)
+	}
+	if want := map[string]bool{"ns-1.channel": true}; !reflect.DeepEqual(want, recorded) {
+		t.Errorf("Unexpected recorded resources. want %v, got %v", want, recorded)
+	}
+}
+
+func TestCollectGarbageOnlyRecorded(t *testing.T) {
+	// resources of other buses or applications sharing the middleware are
+	// named like the bus' resources
+	collector := &fakeCollector{
+		resources: []string{"ns-1.deleted", "ns-3.channel", "orders.created"},
+	}
+	m := newCollectingMonitor(collector, false, "ns-1.deleted")
+
+	m.collectGarbage()
+
+	if want := []string{"ns-1.deleted"}; !reflect.DeepEqual(want, collector.deleted) {
+		t.Errorf("Unexpected deleted resources. want %v, got %v", want, collector.deleted)
+	}
+}
+
+func TestChannelResourceLedger(t *testing.T) {
+	client := kubefake.NewSimpleClientset()
+	ledger := &channelResourceLedger{
+		client:    client,
+		namespace: "knative-eventing",
+		name:      "bus-test-resources",
+		mutex:     &sync.Mutex{},
+	}
+	for _, resource := range []string{"ns.b", "ns.a", "ns.b"} {
+		if err := ledger.record(resource); err != nil {
+			t.Fatalf("Unexpected error recording %q: %v", resource, err)
+		}
+	}
+	// a known resource is recorded without API requests
+	client.ClearActions()
+	ledger.record("ns.a")
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("Unexpected API requests recording a known resource: %v", actions)
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps("knative-eventing").Get("bus-test-resources", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting the ledger ConfigMap: %v", err)
+	}
+	if want := "ns.a\nns.b"; configMap.Data[channelResourcesKey] != want {
+		t.Errorf("Unexpected recorded resources. want %q, got %q", want, configMap.Data[channelResourcesKey])
+	}
+
+	if err := ledger.forget([]string{"ns.a"}); err != nil {
+		t.Fatalf("Unexpected error forgetting: %v", err)
+	}
+	recorded, err := ledger.resources()
+	if err != nil {
+		t.Fatalf("Unexpected error reading the ledger: %v", err)
+	}
+	if want := map[string]bool{"ns.b": true}; !reflect.DeepEqual(want, recorded) {
+		t.Errorf("Unexpected recorded resources. want %v, got %v", want, recorded)
+	}
+}
+
+func TestCollectGarbageDryRun(t *testing.T) {
+	collector := &fakeCollector{
+		resources: []string{"ns-1.channel", "ns-1.deleted", "ns-3.leaked"},
+	}
+	m := newCollectingMonitor(collector, true, "ns-1.deleted")
+	reported := orphanedResourcesCount(t, "reported")
+	unrecorded := orphanedResourcesCount(t, "unrecorded")
+
+	m.collectGarbage()
+
+	if len(collector.deleted) != 0 {
+		t.Errorf("Expected no resources to be deleted in a dry run, got %v", collector.deleted)
+	}
+	if got := orphanedResourcesCount(t, "reported") - reported; got != 1 {
+		t.Errorf("Unexpected reported orphans. want 1, got %v", got)
+	}
+	// resources that leaked before they were recorded are reported too
+	if got := orphanedResourcesCount(t, "unrecorded") - unrecorded; got != 1 {
+		t.Errorf("Unexpected unrecorded orphans. want 1, got %v", got)
+	}
+}
+
+func orphanedResourcesCount(t *testing.T, result string) float64 {
+	var m dto.Metric
+	if err := orphanedResources.WithLabelValues(result).Write(&m); err != nil {
+		t.Fatalf("Unexpected error reading the orphaned resources: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestRecordChannelResources(t *testing.T) {
+	channels := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, channel := range []*channelsv1alpha1.Channel{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "channel"}, Spec: channelsv1alpha1.ChannelSpec{ClusterBus: "test"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-2", Name: "channel"}, Spec: channelsv1alpha1.ChannelSpec{ClusterBus: "other"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-3", Name: "channel"}, Spec: channelsv1alpha1.ChannelSpec{Bus: "test"}},
+	} {
+		channels.Add(channel)
+	}
+	// the ledger holds a resource recorded by a previous leader
+	m := newCollectingMonitor(&fakeCollector{}, true, "ns-4.channel")
+	m.bus = &channelsv1alpha1.ClusterBus{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	m.channelsLister = listers.NewChannelLister(channels)
+
+	m.recordChannelResources()
+
+	recorded, err := m.gc.ledger.resources()
+	if err != nil {
+		t.Fatalf("Unexpected error reading the ledger: %v", err)
+	}
+	if want := map[string]bool{"ns-1.channel": true, "ns-4.channel": true}; !reflect.DeepEqual(want, recorded) {
+		t.Errorf("Unexpected recorded resources. want %v, got %v", want, recorded)
+	}
+}
+
+func TestCollectGarbageDeleteError(t *testing.T) {
+	collector := &fakeCollector{
+		resources: []string{"ns-1.deleted", "ns-3.channel"},
+		deleteErr: errors.New("delete failed"),
+	}
+	m := newCollectingMonitor(collector, false, "ns-1.deleted", "ns-3.channel")
+
+	m.collectGarbage()
+
+	// every orphan is attempted despite errors
+	if len(collector.deleted) != 2 {
+		t.Errorf("Expected both orphans to be deleted, got %v", collector.deleted)
+	}
+}
+
+func TestNewGarbageCollectionConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		dryRun   string
+		want     GarbageCollectionConfig
+		wantErr  bool
+	}{{
+		name: "defaults",
+		want: GarbageCollectionConfig{Interval: DefaultGarbageCollectionInterval, DryRun: true, Namespace: "ns", Name: "bus-kafka-resources"},
+	}, {
+		name:     "configured",
+		interval: "1h",
+		dryRun:   "false",
+		want:     GarbageCollectionConfig{Interval: time.Hour, DryRun: false, Namespace: "ns", Name: "bus-kafka-resources"},
+	}, {
+		name:     "disabled",
+		interval: "0s",
+		want:     GarbageCollectionConfig{Interval: 0, DryRun: true, Namespace: "ns", Name: "bus-kafka-resources"},
+	}, {
+		name:     "invalid interval",
+		interval: "often",
+		wantErr:  true,
+	}, {
+		name:    "invalid dry run",
+		dryRun:  "maybe",
+		wantErr: true,
+	}}
+	defer os.Unsetenv(EnvGarbageCollectionInterval)
+	defer os.Unsetenv(EnvGarbageCollectionDryRun)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv(EnvGarbageCollectionInterval, test.interval)
+			os.Setenv(EnvGarbageCollectionDryRun, test.dryRun)
+			got, err := NewGarbageCollectionConfigFromEnv(&BusReference{Namespace: "ns", Name: "kafka"})
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Unexpected config. want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestChannelFinalizer(t *testing.T) {
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Finalizers: []string{"other"}},
+	}
+	addChannelFinalizer(channel)
+	addChannelFinalizer(channel)
+	if want := []string{"other", ChannelFinalizer}; !reflect.DeepEqual(want, channel.Finalizers) {
+		t.Errorf("Unexpected finalizers. want %v, got %v", want, channel.Finalizers)
+	}
+	if !hasChannelFinalizer(channel) {
+		t.Errorf("Expected channel to have the finalizer")
+	}
+	removeChannelFinalizer(channel)
+	if want := []string{"other"}; !reflect.DeepEqual(want, channel.Finalizers) {
+		t.Errorf("Unexpected finalizers. want %v, got %v", want, channel.Finalizers)
+	}
+}
@@ -20,26 +20,47 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/golang/glog"
 	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
 	"github.com/knative/eventing/pkg/buses"
+	"google.golang.org/api/iterator"
 )
 
+// maxExtension is how long the client extends the ack deadline of a received
+// message for.
+const maxExtension = 10 * time.Minute
+
+// PubSubBus backs each Channel with a Google Cloud Pub/Sub topic and each
+// Subscription with a Pub/Sub subscription to that topic. Delayed messages are
+// held in a delay topic per bus until they are due.
 type PubSubBus struct {
-	name              string
-	monitor           *buses.Monitor
-	messageReceiver   *buses.MessageReceiver
-	messageDispatcher *buses.MessageDispatcher
-	pubsubClient      *pubsub.Client
-	receivers         map[string]context.CancelFunc
+	name         string
+	pubsubClient *pubsub.Client
+
+	// publishDelayed publishes a message to the delay topic
+	publishDelayed func(attributes map[string]string, data []byte) error
+
+	// delayMutex guards the fields below
+	delayMutex       sync.Mutex
+	delayTopicClient *pubsub.Topic
+	delayReceiving   bool
+	// subscribers are keyed by `<namespace>/<name>` of their Subscription
+	subscribers map[string]buses.Subscriber
+	// ownsSubscription returns true if this replica subscribes to a
+	// Subscription, it is nil if every replica subscribes to every
+	// Subscription
+	ownsSubscription func(namespace, name string) bool
 }
 
-func (b *PubSubBus) CreateTopic(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+// Provision creates the Pub/Sub topic for the Channel.
+func (b *PubSubBus) Provision(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
 	ctx := context.Background()
 
-	topicID := b.topicID(channel)
+	topicID := b.topicID(channel.Namespace, channel.Name)
 	topic := b.pubsubClient.Topic(topicID)
 
 	// check if topic exists before creating
@@ -50,18 +71,47 @@ func (b *PubSubBus) CreateTopic(channel *channelsv1alpha1.Channel, parameters bu
 	}
 
 	glog.Infof("Create topic %q\n", topicID)
-	topic, err := b.pubsubClient.CreateTopic(ctx, topicID)
-	if err != nil {
-		return err
-	}
+	_, err := b.pubsubClient.CreateTopic(ctx, topicID)
+	return err
+}
 
-	return nil
+// Unprovision deletes the Pub/Sub topic for the Channel.
+func (b *PubSubBus) Unprovision(channel *channelsv1alpha1.Channel) error {
+	return b.DeleteChannelResource(b.topicID(channel.Namespace, channel.Name))
 }
 
-func (b *PubSubBus) DeleteTopic(channel *channelsv1alpha1.Channel) error {
+// ChannelResourceName returns the ID of the Channel's Pub/Sub topic.
+func (b *PubSubBus) ChannelResourceName(namespace, name string) string {
+	return b.topicID(namespace, name)
+}
+
+// ChannelResources lists the IDs of the Pub/Sub topics named after the bus.
+// The topics of a bus whose name starts with this bus' name followed by a dash
+// are listed too, garbage collection leaves them alone as this bus did not
+// provision them.
+func (b *PubSubBus) ChannelResources() ([]string, error) {
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("channel-%s-", b.name)
+	var topicIDs []string
+	topics := b.pubsubClient.Topics(ctx)
+	for {
+		topic, err := topics.Next()
+		if err == iterator.Done {
+			return topicIDs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(topic.ID(), prefix) {
+			topicIDs = append(topicIDs, topic.ID())
+		}
+	}
+}
+
+// DeleteChannelResource deletes the Pub/Sub topic.
+func (b *PubSubBus) DeleteChannelResource(topicID string) error {
 	ctx := context.Background()
 
-	topicID := b.topicID(channel)
 	topic := b.pubsubClient.Topic(topicID)
 
 	// check if topic exists before deleting
@@ -75,7 +125,26 @@ func (b *PubSubBus) DeleteTopic(channel *channelsv1alpha1.Channel) error {
 	return topic.Delete(ctx)
 }
 
-func (b *PubSubBus) CreateOrUpdateSubscription(sub *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
+var _ buses.SubscriptionSharder = &PubSubBus{}
+
+// ShardsSubscriptions returns true, any dispatcher replica may receive from
+// the Pub/Sub subscription of a Subscription.
+func (b *PubSubBus) ShardsSubscriptions() bool {
+	return true
+}
+
+// SetSubscriptionOwner sets the func telling whether this replica subscribes
+// to a Subscription. A delayed message for a Subscription of another replica
+// is handed back to the delay subscription for that replica to receive.
+func (b *PubSubBus) SetSubscriptionOwner(owns func(namespace, name string) bool) {
+	b.delayMutex.Lock()
+	defer b.delayMutex.Unlock()
+	b.ownsSubscription = owns
+}
+
+// ProvisionSubscription creates the Pub/Sub subscription for the
+// Subscription.
+func (b *PubSubBus) ProvisionSubscription(sub *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
 	ctx := context.Background()
 
 	subscriptionID := b.subscriptionID(sub)
@@ -92,20 +161,23 @@ func (b *PubSubBus) CreateOrUpdateSubscription(sub *channelsv1alpha1.Subscriptio
 	}
 
 	// create subscription
-	channel := b.monitor.Channel(sub.Spec.Channel, sub.Namespace)
-	if channel == nil {
+	topicID := b.topicID(sub.Namespace, sub.Spec.Channel)
+	topic := b.pubsubClient.Topic(topicID)
+	if exists, err := topic.Exists(ctx); err != nil {
+		return err
+	} else if !exists {
 		return fmt.Errorf("Cannot create a Subscription for unknown Channel %q", sub.Spec.Channel)
 	}
-	topicID := b.topicID(channel)
-	topic := b.pubsubClient.Topic(topicID)
 	glog.Infof("Create subscription %q for topic %q\n", subscriptionID, topicID)
-	subscription, err := b.pubsubClient.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{
+	_, err := b.pubsubClient.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{
 		Topic: topic,
 	})
 	return err
 }
 
-func (b *PubSubBus) DeleteSubscription(sub *channelsv1alpha1.Subscription) error {
+// UnprovisionSubscription deletes the Pub/Sub subscription for the
+// Subscription.
+func (b *PubSubBus) UnprovisionSubscription(sub *channelsv1alpha1.Subscription) error {
 	ctx := context.Background()
 
 	subscriptionID := b.subscriptionID(sub)
@@ -122,10 +194,11 @@ func (b *PubSubBus) DeleteSubscription(sub *channelsv1alpha1.Subscription) error
 	return subscription.Delete(ctx)
 }
 
-func (b *PubSubBus) SendEventToTopic(channel *channelsv1alpha1.Channel, message *buses.Message) error {
+// Publish publishes the message to the Channel's topic.
+func (b *PubSubBus) Publish(channel *channelsv1alpha1.Channel, message *buses.Message) error {
 	ctx := context.Background()
 
-	topicID := b.topicID(channel)
+	topicID := b.topicID(channel.Namespace, channel.Name)
 	topic := b.pubsubClient.Topic(topicID)
 
 	result := topic.Publish(ctx, &pubsub.Message{
@@ -133,123 +206,106 @@ func (b *PubSubBus) SendEventToTopic(channel *channelsv1alpha1.Channel, message
 		Attributes: message.Headers,
 	})
 	id, err := result.Get(ctx)
-	if err != nil {
-		return err
-	}
 
 	// TODO allow topics to be reused between publish events, call .Stop after an idle period
 	topic.Stop()
 
+	if err != nil {
+		return fmt.Errorf("unable to send event to topic %q: %v", topicID, err)
+	}
+
 	glog.Infof("Published a message to %s; msg ID: %v\n", topicID, id)
 	return nil
 }
 
-func (b *PubSubBus) ReceiveEvents(sub *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
+// Subscribe receives messages from the Subscription's Pub/Sub subscription
+// until the handle is closed. Messages that are not dispatched are nacked so
+// Pub/Sub redelivers them. Delayed messages for the Subscription are
+// dispatched to the subscriber once due.
+func (b *PubSubBus) Subscribe(sub *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters, subscriber buses.Subscriber) (buses.SubscriptionHandle, error) {
 	ctx := context.Background()
-	cctx, cancel := context.WithCancel(ctx)
 
-	// cancel current subscription receiver, if any
-	b.StopReceiveEvents(sub)
+	if err := b.startDelayReceiver(); err != nil {
+		return nil, err
+	}
 
 	subscriptionID := b.subscriptionID(sub)
 	subscription := b.pubsubClient.Subscription(subscriptionID)
-	b.receivers[subscriptionID] = cancel
 
 	// check if subscription exists before receiving
 	if exists, err := subscription.Exists(ctx); err != nil {
-		return err
+		return nil, err
 	} else if !exists {
-		return fmt.Errorf("cannot receive message for a non-existent subscription %s", subscriptionID)
+		return nil, fmt.Errorf("cannot receive message for a non-existent subscription %s", subscriptionID)
 	}
 
+	subscription.ReceiveSettings.MaxExtension = maxExtension
+
+	key := delayKey(sub.Namespace, sub.Name)
+	b.delayMutex.Lock()
+	b.subscribers[key] = subscriber
+	b.delayMutex.Unlock()
+
+	cctx, cancel := context.WithCancel(ctx)
+
 	// subscription.Receive blocks, so run it in a goroutine
 	go func() {
 		glog.Infof("Start receiving events for subscription %q\n", subscriptionID)
 		err := subscription.Receive(cctx, func(ctx context.Context, pubsubMessage *pubsub.Message) {
-			subscriber := sub.Spec.Subscriber
-			namespace := sub.Namespace
 			message := &buses.Message{
 				Headers: pubsubMessage.Attributes,
 				Payload: pubsubMessage.Data,
 			}
-			err := b.messageDispatcher.DispatchMessage(subscriber, namespace, message)
-			if err != nil {
-				glog.Warningf("Unable to dispatch event %q to %q", pubsubMessage.ID, subscriber)
+			// a delayed message is scheduled, Dispatch returns once it is
+			// in the delay topic
+			if err := subscriber.Dispatch(message); err != nil {
+				glog.Warningf("Unable to dispatch event %q to %q: %v", pubsubMessage.ID, sub.Spec.Subscriber, err)
 				pubsubMessage.Nack()
 			} else {
-				glog.Infof("Dispatched event %q to %q", pubsubMessage.ID, subscriber)
+				glog.Infof("Dispatched event %q to %q", pubsubMessage.ID, sub.Spec.Subscriber)
 				pubsubMessage.Ack()
 			}
 		})
-		if err != nil {
-			glog.Errorf("Error receiving messesages for %q: %v\n", subscriptionID, err)
+		if err != nil && cctx.Err() == nil {
+			subscriber.Failed(fmt.Errorf("error receiving messages for %q: %v", subscriptionID, err))
 		}
-		delete(b.receivers, subscriptionID)
-		b.monitor.RequeueSubscription(sub)
 	}()
 
-	return nil
-}
-
-func (b *PubSubBus) StopReceiveEvents(subscription *channelsv1alpha1.Subscription) error {
-	subscriptionID := b.subscriptionID(subscription)
-	if cancel, ok := b.receivers[subscriptionID]; ok {
+	return buses.SubscriptionHandleFunc(func() error {
 		glog.Infof("Stop receiving events for subscription %q\n", subscriptionID)
 		cancel()
-		delete(b.receivers, subscriptionID)
-	}
-	return nil
+		b.delayMutex.Lock()
+		if b.subscribers[key] == subscriber {
+			delete(b.subscribers, key)
+		}
+		b.delayMutex.Unlock()
+		return nil
+	}), nil
 }
 
-func (b *PubSubBus) topicID(channel *channelsv1alpha1.Channel) string {
-	return fmt.Sprintf("channel-%s-%s-%s", b.name, channel.Namespace, channel.Name)
+func (b *PubSubBus) topicID(namespace, channel string) string {
+	return fmt.Sprintf("channel-%s-%s-%s", b.name, namespace, channel)
 }
 
 func (b *PubSubBus) subscriptionID(subscription *channelsv1alpha1.Subscription) string {
 	return fmt.Sprintf("subscription-%s-%s-%s", b.name, subscription.Namespace, subscription.Name)
 }
 
-func (b *PubSubBus) ReceiveMessage(channel *buses.ChannelReference, message *buses.Message) error {
-	c := b.monitor.Channel(channel.Name, channel.Namespace)
-	if c == nil {
-		return buses.ErrUnknownChannel
-	}
-
-	err := b.SendEventToTopic(c, message)
-	if err != nil {
-		return fmt.Errorf("unable to send event to topic %q: %v", channel.Name, err)
-	}
-
-	return nil
-}
-
-func (b *PubSubBus) splitChannelName(host string) (string, string) {
-	chunks := strings.Split(host, ".")
-	channel := chunks[0]
-	namespace := chunks[1]
-	return channel, namespace
-}
-
-func NewPubSubBus(
-	name string, projectID string,
-	monitor *buses.Monitor,
-	messageReceiver *buses.MessageReceiver,
-	messageDispatcher *buses.MessageDispatcher,
-) (*PubSubBus, error) {
+// NewPubSubBus creates a bus backed by Pub/Sub in the Google Cloud project.
+// Topics and subscriptions are named after the bus.
+func NewPubSubBus(name string, projectID string) (*PubSubBus, error) {
 	ctx := context.Background()
 	pubsubClient, err := pubsub.NewClient(ctx, projectID)
 	if err != nil {
 		return nil, err
 	}
 
-	bus := PubSubBus{
-		name:              name,
-		monitor:           monitor,
-		messageReceiver:   messageReceiver,
-		messageDispatcher: messageDispatcher,
-		pubsubClient:      pubsubClient,
-		receivers:         map[string]context.CancelFunc{},
+	bus := &PubSubBus{
+		name:         name,
+		pubsubClient: pubsubClient,
+		subscribers:  make(map[string]buses.Subscriber),
 	}
+	bus.publishDelayed = bus.publishToDelayTopic
 
-	return &bus, nil
+	return bus, nil
 }
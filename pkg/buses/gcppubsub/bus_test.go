@@ -0,0 +1,201 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gcppubsub
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/knative/eventing/pkg/buses/conformance"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestConformance runs the bus conformance suite against the Pub/Sub emulator
+// at PUBSUB_EMULATOR_HOST.
+func TestConformance(t *testing.T) {
+	if os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
+		t.Skip("PUBSUB_EMULATOR_HOST is not set")
+	}
+
+	bus, err := NewPubSubBus("conformance", "conformance")
+	if err != nil {
+		t.Fatalf("Unable to create bus: %v", err)
+	}
+	conformance.RunTests(t, bus, conformance.Config{})
+}
+
+type recordingSubscriber struct {
+	messages []*buses.Message
+	err      error
+}
+
+func (s *recordingSubscriber) Dispatch(message *buses.Message) error {
+	s.messages = append(s.messages, message)
+	return s.err
+}
+
+func (s *recordingSubscriber) Failed(error) {}
+
+// newDelayTestBus returns a bus recording the messages published to its delay
+// topic.
+func newDelayTestBus() (*PubSubBus, *[]map[string]string) {
+	var published []map[string]string
+	bus := &PubSubBus{
+		name:        "pubsub",
+		subscribers: make(map[string]buses.Subscriber),
+		publishDelayed: func(attributes map[string]string, data []byte) error {
+			published = append(published, attributes)
+			return nil
+		},
+	}
+	return bus, &published
+}
+
+func makeDelayedAttributes(at time.Time, attempts int) map[string]string {
+	return map[string]string{
+		"Content-Type":             "text/plain",
+		attributeDelaySubscription: "default/subscription",
+		attributeDelayDeliverAt:    at.UTC().Format(time.RFC3339Nano),
+		attributeDelayAttempts:     strconv.Itoa(attempts),
+	}
+}
+
+func TestScheduleDelivery(t *testing.T) {
+	bus, published := newDelayTestBus()
+	sub := &channelsv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "subscription"},
+	}
+	at := time.Now().Add(time.Hour)
+	message := &buses.Message{
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Payload: []byte("hello"),
+	}
+
+	if err := bus.ScheduleDelivery(sub, message, at); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []map[string]string{makeDelayedAttributes(at, 0)}
+	if !reflect.DeepEqual(want, *published) {
+		t.Errorf("Unexpected delayed messages. want %v, got %v", want, *published)
+	}
+}
+
+func TestReleaseDelayed(t *testing.T) {
+	bus, published := newDelayTestBus()
+	subscriber := &recordingSubscriber{}
+	bus.subscribers[delayKey("default", "subscription")] = subscriber
+
+	// a message that is not due is delayed again, unchanged
+	at := time.Now().Add(time.Hour)
+	if err := bus.releaseDelayed(makeDelayedAttributes(at, 0), []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []map[string]string{makeDelayedAttributes(at, 0)}; !reflect.DeepEqual(want, *published) {
+		t.Errorf("Unexpected delayed messages. want %v, got %v", want, *published)
+	}
+	if len(subscriber.messages) != 0 {
+		t.Errorf("Unexpected number of dispatched messages. want 0, got %d", len(subscriber.messages))
+	}
+
+	// a due message is dispatched without the delay attributes
+	*published = nil
+	if err := bus.releaseDelayed(makeDelayedAttributes(time.Now().Add(-time.Second), 0), []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []*buses.Message{{
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Payload: []byte("hello"),
+	}}
+	if !reflect.DeepEqual(want, subscriber.messages) {
+		t.Errorf("Unexpected dispatched messages. want %v, got %v", want, subscriber.messages)
+	}
+	if len(*published) != 0 {
+		t.Errorf("Unexpected number of delayed messages. want 0, got %d", len(*published))
+	}
+}
+
+func TestReleaseDelayedDispatchFailure(t *testing.T) {
+	bus, published := newDelayTestBus()
+	bus.subscribers[delayKey("default", "subscription")] = &recordingSubscriber{err: errors.New("subscriber unavailable")}
+
+	// a failed dispatch is delayed again for delayRetry
+	if err := bus.releaseDelayed(makeDelayedAttributes(time.Now().Add(-time.Second), 0), []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(*published) != 1 {
+		t.Fatalf("Unexpected number of delayed messages. want 1, got %d", len(*published))
+	}
+	delayed := (*published)[0]
+	if delayed[attributeDelayAttempts] != "1" {
+		t.Errorf("Unexpected delay attempts. want %q, got %q", "1", delayed[attributeDelayAttempts])
+	}
+	at, err := time.Parse(time.RFC3339Nano, delayed[attributeDelayDeliverAt])
+	if err != nil || time.Until(at) < delayRetry-time.Second {
+		t.Errorf("Unexpected delivery time. want about %v from now, got %q", delayRetry, delayed[attributeDelayDeliverAt])
+	}
+
+	// the message is dropped after the last attempt
+	*published = nil
+	if err := bus.releaseDelayed(makeDelayedAttributes(time.Now().Add(-time.Second), maxDelayAttempts), []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(*published) != 0 {
+		t.Errorf("Unexpected number of delayed messages. want 0, got %d", len(*published))
+	}
+}
+
+func TestReleaseDelayedSharded(t *testing.T) {
+	bus, published := newDelayTestBus()
+	owner := ""
+	bus.SetSubscriptionOwner(func(namespace, name string) bool {
+		return owner == "this"
+	})
+
+	// a due message for a subscription of another replica is redelivered
+	// to the delay subscription as is
+	owner = "other"
+	if err := bus.releaseDelayed(makeDelayedAttributes(time.Now().Add(-time.Second), 0), []byte("hello")); err != errSubscriptionNotOwned {
+		t.Fatalf("Unexpected error. want %v, got %v", errSubscriptionNotOwned, err)
+	}
+	if len(*published) != 0 {
+		t.Errorf("Unexpected number of delayed messages. want 0, got %d", len(*published))
+	}
+
+	// a message which is not due is delayed again by any replica
+	if err := bus.releaseDelayed(makeDelayedAttributes(time.Now().Add(time.Hour), 0), []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(*published) != 1 {
+		t.Fatalf("Unexpected number of delayed messages. want 1, got %d", len(*published))
+	}
+
+	// the owner not subscribed yet retries, counting the attempt
+	owner = "this"
+	*published = nil
+	if err := bus.releaseDelayed(makeDelayedAttributes(time.Now().Add(-time.Second), 0), []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(*published) != 1 || (*published)[0][attributeDelayAttempts] != "1" {
+		t.Errorf("Unexpected delayed messages. want one at attempt 1, got %v", *published)
+	}
+}
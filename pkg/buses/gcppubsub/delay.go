@@ -0,0 +1,283 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gcppubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/golang/glog"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// attributeDelaySubscription holds the `<namespace>/<name>` of the
+	// Subscription a delayed message is for.
+	attributeDelaySubscription = "knative-delay-subscription"
+	// attributeDelayDeliverAt holds the RFC 3339 time a delayed message is
+	// due.
+	attributeDelayDeliverAt = "knative-delay-deliverat"
+	// attributeDelayAttempts holds how many times a due message was delayed
+	// again as its Subscription was not found or its dispatch failed.
+	attributeDelayAttempts = "knative-delay-attempts"
+
+	// delayHold is the longest a delayed message is held by a dispatcher.
+	// A message due later is published to the delay topic again once held
+	// for delayHold, so it is never outstanding for longer than the client
+	// extends its ack deadline for.
+	delayHold = 5 * time.Minute
+
+	// maxHeldDelayed is the number of delayed messages a dispatcher replica
+	// holds at once. Pub/Sub keeps the others until they are received.
+	maxHeldDelayed = 10000
+
+	// delayRetry is how long a due message is delayed again if its
+	// Subscription is not subscribed yet or its dispatch failed, up to
+	// maxDelayAttempts times.
+	delayRetry       = 10 * time.Second
+	maxDelayAttempts = 30
+)
+
+var _ buses.DeliveryScheduler = &PubSubBus{}
+
+// errSubscriptionNotOwned is returned by releaseDelayed for a due message
+// whose Subscription another dispatcher replica subscribes to.
+var errSubscriptionNotOwned = errors.New("subscription is dispatched by another replica")
+
+var delayedDispatchFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "knative",
+		Subsystem: "bus",
+		Name:      "delayed_dispatch_failures_total",
+		Help:      "Number of delayed messages whose dispatch failed when they were due.",
+	},
+	[]string{"bus", "action"},
+)
+
+func init() {
+	prometheus.MustRegister(delayedDispatchFailures)
+}
+
+// ScheduleDelivery publishes a message that is not due yet to the bus' delay
+// topic. A dispatcher replica dispatches it to the Subscription once it is
+// due.
+func (b *PubSubBus) ScheduleDelivery(subscription *channelsv1alpha1.Subscription, message *buses.Message, at time.Time) error {
+	return b.delay(delayKey(subscription.Namespace, subscription.Name), message, at, 0)
+}
+
+// delay publishes the message for the subscription to the delay topic. The
+// publish is acknowledged before delay returns, so the message survives the
+// message it was received as being acked.
+func (b *PubSubBus) delay(subscription string, message *buses.Message, at time.Time, attempts int) error {
+	attributes := make(map[string]string, len(message.Headers)+3)
+	for name, value := range message.Headers {
+		attributes[name] = value
+	}
+	attributes[attributeDelaySubscription] = subscription
+	attributes[attributeDelayDeliverAt] = at.UTC().Format(time.RFC3339Nano)
+	attributes[attributeDelayAttempts] = strconv.Itoa(attempts)
+
+	if err := b.publishDelayed(attributes, message.Payload); err != nil {
+		return fmt.Errorf("unable to delay message for subscription %s: %v", subscription, err)
+	}
+	return nil
+}
+
+// publishToDelayTopic publishes a message to the bus' delay topic and waits
+// for the publish to be acknowledged.
+func (b *PubSubBus) publishToDelayTopic(attributes map[string]string, data []byte) error {
+	ctx := context.Background()
+
+	topic := b.delayTopic()
+	_, err := topic.Publish(ctx, &pubsub.Message{
+		Data:       data,
+		Attributes: attributes,
+	}).Get(ctx)
+	return err
+}
+
+// delayTopic returns the bus' delay topic, creating the client for it once.
+func (b *PubSubBus) delayTopic() *pubsub.Topic {
+	b.delayMutex.Lock()
+	defer b.delayMutex.Unlock()
+
+	if b.delayTopicClient == nil {
+		b.delayTopicClient = b.pubsubClient.Topic(b.delayTopicID())
+	}
+	return b.delayTopicClient
+}
+
+// startDelayReceiver creates the bus' delay topic and its subscription and
+// starts receiving from it, unless already started. The dispatcher replicas
+// share the subscription, each delayed message is received by one replica.
+func (b *PubSubBus) startDelayReceiver() error {
+	b.delayMutex.Lock()
+	defer b.delayMutex.Unlock()
+
+	if b.delayReceiving {
+		return nil
+	}
+	ctx := context.Background()
+
+	topicID := b.delayTopicID()
+	topic := b.pubsubClient.Topic(topicID)
+	if exists, err := topic.Exists(ctx); err != nil {
+		return err
+	} else if !exists {
+		glog.Infof("Create delay topic %q\n", topicID)
+		if _, err := b.pubsubClient.CreateTopic(ctx, topicID); err != nil {
+			return fmt.Errorf("unable to create delay topic %q: %v", topicID, err)
+		}
+	}
+	subscription := b.pubsubClient.Subscription(topicID)
+	if exists, err := subscription.Exists(ctx); err != nil {
+		return err
+	} else if !exists {
+		glog.Infof("Create delay subscription %q\n", topicID)
+		if _, err := b.pubsubClient.CreateSubscription(ctx, topicID, pubsub.SubscriptionConfig{Topic: topic}); err != nil {
+			return fmt.Errorf("unable to create delay subscription %q: %v", topicID, err)
+		}
+	}
+
+	// held messages are outstanding, the client extends their ack deadline
+	// for longer than they are held
+	subscription.ReceiveSettings.MaxExtension = maxExtension
+	subscription.ReceiveSettings.MaxOutstandingMessages = maxHeldDelayed
+	go func() {
+		for {
+			err := subscription.Receive(ctx, b.receiveDelayed)
+			glog.Errorf("Stopped receiving delayed messages from %q, restarting: %v", topicID, err)
+			time.Sleep(delayRetry)
+		}
+	}()
+	b.delayReceiving = true
+	return nil
+}
+
+// receiveDelayed holds a delayed message until it is due, or for delayHold,
+// then moves it on. A message is acked once moved on, and nacked if the
+// receiver stops first so another replica receives it.
+func (b *PubSubBus) receiveDelayed(ctx context.Context, msg *pubsub.Message) {
+	if at, err := time.Parse(time.RFC3339Nano, msg.Attributes[attributeDelayDeliverAt]); err == nil {
+		hold := time.Until(at)
+		if hold > delayHold {
+			hold = delayHold
+		}
+		timer := time.NewTimer(hold)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			msg.Nack()
+			return
+		}
+	}
+	if err := b.releaseDelayed(msg.Attributes, msg.Data); err == errSubscriptionNotOwned {
+		// Pub/Sub redelivers the message, eventually to the replica
+		// subscribed to its subscription
+		msg.Nack()
+		return
+	} else if err != nil {
+		glog.Errorf("Unable to move on delayed message %q, redelivering: %v", msg.ID, err)
+		msg.Nack()
+		return
+	}
+	msg.Ack()
+}
+
+// releaseDelayed dispatches a message that was held until it is due to its
+// Subscription, or publishes it to the delay topic again if it is not due yet.
+// A message whose dispatch fails, or whose Subscription is unknown, is delayed
+// again for delayRetry, up to maxDelayAttempts times. A due message whose
+// Subscription another replica subscribes to is left to that replica with
+// errSubscriptionNotOwned. Messages which are not valid are dropped.
+func (b *PubSubBus) releaseDelayed(attributes map[string]string, data []byte) error {
+	subscription := attributes[attributeDelaySubscription]
+	at, err := time.Parse(time.RFC3339Nano, attributes[attributeDelayDeliverAt])
+	if err != nil || subscription == "" {
+		glog.Warningf("Dropping invalid delayed message for subscription %q", subscription)
+		return nil
+	}
+	attempts, _ := strconv.Atoi(attributes[attributeDelayAttempts])
+
+	message := &buses.Message{
+		Headers: make(map[string]string, len(attributes)),
+		Payload: data,
+	}
+	for name, value := range attributes {
+		if !strings.HasPrefix(strings.ToLower(name), "knative-delay-") {
+			message.Headers[name] = value
+		}
+	}
+
+	if time.Until(at) > 0 {
+		return b.delay(subscription, message, at, attempts)
+	}
+
+	b.delayMutex.Lock()
+	subscriber, ok := b.subscribers[subscription]
+	owns := b.ownsSubscription
+	b.delayMutex.Unlock()
+	if !ok && owns != nil {
+		if parts := strings.SplitN(subscription, "/", 2); len(parts) == 2 && !owns(parts[0], parts[1]) {
+			return errSubscriptionNotOwned
+		}
+	}
+	failed := false
+	if ok {
+		err := subscriber.Dispatch(message)
+		if err == nil {
+			return nil
+		}
+		// a closed subscription may be being replaced, other errors are
+		// failures of the subscriber
+		failed = err != buses.ErrSubscriptionClosed
+		if failed {
+			glog.Warningf("Unable to dispatch a delayed message for subscription %s: %v", subscription, err)
+		}
+	}
+
+	// the subscription may not be subscribed to yet, or is being replaced
+	if attempts++; attempts > maxDelayAttempts {
+		if failed {
+			delayedDispatchFailures.WithLabelValues(b.name, "dropped").Inc()
+		}
+		glog.Warningf("Dropping a delayed message for subscription %s after %d attempts", subscription, maxDelayAttempts)
+		return nil
+	}
+	if failed {
+		delayedDispatchFailures.WithLabelValues(b.name, "retried").Inc()
+	}
+	return b.delay(subscription, message, time.Now().Add(delayRetry), attempts)
+}
+
+// delayTopicID returns the ID of the bus' delay topic, which is also the ID of
+// its subscription.
+func (b *PubSubBus) delayTopicID() string {
+	return fmt.Sprintf("delay-%s", b.name)
+}
+
+func delayKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
@@ -0,0 +1,400 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"container/list"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "bearer "
+
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+
+	// IngressTokenAudience is the audience service account tokens presented
+	// to a Channel's ingress must be issued for. Tokens for other audiences,
+	// such as the API server, are rejected by API servers that support token
+	// audiences (Kubernetes 1.13 and later). Older API servers ignore the
+	// audience and authenticate any token of the service account.
+	IngressTokenAudience = "channels.knative.dev"
+
+	// ingressDecisionTTL is how long an authorization decision for a token is
+	// reused before the API server is consulted again.
+	ingressDecisionTTL = time.Minute
+
+	// apiKeySecretTTL is how long the content of an API key Secret is reused
+	// before it is read again, so a rotated key is accepted, and the old key
+	// rejected, within apiKeySecretTTL plus ingressDecisionTTL.
+	apiKeySecretTTL = time.Minute
+
+	// ingressDecisionCacheSize is the number of decisions cached, the least
+	// recently used decision is evicted first.
+	ingressDecisionCacheSize = 4096
+
+	// tokenReviewQPS and tokenReviewBurst limit the rate of TokenReview calls,
+	// so requests with random tokens can not flood the API server.
+	tokenReviewQPS   = 20
+	tokenReviewBurst = 50
+)
+
+var (
+	// ErrUnauthenticated is returned when a publisher to a Channel with an
+	// ingress policy does not present valid credentials.
+	ErrUnauthenticated = errors.New("publisher is not authenticated")
+
+	// ErrForbidden is returned when an authenticated publisher is not allowed
+	// by the Channel's ingress policy.
+	ErrForbidden = errors.New("publisher is not allowed to publish to channel")
+
+	// ErrThrottled is returned when the publisher's token can not be reviewed
+	// because too many tokens were reviewed recently.
+	ErrThrottled = errors.New("too many publisher tokens to review, retry later")
+)
+
+// IngressAuthorizer decides whether a request may publish a message to a
+// channel. AuthorizeIngress returns nil if the request is allowed,
+// ErrUnauthenticated or ErrForbidden if it is rejected, ErrThrottled if it
+// should be retried later, or another error if the decision could not be
+// made.
+type IngressAuthorizer interface {
+	AuthorizeIngress(channel *ChannelReference, req *http.Request) error
+}
+
+// ingressAuthorizer enforces the ingress policy of Channels. Service account
+// tokens are verified with the TokenReview API and API keys are compared with
+// the content of the referenced Secrets. Only the Secrets named by a Channel's
+// policy are read, from the Channel's namespace, and their content is reused
+// for apiKeySecretTTL so publishers with unknown tokens don't cause API
+// requests. Decisions for authenticated publishers are cached per channel and
+// token for ingressDecisionTTL in a bounded LRU cache, or until the Channel
+// changes. Unknown tokens are not cached so they can not evict the decisions
+// of legitimate publishers.
+type ingressAuthorizer struct {
+	// getSecret reads a Secret from the API server.
+	getSecret     func(namespace, name string) (*corev1.Secret, error)
+	secrets       map[apiKeySecretKey]*apiKeySecret
+	reviewLimiter flowcontrol.RateLimiter
+	// review verifies a token with the TokenReview API.
+	review    func(spec tokenReviewSpec) (*tokenReviewStatus, error)
+	decisions map[ingressDecisionKey]*list.Element
+	order     *list.List
+	size      int
+	mutex     *sync.Mutex
+}
+
+type apiKeySecretKey struct {
+	namespace string
+	name      string
+}
+
+// apiKeySecret is the content of an API key Secret read from the API server.
+// data is nil if the Secret does not exist or may not be read.
+type apiKeySecret struct {
+	data    map[string][]byte
+	expires time.Time
+}
+
+type ingressDecisionKey struct {
+	channel channelKey
+	token   string
+}
+
+type ingressDecision struct {
+	key     ingressDecisionKey
+	err     error
+	expires time.Time
+}
+
+// tokenReview mirrors authenticationv1.TokenReview with the audiences added in
+// Kubernetes 1.13, which the vendored API types predate.
+type tokenReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Spec            tokenReviewSpec   `json:"spec"`
+	Status          tokenReviewStatus `json:"status,omitempty"`
+}
+
+type tokenReviewSpec struct {
+	Token     string   `json:"token,omitempty"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type tokenReviewStatus struct {
+	authenticationv1.TokenReviewStatus `json:",inline"`
+	// Audiences are the audiences of the token that are also in the
+	// requested audiences. API servers that do not support audiences leave it
+	// empty.
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+func newIngressAuthorizer(kubeclientset kubernetes.Interface) *ingressAuthorizer {
+	return &ingressAuthorizer{
+		getSecret: func(namespace, name string) (*corev1.Secret, error) {
+			return kubeclientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		},
+		secrets:       make(map[apiKeySecretKey]*apiKeySecret),
+		reviewLimiter: flowcontrol.NewTokenBucketRateLimiter(tokenReviewQPS, tokenReviewBurst),
+		review:        tokenReviewer(kubeclientset),
+		decisions:     make(map[ingressDecisionKey]*list.Element),
+		order:         list.New(),
+		size:          ingressDecisionCacheSize,
+		mutex:         &sync.Mutex{},
+	}
+}
+
+// authorize checks the request against the Channel's ingress policy. Channels
+// without an ingress policy accept every request.
+func (a *ingressAuthorizer) authorize(channel *channelsv1alpha1.Channel, req *http.Request) error {
+	policy := channel.Spec.IngressPolicy
+	if policy == nil {
+		return nil
+	}
+
+	token := bearerToken(req)
+	if token == "" {
+		return ErrUnauthenticated
+	}
+
+	key := ingressDecisionKey{
+		channel: makeChannelKeyFromChannel(channel),
+		token:   token,
+	}
+	if err, ok := a.cachedDecision(key); ok {
+		return err
+	}
+
+	err := a.decide(channel, policy, token)
+	if err == nil || err == ErrForbidden {
+		// only cache decisions for authenticated publishers, errors talking
+		// to the API server should be retried on the next request
+		a.cacheDecision(key, err)
+	}
+	return err
+}
+
+func (a *ingressAuthorizer) decide(channel *channelsv1alpha1.Channel, policy *channelsv1alpha1.ChannelIngressPolicy, token string) error {
+	for _, selector := range policy.APIKeySecrets {
+		data := a.apiKeys(channel, selector.Name)
+		if apiKey, ok := data[selector.Key]; ok && subtle.ConstantTimeCompare(apiKey, []byte(token)) == 1 {
+			return nil
+		}
+	}
+
+	if len(policy.ServiceAccounts) == 0 {
+		return ErrUnauthenticated
+	}
+	if !a.reviewLimiter.TryAccept() {
+		return ErrThrottled
+	}
+
+	review, err := a.review(tokenReviewSpec{
+		Token:     token,
+		Audiences: []string{IngressTokenAudience},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to review publisher token: %v", err)
+	}
+	if !review.Authenticated {
+		return ErrUnauthenticated
+	}
+	// API servers that do not support audiences report none, those that do
+	// only authenticate tokens issued for one of the requested audiences
+	if len(review.Audiences) > 0 && !hasAudience(review.Audiences, IngressTokenAudience) {
+		return ErrUnauthenticated
+	}
+
+	username := review.User.Username
+	for _, sa := range policy.ServiceAccounts {
+		namespace := sa.Namespace
+		if namespace == "" {
+			namespace = channel.Namespace
+		}
+		if username == fmt.Sprintf("%s%s:%s", serviceAccountUsernamePrefix, namespace, sa.Name) {
+			return nil
+		}
+	}
+	glog.Infof("Publisher %q is not allowed to publish to channel %s/%s", username, channel.Namespace, channel.Name)
+	return ErrForbidden
+}
+
+// apiKeys returns the content of the named API key Secret in the Channel's
+// namespace, or nil if it can not be read. The content is read from the API
+// server at most once per apiKeySecretTTL, unless reading fails with an error
+// that may be transient.
+func (a *ingressAuthorizer) apiKeys(channel *channelsv1alpha1.Channel, name string) map[string][]byte {
+	key := apiKeySecretKey{namespace: channel.Namespace, name: name}
+	a.mutex.Lock()
+	cached, ok := a.secrets[key]
+	a.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.data
+	}
+
+	var data map[string][]byte
+	secret, err := a.getSecret(key.namespace, key.name)
+	switch {
+	case err == nil:
+		data = secret.Data
+	case apierrors.IsNotFound(err):
+	case apierrors.IsForbidden(err):
+		glog.Warningf("Not allowed to read API key secret %s/%s for channel %q: %v", key.namespace, key.name, channel.Name, err)
+	default:
+		glog.Warningf("Unable to read API key secret %s/%s for channel %q: %v", key.namespace, key.name, channel.Name, err)
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	for k, s := range a.secrets {
+		if now.After(s.expires) {
+			delete(a.secrets, k)
+		}
+	}
+	a.secrets[key] = &apiKeySecret{data: data, expires: now.Add(apiKeySecretTTL)}
+	return data
+}
+
+// tokenReviewer returns a function creating TokenReviews with the clientset's
+// REST client, so the audiences unknown to the vendored API types are sent.
+func tokenReviewer(kubeclientset kubernetes.Interface) func(spec tokenReviewSpec) (*tokenReviewStatus, error) {
+	return func(spec tokenReviewSpec) (*tokenReviewStatus, error) {
+		body, err := json.Marshal(&tokenReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: authenticationv1.SchemeGroupVersion.String(),
+				Kind:       "TokenReview",
+			},
+			Spec: spec,
+		})
+		if err != nil {
+			return nil, err
+		}
+		raw, err := kubeclientset.AuthenticationV1().RESTClient().Post().
+			Resource("tokenreviews").
+			SetHeader("Content-Type", "application/json").
+			Body(body).
+			Do().
+			Raw()
+		if err != nil {
+			return nil, err
+		}
+		review := &tokenReview{}
+		if err := json.Unmarshal(raw, review); err != nil {
+			return nil, err
+		}
+		return &review.Status, nil
+	}
+}
+
+// hasAudience returns true if the audience is one of the audiences.
+func hasAudience(audiences []string, audience string) bool {
+	for _, a := range audiences {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// forget drops the cached decisions for channels matched by match, so the
+// next request is decided with the current policy.
+func (a *ingressAuthorizer) forget(match func(channel channelKey) bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for key, element := range a.decisions {
+		if match(key.channel) {
+			a.order.Remove(element)
+			delete(a.decisions, key)
+		}
+	}
+}
+
+// forgetChannel drops the cached decisions for the channel.
+func (a *ingressAuthorizer) forgetChannel(namespace, name string) {
+	key := makeChannelKeyWithNames(namespace, name)
+	a.forget(func(channel channelKey) bool {
+		return channel == key
+	})
+}
+
+func (a *ingressAuthorizer) cachedDecision(key ingressDecisionKey) (error, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	element, ok := a.decisions[key]
+	if !ok {
+		return nil, false
+	}
+	decision := element.Value.(*ingressDecision)
+	if time.Now().After(decision.expires) {
+		a.order.Remove(element)
+		delete(a.decisions, key)
+		return nil, false
+	}
+	a.order.MoveToFront(element)
+	return decision.err, true
+}
+
+func (a *ingressAuthorizer) cacheDecision(key ingressDecisionKey, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	decision := &ingressDecision{
+		key:     key,
+		err:     err,
+		expires: time.Now().Add(ingressDecisionTTL),
+	}
+	if element, ok := a.decisions[key]; ok {
+		element.Value = decision
+		a.order.MoveToFront(element)
+		return
+	}
+	a.decisions[key] = a.order.PushFront(decision)
+	for a.order.Len() > a.size {
+		oldest := a.order.Back()
+		a.order.Remove(oldest)
+		delete(a.decisions, oldest.Value.(*ingressDecision).key)
+	}
+}
+
+// bearerToken returns the bearer token from the request's Authorization
+// header, or an empty string if there is none.
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get(authorizationHeader)
+	if len(auth) <= len(bearerPrefix) || strings.ToLower(auth[:len(bearerPrefix)]) != bearerPrefix {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(bearerPrefix):])
+}
@@ -0,0 +1,454 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// newTestIngressAuthorizer creates an authorizer reading the secrets from a
+// fake clientset and reviewing tokens with review.
+func newTestIngressAuthorizer(review func(spec tokenReviewSpec) (*tokenReviewStatus, error), secrets ...*corev1.Secret) (*ingressAuthorizer, *fake.Clientset) {
+	objects := make([]runtime.Object, len(secrets))
+	for i, secret := range secrets {
+		objects[i] = secret
+	}
+	kubeclientset := fake.NewSimpleClientset(objects...)
+	authorizer := newIngressAuthorizer(kubeclientset)
+	authorizer.review = review
+	return authorizer, kubeclientset
+}
+
+// serviceAccountReview authenticates tokens of the form "sa-<namespace>:<name>"
+// as the named service account, when issued for one of the audiences of the
+// token review. Without audiences it behaves like an API server that does not
+// support them, and authenticates the token for any audience.
+func serviceAccountReview(audiences ...string) func(spec tokenReviewSpec) (*tokenReviewStatus, error) {
+	return func(spec tokenReviewSpec) (*tokenReviewStatus, error) {
+		status := &tokenReviewStatus{}
+		parts := strings.SplitN(strings.TrimPrefix(spec.Token, "sa-"), ":", 2)
+		if !strings.HasPrefix(spec.Token, "sa-") || len(parts) != 2 {
+			return status, nil
+		}
+		for _, audience := range audiences {
+			if hasAudience(spec.Audiences, audience) {
+				status.Audiences = append(status.Audiences, audience)
+			}
+		}
+		if len(audiences) > 0 && len(status.Audiences) == 0 {
+			return status, nil
+		}
+		namespace, name := parts[0], parts[1]
+		status.Authenticated = true
+		status.User.Username = serviceAccountUsernamePrefix + namespace + ":" + name
+		return status, nil
+	}
+}
+
+func TestIngressAuthorizer(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "publisher-key"},
+		Data:       map[string][]byte{"key": []byte("s3cr3t")},
+	}
+
+	policy := &channelsv1alpha1.ChannelIngressPolicy{
+		ServiceAccounts: []channelsv1alpha1.ServiceAccountReference{
+			{Name: "publisher"},
+		},
+		APIKeySecrets: []corev1.SecretKeySelector{
+			{LocalObjectReference: corev1.LocalObjectReference{Name: "publisher-key"}, Key: "key"},
+			{LocalObjectReference: corev1.LocalObjectReference{Name: "missing-key"}, Key: "key"},
+		},
+	}
+
+	for _, test := range []struct {
+		name          string
+		policy        *channelsv1alpha1.ChannelIngressPolicy
+		audiences     []string
+		authorization string
+		want          error
+	}{
+		{
+			name: "no policy",
+		},
+		{
+			name:   "missing token",
+			policy: policy,
+			want:   ErrUnauthenticated,
+		},
+		{
+			name:          "api key",
+			policy:        policy,
+			authorization: "Bearer s3cr3t",
+		},
+		{
+			name:          "service account",
+			policy:        policy,
+			audiences:     []string{IngressTokenAudience},
+			authorization: "Bearer sa-ns:publisher",
+		},
+		{
+			name:          "service account token for another audience",
+			policy:        policy,
+			audiences:     []string{"https://kubernetes.default.svc"},
+			authorization: "Bearer sa-ns:publisher",
+			want:          ErrUnauthenticated,
+		},
+		{
+			name:          "service account without audience support",
+			policy:        policy,
+			authorization: "Bearer sa-ns:publisher",
+		},
+		{
+			name:          "service account not in policy",
+			policy:        policy,
+			audiences:     []string{IngressTokenAudience},
+			authorization: "Bearer sa-other:publisher",
+			want:          ErrForbidden,
+		},
+		{
+			name:          "invalid token",
+			policy:        policy,
+			authorization: "Bearer garbage",
+			want:          ErrUnauthenticated,
+		},
+		{
+			name:          "not a bearer token",
+			policy:        policy,
+			authorization: "Basic s3cr3t",
+			want:          ErrUnauthenticated,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			channel := &channelsv1alpha1.Channel{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"},
+				Spec: channelsv1alpha1.ChannelSpec{
+					IngressPolicy: test.policy,
+				},
+			}
+			req := httptest.NewRequest(http.MethodPost, "http://channel.ns.channels.cluster.local/", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			authorizer, _ := newTestIngressAuthorizer(serviceAccountReview(test.audiences...), secret)
+			if got := authorizer.authorize(channel, req); got != test.want {
+				t.Errorf("Unexpected authorization result. want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestIngressAuthorizerCache(t *testing.T) {
+	reviews := 0
+	review := func(spec tokenReviewSpec) (*tokenReviewStatus, error) {
+		reviews++
+		return serviceAccountReview(IngressTokenAudience)(spec)
+	}
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"},
+		Spec: channelsv1alpha1.ChannelSpec{
+			IngressPolicy: &channelsv1alpha1.ChannelIngressPolicy{
+				ServiceAccounts: []channelsv1alpha1.ServiceAccountReference{{Name: "publisher"}},
+			},
+		},
+	}
+	authorize := func(a *ingressAuthorizer, token string) error {
+		req := httptest.NewRequest(http.MethodPost, "http://channel.ns.channels.cluster.local/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.authorize(channel, req)
+	}
+
+	authorizer, _ := newTestIngressAuthorizer(review)
+	authorizer.size = 2
+
+	// decisions for authenticated publishers are cached
+	for i := 0; i < 3; i++ {
+		if err := authorize(authorizer, "sa-ns:publisher"); err != nil {
+			t.Fatalf("Unexpected authorization result. want %v, got %v", nil, err)
+		}
+	}
+	if reviews != 1 {
+		t.Errorf("Unexpected number of token reviews. want 1, got %d", reviews)
+	}
+
+	// unknown tokens are not cached, so they don't evict other decisions
+	reviews = 0
+	for i := 0; i < 3; i++ {
+		authorize(authorizer, fmt.Sprintf("garbage-%d", i))
+	}
+	authorize(authorizer, "sa-ns:publisher")
+	if reviews != 3 {
+		t.Errorf("Unexpected number of token reviews with unknown tokens. want 3, got %d", reviews)
+	}
+	if len(authorizer.decisions) != 1 {
+		t.Errorf("Unexpected cache size. want 1, got %d", len(authorizer.decisions))
+	}
+
+	// the least recently used decision is evicted
+	authorize(authorizer, "sa-ns:other")
+	authorize(authorizer, "sa-ns:publisher")
+	authorize(authorizer, "sa-other:publisher")
+	if len(authorizer.decisions) != 2 || authorizer.order.Len() != 2 {
+		t.Errorf("Unexpected cache size. want 2, got %d", len(authorizer.decisions))
+	}
+	reviews = 0
+	authorize(authorizer, "sa-ns:publisher")
+	authorize(authorizer, "sa-ns:other")
+	if reviews != 1 {
+		t.Errorf("Unexpected number of token reviews after eviction. want 1, got %d", reviews)
+	}
+
+	// decisions are forgotten when the channel changes
+	authorize(authorizer, "sa-ns:publisher")
+	authorizer.forgetChannel("ns", "channel")
+	reviews = 0
+	authorize(authorizer, "sa-ns:publisher")
+	if reviews != 1 {
+		t.Errorf("Unexpected number of token reviews after forgetting. want 1, got %d", reviews)
+	}
+	authorizer.forgetChannel("ns", "other-channel")
+	authorizer.forgetChannel("other", "channel")
+	reviews = 0
+	authorize(authorizer, "sa-ns:publisher")
+	if reviews != 0 {
+		t.Errorf("Unexpected number of token reviews after forgetting other channels. want 0, got %d", reviews)
+	}
+
+	// token reviews are rate limited
+	authorizer, _ = newTestIngressAuthorizer(review)
+	authorizer.reviewLimiter = flowcontrol.NewTokenBucketRateLimiter(0.001, 2)
+	var errs []error
+	for i := 0; i < 3; i++ {
+		errs = append(errs, authorize(authorizer, fmt.Sprintf("random-%d", i)))
+	}
+	want := []error{ErrUnauthenticated, ErrUnauthenticated, ErrThrottled}
+	for i := range want {
+		if errs[i] != want[i] {
+			t.Errorf("Unexpected authorization result %d. want %v, got %v", i, want[i], errs[i])
+		}
+	}
+	// throttled requests are not cached
+	if _, ok := authorizer.cachedDecision(ingressDecisionKey{channel: makeChannelKeyFromChannel(channel), token: "random-2"}); ok {
+		t.Errorf("Unexpected cached decision for a throttled request")
+	}
+}
+
+func TestIngressAuthorizerAPIKeysNotThrottled(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "publisher-key"},
+		Data:       map[string][]byte{"key": []byte("s3cr3t")},
+	}
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"},
+		Spec: channelsv1alpha1.ChannelSpec{
+			IngressPolicy: &channelsv1alpha1.ChannelIngressPolicy{
+				ServiceAccounts: []channelsv1alpha1.ServiceAccountReference{{Name: "publisher"}},
+				APIKeySecrets: []corev1.SecretKeySelector{
+					{LocalObjectReference: corev1.LocalObjectReference{Name: "publisher-key"}, Key: "key"},
+				},
+			},
+		},
+	}
+	authorize := func(a *ingressAuthorizer, token string) error {
+		req := httptest.NewRequest(http.MethodPost, "http://channel.ns.channels.cluster.local/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.authorize(channel, req)
+	}
+
+	authorizer, kubeclientset := newTestIngressAuthorizer(serviceAccountReview(IngressTokenAudience), secret)
+	authorizer.reviewLimiter = flowcontrol.NewTokenBucketRateLimiter(0.001, 1)
+
+	// random tokens exhaust the token reviews, API keys are still checked
+	authorize(authorizer, "random-1")
+	if err := authorize(authorizer, "random-2"); err != ErrThrottled {
+		t.Errorf("Unexpected authorization result. want %v, got %v", ErrThrottled, err)
+	}
+	if err := authorize(authorizer, "s3cr3t"); err != nil {
+		t.Errorf("Unexpected authorization result. want %v, got %v", nil, err)
+	}
+
+	// a rotated key is rejected once the cached secret and decision expire
+	rotated := secret.DeepCopy()
+	rotated.Data["key"] = []byte("n3w")
+	kubeclientset.CoreV1().Secrets("ns").Update(rotated)
+	for _, cached := range authorizer.secrets {
+		cached.expires = time.Now()
+	}
+	authorizer.forgetChannel("ns", "channel")
+	if err := authorize(authorizer, "s3cr3t"); err != ErrThrottled {
+		t.Errorf("Unexpected authorization result for a rotated key. want %v, got %v", ErrThrottled, err)
+	}
+	if err := authorize(authorizer, "n3w"); err != nil {
+		t.Errorf("Unexpected authorization result. want %v, got %v", nil, err)
+	}
+}
+
+func TestIngressAuthorizerReadsNamedSecrets(t *testing.T) {
+	secrets := []*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "publisher-key"},
+			Data:       map[string][]byte{"key": []byte("s3cr3t")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "other-key"},
+			Data:       map[string][]byte{"key": []byte("0th3r")},
+		},
+	}
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"},
+		Spec: channelsv1alpha1.ChannelSpec{
+			IngressPolicy: &channelsv1alpha1.ChannelIngressPolicy{
+				APIKeySecrets: []corev1.SecretKeySelector{
+					{LocalObjectReference: corev1.LocalObjectReference{Name: "publisher-key"}, Key: "key"},
+					{LocalObjectReference: corev1.LocalObjectReference{Name: "missing-key"}, Key: "key"},
+					{LocalObjectReference: corev1.LocalObjectReference{Name: "other-key"}, Key: "key"},
+				},
+			},
+		},
+	}
+	authorize := func(a *ingressAuthorizer, token string) error {
+		req := httptest.NewRequest(http.MethodPost, "http://channel.ns.channels.cluster.local/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.authorize(channel, req)
+	}
+
+	authorizer, kubeclientset := newTestIngressAuthorizer(serviceAccountReview(IngressTokenAudience), secrets...)
+	for i := 0; i < 3; i++ {
+		if err := authorize(authorizer, fmt.Sprintf("random-%d", i)); err != ErrUnauthenticated {
+			t.Errorf("Unexpected authorization result. want %v, got %v", ErrUnauthenticated, err)
+		}
+	}
+	// secrets of other namespaces can not be named
+	if err := authorize(authorizer, "0th3r"); err != ErrUnauthenticated {
+		t.Errorf("Unexpected authorization result. want %v, got %v", ErrUnauthenticated, err)
+	}
+	if err := authorize(authorizer, "s3cr3t"); err != nil {
+		t.Errorf("Unexpected authorization result. want %v, got %v", nil, err)
+	}
+
+	// each named secret is read once from the channel's namespace, missing
+	// secrets included
+	var got []string
+	for _, action := range kubeclientset.Actions() {
+		get, ok := action.(clienttesting.GetAction)
+		if !ok || action.GetVerb() != "get" || action.GetResource().Resource != "secrets" {
+			t.Fatalf("Unexpected action %v", action)
+		}
+		got = append(got, get.GetNamespace()+"/"+get.GetName())
+	}
+	want := []string{"ns/publisher-key", "ns/missing-key", "ns/other-key"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Unexpected secrets read. want %v, got %v", want, got)
+	}
+}
+
+func TestTokenReviewer(t *testing.T) {
+	var got tokenReview
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/apis/authentication.k8s.io/v1/tokenreviews" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Unexpected error decoding the token review: %v", err)
+		}
+		review := got
+		review.Status.Authenticated = true
+		review.Status.User.Username = "system:serviceaccount:ns:publisher"
+		review.Status.Audiences = got.Spec.Audiences
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&review)
+	}))
+	defer server.Close()
+
+	kubeclientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error creating the clientset: %v", err)
+	}
+	status, err := tokenReviewer(kubeclientset)(tokenReviewSpec{
+		Token:     "token",
+		Audiences: []string{IngressTokenAudience},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error reviewing the token: %v", err)
+	}
+
+	if got.Kind != "TokenReview" || got.Spec.Token != "token" || !hasAudience(got.Spec.Audiences, IngressTokenAudience) {
+		t.Errorf("Unexpected token review request. want token %q for audience %q, got %+v", "token", IngressTokenAudience, got)
+	}
+	if !status.Authenticated || status.User.Username != "system:serviceaccount:ns:publisher" || !hasAudience(status.Audiences, IngressTokenAudience) {
+		t.Errorf("Unexpected token review status. got %+v", status)
+	}
+}
+
+type staticAuthorizer struct {
+	err error
+}
+
+func (a staticAuthorizer) AuthorizeIngress(*ChannelReference, *http.Request) error {
+	return a.err
+}
+
+func TestMessageReceiverIngressAuthorization(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "allowed", status: http.StatusAccepted},
+		{name: "unauthenticated", err: ErrUnauthenticated, status: http.StatusUnauthorized},
+		{name: "forbidden", err: ErrForbidden, status: http.StatusForbidden},
+		{name: "throttled", err: ErrThrottled, status: http.StatusTooManyRequests},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			received := false
+			receiver := NewMessageReceiver(func(*ChannelReference, *Message) error {
+				received = true
+				return nil
+			})
+			receiver.SetIngressAuthorizer(staticAuthorizer{err: test.err})
+
+			req := httptest.NewRequest(http.MethodPost, "http://channel.ns.channels.cluster.local/", nil)
+			res := httptest.NewRecorder()
+			receiver.HandleRequest(res, req)
+
+			if res.Code != test.status {
+				t.Errorf("Unexpected status code. want %d, got %d", test.status, res.Code)
+			}
+			if received != (test.err == nil) {
+				t.Errorf("Unexpected delivery to the receiver func. want %v, got %v", test.err == nil, received)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+	"github.com/golang/glog"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/knative/eventing/pkg/event"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// NumPartitions is the Channel parameter for the number of partitions of
+	// the Channel's topic.
+	NumPartitions = "NumPartitions"
+
+	// InitialOffset is the Subscription parameter for where a new consumer
+	// starts reading the Channel's topic, either Oldest or Newest.
+	InitialOffset = "initialOffset"
+	Newest        = "Newest"
+	Oldest        = "Oldest"
+)
+
+// KafkaBus backs each Channel with a Kafka topic named
+// `<namespace>.<channel-name>` and each Subscription with a consumer group.
+// Messages which are not due for delivery yet are held in the bus' delay
+// topics.
+type KafkaBus struct {
+	name    string
+	brokers []string
+	config  *sarama.Config
+
+	// admin, client and producers are created on first use, as each is only
+	// needed by one role
+	admin        sarama.ClusterAdmin
+	client       sarama.Client
+	producer     sarama.AsyncProducer
+	producerSync sarama.SyncProducer
+	// subscribers are the Subscribers of the dispatcher's subscriptions by
+	// `<namespace>/<name>`, for delayed messages to be dispatched to
+	subscribers map[string]buses.Subscriber
+	mutex       *sync.Mutex
+
+	delayConsumer *cluster.Consumer
+	delayMutex    *sync.Mutex
+}
+
+// NewKafkaBus creates a bus backed by the Kafka brokers. The client ID
+// identifies the bus component to the brokers.
+func NewKafkaBus(name string, brokers []string, clientID string) *KafkaBus {
+	config := sarama.NewConfig()
+	config.Version = sarama.V1_1_0_0
+	config.ClientID = clientID
+
+	return &KafkaBus{
+		name:        name,
+		brokers:     brokers,
+		config:      config,
+		subscribers: make(map[string]buses.Subscriber),
+		mutex:       &sync.Mutex{},
+		delayMutex:  &sync.Mutex{},
+	}
+}
+
+// Provision creates the topic for the Channel.
+func (b *KafkaBus) Provision(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+	admin, err := b.clusterAdmin()
+	if err != nil {
+		return err
+	}
+
+	topicName := topicName(channel.Namespace, channel.Name)
+	glog.Infof("Provisioning topic %s on bus backed by Kafka", topicName)
+
+	partitions := 1
+	if p, ok := parameters[NumPartitions]; ok {
+		var err error
+		partitions, err = strconv.Atoi(p)
+		if err != nil {
+			glog.Warningf("Could not parse partition count for %s/%s: %s", channel.Namespace, channel.Name, p)
+		}
+	}
+
+	err = admin.CreateTopic(topicName, &sarama.TopicDetail{ReplicationFactor: 1, NumPartitions: int32(partitions)}, false)
+	if err == sarama.ErrTopicAlreadyExists {
+		return nil
+	} else if err != nil {
+		glog.Errorf("Error creating topic %s: %v", topicName, err)
+	} else {
+		glog.Infof("Successfully created topic %s", topicName)
+	}
+	return err
+}
+
+// Unprovision deletes the topic for the Channel.
+func (b *KafkaBus) Unprovision(channel *channelsv1alpha1.Channel) error {
+	return b.DeleteChannelResource(topicName(channel.Namespace, channel.Name))
+}
+
+// ChannelResourceName returns the name of the Channel's topic.
+func (b *KafkaBus) ChannelResourceName(namespace, name string) string {
+	return topicName(namespace, name)
+}
+
+// ChannelResources lists the topics named like the topic of a Channel. As
+// topics are not named after the bus, the topics of every Kafka bus sharing
+// the brokers, or of other applications, are listed. Garbage collection
+// leaves them alone as this bus did not provision them.
+func (b *KafkaBus) ChannelResources() ([]string, error) {
+	client, err := b.metadataClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.RefreshMetadata(); err != nil {
+		return nil, err
+	}
+	topics, err := client.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	var channelTopics []string
+	for _, topic := range topics {
+		if isChannelTopic(topic) {
+			channelTopics = append(channelTopics, topic)
+		}
+	}
+	return channelTopics, nil
+}
+
+// DeleteChannelResource deletes the topic.
+func (b *KafkaBus) DeleteChannelResource(topicName string) error {
+	admin, err := b.clusterAdmin()
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Un-provisioning topic %s from bus backed by Kafka", topicName)
+
+	err = admin.DeleteTopic(topicName)
+	if err == sarama.ErrUnknownTopicOrPartition {
+		return nil
+	} else if err != nil {
+		glog.Errorf("Error deleting topic %s: %v", topicName, err)
+	} else {
+		glog.Infof("Successfully deleted topic %s", topicName)
+	}
+
+	return err
+}
+
+// Publish writes the message to the Channel's topic.
+func (b *KafkaBus) Publish(channel *channelsv1alpha1.Channel, message *buses.Message) error {
+	producer, err := b.asyncProducer()
+	if err != nil {
+		return err
+	}
+	producer.Input() <- toKafkaMessage(topicName(channel.Namespace, channel.Name), message)
+	return nil
+}
+
+// Subscribe creates a consumer for the Subscription that reads the Channel's
+// topic until the handle is closed.
+func (b *KafkaBus) Subscribe(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters, subscriber buses.Subscriber) (buses.SubscriptionHandle, error) {
+	glog.Infof("Subscribing %s/%s: %s -> %s  (%v)", subscription.Namespace,
+		subscription.Name, subscription.Spec.Channel, subscription.Spec.Subscriber, parameters)
+	topicName := topicName(subscription.Namespace, subscription.Spec.Channel)
+
+	initialOffset, err := initialOffset(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.startDelayConsumer(); err != nil {
+		return nil, err
+	}
+
+	group := fmt.Sprintf("%s.%s.%s", b.name, subscription.Namespace, subscription.Name)
+	consumerConfig := cluster.NewConfig()
+	consumerConfig.Version = sarama.V1_1_0_0
+	consumerConfig.Consumer.Offsets.Initial = initialOffset
+	consumer, err := cluster.NewConsumer(b.brokers, group, []string{topicName}, consumerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if policy := buses.BatchingPolicyForSubscription(subscription.Spec); policy != nil {
+			// A batch only fills if its messages are dispatched concurrently.
+			dispatchPipelined(consumer, subscriber, policy.MaxEvents)
+		} else {
+			for msg := range consumer.Messages() {
+				glog.Infof("Dispatching a message for subscription %s/%s: %s -> %s", subscription.Namespace,
+					subscription.Name, subscription.Spec.Channel, subscription.Spec.Subscriber)
+				// Messages which are not due are written to a delay topic
+				// before Dispatch returns.
+				err := subscriber.Dispatch(fromKafkaMessage(msg))
+				if err == buses.ErrSubscriptionClosed {
+					break
+				}
+				// TODO: handle errors with pluggable strategy
+				consumer.MarkOffset(msg, "") // Mark message as processed
+			}
+		}
+		glog.Infof("Consumer for subscription %s/%s stopped", subscription.Namespace, subscription.Name)
+	}()
+
+	key := delayKey(subscription.Namespace, subscription.Name)
+	b.mutex.Lock()
+	b.subscribers[key] = subscriber
+	b.mutex.Unlock()
+
+	return buses.SubscriptionHandleFunc(func() error {
+		glog.Infof("Un-Subscribing %s/%s: %s -> %s", subscription.Namespace,
+			subscription.Name, subscription.Spec.Channel, subscription.Spec.Subscriber)
+		b.mutex.Lock()
+		if b.subscribers[key] == subscriber {
+			delete(b.subscribers, key)
+		}
+		b.mutex.Unlock()
+		return consumer.Close()
+	}), nil
+}
+
+// dispatchPipelined dispatches up to depth messages concurrently. The offset
+// of a message is marked once it and all messages before it are dispatched,
+// offsets are not marked after the subscription is closed.
+func dispatchPipelined(consumer *cluster.Consumer, subscriber buses.Subscriber, depth int) {
+	type pending struct {
+		msg  *sarama.ConsumerMessage
+		done chan error
+	}
+
+	queue := make(chan pending, depth)
+	marked := make(chan struct{})
+	go func() {
+		defer close(marked)
+		closed := false
+		for p := range queue {
+			if err := <-p.done; err == buses.ErrSubscriptionClosed {
+				closed = true
+			}
+			if !closed {
+				// TODO: handle errors with pluggable strategy
+				consumer.MarkOffset(p.msg, "")
+			}
+		}
+	}()
+
+	for msg := range consumer.Messages() {
+		p := pending{msg: msg, done: make(chan error, 1)}
+		queue <- p
+		go func() {
+			p.done <- subscriber.Dispatch(fromKafkaMessage(p.msg))
+		}()
+	}
+	close(queue)
+	<-marked
+}
+
+func (b *KafkaBus) clusterAdmin() (sarama.ClusterAdmin, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.admin == nil {
+		admin, err := sarama.NewClusterAdmin(b.brokers, b.config)
+		if err != nil {
+			return nil, fmt.Errorf("error building kafka admin client: %v", err)
+		}
+		b.admin = admin
+	}
+	return b.admin, nil
+}
+
+func (b *KafkaBus) metadataClient() (sarama.Client, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.client == nil {
+		client, err := sarama.NewClient(b.brokers, b.config)
+		if err != nil {
+			return nil, fmt.Errorf("error building kafka client: %v", err)
+		}
+		b.client = client
+	}
+	return b.client, nil
+}
+
+func (b *KafkaBus) asyncProducer() (sarama.AsyncProducer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.producer == nil {
+		producer, err := sarama.NewAsyncProducer(b.brokers, b.config)
+		if err != nil {
+			return nil, fmt.Errorf("error building kafka producer: %v", err)
+		}
+		go func() {
+			for {
+				select {
+				case e := <-producer.Errors():
+					glog.Warningf("Got %v", e)
+				case s := <-producer.Successes():
+					glog.Infof("Sent %v", s)
+				}
+			}
+		}()
+		b.producer = producer
+	}
+	return b.producer, nil
+}
+
+func initialOffset(parameters buses.ResolvedParameters) (int64, error) {
+	sInitial := parameters[InitialOffset]
+	if sInitial == Oldest {
+		return sarama.OffsetOldest, nil
+	} else if sInitial == Newest {
+		return sarama.OffsetNewest, nil
+	} else {
+		return 0, fmt.Errorf("unsupported initialOffset value. Must be one of %s or %s", Oldest, Newest)
+	}
+}
+
+func topicName(namespace, channel string) string {
+	return fmt.Sprintf("%s.%s", namespace, channel)
+}
+
+// isChannelTopic returns true if the topic is named like the topic of a
+// Channel, `<namespace>.<channel-name>`.
+func isChannelTopic(topic string) bool {
+	parts := strings.SplitN(topic, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	return len(validation.IsDNS1123Label(parts[0])) == 0 && len(validation.IsDNS1123Subdomain(parts[1])) == 0
+}
+
+// toKafkaMessage writes a CloudEvent message in the CloudEvents Kafka binding,
+// in the mode it was received in. Other headers, such as tracing headers, are
+// kept as is. Messages which are not CloudEvents are written with their
+// headers as is.
+func toKafkaMessage(topic string, message *buses.Message) *sarama.ProducerMessage {
+	kafkaMessage := sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(message.Payload),
+	}
+
+	record, err := toKafkaRecord(message)
+	if err != nil {
+		glog.V(4).Infof("Writing message as is, it is not a CloudEvent: %v", err)
+		for h, v := range message.Headers {
+			kafkaMessage.Headers = append(kafkaMessage.Headers, sarama.RecordHeader{Key: []byte(h), Value: []byte(v)})
+		}
+		return &kafkaMessage
+	}
+
+	kafkaMessage.Value = sarama.ByteEncoder(record.Value)
+	if record.Key != nil {
+		kafkaMessage.Key = sarama.ByteEncoder(record.Key)
+	}
+	for h, v := range record.Headers {
+		kafkaMessage.Headers = append(kafkaMessage.Headers, sarama.RecordHeader{Key: []byte(h), Value: v})
+	}
+	for h, v := range message.Headers {
+		if !isEventHeader(h) {
+			kafkaMessage.Headers = append(kafkaMessage.Headers, sarama.RecordHeader{Key: []byte(h), Value: []byte(v)})
+		}
+	}
+	return &kafkaMessage
+}
+
+func toKafkaRecord(message *buses.Message) (*event.KafkaRecord, error) {
+	req := &http.Request{
+		Header: http.Header{},
+		Body:   ioutil.NopCloser(bytes.NewReader(message.Payload)),
+	}
+	for h, v := range message.Headers {
+		req.Header.Set(h, v)
+	}
+	var data []byte
+	context, err := event.FromRequest(&data, req)
+	if err != nil {
+		return nil, err
+	}
+	mode := event.BinaryMode
+	if isStructured(req.Header.Get(event.HeaderContentType)) {
+		mode = event.StructuredMode
+	}
+	return event.NewKafkaRecord(mode, data, *context)
+}
+
+// fromKafkaMessage reads a message from a record. Records of the CloudEvents
+// Kafka binding are read in either mode, which lets the bus dispatch events
+// written by producers other than the bus. Other headers are kept as is.
+// Records which are not CloudEvents, including those written by earlier
+// versions of the bus, are read with their headers as is.
+func fromKafkaMessage(kafkaMessage *sarama.ConsumerMessage) *buses.Message {
+	record := &event.KafkaRecord{
+		Key:     kafkaMessage.Key,
+		Headers: make(map[string][]byte, len(kafkaMessage.Headers)),
+		Value:   kafkaMessage.Value,
+	}
+	for _, header := range kafkaMessage.Headers {
+		record.Headers[string(header.Key)] = header.Value
+	}
+
+	message, err := fromKafkaRecord(record)
+	if err != nil {
+		glog.V(4).Infof("Reading record as is, it is not a CloudEvent: %v", err)
+		headers := make(map[string]string)
+		for name, value := range record.Headers {
+			headers[name] = string(value)
+		}
+		return &buses.Message{
+			Headers: headers,
+			Payload: kafkaMessage.Value,
+		}
+	}
+	return message
+}
+
+func fromKafkaRecord(record *event.KafkaRecord) (*buses.Message, error) {
+	var data []byte
+	context, err := event.FromKafkaRecord(&data, record)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if isStructured(string(record.Headers[event.KafkaHeaderContentType])) {
+		req, err = event.Structured.NewRequest("", data, *context)
+	} else {
+		req, err = event.Binary.NewRequest("", data, *context)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string)
+	for name, value := range record.Headers {
+		if !isEventHeader(name) {
+			headers[name] = string(value)
+		}
+	}
+	// binary 0.1 extension headers are not in canonical form
+	for name, values := range req.Header {
+		if len(values) > 0 {
+			headers[name] = values[0]
+		}
+	}
+	return &buses.Message{
+		Headers: headers,
+		Payload: payload,
+	}, nil
+}
+
+// isEventHeader returns true if the header carries the content type or a
+// context attribute of an event, either over HTTP or in the Kafka binding.
+func isEventHeader(name string) bool {
+	name = strings.ToLower(name)
+	return name == event.KafkaHeaderContentType ||
+		strings.HasPrefix(name, strings.ToLower(event.KafkaHeaderPrefix)) ||
+		strings.HasPrefix(name, "ce-")
+}
+
+func isStructured(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), event.ContentTypeStructuredJSON)
+}
@@ -0,0 +1,462 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package kafka
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/knative/eventing/pkg/buses/conformance"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestConformance runs the bus conformance suite against the brokers in
+// KAFKA_BROKERS, for example a single local broker started for the test. The
+// suite needs messages to round trip through a broker, which a MockBroker
+// can't do, the tests below cover the bus' requests to a MockBroker.
+func TestConformance(t *testing.T) {
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_BROKERS is not set")
+	}
+
+	// read from the start of each topic so messages published before the
+	// consumer group is balanced are delivered
+	oldest := Oldest
+	conformance.RunTests(t, NewKafkaBus("conformance", strings.Split(brokers, ","), "conformance"), conformance.Config{
+		Parameters: &channelsv1alpha1.BusParameters{
+			Subscription: &[]channelsv1alpha1.Parameter{
+				{Name: InitialOffset, Default: &oldest},
+			},
+		},
+		// the offsets of failed dispatches are marked
+		NoRedelivery: true,
+	})
+}
+
+// newMockBroker starts a broker that is the controller and the leader of the
+// topic of the default/channel Channel. Admin responses are of the versions
+// requested by the bus' client version.
+func newMockBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("default.channel", 0, broker.BrokerID()).
+			SetLeader("__consumer_offsets", 0, broker.BrokerID()),
+		"CreateTopicsRequest": sarama.NewMockWrapper(&sarama.CreateTopicsResponse{
+			Version:     2,
+			TopicErrors: map[string]*sarama.TopicError{"default.channel": {Err: sarama.ErrNoError}},
+		}),
+		"DeleteTopicsRequest": sarama.NewMockWrapper(&sarama.DeleteTopicsResponse{
+			Version:         1,
+			TopicErrorCodes: map[string]sarama.KError{"default.channel": sarama.ErrNoError},
+		}),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+	return broker
+}
+
+// awaitRequest waits for the broker to receive a request matching the func.
+func awaitRequest(t *testing.T, broker *sarama.MockBroker, match func(request interface{}) bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, rr := range broker.History() {
+			if match(rr.Request) {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for a request to the broker")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProvisionMockBroker(t *testing.T) {
+	broker := newMockBroker(t)
+	defer broker.Close()
+	bus := NewKafkaBus("kafka", []string{broker.Addr()}, "test")
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "channel"},
+	}
+
+	if err := bus.Provision(channel, buses.ResolvedParameters{NumPartitions: "3"}); err != nil {
+		t.Fatalf("Unexpected provision error: %v", err)
+	}
+	awaitRequest(t, broker, func(request interface{}) bool {
+		create, ok := request.(*sarama.CreateTopicsRequest)
+		if !ok {
+			return false
+		}
+		detail := create.TopicDetails["default.channel"]
+		if detail == nil || detail.NumPartitions != 3 {
+			t.Errorf("Unexpected topic detail for %q: %v", "default.channel", detail)
+		}
+		return true
+	})
+
+	resources, err := bus.ChannelResources()
+	if err != nil {
+		t.Fatalf("Unexpected error listing channel resources: %v", err)
+	}
+	if want := []string{"default.channel"}; !reflect.DeepEqual(want, resources) {
+		t.Errorf("Unexpected channel resources. want %v, got %v", want, resources)
+	}
+
+	if err := bus.Unprovision(channel); err != nil {
+		t.Fatalf("Unexpected unprovision error: %v", err)
+	}
+	awaitRequest(t, broker, func(request interface{}) bool {
+		del, ok := request.(*sarama.DeleteTopicsRequest)
+		if !ok {
+			return false
+		}
+		if want := []string{"default.channel"}; !reflect.DeepEqual(want, del.Topics) {
+			t.Errorf("Unexpected deleted topics. want %v, got %v", want, del.Topics)
+		}
+		return true
+	})
+}
+
+func TestPublishMockBroker(t *testing.T) {
+	broker := newMockBroker(t)
+	defer broker.Close()
+	bus := NewKafkaBus("kafka", []string{broker.Addr()}, "test")
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "channel"},
+	}
+
+	message := &buses.Message{
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Payload: []byte("hello"),
+	}
+	if err := bus.Publish(channel, message); err != nil {
+		t.Fatalf("Unexpected publish error: %v", err)
+	}
+	awaitRequest(t, broker, func(request interface{}) bool {
+		_, ok := request.(*sarama.ProduceRequest)
+		return ok
+	})
+}
+
+func TestIsChannelTopic(t *testing.T) {
+	for topic, want := range map[string]bool{
+		"default.channel":      true,
+		"default.my.channel":   true,
+		"__consumer_offsets":   false,
+		"channel":              false,
+		"Default.channel":      false,
+		"default.":             false,
+		"default.Channel_Name": false,
+	} {
+		if got := isChannelTopic(topic); got != want {
+			t.Errorf("Unexpected isChannelTopic(%q). want %v, got %v", topic, want, got)
+		}
+	}
+}
+
+// consumed returns the record a consumer reads for a produced message.
+func consumed(t *testing.T, produced *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	value, err := produced.Value.Encode()
+	if err != nil {
+		t.Fatalf("Unexpected error encoding value: %v", err)
+	}
+	consumed := &sarama.ConsumerMessage{Topic: produced.Topic, Value: value}
+	if produced.Key != nil {
+		if consumed.Key, err = produced.Key.Encode(); err != nil {
+			t.Fatalf("Unexpected error encoding key: %v", err)
+		}
+	}
+	for i := range produced.Headers {
+		consumed.Headers = append(consumed.Headers, &produced.Headers[i])
+	}
+	return consumed
+}
+
+func recordHeaders(message *sarama.ProducerMessage) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range message.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	return headers
+}
+
+func TestKafkaMessageBinding(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		message     *buses.Message
+		wantHeaders map[string]string
+		wantKey     string
+	}{
+		{
+			name: "binary",
+			message: &buses.Message{
+				Headers: map[string]string{
+					"Ce-Specversion":  "0.2",
+					"Ce-Id":           "1234",
+					"Ce-Type":         "dev.knative.test",
+					"Ce-Source":       "tests://kafka",
+					"Ce-Partitionkey": "key",
+					"Content-Type":    "application/json",
+					"X-B3-Traceid":    "abc",
+				},
+				Payload: []byte(`{"value":1}`),
+			},
+			wantHeaders: map[string]string{
+				"ce_specversion":  "0.2",
+				"ce_id":           "1234",
+				"ce_type":         "dev.knative.test",
+				"ce_source":       "tests://kafka",
+				"ce_partitionkey": "key",
+				"content-type":    "application/json",
+				"X-B3-Traceid":    "abc",
+			},
+			wantKey: "key",
+		},
+		{
+			name: "structured",
+			message: &buses.Message{
+				Headers: map[string]string{
+					"Content-Type": "application/cloudevents+json",
+				},
+				Payload: []byte(`{"data":{"value":1},"id":"1234","source":"tests://kafka","specversion":"0.2","type":"dev.knative.test"}`),
+			},
+			wantHeaders: map[string]string{
+				"content-type": "application/cloudevents+json",
+			},
+		},
+		{
+			name: "not a CloudEvent",
+			message: &buses.Message{
+				Headers: map[string]string{
+					"Content-Type": "text/plain",
+				},
+				Payload: []byte("hello"),
+			},
+			wantHeaders: map[string]string{
+				"Content-Type": "text/plain",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			produced := toKafkaMessage("default.channel", test.message)
+			if got := recordHeaders(produced); !reflect.DeepEqual(got, test.wantHeaders) {
+				t.Errorf("Unexpected record headers. want %v, got %v", test.wantHeaders, got)
+			}
+			if test.wantKey != "" {
+				if key, _ := produced.Key.Encode(); string(key) != test.wantKey {
+					t.Errorf("Unexpected record key. want %q, got %q", test.wantKey, key)
+				}
+			}
+
+			got := fromKafkaMessage(consumed(t, produced))
+			if string(got.Payload) != string(test.message.Payload) {
+				t.Errorf("Unexpected payload. want %s, got %s", test.message.Payload, got.Payload)
+			}
+			for name, want := range test.message.Headers {
+				if got.Headers[name] != want {
+					t.Errorf("Unexpected header %q. want %q, got %q", name, want, got.Headers[name])
+				}
+			}
+		})
+	}
+}
+
+func TestFromKafkaMessageLegacy(t *testing.T) {
+	// Records written by earlier versions of the bus carry HTTP headers.
+	record := &sarama.ConsumerMessage{
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("Ce-Eventid"), Value: []byte("1234")},
+			{Key: []byte("Content-Type"), Value: []byte("application/json")},
+		},
+		Value: []byte(`{"value":1}`),
+	}
+	want := &buses.Message{
+		Headers: map[string]string{
+			"Ce-Eventid":   "1234",
+			"Content-Type": "application/json",
+		},
+		Payload: []byte(`{"value":1}`),
+	}
+	if got := fromKafkaMessage(record); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected message. want %+v, got %+v", want, got)
+	}
+}
+
+func TestDelayTier(t *testing.T) {
+	for remaining, want := range map[time.Duration]time.Duration{
+		0:                      time.Second,
+		500 * time.Millisecond: time.Second,
+		time.Second:            time.Second,
+		59 * time.Second:       10 * time.Second,
+		90 * time.Minute:       time.Hour,
+		24 * time.Hour:         10 * time.Hour,
+	} {
+		if got := delayTier(remaining); got != want {
+			t.Errorf("Unexpected delay tier for %v. want %v, got %v", remaining, want, got)
+		}
+	}
+}
+
+func TestDelayTopicName(t *testing.T) {
+	for _, bus := range []string{"kafka", "my.kafka"} {
+		topic := delayTopicName(bus, time.Minute)
+		if isChannelTopic(topic) {
+			t.Errorf("Expected delay topic %q not to be a channel topic", topic)
+		}
+	}
+	if got, want := delayTopicName("kafka", 10*time.Minute), "_knative-bus.kafka.delay-600s"; got != want {
+		t.Errorf("Unexpected delay topic name. want %q, got %q", want, got)
+	}
+}
+
+type recordingSubscriber struct {
+	messages []*buses.Message
+	err      error
+}
+
+func (s *recordingSubscriber) Dispatch(message *buses.Message) error {
+	s.messages = append(s.messages, message)
+	return s.err
+}
+
+func (s *recordingSubscriber) Failed(error) {}
+
+// recordingProducer is a sarama.SyncProducer recording the messages sent.
+type recordingProducer struct {
+	messages []*sarama.ProducerMessage
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.messages = append(p.messages, msg)
+	return 0, int64(len(p.messages)), nil
+}
+
+func (p *recordingProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.messages = append(p.messages, msgs...)
+	return nil
+}
+
+func (p *recordingProducer) Close() error {
+	return nil
+}
+
+// makeDelayedRecord makes a record of the bus' shortest delay topic for the
+// subscription, due at the time.
+func makeDelayedRecord(subscription string, at time.Time, attempts int) (*sarama.ConsumerMessage, map[string]string) {
+	due := at.UTC().Format(time.RFC3339Nano)
+	msg := &sarama.ConsumerMessage{
+		Topic: delayTopicName("kafka", time.Second),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("Content-Type"), Value: []byte("text/plain")},
+			{Key: []byte(headerDelaySubscription), Value: []byte(subscription)},
+			{Key: []byte(headerDelayDeliverAt), Value: []byte(due)},
+			{Key: []byte(headerDelayUntil), Value: []byte(due)},
+			{Key: []byte(headerDelayAttempts), Value: []byte(strconv.Itoa(attempts))},
+		},
+		Value: []byte("hello"),
+	}
+	headers := make(map[string]string)
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	return msg, headers
+}
+
+func TestReleaseDelayedDispatchFailure(t *testing.T) {
+	bus := NewKafkaBus("kafka", nil, "test")
+	producer := &recordingProducer{}
+	bus.producerSync = producer
+	bus.subscribers[delayKey("default", "subscription")] = &recordingSubscriber{err: errors.New("subscriber unavailable")}
+
+	// a failed dispatch is delayed again for the retry tier
+	msg, headers := makeDelayedRecord("default/subscription", time.Now().Add(-time.Second), 0)
+	if err := bus.releaseDelayed(msg, headers); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(producer.messages) != 1 {
+		t.Fatalf("Unexpected number of delayed messages. want 1, got %d", len(producer.messages))
+	}
+	delayed := producer.messages[0]
+	for _, header := range delayed.Headers {
+		switch string(header.Key) {
+		case headerDelayAttempts:
+			if string(header.Value) != "1" {
+				t.Errorf("Unexpected delay attempts. want %q, got %q", "1", header.Value)
+			}
+		case headerDelayDeliverAt:
+			at, err := time.Parse(time.RFC3339Nano, string(header.Value))
+			if err != nil || time.Until(at) < delayRetry-time.Second {
+				t.Errorf("Unexpected delivery time. want about %v from now, got %q", delayRetry, header.Value)
+			}
+		}
+	}
+
+	// the message is dropped after the last attempt
+	producer.messages = nil
+	msg, headers = makeDelayedRecord("default/subscription", time.Now().Add(-time.Second), maxDelayAttempts)
+	if err := bus.releaseDelayed(msg, headers); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(producer.messages) != 0 {
+		t.Errorf("Unexpected number of delayed messages. want 0, got %d", len(producer.messages))
+	}
+}
+
+func TestReleaseDelayed(t *testing.T) {
+	bus := NewKafkaBus("kafka", nil, "test")
+	subscriber := &recordingSubscriber{}
+	bus.subscribers[delayKey("default", "subscription")] = subscriber
+
+	due := time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano)
+	msg := &sarama.ConsumerMessage{
+		Topic: delayTopicName("kafka", time.Second),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("Content-Type"), Value: []byte("text/plain")},
+			{Key: []byte(headerDelaySubscription), Value: []byte("default/subscription")},
+			{Key: []byte(headerDelayDeliverAt), Value: []byte(due)},
+			{Key: []byte(headerDelayUntil), Value: []byte(due)},
+			{Key: []byte(headerDelayAttempts), Value: []byte("0")},
+		},
+		Value: []byte("hello"),
+	}
+	headers := make(map[string]string)
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+
+	if err := bus.releaseDelayed(msg, headers); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []*buses.Message{{
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Payload: []byte("hello"),
+	}}
+	if !reflect.DeepEqual(subscriber.messages, want) {
+		t.Errorf("Unexpected dispatched messages. want %+v, got %+v", want, subscriber.messages)
+	}
+}
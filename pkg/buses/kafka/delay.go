@@ -0,0 +1,283 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+	"github.com/golang/glog"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// headerDelaySubscription holds the `<namespace>/<name>` of the
+	// Subscription a delayed record is for.
+	headerDelaySubscription = "knative-delay-subscription"
+	// headerDelayDeliverAt holds the RFC 3339 time a delayed record is due.
+	headerDelayDeliverAt = "knative-delay-deliverat"
+	// headerDelayUntil holds the RFC 3339 time a delayed record leaves its
+	// delay topic.
+	headerDelayUntil = "knative-delay-until"
+	// headerDelayAttempts holds how many times a due record was delayed again
+	// as its Subscription was not found or its dispatch failed.
+	headerDelayAttempts = "knative-delay-attempts"
+
+	// delayTopicPartitions is the number of partitions of each delay topic,
+	// which are balanced between the dispatcher replicas.
+	delayTopicPartitions = 4
+
+	// delayRetry is how long a due record is delayed again if its
+	// Subscription is not subscribed yet or its dispatch failed, up to
+	// maxDelayAttempts times.
+	delayRetry       = 10 * time.Second
+	maxDelayAttempts = 30
+)
+
+var _ buses.DeliveryScheduler = &KafkaBus{}
+
+var delayedDispatchFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "knative",
+		Subsystem: "bus",
+		Name:      "delayed_dispatch_failures_total",
+		Help:      "Number of delayed messages whose dispatch failed when they were due.",
+	},
+	[]string{"bus", "action"},
+)
+
+func init() {
+	prometheus.MustRegister(delayedDispatchFailures)
+}
+
+// delayTiers are the delays of the bus' delay topics, shortest first. A
+// delayed record waits in the topic with the longest delay that does not
+// exceed the time until it is due, then moves on to the next topic or is
+// dispatched. Every record of a delay topic waits for the same time, so a
+// record is never due before the records written to the topic before it and a
+// consumer can wait for the record at the head of each partition without
+// holding up the others.
+var delayTiers = []time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	10 * time.Hour,
+}
+
+// delayTier returns the delay topic tier for a record due in the remaining
+// time. Records due within the shortest tier wait for at most that tier.
+func delayTier(remaining time.Duration) time.Duration {
+	tier := delayTiers[0]
+	for _, t := range delayTiers {
+		if t <= remaining {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// delayTopicName returns the name of the bus' delay topic for the tier. The
+// name is not a valid DNS label before the first dot, so it is never mistaken
+// for the topic of a Channel.
+func delayTopicName(bus string, tier time.Duration) string {
+	return fmt.Sprintf("_knative-bus.%s.delay-%ds", bus, int64(tier/time.Second))
+}
+
+// ScheduleDelivery writes a message that is not due yet to the bus' delay
+// topics. A dispatcher replica dispatches it to the Subscription once it is
+// due.
+func (b *KafkaBus) ScheduleDelivery(subscription *channelsv1alpha1.Subscription, message *buses.Message, at time.Time) error {
+	return b.delay(delayKey(subscription.Namespace, subscription.Name), message, at, 0)
+}
+
+// delay writes the message for the subscription to the delay topic for the
+// time until it is due. The write is acknowledged before delay returns, so
+// the message survives the offset of its source being marked.
+func (b *KafkaBus) delay(subscription string, message *buses.Message, at time.Time, attempts int) error {
+	producer, err := b.syncProducer()
+	if err != nil {
+		return err
+	}
+
+	tier := delayTier(time.Until(at))
+	until := time.Now().Add(tier)
+	if at.Before(until) {
+		until = at
+	}
+	record := toKafkaMessage(delayTopicName(b.name, tier), message)
+	record.Headers = append(record.Headers,
+		sarama.RecordHeader{Key: []byte(headerDelaySubscription), Value: []byte(subscription)},
+		sarama.RecordHeader{Key: []byte(headerDelayDeliverAt), Value: []byte(at.UTC().Format(time.RFC3339Nano))},
+		sarama.RecordHeader{Key: []byte(headerDelayUntil), Value: []byte(until.UTC().Format(time.RFC3339Nano))},
+		sarama.RecordHeader{Key: []byte(headerDelayAttempts), Value: []byte(strconv.Itoa(attempts))},
+	)
+	if _, _, err := producer.SendMessage(record); err != nil {
+		return fmt.Errorf("unable to delay message for subscription %s: %v", subscription, err)
+	}
+	return nil
+}
+
+// startDelayConsumer creates the bus' delay topics and starts consuming them,
+// unless already started. The dispatcher replicas share a consumer group, each
+// partition is consumed by one replica.
+func (b *KafkaBus) startDelayConsumer() error {
+	b.delayMutex.Lock()
+	defer b.delayMutex.Unlock()
+
+	if b.delayConsumer != nil {
+		return nil
+	}
+	admin, err := b.clusterAdmin()
+	if err != nil {
+		return err
+	}
+	var topics []string
+	for _, tier := range delayTiers {
+		topic := delayTopicName(b.name, tier)
+		err := admin.CreateTopic(topic, &sarama.TopicDetail{ReplicationFactor: 1, NumPartitions: delayTopicPartitions}, false)
+		if err != nil && err != sarama.ErrTopicAlreadyExists {
+			return fmt.Errorf("unable to create delay topic %s: %v", topic, err)
+		}
+		topics = append(topics, topic)
+	}
+
+	consumerConfig := cluster.NewConfig()
+	consumerConfig.Version = sarama.V1_1_0_0
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	consumerConfig.Group.Mode = cluster.ConsumerModePartitions
+	consumer, err := cluster.NewConsumer(b.brokers, fmt.Sprintf("%s.delay", b.name), topics, consumerConfig)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for partition := range consumer.Partitions() {
+			go b.consumeDelayed(consumer, partition)
+		}
+	}()
+	b.delayConsumer = consumer
+	return nil
+}
+
+// consumeDelayed waits for each record of a delay topic partition to leave the
+// topic, then moves it on. A record is marked once moved on, a record being
+// waited for when the partition is reassigned is consumed again by its new
+// owner.
+func (b *KafkaBus) consumeDelayed(consumer *cluster.Consumer, partition cluster.PartitionConsumer) {
+	for msg := range partition.Messages() {
+		headers := make(map[string]string, len(msg.Headers))
+		for _, header := range msg.Headers {
+			headers[string(header.Key)] = string(header.Value)
+		}
+		if until, err := time.Parse(time.RFC3339Nano, headers[headerDelayUntil]); err == nil {
+			// later records of the partition are not due before this one
+			time.Sleep(time.Until(until))
+		}
+		for {
+			err := b.releaseDelayed(msg, headers)
+			if err == nil {
+				break
+			}
+			glog.Errorf("Unable to move on a delayed message from %s/%d, retrying: %v", msg.Topic, msg.Partition, err)
+			time.Sleep(delayRetry)
+		}
+		consumer.MarkOffset(msg, "")
+	}
+}
+
+// releaseDelayed dispatches a record that left its delay topic to its
+// Subscription, or writes it to the next delay topic if it is not due yet.
+// A record whose dispatch fails, or whose Subscription is unknown, is delayed
+// again for delayRetry, up to maxDelayAttempts times. Records which are not
+// valid are dropped.
+func (b *KafkaBus) releaseDelayed(msg *sarama.ConsumerMessage, headers map[string]string) error {
+	subscription := headers[headerDelaySubscription]
+	at, err := time.Parse(time.RFC3339Nano, headers[headerDelayDeliverAt])
+	if err != nil || subscription == "" {
+		glog.Warningf("Dropping invalid delayed message from %s/%d at offset %d", msg.Topic, msg.Partition, msg.Offset)
+		return nil
+	}
+	attempts, _ := strconv.Atoi(headers[headerDelayAttempts])
+
+	message := fromKafkaMessage(msg)
+	for name := range message.Headers {
+		if strings.HasPrefix(strings.ToLower(name), "knative-delay-") {
+			delete(message.Headers, name)
+		}
+	}
+
+	if time.Until(at) > 0 {
+		return b.delay(subscription, message, at, attempts)
+	}
+
+	b.mutex.Lock()
+	subscriber, ok := b.subscribers[subscription]
+	b.mutex.Unlock()
+	failed := false
+	if ok {
+		err := subscriber.Dispatch(message)
+		if err == nil {
+			return nil
+		}
+		// a closed subscription may be being replaced, other errors are
+		// failures of the subscriber
+		failed = err != buses.ErrSubscriptionClosed
+		if failed {
+			glog.Warningf("Unable to dispatch a delayed message for subscription %s: %v", subscription, err)
+		}
+	}
+
+	// the subscription may not be subscribed to yet, or is being replaced
+	if attempts++; attempts > maxDelayAttempts {
+		if failed {
+			delayedDispatchFailures.WithLabelValues(b.name, "dropped").Inc()
+		}
+		glog.Warningf("Dropping a delayed message for subscription %s after %d attempts", subscription, maxDelayAttempts)
+		return nil
+	}
+	if failed {
+		delayedDispatchFailures.WithLabelValues(b.name, "retried").Inc()
+	}
+	return b.delay(subscription, message, time.Now().Add(delayRetry), attempts)
+}
+
+func (b *KafkaBus) syncProducer() (sarama.SyncProducer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.producerSync == nil {
+		// the sync producer requires successes to be returned
+		config := *b.config
+		producer, err := sarama.NewSyncProducer(b.brokers, &config)
+		if err != nil {
+			return nil, fmt.Errorf("error building kafka producer: %v", err)
+		}
+		b.producerSync = producer
+	}
+	return b.producerSync, nil
+}
+
+func delayKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
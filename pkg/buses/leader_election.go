@@ -0,0 +1,302 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// EnvPodName is the environment variable holding the name of the pod a
+	// bus component runs in. It identifies the component in leader election.
+	EnvPodName = "POD_NAME"
+
+	// EnvPodNamespace is the environment variable holding the namespace of
+	// the pod a bus component runs in. The leader election lock is created in
+	// this namespace.
+	EnvPodNamespace = "POD_NAMESPACE"
+
+	// LeaderAnnotation is the annotation on the lock ConfigMap that holds the
+	// leader election record. It is the annotation used by Kubernetes
+	// components for ConfigMap locks.
+	LeaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+	// DefaultLeaseDuration is how long followers wait after the leader last
+	// renewed its lease before trying to take over.
+	DefaultLeaseDuration = 15 * time.Second
+	// DefaultRenewDeadline is how long the leader keeps trying to renew its
+	// lease before giving up leadership.
+	DefaultRenewDeadline = 10 * time.Second
+	// DefaultRetryPeriod is how often candidates try to acquire or renew the
+	// lease.
+	DefaultRetryPeriod = 2 * time.Second
+)
+
+// LeaderElectionConfig configures leader election between replicas of a bus
+// component. The replicas hold a lease recorded on a ConfigMap.
+type LeaderElectionConfig struct {
+	// Namespace and Name identify the ConfigMap used as the lock. It is
+	// created if it doesn't exist.
+	Namespace string
+	Name      string
+
+	// Identity uniquely identifies this replica, typically its pod name.
+	Identity string
+
+	// LeaseDuration, RenewDeadline and RetryPeriod default to
+	// DefaultLeaseDuration, DefaultRenewDeadline and DefaultRetryPeriod.
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// NewLeaderElectionConfigFromEnv creates a configuration electing a leader
+// between the replicas of a component of the bus. The replicas are identified
+// by the POD_NAME environment variable, or the hostname, and lock a ConfigMap
+// in the POD_NAMESPACE namespace, or the Bus' namespace.
+func NewLeaderElectionConfigFromEnv(bus *BusReference, role string) (LeaderElectionConfig, error) {
+	identity := os.Getenv(EnvPodName)
+	if len(identity) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return LeaderElectionConfig{}, fmt.Errorf("unable to identify the leader election candidate: %v", err)
+		}
+		identity = hostname
+	}
+
+	namespace, name, err := busConfigMap(bus, role)
+	if err != nil {
+		return LeaderElectionConfig{}, err
+	}
+
+	return LeaderElectionConfig{
+		Namespace: namespace,
+		Name:      name,
+		Identity:  identity,
+	}, nil
+}
+
+// leaderElectionRecord is the record of the leader stored on the lock. It is
+// compatible with the record written by Kubernetes leader election.
+type leaderElectionRecord struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaderTransitions    int         `json:"leaderTransitions"`
+}
+
+// leaderElector campaigns for leadership with a ConfigMap lock. A candidate
+// takes the lock once the current holder has not renewed it for the lease
+// duration. Concurrent candidates are arbitrated by the ConfigMap's resource
+// version, so only one update of the lock succeeds.
+type leaderElector struct {
+	client kubernetes.Interface
+	config LeaderElectionConfig
+
+	// leading is 1 while this candidate holds the lock.
+	leading int32
+
+	// observedRecord is the last record read from the lock and observedTime
+	// is the local time it was first seen. Leases are timed with the local
+	// clock so candidates don't depend on synchronized clocks.
+	observedRecord leaderElectionRecord
+	observedTime   time.Time
+
+	now func() time.Time
+}
+
+func newLeaderElector(client kubernetes.Interface, config LeaderElectionConfig) *leaderElector {
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = DefaultLeaseDuration
+	}
+	if config.RenewDeadline == 0 {
+		config.RenewDeadline = DefaultRenewDeadline
+	}
+	if config.RetryPeriod == 0 {
+		config.RetryPeriod = DefaultRetryPeriod
+	}
+	return &leaderElector{
+		client: client,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// IsLeader returns true while this candidate holds the lock.
+func (le *leaderElector) IsLeader() bool {
+	return atomic.LoadInt32(&le.leading) == 1
+}
+
+// Run campaigns for leadership until stopCh is closed. onStartedLeading is
+// called when the lock is acquired and onStoppedLeading when it is lost or
+// Run stops while leading.
+func (le *leaderElector) Run(stopCh <-chan struct{}, onStartedLeading, onStoppedLeading func()) {
+	for le.acquire(stopCh) {
+		glog.Infof("%s became the leader for %s/%s", le.config.Identity, le.config.Namespace, le.config.Name)
+		atomic.StoreInt32(&le.leading, 1)
+		onStartedLeading()
+
+		le.renew(stopCh)
+
+		glog.Infof("%s stopped leading for %s/%s", le.config.Identity, le.config.Namespace, le.config.Name)
+		atomic.StoreInt32(&le.leading, 0)
+		onStoppedLeading()
+	}
+}
+
+// acquire blocks until the lock is acquired, returning true, or stopCh is
+// closed, returning false.
+func (le *leaderElector) acquire(stopCh <-chan struct{}) bool {
+	for {
+		select {
+		case <-stopCh:
+			return false
+		default:
+		}
+		if le.tryAcquireOrRenew() {
+			return true
+		}
+		select {
+		case <-stopCh:
+			return false
+		case <-time.After(le.config.RetryPeriod):
+		}
+	}
+}
+
+// renew keeps renewing the lock until a renewal hasn't succeeded for the
+// renew deadline or stopCh is closed.
+func (le *leaderElector) renew(stopCh <-chan struct{}) {
+	renewed := le.now()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(le.config.RetryPeriod):
+		}
+		if le.tryAcquireOrRenew() {
+			renewed = le.now()
+		} else if le.now().Sub(renewed) > le.config.RenewDeadline {
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew acquires the lock if it is free or has expired, or renews
+// it if this candidate holds it. It returns true if the candidate holds the
+// lock afterwards.
+func (le *leaderElector) tryAcquireOrRenew() bool {
+	now := metav1.NewTime(le.now())
+	record := leaderElectionRecord{
+		HolderIdentity:       le.config.Identity,
+		LeaseDurationSeconds: int(le.config.LeaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	configMaps := le.client.CoreV1().ConfigMaps(le.config.Namespace)
+	lock, err := configMaps.Get(le.config.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lock = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: le.config.Namespace,
+				Name:      le.config.Name,
+			},
+		}
+		if err := setLeaderElectionRecord(lock, record); err != nil {
+			glog.Errorf("Error encoding leader election record: %v", err)
+			return false
+		}
+		if _, err := configMaps.Create(lock); err != nil {
+			glog.Warningf("Error creating leader election lock %s/%s: %v", le.config.Namespace, le.config.Name, err)
+			return false
+		}
+		le.observe(record)
+		return true
+	}
+	if err != nil {
+		glog.Warningf("Error getting leader election lock %s/%s: %v", le.config.Namespace, le.config.Name, err)
+		return false
+	}
+
+	var old leaderElectionRecord
+	if value, ok := lock.Annotations[LeaderAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &old); err != nil {
+			glog.Errorf("Error decoding leader election record of %s/%s: %v", le.config.Namespace, le.config.Name, err)
+			return false
+		}
+	}
+	if !reflect.DeepEqual(old, le.observedRecord) {
+		le.observe(old)
+	}
+
+	held := len(old.HolderIdentity) > 0 && old.HolderIdentity != le.config.Identity
+	if held && le.observedTime.Add(le.config.LeaseDuration).After(now.Time) {
+		// another candidate holds a current lease
+		return false
+	}
+
+	if old.HolderIdentity == le.config.Identity {
+		record.AcquireTime = old.AcquireTime
+		record.LeaderTransitions = old.LeaderTransitions
+	} else {
+		record.LeaderTransitions = old.LeaderTransitions + 1
+	}
+
+	lock = lock.DeepCopy()
+	if err := setLeaderElectionRecord(lock, record); err != nil {
+		glog.Errorf("Error encoding leader election record: %v", err)
+		return false
+	}
+	// The update carries the lock's resource version, so it fails if
+	// another candidate changed the lock since it was read.
+	if _, err := configMaps.Update(lock); err != nil {
+		glog.Warningf("Error updating leader election lock %s/%s: %v", le.config.Namespace, le.config.Name, err)
+		return false
+	}
+	le.observe(record)
+	return true
+}
+
+func (le *leaderElector) observe(record leaderElectionRecord) {
+	le.observedRecord = record
+	le.observedTime = le.now()
+}
+
+func setLeaderElectionRecord(lock *corev1.ConfigMap, record leaderElectionRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if lock.Annotations == nil {
+		lock.Annotations = make(map[string]string)
+	}
+	lock.Annotations[LeaderAnnotation] = string(value)
+	return nil
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	listers "github.com/knative/eventing/pkg/client/listers/channels/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLeaderElector(client *fake.Clientset, clock *fakeClock, identity string) *leaderElector {
+	le := newLeaderElector(client, LeaderElectionConfig{
+		Namespace: "test-namespace",
+		Name:      "bus-test-provisioner",
+		Identity:  identity,
+	})
+	le.now = clock.Now
+	return le
+}
+
+func getLeaderElectionRecord(t *testing.T, client *fake.Clientset) leaderElectionRecord {
+	t.Helper()
+	lock, err := client.CoreV1().ConfigMaps("test-namespace").Get("bus-test-provisioner", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting lock: %v", err)
+	}
+	var record leaderElectionRecord
+	if err := json.Unmarshal([]byte(lock.Annotations[LeaderAnnotation]), &record); err != nil {
+		t.Fatalf("Error decoding leader election record: %v", err)
+	}
+	return record
+}
+
+func TestLeaderElectorAcquireAndRenew(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	clock := &fakeClock{now: time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)}
+	a := newTestLeaderElector(client, clock, "a")
+	b := newTestLeaderElector(client, clock, "b")
+
+	if !a.tryAcquireOrRenew() {
+		t.Fatalf("Expected a to acquire the free lock")
+	}
+	if b.tryAcquireOrRenew() {
+		t.Fatalf("Expected b not to acquire a held lock")
+	}
+
+	clock.now = clock.now.Add(5 * time.Second)
+	if !a.tryAcquireOrRenew() {
+		t.Fatalf("Expected a to renew its lock")
+	}
+	record := getLeaderElectionRecord(t, client)
+	if record.HolderIdentity != "a" {
+		t.Errorf("Unexpected holder. want %q, got %q", "a", record.HolderIdentity)
+	}
+	if !record.RenewTime.Time.Equal(clock.now) || record.AcquireTime.Time.Equal(clock.now) {
+		t.Errorf("Unexpected renewal. acquired %v, renewed %v", record.AcquireTime, record.RenewTime)
+	}
+
+	// b times the lease from when it saw the renewal
+	if b.tryAcquireOrRenew() {
+		t.Fatalf("Expected b not to acquire a renewed lock")
+	}
+	clock.now = clock.now.Add(DefaultLeaseDuration - time.Second)
+	if b.tryAcquireOrRenew() {
+		t.Fatalf("Expected b not to acquire the lock before the lease expires")
+	}
+}
+
+func TestLeaderElectorTakeOver(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	clock := &fakeClock{now: time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)}
+	a := newTestLeaderElector(client, clock, "a")
+	b := newTestLeaderElector(client, clock, "b")
+
+	if !a.tryAcquireOrRenew() {
+		t.Fatalf("Expected a to acquire the free lock")
+	}
+	if b.tryAcquireOrRenew() {
+		t.Fatalf("Expected b not to acquire a held lock")
+	}
+
+	clock.now = clock.now.Add(DefaultLeaseDuration + time.Second)
+	if !b.tryAcquireOrRenew() {
+		t.Fatalf("Expected b to acquire an expired lock")
+	}
+	record := getLeaderElectionRecord(t, client)
+	if record.HolderIdentity != "b" {
+		t.Errorf("Unexpected holder. want %q, got %q", "b", record.HolderIdentity)
+	}
+	if record.LeaderTransitions != 1 {
+		t.Errorf("Unexpected leader transitions. want 1, got %d", record.LeaderTransitions)
+	}
+	if a.tryAcquireOrRenew() {
+		t.Errorf("Expected a not to take back the lock")
+	}
+}
+
+func TestLeaderElectorRun(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	le := newLeaderElector(client, LeaderElectionConfig{
+		Namespace:   "test-namespace",
+		Name:        "bus-test-provisioner",
+		Identity:    "a",
+		RetryPeriod: 10 * time.Millisecond,
+	})
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	stopCh := make(chan struct{})
+	go le.Run(stopCh, func() { close(started) }, func() { close(stopped) })
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting to lead")
+	}
+	if !le.IsLeader() {
+		t.Errorf("Expected to be the leader")
+	}
+
+	close(stopCh)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting to stop leading")
+	}
+	if le.IsLeader() {
+		t.Errorf("Expected not to be the leader")
+	}
+}
+
+func TestMonitorFollowerDoesNotSync(t *testing.T) {
+	m := &Monitor{
+		elector:   newLeaderElector(fake.NewSimpleClientset(), LeaderElectionConfig{Identity: "a"}),
+		syncMutex: &sync.RWMutex{},
+		workqueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer m.workqueue.ShutDown()
+	if m.IsLeader() {
+		t.Fatalf("Expected the monitor not to lead before the election")
+	}
+
+	// a monitor that is syncing would fail as it has not seen its bus, a
+	// follower drops the item
+	key := makeWorkqueueKey(channelKind, "test-namespace", "channel")
+	m.workqueue.Add(key)
+	if !m.processNextWorkItem() {
+		t.Fatalf("Unexpected workqueue shutdown")
+	}
+	if got := m.workqueue.NumRequeues(key); got != 0 {
+		t.Errorf("Unexpected requeues of the item. want 0, got %d", got)
+	}
+	if got := m.workqueue.Len(); got != 0 {
+		t.Errorf("Unexpected items queued. want 0, got %d", got)
+	}
+}
+
+func TestMonitorHandoverSyncsPromptly(t *testing.T) {
+	channels := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "channel"},
+	}
+	channels.Add(channel)
+	m := &Monitor{
+		busesLister:         listers.NewBusLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		clusterBusesLister:  listers.NewClusterBusLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		channelsLister:      listers.NewChannelLister(channels),
+		subscriptionsLister: listers.NewSubscriptionLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		elector:             newLeaderElector(fake.NewSimpleClientset(), LeaderElectionConfig{Identity: "a"}),
+		syncMutex:           &sync.RWMutex{},
+		workqueue:           workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer m.workqueue.ShutDown()
+
+	// the follower sees the channel change several times while another
+	// replica leads
+	key := makeWorkqueueKeyForChannel(channel)
+	for i := 0; i < 5; i++ {
+		m.workqueue.Add(key)
+		if !m.processNextWorkItem() {
+			t.Fatalf("Unexpected workqueue shutdown")
+		}
+	}
+
+	// once it leads the channel is queued without backing off
+	atomic.StoreInt32(&m.elector.leading, 1)
+	m.startedLeading()
+	if got := m.workqueue.Len(); got != 1 {
+		t.Fatalf("Unexpected items queued. want 1, got %d", got)
+	}
+	if got := m.workqueue.NumRequeues(key); got != 0 {
+		t.Errorf("Unexpected requeues of the item. want 0, got %d", got)
+	}
+	got, _ := m.workqueue.Get()
+	if got != key {
+		t.Errorf("Unexpected item queued. want %q, got %q", key, got)
+	}
+	m.workqueue.Done(got)
+}
+
+func TestMonitorStoppedLeadingWaitsForSync(t *testing.T) {
+	m := &Monitor{
+		indexMutex: &sync.Mutex{},
+		syncMutex:  &sync.RWMutex{},
+	}
+	m.currentIndex.Store(newMonitorIndex())
+	key := makeChannelKeyWithNames("test-namespace", "channel")
+
+	// a worker syncing an item holds the sync mutex until it recorded the
+	// channel
+	m.syncMutex.RLock()
+	stopped := make(chan struct{})
+	go func() {
+		m.stoppedLeading()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("Expected stoppedLeading to wait for the sync")
+	case <-time.After(100 * time.Millisecond):
+	}
+	m.updateIndex(func(index *monitorIndex) {
+		index.setChannel(key, &channelsv1alpha1.Channel{})
+	})
+	m.syncMutex.RUnlock()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for stoppedLeading")
+	}
+	if m.index().channel(key) != nil {
+		t.Errorf("Expected the index to be cleared after the sync")
+	}
+}
@@ -18,6 +18,25 @@ package buses
 
 import (
 	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ExtensionDeliverAt is the CloudEvents extension a publisher sets to
+	// request that an event is not delivered before the given RFC 3339 time.
+	// It is read in every CloudEvents version and encoding.
+	ExtensionDeliverAt = "deliverat"
+
+	// HeaderDeliverAt is the header carrying the "deliverat" extension of
+	// binary CloudEvents 0.1 events. It is also read from messages that are
+	// not CloudEvents.
+	HeaderDeliverAt = "ce-x-deliverat"
+
+	// HeaderIngestionTime is set by the MessageReceiver to the RFC 3339 time
+	// the message was received by the bus. It is not forwarded to subscribers.
+	HeaderIngestionTime = "knative-ingestiontime"
 )
 
 var forwardHeaders = []string{
@@ -47,6 +66,28 @@ type Message struct {
 	// Payload is the raw binary content of the message. The payload format is
 	// often described by the 'content-type' header.
 	Payload []byte
+
+	// deliverAtOnce guards the delivery time requested by the message, which
+	// is parsed the first time it is needed and shared by every subscriber
+	// the message is dispatched to. Headers and Payload must not change
+	// once the message is published or dispatched.
+	deliverAtOnce sync.Once
+	deliverAtTime time.Time
+	deliverAtSet  bool
+}
+
+// header returns the value of the named header. Header names are compared
+// case-insensitively.
+func (m *Message) header(name string) (string, bool) {
+	if value, ok := m.Headers[name]; ok {
+		return value, true
+	}
+	for h, value := range m.Headers {
+		if strings.EqualFold(h, name) {
+			return value, true
+		}
+	}
+	return "", false
 }
 
 // ErrUnknownChannel is returned when a message is received by a bus for a
@@ -19,9 +19,15 @@ package buses
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative/eventing/pkg/event"
 )
 
 // MessageDispatcher dispatches messages to a destination over HTTP.
@@ -30,6 +36,8 @@ type MessageDispatcher struct {
 	forwardHeaders   map[string]bool
 	forwardPrefixes  []string
 	supportedSchemes map[string]bool
+	expiry           ExpiryPolicySource
+	bearerToken      string
 }
 
 // NewMessageDispatcher creates a new message dispatcher that can dispatch
@@ -46,7 +54,8 @@ func NewMessageDispatcher() *MessageDispatcher {
 	}
 }
 
-// DispatchMessage dispatches a message to a destination over HTTP.
+// DispatchMessage dispatches a message to a destination over HTTP. Responses
+// without a 2xx status code are returned as an error.
 //
 // The destination is a DNS name. For destinations with a single label, the
 // default namespace is used to expand the destination into a fully qualified
@@ -58,13 +67,116 @@ func (d *MessageDispatcher) DispatchMessage(destination string, defaultNamespace
 		return fmt.Errorf("Unable to create request %v", err)
 	}
 	req.Header = d.toHTTPHeaders(message.Headers)
-	_, err = d.httpClient.Do(req)
+	return d.send(req)
+}
+
+// DispatchBatch dispatches messages holding CloudEvents to a destination in
+// one request, as a JSON array of events in the structured encoding.
+// Responses without a 2xx status code are returned as an error. Messages which
+// are not CloudEvents can not be batched, the batch is rejected without sending
+// any of its messages if it holds one.
+func (d *MessageDispatcher) DispatchBatch(destination string, defaultNamespace string, messages []*Message) error {
+	events := make([]event.Event, 0, len(messages))
+	for _, message := range messages {
+		e, err := toEvent(message)
+		if err != nil {
+			return fmt.Errorf("Unable to batch message which is not a valid CloudEvent: %v", err)
+		}
+		events = append(events, e)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	url := d.resolveURL(destination, defaultNamespace)
+	req, err := event.NewBatchRequest(url.String(), events)
+	if err != nil {
+		return fmt.Errorf("Unable to create request %v", err)
+	}
+	return d.send(req)
+}
+
+// send sends a request to a destination, presenting the bearer token if one is
+// set.
+func (d *MessageDispatcher) send(req *http.Request) error {
+	if d.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.bearerToken)
+	}
+	res, err := d.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("Unable to complete request %v", err)
 	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected response status %q", res.Status)
+	}
 	return nil
 }
 
+// SetExpiryPolicySource sets the source of Channel expiry policies enforced by
+// DispatchChannelMessage.
+func (d *MessageDispatcher) SetExpiryPolicySource(expiry ExpiryPolicySource) {
+	d.expiry = expiry
+}
+
+// SetBearerToken sets a token presented to destinations in the Authorization
+// header, as a remote channel ingress requires. The token is sent to every
+// destination, so it must only be set when all destinations are trusted.
+func (d *MessageDispatcher) SetBearerToken(token string) {
+	d.bearerToken = token
+}
+
+// DispatchChannelMessage dispatches a message received on a channel to a
+// destination over HTTP, enforcing the channel's expiry policy.
+//
+// Messages older than the channel's TTL are not sent to the destination. They
+// are sent to the channel's dead-letter target if it has one, otherwise they
+// are dropped. Dropping an expired message is not an error.
+func (d *MessageDispatcher) DispatchChannelMessage(channel *ChannelReference, destination string, defaultNamespace string, message *Message) error {
+	if expired, err := d.dispatchExpired(channel, message); expired {
+		return err
+	}
+	return d.DispatchMessage(destination, defaultNamespace, message)
+}
+
+// DispatchChannelBatch dispatches a batch of messages received on a channel to
+// a destination over HTTP, enforcing the channel's expiry policy on each
+// message like DispatchChannelMessage.
+func (d *MessageDispatcher) DispatchChannelBatch(channel *ChannelReference, destination string, defaultNamespace string, messages []*Message) error {
+	batch := make([]*Message, 0, len(messages))
+	for _, message := range messages {
+		if expired, err := d.dispatchExpired(channel, message); expired {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		batch = append(batch, message)
+	}
+	return d.DispatchBatch(destination, defaultNamespace, batch)
+}
+
+// dispatchExpired drops an expired message or sends it to the channel's
+// dead-letter target. It returns false if the message has not expired.
+func (d *MessageDispatcher) dispatchExpired(channel *ChannelReference, message *Message) (bool, error) {
+	if d.expiry == nil {
+		return false, nil
+	}
+	policy := d.expiry.ExpiryPolicy(channel)
+	if !policy.Expired(message, time.Now()) {
+		return false, nil
+	}
+	if policy.DeadLetterTarget == "" {
+		glog.Infof("Dropping expired message for channel %q", channel)
+		messagesExpired.WithLabelValues(channel.Namespace, channel.Name, "dropped").Inc()
+		return true, nil
+	}
+	glog.Infof("Sending expired message for channel %q to dead-letter target %q", channel, policy.DeadLetterTarget)
+	messagesExpired.WithLabelValues(channel.Namespace, channel.Name, "deadlettered").Inc()
+	return true, d.DispatchMessage(policy.DeadLetterTarget, channel.Namespace, message)
+}
+
 // toHTTPHeaders converts message headers to HTTP headers.
 //
 // Only headers whitelisted as safe are copied.
@@ -20,14 +20,28 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// metricsScrapeAddr and metricsScrapePath are where the bus' Prometheus
+	// metrics are served. The listener is separate from the one receiving
+	// messages, so metrics are not exposed on the Channels' hostnames.
+	metricsScrapeAddr = ":9090"
+	metricsScrapePath = "/metrics"
 )
 
 // MessageReceiver starts a server to receive new messages for the bus. The new
 // message is emitted via the receiver function.
 type MessageReceiver struct {
 	receiverFunc    func(*ChannelReference, *Message) error
+	authorizer      IngressAuthorizer
+	validator       EventValidator
+	dedupePolicies  DedupePolicySource
+	dedupeStore     DedupeStore
 	forwardHeaders  map[string]bool
 	forwardPrefixes []string
 }
@@ -43,6 +57,25 @@ func NewMessageReceiver(receiverFunc func(*ChannelReference, *Message) error) *M
 	return receiver
 }
 
+// SetIngressAuthorizer sets the authorizer that is consulted before a message
+// is accepted for a channel. Without an authorizer every request is accepted.
+func (r *MessageReceiver) SetIngressAuthorizer(authorizer IngressAuthorizer) {
+	r.authorizer = authorizer
+}
+
+// SetEventValidator sets the validator that is consulted before a message is
+// accepted for a channel. Without a validator every message is accepted.
+func (r *MessageReceiver) SetEventValidator(validator EventValidator) {
+	r.validator = validator
+}
+
+// SetDeduplicator enables deduplication of received messages for channels
+// with a dedupe policy. Keys of received messages are remembered in the store.
+func (r *MessageReceiver) SetDeduplicator(policies DedupePolicySource, store DedupeStore) {
+	r.dedupePolicies = policies
+	r.dedupeStore = store
+}
+
 // Run starts receiving messages for the receiver.
 //
 // Only HTTP POST requests to the root path (/) are accepted. If other paths or
@@ -53,6 +86,8 @@ func NewMessageReceiver(receiverFunc func(*ChannelReference, *Message) error) *M
 func (r *MessageReceiver) Run(stopCh <-chan struct{}) {
 	svr := r.start()
 	defer r.stop(svr)
+	metrics := startMetrics()
+	defer r.stop(metrics)
 
 	<-stopCh
 }
@@ -82,6 +117,23 @@ func (r *MessageReceiver) start() *http.Server {
 	return srv
 }
 
+// startMetrics starts the listener Prometheus scrapes the bus' metrics from.
+func startMetrics() *http.Server {
+	glog.Infof("Starting metrics listener at %s", metricsScrapeAddr)
+	mux := http.NewServeMux()
+	mux.Handle(metricsScrapePath, promhttp.Handler())
+	srv := &http.Server{
+		Addr:    metricsScrapeAddr,
+		Handler: mux,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			glog.Errorf("Metrics listener: ListenAndServe() error: %v", err)
+		}
+	}()
+	return srv
+}
+
 func (r *MessageReceiver) stop(srv *http.Server) {
 	glog.Info("Shutdown web server")
 	if err := srv.Shutdown(nil); err != nil {
@@ -93,23 +145,78 @@ func (r *MessageReceiver) stop(srv *http.Server) {
 // Message and emitted to the receiver func.
 //
 // The response status codes:
-//   202 - the message was sent to subscibers
+//   202 - the message was sent to subscibers, or was a duplicate
+//   400 - the message was rejected by the channel's event validation, or
+//         requested a delivery time the bus can not hold it for
+//   401 - the publisher did not present valid credentials
+//   403 - the publisher is not allowed to publish to the channel
 //   404 - the request was for an unknown channel
+//   429 - the publisher's credentials can not be verified right now
 //   500 - an error occured processing the request
 func (r *MessageReceiver) HandleRequest(res http.ResponseWriter, req *http.Request) {
 	host := req.Host
 	glog.Infof("Received request for %s\n", host)
 	channelReference := r.parseChannelReference(host)
 
+	if r.authorizer != nil {
+		if err := r.authorizer.AuthorizeIngress(channelReference, req); err != nil {
+			switch err {
+			case ErrUnauthenticated:
+				res.Header().Set("WWW-Authenticate", "Bearer")
+				res.WriteHeader(http.StatusUnauthorized)
+			case ErrForbidden:
+				res.WriteHeader(http.StatusForbidden)
+			case ErrThrottled:
+				res.Header().Set("Retry-After", "1")
+				res.WriteHeader(http.StatusTooManyRequests)
+			default:
+				glog.Errorf("Unable to authorize request for %s: %v", channelReference, err)
+				res.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
 	message, err := r.fromRequest(req)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	if r.validator != nil {
+		if err := r.validator.ValidateEvent(channelReference, message); err != nil {
+			if invalid, ok := err.(*InvalidEventError); ok {
+				glog.Infof("Rejecting invalid message for channel %q: %v", channelReference, invalid)
+				messagesInvalid.WithLabelValues(channelReference.Namespace, channelReference.Name).Inc()
+				http.Error(res, invalid.Error(), http.StatusBadRequest)
+			} else {
+				glog.Errorf("Unable to validate message for channel %q: %v", channelReference, err)
+				res.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	dedupeKey, duplicate := r.deduplicate(channelReference, message)
+	if duplicate {
+		glog.Infof("Dropping duplicate message for channel %q", channelReference)
+		messagesDuplicate.WithLabelValues(channelReference.Namespace, channelReference.Name).Inc()
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	err = r.receiverFunc(channelReference, message)
 	if err != nil {
-		if err == ErrUnknownChannel {
+		if dedupeKey != "" {
+			// the message wasn't received, accept a redelivery
+			if err := r.dedupeStore.Forget(dedupeKey); err != nil {
+				glog.Warningf("Unable to forget dedupe key for channel %q: %v", channelReference, err)
+			}
+		}
+		if invalid, ok := err.(*InvalidEventError); ok {
+			glog.Infof("Rejecting message for channel %q: %v", channelReference, invalid)
+			http.Error(res, invalid.Error(), http.StatusBadRequest)
+		} else if err == ErrUnknownChannel {
 			res.WriteHeader(http.StatusNotFound)
 		} else {
 			res.WriteHeader(http.StatusInternalServerError)
@@ -120,12 +227,37 @@ func (r *MessageReceiver) HandleRequest(res http.ResponseWriter, req *http.Reque
 	res.WriteHeader(http.StatusAccepted)
 }
 
+// deduplicate records the message's dedupe key and reports whether the message
+// is a duplicate. The key is empty if the message is not deduplicated. Errors
+// from the dedupe store let the message through.
+func (r *MessageReceiver) deduplicate(channel *ChannelReference, message *Message) (string, bool) {
+	if r.dedupePolicies == nil || r.dedupeStore == nil {
+		return "", false
+	}
+	policy := r.dedupePolicies.DedupePolicy(channel)
+	if policy == nil {
+		return "", false
+	}
+	key, err := policy.Key(channel, message)
+	if err != nil {
+		glog.Warningf("Not deduplicating message for channel %q: %v", channel, err)
+		return "", false
+	}
+	seen, err := r.dedupeStore.SeenBefore(key, policy.Window)
+	if err != nil {
+		glog.Warningf("Unable to deduplicate message for channel %q: %v", channel, err)
+		return "", false
+	}
+	return key, seen
+}
+
 func (r *MessageReceiver) fromRequest(req *http.Request) (*Message, error) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return nil, err
 	}
 	headers := r.fromHTTPHeaders(req.Header)
+	headers[HeaderIngestionTime] = time.Now().UTC().Format(time.RFC3339Nano)
 	message := &Message{
 		Headers: headers,
 		Payload: body,
@@ -18,22 +18,28 @@ package buses
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
 	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	feedsv1alpha1 "github.com/knative/eventing/pkg/apis/feeds/v1alpha1"
 	"github.com/knative/eventing/pkg/client/clientset/versioned/scheme"
 	channelscheme "github.com/knative/eventing/pkg/client/clientset/versioned/scheme"
 	listers "github.com/knative/eventing/pkg/client/listers/channels/v1alpha1"
+	feedslisters "github.com/knative/eventing/pkg/client/listers/feeds/v1alpha1"
 
 	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
 	informers "github.com/knative/eventing/pkg/client/informers/externalversions"
 	"github.com/knative/eventing/pkg/controller/util"
+	"github.com/knative/eventing/pkg/event"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -50,7 +56,7 @@ const (
 
 	busKind          = "Bus"
 	clusterBusKind   = "ClusterBus"
-	channelKind      = "Channel"
+	channelKind      = channelsv1alpha1.ChannelKind
 	subscriptionKind = "Subscription"
 
 	// SuccessSynced is used as part of the Event 'reason' when a resource is synced
@@ -58,6 +64,19 @@ const (
 	// ErrResourceSync is used as part of the Event 'reason' when a resource fails
 	// to sync.
 	errResourceSync = "ErrResourceSync"
+	// SubscriberResolved is used as part of the condition reason when a
+	// Subscription's subscriber reference is resolved.
+	subscriberResolved = "SubscriberResolved"
+	// ErrSubscriberNotResolved is used as part of the condition reason when a
+	// Subscription's subscriber reference cannot be resolved.
+	errSubscriberNotResolved = "ErrSubscriberNotResolved"
+	// ErrInvalidArguments is used as part of the Event and condition reason
+	// when a resource's arguments break the Bus' parameter rules.
+	errInvalidArguments = "ErrInvalidArguments"
+
+	// subscriberRefIndex indexes Subscriptions by the namespace/name of the
+	// Channel their SubscriberRef references.
+	subscriberRefIndex = "subscriberRef"
 )
 
 // Monitor is a utility mix-in intended to be used by Bus authors to easily
@@ -69,22 +88,37 @@ const (
 // event handler functions to be called when Provision/Unprovision and
 // Subscribe/Unsubscribe happen.
 type Monitor struct {
-	bus                      channelsv1alpha1.GenericBus
-	handler                  MonitorEventHandlerFuncs
-	informerFactory          informers.SharedInformerFactory
-	clientset                clientset.Interface
-	busesLister              listers.BusLister
-	busesSynced              cache.InformerSynced
-	clusterBusesLister       listers.ClusterBusLister
-	clusterBusesSynced       cache.InformerSynced
-	channelsLister           listers.ChannelLister
-	channelsSynced           cache.InformerSynced
-	subscriptionsLister      listers.SubscriptionLister
-	subscriptionsSynced      cache.InformerSynced
-	cache                    map[channelKey]*channelSummary
-	provisionedChannels      map[channelKey]*channelsv1alpha1.Channel
-	provisionedSubscriptions map[subscriptionKey]*channelsv1alpha1.Subscription
-	mutex                    *sync.Mutex
+	bus                     channelsv1alpha1.GenericBus
+	handler                 MonitorEventHandlerFuncs
+	informerFactory         informers.SharedInformerFactory
+	kubeclientset           kubernetes.Interface
+	clientset               clientset.Interface
+	busesLister             listers.BusLister
+	busesSynced             cache.InformerSynced
+	clusterBusesLister      listers.ClusterBusLister
+	clusterBusesSynced      cache.InformerSynced
+	channelsLister          listers.ChannelLister
+	channelsSynced          cache.InformerSynced
+	subscriptionsLister     listers.SubscriptionLister
+	subscriptionsIndexer    cache.Indexer
+	subscriptionsSynced     cache.InformerSynced
+	eventTypesLister        feedslisters.EventTypeLister
+	eventTypesSynced        cache.InformerSynced
+	clusterEventTypesLister feedslisters.ClusterEventTypeLister
+	clusterEventTypesSynced cache.InformerSynced
+	ingress                 *ingressAuthorizer
+	schemas                 *schemaCache
+	elector                 *leaderElector
+	sharder                 *sharder
+	gc                      *garbageCollector
+
+	// currentIndex holds the *monitorIndex of Channels and Subscriptions. It
+	// is read without locking; indexMutex serializes writers.
+	currentIndex atomic.Value
+	indexMutex   *sync.Mutex
+	// syncMutex is held for reading by workers while they sync an item as
+	// the leader, stoppedLeading holds it to wait for them to finish.
+	syncMutex *sync.RWMutex
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -99,6 +133,16 @@ type Monitor struct {
 
 type ResolvedParameters = map[string]string
 
+// busChannelArguments are Channel arguments handled by every bus. They do not
+// need to be declared as Bus parameters.
+var busChannelArguments = map[string]bool{
+	ArgumentMessageTTL:       true,
+	ArgumentDeadLetterTarget: true,
+	ArgumentDedupeWindow:     true,
+	ArgumentDedupeKeys:       true,
+	ArgumentEventValidation:  true,
+}
+
 // MonitorEventHandlerFuncs is a set of handler functions that are called when a
 // bus requires sync, channels are provisioned/unprovisioned, or a subscription
 // is created or deleted, or if one of the relevant resources is changed.
@@ -114,7 +158,9 @@ type MonitorEventHandlerFuncs struct {
 	UnprovisionFunc func(channel *channelsv1alpha1.Channel) error
 
 	// SubscribeFunc is invoked when a new Subscription should be set up or when
-	// the attributes change.
+	// the attributes change. The Subscription's Spec.Subscriber holds the
+	// resolved address of the subscriber, even when the Subscription
+	// references its subscriber with Spec.SubscriberRef.
 	SubscribeFunc func(subscription *channelsv1alpha1.Subscription, parameters ResolvedParameters) error
 
 	// UnsubscribeFunc is invoked when a Subscription should be deleted.
@@ -138,10 +184,24 @@ func (h MonitorEventHandlerFuncs) onProvision(channel *channelsv1alpha1.Channel,
 	if h.ProvisionFunc != nil {
 		parameters, err := monitor.resolveChannelParameters(channel.Spec)
 		if err != nil {
+			monitor.recorder.Eventf(channel, corev1.EventTypeWarning, errInvalidArguments, "Invalid arguments: %s", err)
+			channelCopy := channel.DeepCopy()
+			cond := util.NewChannelCondition(channelsv1alpha1.ChannelProvisioned, corev1.ConditionFalse, errInvalidArguments, err.Error())
+			util.SetChannelCondition(&channelCopy.Status, *cond)
+			util.ConsolidateChannelCondition(&channelCopy.Status)
+			if _, errS := monitor.clientset.ChannelsV1alpha1().Channels(channel.Namespace).Update(channelCopy); errS != nil {
+				glog.Warningf("Could not update status: %v", errS)
+			}
 			return err
 		}
 		err = h.ProvisionFunc(channel, parameters)
 		channelCopy := channel.DeepCopy()
+		// the finalizer guarantees the channel is unprovisioned before it is
+		// deleted, even if it was only partially provisioned
+		addFinalizer := h.UnprovisionFunc != nil && !hasChannelFinalizer(channel)
+		if addFinalizer {
+			addChannelFinalizer(channelCopy)
+		}
 		var cond *channelsv1alpha1.ChannelCondition
 		if err != nil {
 			monitor.recorder.Eventf(channel, corev1.EventTypeWarning, errResourceSync, "Error provisioning channel: %s", err)
@@ -155,6 +215,9 @@ func (h MonitorEventHandlerFuncs) onProvision(channel *channelsv1alpha1.Channel,
 		_, errS := monitor.clientset.ChannelsV1alpha1().Channels(channel.Namespace).Update(channelCopy)
 		if errS != nil {
 			glog.Warningf("Could not update status: %v", errS)
+			if err == nil && addFinalizer {
+				return errS
+			}
 		}
 		return err
 	}
@@ -165,6 +228,10 @@ func (h MonitorEventHandlerFuncs) onUnprovision(channel *channelsv1alpha1.Channe
 	if h.UnprovisionFunc != nil {
 		err := h.UnprovisionFunc(channel)
 		channelCopy := channel.DeepCopy()
+		removeFinalizer := err == nil && hasChannelFinalizer(channel)
+		if removeFinalizer {
+			removeChannelFinalizer(channelCopy)
+		}
 		var cond *channelsv1alpha1.ChannelCondition
 		if err != nil {
 			monitor.recorder.Eventf(channel, corev1.EventTypeWarning, errResourceSync, "Error unprovisioning channel: %s", err)
@@ -178,19 +245,30 @@ func (h MonitorEventHandlerFuncs) onUnprovision(channel *channelsv1alpha1.Channe
 		_, errS := monitor.clientset.ChannelsV1alpha1().Channels(channel.Namespace).Update(channelCopy)
 		if errS != nil {
 			glog.Warningf("Could not update status: %v", errS)
+			if removeFinalizer {
+				// the channel stays until the finalizer is removed
+				return errS
+			}
 		}
 		return err
 	}
 	return nil
 }
 
-func (h MonitorEventHandlerFuncs) onSubscribe(subscription *channelsv1alpha1.Subscription, monitor *Monitor) error {
+func (h MonitorEventHandlerFuncs) onSubscribe(subscription, resolved *channelsv1alpha1.Subscription, monitor *Monitor) error {
 	if h.SubscribeFunc != nil {
 		attributes, err := monitor.resolveSubscriptionParameters(subscription.Spec)
 		if err != nil {
+			monitor.recorder.Eventf(subscription, corev1.EventTypeWarning, errInvalidArguments, "Invalid arguments: %s", err)
+			subscriptionCopy := subscription.DeepCopy()
+			cond := util.NewSubscriptionCondition(channelsv1alpha1.SubscriptionDispatching, corev1.ConditionFalse, errInvalidArguments, err.Error())
+			util.SetSubscriptionCondition(&subscriptionCopy.Status, *cond)
+			if _, errS := monitor.clientset.ChannelsV1alpha1().Subscriptions(subscription.Namespace).Update(subscriptionCopy); errS != nil {
+				glog.Warningf("Could not update status: %v", errS)
+			}
 			return err
 		}
-		err = h.SubscribeFunc(subscription, attributes)
+		err = h.SubscribeFunc(resolved, attributes)
 		subscriptionCopy := subscription.DeepCopy()
 		var cond *channelsv1alpha1.SubscriptionCondition
 		if err != nil {
@@ -232,19 +310,6 @@ func (h MonitorEventHandlerFuncs) onUnsubscribe(subscription *channelsv1alpha1.S
 	return nil
 }
 
-// channelSummary is a record, for a particular Channel, of that Channel's spec
-// and its current subscriptions.
-type channelSummary struct {
-	Channel       *channelsv1alpha1.ChannelSpec
-	Subscriptions map[subscriptionKey]subscriptionSummary
-}
-
-// subscriptionSummary is a record of a Subscription's spec that is used as part
-// of a channelSummary.
-type subscriptionSummary struct {
-	Subscription channelsv1alpha1.SubscriptionSpec
-}
-
 // NewMonitor creates a monitor for a bus given:
 //
 // component: the name of the component this monitor should use in created k8s events
@@ -271,10 +336,27 @@ func NewMonitor(
 	}
 
 	informerFactory := informers.NewSharedInformerFactory(client, time.Second*30)
+
+	return NewMonitorFromClients(component, kubeClient, client, informerFactory, handler)
+}
+
+// NewMonitorFromClients creates a monitor for a bus using the given Kubernetes
+// and Channels clientsets. The monitor's informers are created from
+// informerFactory, which must be backed by client. It is used by NewMonitor
+// and by tests that run a monitor against fake clientsets.
+func NewMonitorFromClients(
+	component string,
+	kubeClient kubernetes.Interface,
+	client clientset.Interface,
+	informerFactory informers.SharedInformerFactory,
+	handler MonitorEventHandlerFuncs,
+) *Monitor {
 	busInformer := informerFactory.Channels().V1alpha1().Buses()
 	clusterBusInformer := informerFactory.Channels().V1alpha1().ClusterBuses()
 	channelInformer := informerFactory.Channels().V1alpha1().Channels()
 	subscriptionInformer := informerFactory.Channels().V1alpha1().Subscriptions()
+	eventTypeInformer := informerFactory.Feeds().V1alpha1().EventTypes()
+	clusterEventTypeInformer := informerFactory.Feeds().V1alpha1().ClusterEventTypes()
 
 	// Create event broadcaster
 	// Add types to the default Kubernetes Scheme so Events can be logged for the component.
@@ -289,24 +371,34 @@ func NewMonitor(
 		bus:     nil,
 		handler: handler,
 
-		clientset:                client,
-		informerFactory:          informerFactory,
-		busesLister:              busInformer.Lister(),
-		busesSynced:              busInformer.Informer().HasSynced,
-		clusterBusesLister:       clusterBusInformer.Lister(),
-		clusterBusesSynced:       clusterBusInformer.Informer().HasSynced,
-		channelsLister:           channelInformer.Lister(),
-		channelsSynced:           channelInformer.Informer().HasSynced,
-		subscriptionsLister:      subscriptionInformer.Lister(),
-		subscriptionsSynced:      subscriptionInformer.Informer().HasSynced,
-		cache:                    make(map[channelKey]*channelSummary),
-		provisionedChannels:      make(map[channelKey]*channelsv1alpha1.Channel),
-		provisionedSubscriptions: make(map[subscriptionKey]*channelsv1alpha1.Subscription),
-		mutex: &sync.Mutex{},
+		kubeclientset:           kubeClient,
+		clientset:               client,
+		informerFactory:         informerFactory,
+		busesLister:             busInformer.Lister(),
+		busesSynced:             busInformer.Informer().HasSynced,
+		clusterBusesLister:      clusterBusInformer.Lister(),
+		clusterBusesSynced:      clusterBusInformer.Informer().HasSynced,
+		channelsLister:          channelInformer.Lister(),
+		channelsSynced:          channelInformer.Informer().HasSynced,
+		subscriptionsLister:     subscriptionInformer.Lister(),
+		subscriptionsIndexer:    subscriptionInformer.Informer().GetIndexer(),
+		subscriptionsSynced:     subscriptionInformer.Informer().HasSynced,
+		eventTypesLister:        eventTypeInformer.Lister(),
+		eventTypesSynced:        eventTypeInformer.Informer().HasSynced,
+		clusterEventTypesLister: clusterEventTypeInformer.Lister(),
+		clusterEventTypesSynced: clusterEventTypeInformer.Informer().HasSynced,
+		ingress:                 newIngressAuthorizer(kubeClient),
+		schemas:                 newSchemaCache(),
+		indexMutex:              &sync.Mutex{},
+		syncMutex:               &sync.RWMutex{},
 
 		workqueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Monitor"),
 		recorder:  recorder,
 	}
+	monitor.currentIndex.Store(newMonitorIndex())
+	subscriptionInformer.Informer().AddIndexers(cache.Indexers{
+		subscriberRefIndex: subscriberRefIndexFunc,
+	})
 
 	glog.Info("Setting up event handlers")
 	// Set up an event handler for when Bus resources change
@@ -347,11 +439,14 @@ func NewMonitor(
 			monitor.workqueue.AddRateLimited(makeWorkqueueKeyForClusterBus(newClusterBus))
 		},
 	})
-	// Set up an event handler for when Channel resources change
+	// Set up an event handler for when Channel resources change. Subscriptions
+	// referencing a Channel as their subscriber are resolved again when its
+	// address or ingress policy change.
 	channelInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			channel := obj.(*channelsv1alpha1.Channel)
 			monitor.workqueue.AddRateLimited(makeWorkqueueKeyForChannel(channel))
+			monitor.enqueueSubscriberRefs(channel)
 		},
 		UpdateFunc: func(old, new interface{}) {
 			oldChannel := old.(*channelsv1alpha1.Channel)
@@ -363,11 +458,18 @@ func NewMonitor(
 				return
 			}
 
+			monitor.ingress.forgetChannel(newChannel.Namespace, newChannel.Name)
 			monitor.workqueue.AddRateLimited(makeWorkqueueKeyForChannel(newChannel))
+			if oldChannel.Status.DomainInternal != newChannel.Status.DomainInternal ||
+				!reflect.DeepEqual(oldChannel.Spec.IngressPolicy, newChannel.Spec.IngressPolicy) {
+				monitor.enqueueSubscriberRefs(newChannel)
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			channel := obj.(*channelsv1alpha1.Channel)
+			monitor.ingress.forgetChannel(channel.Namespace, channel.Name)
 			monitor.workqueue.AddRateLimited(makeWorkqueueKeyForChannel(channel))
+			monitor.enqueueSubscriberRefs(channel)
 		},
 	})
 	// Set up an event handler for when Subscription resources change
@@ -401,47 +503,132 @@ func NewMonitor(
 // nil if such a Channel hasn't been provisioned.
 func (m *Monitor) Channel(name string, namespace string) *channelsv1alpha1.Channel {
 	channelKey := makeChannelKeyWithNames(namespace, name)
-	if channel, ok := m.provisionedChannels[channelKey]; ok {
-		return channel
-	}
-	return nil
+	return m.index().channel(channelKey)
 }
 
 // Subscription returns the provisioned Subscription with the given name and
 // namespace, or nil if such a Subscription hasn't been provisioned.
 func (m *Monitor) Subscription(name string, namespace string) *channelsv1alpha1.Subscription {
 	subscriptionKey := makeSubscriptionKeyWithNames(namespace, name)
-	if subscription, ok := m.provisionedSubscriptions[subscriptionKey]; ok {
-		return subscription
-	}
-	return nil
+	return m.index().subscription(subscriptionKey)
 }
 
 // Subscriptions returns a slice of SubscriptionSpecs for the Channel with the
-// given name and namespace, or nil if the Channel hasn't been provisioned.
+// given name and namespace, or nil if the Channel hasn't been provisioned. The
+// slice is shared and must not be modified.
 func (m *Monitor) Subscriptions(channelName string, namespace string) *[]channelsv1alpha1.SubscriptionSpec {
 	channelKey := makeChannelKeyWithNames(namespace, channelName)
-	summary := m.getChannelSummary(channelKey)
-	channel := m.Channel(channelName, namespace)
+	index := m.index()
+	summary := index.summary(channelKey)
 
-	if summary == nil || summary.Channel == nil || channel == nil {
+	// Only Channels backed by this bus are provisioned.
+	if index.channel(channelKey) == nil || summary == nil || summary.Channel == nil {
 		// the channel is unknown
 		return nil
 	}
 
-	if !m.bus.BacksChannel(channel) {
-		// the channel is not for this bus
+	return &summary.specs
+}
+
+// AuthorizeIngress checks that the request is allowed to publish to the
+// channel by the Channel's ingress policy. Requests for unknown channels are
+// allowed so the receiver can report them as such.
+func (m *Monitor) AuthorizeIngress(ref *ChannelReference, req *http.Request) error {
+	channel := m.Channel(ref.Name, ref.Namespace)
+	if channel == nil {
 		return nil
 	}
+	return m.ingress.authorize(channel, req)
+}
 
-	m.mutex.Lock()
-	subscriptions := []channelsv1alpha1.SubscriptionSpec{}
-	for _, subscription := range summary.Subscriptions {
-		subscriptions = append(subscriptions, subscription.Subscription)
+// ExpiryPolicy returns the expiry policy for the channel, or nil if messages
+// on the channel do not expire. Channels with an invalid message TTL are
+// treated as having none.
+func (m *Monitor) ExpiryPolicy(ref *ChannelReference) *ExpiryPolicy {
+	channel := m.Channel(ref.Name, ref.Namespace)
+	if channel == nil {
+		return nil
 	}
-	m.mutex.Unlock()
+	policy, err := ExpiryPolicyForChannel(channel)
+	if err != nil {
+		glog.Warningf("Ignoring expiry policy for channel %q: %v", ref, err)
+		return nil
+	}
+	return policy
+}
 
-	return &subscriptions
+// DedupePolicy returns the dedupe policy for the channel, or nil if events on
+// the channel are not deduplicated. Channels with an invalid dedupe policy are
+// treated as having none.
+func (m *Monitor) DedupePolicy(ref *ChannelReference) *DedupePolicy {
+	channel := m.Channel(ref.Name, ref.Namespace)
+	if channel == nil {
+		return nil
+	}
+	policy, err := DedupePolicyForChannel(channel)
+	if err != nil {
+		glog.Warningf("Ignoring dedupe policy for channel %q: %v", ref, err)
+		return nil
+	}
+	return policy
+}
+
+// ValidateEvent validates a message received for the channel by the Channel's
+// event validation mode. The schema of the event is looked up by the name of
+// its type, first as an EventType in the Channel's namespace, then as a
+// ClusterEventType. Channels with an invalid validation mode, and unknown
+// channels, accept every message.
+func (m *Monitor) ValidateEvent(ref *ChannelReference, message *Message) error {
+	channel := m.Channel(ref.Name, ref.Namespace)
+	if channel == nil {
+		return nil
+	}
+	mode, err := EventValidationForChannel(channel)
+	if err != nil {
+		glog.Warningf("Ignoring event validation for channel %q: %v", ref, err)
+		return nil
+	}
+	if mode == "" {
+		return nil
+	}
+	return ValidateMessage(mode, message, func(eventType string) (*event.Schema, bool, error) {
+		return m.eventTypeSchema(ref.Namespace, eventType)
+	})
+}
+
+// eventTypeSchema returns the compiled schema of the named EventType in the
+// namespace, or of the named ClusterEventType. found is false if there is
+// neither.
+func (m *Monitor) eventTypeSchema(namespace, name string) (*event.Schema, bool, error) {
+	var key, resourceVersion string
+	var spec *feedsv1alpha1.CommonEventTypeSpec
+	eventType, err := m.eventTypesLister.EventTypes(namespace).Get(name)
+	if err == nil {
+		key = namespace + "/" + name
+		resourceVersion = eventType.ResourceVersion
+		spec = &eventType.Spec.CommonEventTypeSpec
+	} else if errors.IsNotFound(err) {
+		clusterEventType, err := m.clusterEventTypesLister.Get(name)
+		if errors.IsNotFound(err) {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		key = name
+		resourceVersion = clusterEventType.ResourceVersion
+		spec = &clusterEventType.Spec.CommonEventTypeSpec
+	} else {
+		return nil, false, err
+	}
+
+	if spec.EventSchema == nil || len(spec.EventSchema.Raw) == 0 {
+		return nil, true, nil
+	}
+	schema, err := m.schemas.compile(key, resourceVersion, spec.EventSchema.Raw)
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid schema for event type %q: %v", name, err)
+	}
+	return schema, true, nil
 }
 
 // resolveChannelParameters resolves the given Channel Parameters and the Bus'
@@ -472,7 +659,7 @@ func (m *Monitor) resolveSubscriptionParameters(subscription channelsv1alpha1.Su
 // optionally defaulted if a default value for the Parameter is specified.
 // resolveAttributes combines the given arrays of Parameters and Arguments,
 // using default values where necessary and returning an error if there are
-// missing Arguments.
+// missing Arguments or Arguments that break their Parameter's rules.
 func (m *Monitor) resolveParameters(parameters *[]channelsv1alpha1.Parameter, arguments *[]channelsv1alpha1.Argument) (ResolvedParameters, error) {
 	resolved := make(ResolvedParameters)
 	known := make(map[string]interface{})
@@ -493,8 +680,11 @@ func (m *Monitor) resolveParameters(parameters *[]channelsv1alpha1.Parameter, ar
 	if arguments != nil {
 		for _, arg := range *arguments {
 			if _, ok := known[arg.Name]; !ok {
-				// ignore arguments not defined by parameters
-				glog.Warningf("Skipping unknown argument: %s\n", arg.Name)
+				// ignore arguments not defined by parameters, arguments handled
+				// by every bus are expected
+				if !busChannelArguments[arg.Name] {
+					glog.Warningf("Skipping unknown argument: %s\n", arg.Name)
+				}
 				continue
 			}
 			delete(required, arg.Name)
@@ -511,14 +701,143 @@ func (m *Monitor) resolveParameters(parameters *[]channelsv1alpha1.Parameter, ar
 		return nil, fmt.Errorf("missing required arguments: %v", missing)
 	}
 
+	// check argument values against the parameters' rules
+	if parameters != nil {
+		for _, param := range *parameters {
+			if value, ok := resolved[param.Name]; ok {
+				if err := param.Validate(value); err != nil {
+					return nil, fmt.Errorf("invalid argument %s: %v", param.Name, err)
+				}
+			}
+		}
+	}
+
 	return resolved, nil
 }
 
+// enqueueSubscriberRefs queues the Subscriptions whose SubscriberRef
+// references the Channel.
+func (m *Monitor) enqueueSubscriberRefs(channel *channelsv1alpha1.Channel) {
+	objs, err := m.subscriptionsIndexer.ByIndex(subscriberRefIndex, channel.Namespace+"/"+channel.Name)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, obj := range objs {
+		m.workqueue.AddRateLimited(makeWorkqueueKeyForSubscription(obj.(*channelsv1alpha1.Subscription)))
+	}
+}
+
+// subscriberRefIndexFunc indexes a Subscription by the Channel its
+// SubscriberRef references.
+func subscriberRefIndexFunc(obj interface{}) ([]string, error) {
+	subscription, ok := obj.(*channelsv1alpha1.Subscription)
+	if !ok {
+		return nil, nil
+	}
+	ref := subscription.Spec.SubscriberRef
+	if ref == nil || ref.Kind != channelKind {
+		return nil, nil
+	}
+	return []string{subscription.Namespace + "/" + ref.Name}, nil
+}
+
 func (m *Monitor) RequeueSubscription(subscription *channelsv1alpha1.Subscription) {
 	glog.Infof("Requeue subscription %q\n", subscription.Name)
 	m.workqueue.AddRateLimited(makeWorkqueueKeyForSubscription(subscription))
 }
 
+// SetLeaderElection makes the monitor elect a leader with the other replicas
+// of its component. Only the leader calls the handler functions; followers
+// keep their caches warm so they can take over quickly. It must be called
+// before Run.
+func (m *Monitor) SetLeaderElection(config LeaderElectionConfig) {
+	m.elector = newLeaderElector(m.kubeclientset, config)
+}
+
+// IsLeader returns true if the monitor calls the handler functions, either
+// because it is the elected leader or because leader election is not used.
+func (m *Monitor) IsLeader() bool {
+	return m.elector == nil || m.elector.IsLeader()
+}
+
+// SetSharding makes the monitor share the bus' Subscriptions with the other
+// replicas of its component. Each Subscription is assigned to one replica by
+// consistent hashing, and only that replica calls the subscribe handler
+// function for it. Subscriptions are reassigned as replicas come and go. It
+// must be called before Run.
+func (m *Monitor) SetSharding(config ShardingConfig) {
+	m.sharder = newSharder(m.kubeclientset, config, m.rebalance)
+}
+
+// OwnsSubscription returns true if this replica subscribes to the named
+// Subscription, either because it is assigned the Subscription or because
+// Subscriptions are not sharded.
+func (m *Monitor) OwnsSubscription(namespace, name string) bool {
+	return m.sharder == nil || m.sharder.owns(subscriptionKey{Namespace: namespace, Name: name})
+}
+
+// rebalance queues every Subscription so each replica subscribes to the
+// Subscriptions it now owns and releases the others.
+func (m *Monitor) rebalance() {
+	subscriptions, err := m.subscriptionsLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+	}
+	for _, subscription := range subscriptions {
+		m.workqueue.Add(makeWorkqueueKeyForSubscription(subscription))
+	}
+}
+
+// startedLeading queues every resource so the new leader converges on them,
+// followers drop the items they are given. The resources of the bus' Channels
+// are recorded for garbage collection.
+func (m *Monitor) startedLeading() {
+	m.recordChannelResources()
+
+	buses, err := m.busesLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+	}
+	for _, bus := range buses {
+		m.workqueue.Add(makeWorkqueueKeyForBus(bus))
+	}
+	clusterBuses, err := m.clusterBusesLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+	}
+	for _, clusterBus := range clusterBuses {
+		m.workqueue.Add(makeWorkqueueKeyForClusterBus(clusterBus))
+	}
+	channels, err := m.channelsLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+	}
+	for _, channel := range channels {
+		m.workqueue.Add(makeWorkqueueKeyForChannel(channel))
+	}
+	subscriptions, err := m.subscriptionsLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+	}
+	for _, subscription := range subscriptions {
+		m.workqueue.Add(makeWorkqueueKeyForSubscription(subscription))
+	}
+}
+
+// stoppedLeading forgets what was provisioned, the next leader owns it now.
+// Should this monitor lead again, everything is provisioned again. Items being
+// synced are finished first, so they don't record what they provisioned in the
+// cleared index.
+func (m *Monitor) stoppedLeading() {
+	m.syncMutex.Lock()
+	defer m.syncMutex.Unlock()
+
+	m.updateIndex(func(index *monitorIndex) {
+		*index = *newMonitorIndex()
+	})
+}
+
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
 // is closed, at which point it will shutdown the workqueue and wait for
@@ -530,6 +849,9 @@ func (m *Monitor) Run(busNamespace, busName string, threadiness int, stopCh <-ch
 	// Start the informer factories to begin populating the informer caches
 	glog.Info("Starting monitor")
 	go m.informerFactory.Start(stopCh)
+	if m.sharder != nil {
+		go m.sharder.informer.Run(stopCh)
+	}
 
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
@@ -560,6 +882,15 @@ func (m *Monitor) Run(busNamespace, busName string, threadiness int, stopCh <-ch
 	}
 
 	glog.Info("Started workers")
+	if m.elector != nil {
+		go m.elector.Run(stopCh, m.startedLeading, m.stoppedLeading)
+	} else {
+		// the monitor leads from the start
+		go m.recordChannelResources()
+	}
+	if m.gc != nil && m.gc.config.Interval > 0 {
+		go wait.Until(m.collectGarbage, m.gc.config.Interval, stopCh)
+	}
 	<-stopCh
 	glog.Info("Shutting down workers")
 
@@ -569,7 +900,11 @@ func (m *Monitor) Run(busNamespace, busName string, threadiness int, stopCh <-ch
 // WaitForCacheSync blocks returning until the monitor's informers have
 // synchronized. It returns an error if the caches cannot sync.
 func (m *Monitor) WaitForCacheSync(stopCh <-chan struct{}) error {
-	if ok := cache.WaitForCacheSync(stopCh, m.busesSynced, m.clusterBusesSynced, m.channelsSynced, m.subscriptionsSynced); !ok {
+	synced := []cache.InformerSynced{m.busesSynced, m.clusterBusesSynced, m.channelsSynced, m.subscriptionsSynced, m.eventTypesSynced, m.clusterEventTypesSynced}
+	if m.sharder != nil {
+		synced = append(synced, m.sharder.informer.HasSynced)
+	}
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 	return nil
@@ -616,8 +951,18 @@ func (m *Monitor) processNextWorkItem() bool {
 			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
+		m.syncMutex.RLock()
+		if !m.IsLeader() {
+			m.syncMutex.RUnlock()
+			// the leader syncs the item meanwhile, and startedLeading
+			// queues it again should this monitor lead
+			m.workqueue.Forget(obj)
+			return nil
+		}
 		// Run the syncHandler, passing it the name string of the resource to be synced.
-		if err := m.syncHandler(key); err != nil {
+		err := m.syncHandler(key)
+		m.syncMutex.RUnlock()
+		if err != nil {
 			m.workqueue.AddRateLimited(obj)
 			return fmt.Errorf("error syncing monitor '%s': %s", key, err.Error())
 		}
@@ -767,25 +1112,6 @@ func (m *Monitor) syncSubscription(namespace string, name string) error {
 	return nil
 }
 
-func (m *Monitor) getChannelSummary(key channelKey) *channelSummary {
-	return m.cache[key]
-}
-
-func (m *Monitor) getOrCreateChannelSummary(key channelKey) *channelSummary {
-	m.mutex.Lock()
-	summary, ok := m.cache[key]
-	if !ok {
-		summary = &channelSummary{
-			Channel:       nil,
-			Subscriptions: make(map[subscriptionKey]subscriptionSummary),
-		}
-		m.cache[key] = summary
-	}
-	m.mutex.Unlock()
-
-	return summary
-}
-
 func (m *Monitor) createOrUpdateBus(bus *channelsv1alpha1.Bus) error {
 	if bus.Namespace != m.bus.GetObjectMeta().GetNamespace() ||
 		bus.Name != m.bus.GetObjectMeta().GetName() {
@@ -822,66 +1148,123 @@ func (m *Monitor) createOrUpdateClusterBus(clusterBus *channelsv1alpha1.ClusterB
 }
 
 func (m *Monitor) createOrUpdateChannel(channel *channelsv1alpha1.Channel) error {
+	if channel.DeletionTimestamp != nil {
+		return m.finalizeChannel(channel)
+	}
+
 	channelKey := makeChannelKeyFromChannel(channel)
-	summary := m.getOrCreateChannelSummary(channelKey)
 
-	m.mutex.Lock()
-	old := summary.Channel
+	var old *channelsv1alpha1.ChannelSpec
 	new := &channel.Spec
-	summary.Channel = new
-	m.mutex.Unlock()
+	m.updateIndex(func(index *monitorIndex) {
+		index.updateSummary(channelKey, func(summary *channelSummary) {
+			old = summary.Channel
+			summary.Channel = new
+		})
+	})
 
-	if m.bus.BacksChannel(channel) && !reflect.DeepEqual(old, new) {
+	// a channel provisioned before finalizers were used is provisioned again
+	// to add the finalizer
+	missingFinalizer := m.handler.UnprovisionFunc != nil && !hasChannelFinalizer(channel)
+	if m.bus.BacksChannel(channel) && (!reflect.DeepEqual(old, new) || missingFinalizer) {
+		if err := m.recordChannelResource(channel); err != nil {
+			return err
+		}
 		err := m.handler.onProvision(channel, m)
 		if err != nil {
 			return err
 		}
-		m.provisionedChannels[channelKey] = channel
+		m.updateIndex(func(index *monitorIndex) {
+			index.setChannel(channelKey, channel)
+		})
 	}
 
 	return nil
 }
 
+// finalizeChannel unprovisions a Channel that is being deleted, removing the
+// finalizer that holds its deletion.
+func (m *Monitor) finalizeChannel(channel *channelsv1alpha1.Channel) error {
+	channelKey := makeChannelKeyFromChannel(channel)
+	m.updateIndex(func(index *monitorIndex) {
+		index.updateSummary(channelKey, func(summary *channelSummary) {
+			summary.Channel = nil
+		})
+	})
+
+	if m.bus.BacksChannel(channel) && hasChannelFinalizer(channel) {
+		err := m.handler.onUnprovision(channel, m)
+		if err != nil {
+			return err
+		}
+	}
+	m.updateIndex(func(index *monitorIndex) {
+		index.setChannel(channelKey, nil)
+	})
+
+	return nil
+}
+
 func (m *Monitor) removeChannel(namespace string, name string) error {
 	channelKey := makeChannelKeyWithNames(namespace, name)
-	channel, ok := m.provisionedChannels[channelKey]
-	if !ok {
+	channel := m.index().channel(channelKey)
+	if channel == nil {
 		return nil
 	}
 
-	summary := m.getOrCreateChannelSummary(channelKey)
-
-	m.mutex.Lock()
-	summary.Channel = nil
-	m.mutex.Unlock()
+	m.updateIndex(func(index *monitorIndex) {
+		index.updateSummary(channelKey, func(summary *channelSummary) {
+			summary.Channel = nil
+		})
+	})
 
 	err := m.handler.onUnprovision(channel, m)
 	if err != nil {
 		return err
 	}
-	delete(m.provisionedChannels, channelKey)
+	m.updateIndex(func(index *monitorIndex) {
+		index.setChannel(channelKey, nil)
+	})
 
 	return nil
 }
 
 func (m *Monitor) isSubscriptionProvisioned(subscription *channelsv1alpha1.Subscription) bool {
 	subscriptionKey := makeSubscriptionKeyFromSubscription(subscription)
-	_, ok := m.provisionedSubscriptions[subscriptionKey]
-	return ok
+	return m.index().subscription(subscriptionKey) != nil
 }
 
 func (m *Monitor) createOrUpdateSubscription(subscription *channelsv1alpha1.Subscription) error {
 	subscriptionKey := makeSubscriptionKeyFromSubscription(subscription)
 	channelKey := makeChannelKeyFromSubscription(subscription)
-	summary := m.getOrCreateChannelSummary(channelKey)
 
-	m.mutex.Lock()
-	old := summary.Subscriptions[subscriptionKey]
+	resolved, err := m.resolveSubscriber(subscription)
+	if subscription.Spec.SubscriberRef != nil {
+		m.updateSubscriberResolvedCondition(subscription, err)
+	}
+	if err != nil {
+		// stop dispatching to a subscriber that can no longer be resolved
+		m.updateIndex(func(index *monitorIndex) {
+			index.updateSummary(channelKey, func(summary *channelSummary) {
+				delete(summary.Subscriptions, subscriptionKey)
+			})
+		})
+		if errU := m.releaseSubscription(subscriptionKey); errU != nil {
+			return errU
+		}
+		return err
+	}
+
+	var old subscriptionSummary
 	new := subscriptionSummary{
-		Subscription: subscription.Spec,
+		Subscription: resolved.Spec,
 	}
-	summary.Subscriptions[subscriptionKey] = new
-	m.mutex.Unlock()
+	m.updateIndex(func(index *monitorIndex) {
+		index.updateSummary(channelKey, func(summary *channelSummary) {
+			old = summary.Subscriptions[subscriptionKey]
+			summary.Subscriptions[subscriptionKey] = new
+		})
+	})
 
 	channel := m.Channel(subscription.Spec.Channel, subscription.Namespace)
 	if channel == nil {
@@ -890,37 +1273,132 @@ func (m *Monitor) createOrUpdateSubscription(subscription *channelsv1alpha1.Subs
 	if !m.bus.BacksChannel(channel) {
 		return nil
 	}
+	if m.sharder != nil && !m.sharder.owns(subscriptionKey) {
+		// another replica dispatches the subscription
+		return m.releaseSubscription(subscriptionKey)
+	}
 
 	if !m.isSubscriptionProvisioned(subscription) || !reflect.DeepEqual(old.Subscription, new.Subscription) {
-		err := m.handler.onSubscribe(subscription, m)
+		err := m.handler.onSubscribe(subscription, resolved, m)
 		if err != nil {
 			return err
 		}
-		m.provisionedSubscriptions[subscriptionKey] = subscription
+		m.updateIndex(func(index *monitorIndex) {
+			index.setSubscription(subscriptionKey, subscription)
+		})
+	}
+
+	return nil
+}
+
+// resolveSubscriber returns a copy of the Subscription whose Spec.Subscriber
+// holds the address events should be dispatched to. A Subscription that
+// references a Channel resolves to the Channel's internal domain. The Channel
+// must be in the Subscription's namespace and accept events without
+// credentials, as dispatchers don't present any.
+func (m *Monitor) resolveSubscriber(subscription *channelsv1alpha1.Subscription) (*channelsv1alpha1.Subscription, error) {
+	ref := subscription.Spec.SubscriberRef
+	if ref == nil {
+		return subscription, nil
+	}
+	if ref.Kind != channelKind {
+		return nil, fmt.Errorf("unsupported subscriber kind %q", ref.Kind)
+	}
+
+	namespace := subscription.Namespace
+	if len(ref.Namespace) != 0 && ref.Namespace != namespace {
+		return nil, fmt.Errorf("subscriber channel %s/%s is not in the subscription's namespace", ref.Namespace, ref.Name)
+	}
+	channel, err := m.channelsLister.Channels(namespace).Get(ref.Name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("subscriber channel %s/%s does not exist", namespace, ref.Name)
+		}
+		return nil, err
+	}
+	if channel.Spec.IngressPolicy != nil {
+		return nil, fmt.Errorf("subscriber channel %s/%s has an ingress policy, events can not be forwarded to it", namespace, ref.Name)
+	}
+	if len(channel.Status.DomainInternal) == 0 {
+		return nil, fmt.Errorf("subscriber channel %s/%s does not have an address yet", namespace, ref.Name)
+	}
+
+	resolved := subscription.DeepCopy()
+	resolved.Spec.Subscriber = channel.Status.DomainInternal
+	return resolved, nil
+}
+
+// updateSubscriberResolvedCondition records whether the Subscription's
+// subscriber reference could be resolved. The Subscription is only updated
+// when the condition changes.
+func (m *Monitor) updateSubscriberResolvedCondition(subscription *channelsv1alpha1.Subscription, resolveErr error) {
+	var cond *channelsv1alpha1.SubscriptionCondition
+	if resolveErr != nil {
+		cond = util.NewSubscriptionCondition(channelsv1alpha1.SubscriptionSubscriberResolved, corev1.ConditionFalse, errSubscriberNotResolved, resolveErr.Error())
+	} else {
+		cond = util.NewSubscriptionCondition(channelsv1alpha1.SubscriptionSubscriberResolved, corev1.ConditionTrue, subscriberResolved, "Subscriber successfully resolved")
+	}
+
+	current := util.GetSubscriptionCondition(subscription.Status, cond.Type)
+	if current != nil && current.Status == cond.Status && current.Message == cond.Message {
+		return
+	}
+	if resolveErr != nil {
+		m.recorder.Eventf(subscription, corev1.EventTypeWarning, errSubscriberNotResolved, "Unable to resolve subscriber: %s", resolveErr)
+	}
+
+	subscriptionCopy := subscription.DeepCopy()
+	util.RemoveSubscriptionCondition(&subscriptionCopy.Status, cond.Type)
+	util.SetSubscriptionCondition(&subscriptionCopy.Status, *cond)
+	_, err := m.clientset.ChannelsV1alpha1().Subscriptions(subscription.Namespace).Update(subscriptionCopy)
+	if err != nil {
+		glog.Warningf("Could not update status: %v", err)
+	}
+}
+
+// releaseSubscription stops handling a Subscription that has been assigned to
+// another replica, or whose subscriber can no longer be resolved. Unlike
+// removeSubscription, the Subscription's status is left to the replica that
+// now owns it, or to the SubscriberResolved condition.
+func (m *Monitor) releaseSubscription(key subscriptionKey) error {
+	subscription := m.index().subscription(key)
+	if subscription == nil {
+		return nil
+	}
+
+	if m.handler.UnsubscribeFunc != nil {
+		if err := m.handler.UnsubscribeFunc(subscription); err != nil {
+			return err
+		}
 	}
+	m.updateIndex(func(index *monitorIndex) {
+		index.setSubscription(key, nil)
+	})
 
 	return nil
 }
 
 func (m *Monitor) removeSubscription(namespace string, name string) error {
 	subscriptionKey := makeSubscriptionKeyWithNames(namespace, name)
-	subscription, ok := m.provisionedSubscriptions[subscriptionKey]
-	if !ok {
+	subscription := m.index().subscription(subscriptionKey)
+	if subscription == nil {
 		return nil
 	}
 
 	channelKey := makeChannelKeyFromSubscription(subscription)
-	summary := m.getOrCreateChannelSummary(channelKey)
-
-	m.mutex.Lock()
-	delete(summary.Subscriptions, subscriptionKey)
-	m.mutex.Unlock()
+	m.updateIndex(func(index *monitorIndex) {
+		index.updateSummary(channelKey, func(summary *channelSummary) {
+			delete(summary.Subscriptions, subscriptionKey)
+		})
+	})
 
 	err := m.handler.onUnsubscribe(subscription, m)
 	if err != nil {
 		return err
 	}
-	delete(m.provisionedSubscriptions, subscriptionKey)
+	m.updateIndex(func(index *monitorIndex) {
+		index.setSubscription(subscriptionKey, nil)
+	})
 
 	return nil
 }
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+)
+
+// monitorIndex is a snapshot of the Channels and Subscriptions known to a
+// Monitor. A published index is never modified: writers clone it, change the
+// clone and atomically swap it in, so readers on the message path look up
+// Channels and Subscriptions without taking a lock.
+//
+// Cloning a large index for every write would make syncing N Subscriptions
+// cost O(N²), so an index holds only the entries changed since its base, an
+// older index that is shared between clones. A clone copies the changed
+// entries, and folds them into a new base once they outnumber the square root
+// of the base's size, which bounds the cost of a write to O(√N) on average.
+type monitorIndex struct {
+	// summaries records the spec of every Channel seen and its Subscriptions.
+	summaries map[channelKey]*channelSummary
+	// channels holds the Channels provisioned for this bus.
+	channels map[channelKey]*channelsv1alpha1.Channel
+	// subscriptions holds the Subscriptions provisioned for this bus.
+	subscriptions map[subscriptionKey]*channelsv1alpha1.Subscription
+
+	// base holds the entries that have not changed since it was folded, the
+	// maps above hold a nil value for an entry removed since. The base of an
+	// index has no base itself.
+	base *monitorIndex
+}
+
+// channelSummary is a record, for a particular Channel, of that Channel's spec
+// and its current subscriptions.
+type channelSummary struct {
+	Channel       *channelsv1alpha1.ChannelSpec
+	Subscriptions map[subscriptionKey]subscriptionSummary
+
+	// specs holds the spec of each of Subscriptions. It is built when the
+	// summary is changed so readers don't copy it for every message.
+	specs []channelsv1alpha1.SubscriptionSpec
+}
+
+// subscriptionSummary is a record of a Subscription's spec that is used as part
+// of a channelSummary.
+type subscriptionSummary struct {
+	Subscription channelsv1alpha1.SubscriptionSpec
+}
+
+func newMonitorIndex() *monitorIndex {
+	return &monitorIndex{
+		summaries:     make(map[channelKey]*channelSummary),
+		channels:      make(map[channelKey]*channelsv1alpha1.Channel),
+		subscriptions: make(map[subscriptionKey]*channelsv1alpha1.Subscription),
+	}
+}
+
+// clone returns a copy of the index that may be changed. The summaries are
+// shared with the original and must be replaced, not modified, with
+// updateSummary.
+func (i *monitorIndex) clone() *monitorIndex {
+	if i.base == nil || i.changes()*i.changes() > i.base.changes() {
+		return &monitorIndex{
+			summaries:     make(map[channelKey]*channelSummary),
+			channels:      make(map[channelKey]*channelsv1alpha1.Channel),
+			subscriptions: make(map[subscriptionKey]*channelsv1alpha1.Subscription),
+			base:          i.fold(),
+		}
+	}
+
+	clone := &monitorIndex{
+		summaries:     make(map[channelKey]*channelSummary, len(i.summaries)),
+		channels:      make(map[channelKey]*channelsv1alpha1.Channel, len(i.channels)),
+		subscriptions: make(map[subscriptionKey]*channelsv1alpha1.Subscription, len(i.subscriptions)),
+		base:          i.base,
+	}
+	for key, summary := range i.summaries {
+		clone.summaries[key] = summary
+	}
+	for key, channel := range i.channels {
+		clone.channels[key] = channel
+	}
+	for key, subscription := range i.subscriptions {
+		clone.subscriptions[key] = subscription
+	}
+	return clone
+}
+
+// fold returns an index without a base holding the entries of the index.
+func (i *monitorIndex) fold() *monitorIndex {
+	if i.base == nil {
+		return i
+	}
+	folded := &monitorIndex{
+		summaries:     make(map[channelKey]*channelSummary, len(i.base.summaries)+len(i.summaries)),
+		channels:      make(map[channelKey]*channelsv1alpha1.Channel, len(i.base.channels)+len(i.channels)),
+		subscriptions: make(map[subscriptionKey]*channelsv1alpha1.Subscription, len(i.base.subscriptions)+len(i.subscriptions)),
+	}
+	for _, index := range []*monitorIndex{i.base, i} {
+		for key, summary := range index.summaries {
+			if summary != nil {
+				folded.summaries[key] = summary
+			} else {
+				delete(folded.summaries, key)
+			}
+		}
+		for key, channel := range index.channels {
+			if channel != nil {
+				folded.channels[key] = channel
+			} else {
+				delete(folded.channels, key)
+			}
+		}
+		for key, subscription := range index.subscriptions {
+			if subscription != nil {
+				folded.subscriptions[key] = subscription
+			} else {
+				delete(folded.subscriptions, key)
+			}
+		}
+	}
+	return folded
+}
+
+// changes returns the number of entries held by the index itself.
+func (i *monitorIndex) changes() int {
+	return len(i.summaries) + len(i.channels) + len(i.subscriptions)
+}
+
+// channel returns the provisioned channel, or nil if the channel is not
+// provisioned.
+func (i *monitorIndex) channel(key channelKey) *channelsv1alpha1.Channel {
+	if channel, ok := i.channels[key]; ok || i.base == nil {
+		return channel
+	}
+	return i.base.channels[key]
+}
+
+// setChannel records the channel as provisioned, or as not provisioned if it
+// is nil.
+func (i *monitorIndex) setChannel(key channelKey, channel *channelsv1alpha1.Channel) {
+	if channel == nil && i.base == nil {
+		delete(i.channels, key)
+		return
+	}
+	i.channels[key] = channel
+}
+
+// subscription returns the provisioned subscription, or nil if the
+// subscription is not provisioned.
+func (i *monitorIndex) subscription(key subscriptionKey) *channelsv1alpha1.Subscription {
+	if subscription, ok := i.subscriptions[key]; ok || i.base == nil {
+		return subscription
+	}
+	return i.base.subscriptions[key]
+}
+
+// setSubscription records the subscription as provisioned, or as not
+// provisioned if it is nil.
+func (i *monitorIndex) setSubscription(key subscriptionKey, subscription *channelsv1alpha1.Subscription) {
+	if subscription == nil && i.base == nil {
+		delete(i.subscriptions, key)
+		return
+	}
+	i.subscriptions[key] = subscription
+}
+
+// summary returns the summary for the channel, or nil if the channel has not
+// been seen.
+func (i *monitorIndex) summary(key channelKey) *channelSummary {
+	if summary, ok := i.summaries[key]; ok || i.base == nil {
+		return summary
+	}
+	return i.base.summaries[key]
+}
+
+// updateSummary replaces the summary for the channel with a copy changed by
+// update. A summary is created if the channel has not been seen.
+func (i *monitorIndex) updateSummary(key channelKey, update func(summary *channelSummary)) {
+	summary := &channelSummary{
+		Subscriptions: make(map[subscriptionKey]subscriptionSummary),
+	}
+	if old := i.summary(key); old != nil {
+		summary.Channel = old.Channel
+		for subscriptionKey, subscription := range old.Subscriptions {
+			summary.Subscriptions[subscriptionKey] = subscription
+		}
+	}
+	update(summary)
+
+	summary.specs = make([]channelsv1alpha1.SubscriptionSpec, 0, len(summary.Subscriptions))
+	for _, subscription := range summary.Subscriptions {
+		summary.specs = append(summary.specs, subscription.Subscription)
+	}
+	i.summaries[key] = summary
+}
+
+// index returns the Monitor's current index. The index must not be modified.
+func (m *Monitor) index() *monitorIndex {
+	return m.currentIndex.Load().(*monitorIndex)
+}
+
+// updateIndex publishes a copy of the current index changed by update. Updates
+// are serialized so concurrent workers do not lose each other's changes.
+func (m *Monitor) updateIndex(update func(index *monitorIndex)) {
+	m.indexMutex.Lock()
+	defer m.indexMutex.Unlock()
+
+	index := m.index().clone()
+	update(index)
+	m.currentIndex.Store(index)
+}
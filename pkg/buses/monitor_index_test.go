@@ -0,0 +1,227 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const indexNamespace = "index-namespace"
+
+// newIndexedMonitor returns a Monitor whose index holds the given number of
+// provisioned channels, each with the given number of subscriptions.
+func newIndexedMonitor(channels, subscriptions int) *Monitor {
+	m := &Monitor{indexMutex: &sync.Mutex{}}
+	m.currentIndex.Store(newMonitorIndex())
+	m.updateIndex(func(index *monitorIndex) {
+		for c := 0; c < channels; c++ {
+			channel := &channelsv1alpha1.Channel{
+				ObjectMeta: metav1.ObjectMeta{Namespace: indexNamespace, Name: fmt.Sprintf("channel-%d", c)},
+			}
+			key := makeChannelKeyFromChannel(channel)
+			index.setChannel(key, channel)
+			index.updateSummary(key, func(summary *channelSummary) {
+				summary.Channel = &channel.Spec
+				for s := 0; s < subscriptions; s++ {
+					name := fmt.Sprintf("%s-subscription-%d", channel.Name, s)
+					summary.Subscriptions[makeSubscriptionKeyWithNames(indexNamespace, name)] = subscriptionSummary{
+						Subscription: channelsv1alpha1.SubscriptionSpec{Channel: channel.Name, Subscriber: name},
+					}
+				}
+			})
+		}
+	})
+	return m
+}
+
+func TestMonitorSubscriptions(t *testing.T) {
+	m := newIndexedMonitor(2, 3)
+
+	subscriptions := m.Subscriptions("channel-1", indexNamespace)
+	if subscriptions == nil || len(*subscriptions) != 3 {
+		t.Fatalf("Unexpected subscriptions. want 3, got %v", subscriptions)
+	}
+	if got := m.Subscriptions("channel-2", indexNamespace); got != nil {
+		t.Errorf("Unexpected subscriptions for an unknown channel: %v", got)
+	}
+
+	// a channel that has been seen but is not provisioned has no subscriptions
+	m.updateIndex(func(index *monitorIndex) {
+		index.setChannel(makeChannelKeyWithNames(indexNamespace, "channel-1"), nil)
+	})
+	if got := m.Subscriptions("channel-1", indexNamespace); got != nil {
+		t.Errorf("Unexpected subscriptions for an unprovisioned channel: %v", got)
+	}
+}
+
+func TestMonitorIndexCopyOnWrite(t *testing.T) {
+	m := newIndexedMonitor(1, 1)
+	key := makeChannelKeyWithNames(indexNamespace, "channel-0")
+
+	before := m.index()
+	m.updateIndex(func(index *monitorIndex) {
+		index.updateSummary(key, func(summary *channelSummary) {
+			summary.Subscriptions[makeSubscriptionKeyWithNames(indexNamespace, "added")] = subscriptionSummary{}
+		})
+		index.setChannel(key, nil)
+	})
+
+	if before.channel(key) == nil {
+		t.Errorf("Expected the earlier snapshot to keep the channel")
+	}
+	if got := len(before.summary(key).specs); got != 1 {
+		t.Errorf("Unexpected subscriptions in the earlier snapshot. want 1, got %d", got)
+	}
+	if got := len(m.index().summary(key).specs); got != 2 {
+		t.Errorf("Unexpected subscriptions in the current snapshot. want 2, got %d", got)
+	}
+}
+
+func TestMonitorIndexFold(t *testing.T) {
+	m := newIndexedMonitor(100, 0)
+
+	// each write adds to the changes held on top of the base until they are
+	// folded into a new base
+	for c := 0; c < 100; c++ {
+		key := makeChannelKeyWithNames(indexNamespace, fmt.Sprintf("channel-%d", c))
+		m.updateIndex(func(index *monitorIndex) {
+			index.setChannel(key, nil)
+		})
+		if index := m.index(); index.base != nil && index.changes()*index.changes() > 2*index.base.changes()+1 {
+			t.Fatalf("Unexpected number of changes over a base of %d entries: %d", index.base.changes(), index.changes())
+		}
+		if m.Channel(fmt.Sprintf("channel-%d", c), indexNamespace) != nil {
+			t.Fatalf("Expected channel-%d to be removed", c)
+		}
+		if c < 99 && m.Channel(fmt.Sprintf("channel-%d", c+1), indexNamespace) == nil {
+			t.Fatalf("Expected channel-%d to be kept", c+1)
+		}
+	}
+
+	folded := m.index().fold()
+	if got := len(folded.channels); got != 0 {
+		t.Errorf("Unexpected channels after folding. want 0, got %d", got)
+	}
+	if got := len(folded.summaries); got != 100 {
+		t.Errorf("Unexpected summaries after folding. want 100, got %d", got)
+	}
+}
+
+func TestMonitorIndexConcurrentAccess(t *testing.T) {
+	m := newIndexedMonitor(10, 1)
+	key := makeChannelKeyWithNames(indexNamespace, "channel-0")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if subscriptions := m.Subscriptions("channel-0", indexNamespace); subscriptions != nil {
+					for range *subscriptions {
+					}
+				}
+				m.Channel("channel-0", indexNamespace)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		m.updateIndex(func(index *monitorIndex) {
+			index.updateSummary(key, func(summary *channelSummary) {
+				summary.Subscriptions[makeSubscriptionKeyWithNames(indexNamespace, fmt.Sprintf("s-%d", i))] = subscriptionSummary{}
+			})
+		})
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := len(*m.Subscriptions("channel-0", indexNamespace)); got != 101 {
+		t.Errorf("Unexpected subscriptions. want 101, got %d", got)
+	}
+}
+
+// BenchmarkMonitorSubscriptions measures the lookup made for each message
+// dispatched on a busy bus.
+func BenchmarkMonitorSubscriptions(b *testing.B) {
+	for _, channels := range []int{10, 5000} {
+		b.Run(fmt.Sprintf("channels=%d", channels), func(b *testing.B) {
+			m := newIndexedMonitor(channels, 5)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if m.Subscriptions("channel-7", indexNamespace) == nil {
+						b.Fatal("Expected subscriptions")
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMonitorSync measures adding the Subscriptions of a bus one at a
+// time, as a Monitor does while it syncs.
+func BenchmarkMonitorSync(b *testing.B) {
+	for _, subscriptions := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("subscriptions=%d", subscriptions), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				m := newIndexedMonitor(100, 0)
+				for s := 0; s < subscriptions; s++ {
+					channelKey := makeChannelKeyWithNames(indexNamespace, fmt.Sprintf("channel-%d", s%100))
+					subscription := &channelsv1alpha1.Subscription{
+						ObjectMeta: metav1.ObjectMeta{Namespace: indexNamespace, Name: fmt.Sprintf("subscription-%d", s)},
+					}
+					subscriptionKey := makeSubscriptionKeyFromSubscription(subscription)
+					m.updateIndex(func(index *monitorIndex) {
+						index.updateSummary(channelKey, func(summary *channelSummary) {
+							summary.Subscriptions[subscriptionKey] = subscriptionSummary{Subscription: subscription.Spec}
+						})
+					})
+					m.updateIndex(func(index *monitorIndex) {
+						index.setSubscription(subscriptionKey, subscription)
+					})
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMonitorChannel measures the lookup made for each message received.
+func BenchmarkMonitorChannel(b *testing.B) {
+	m := newIndexedMonitor(5000, 5)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if m.Channel("channel-7", indexNamespace) == nil {
+				b.Fatal("Expected channel")
+			}
+		}
+	})
+}
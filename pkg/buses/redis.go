@@ -0,0 +1,210 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// redisTimeout bounds connecting to Redis, waiting for a connection and
+	// each command.
+	redisTimeout = 5 * time.Second
+
+	// redisMaxConns bounds the connections a client opens to Redis.
+	redisMaxConns = 8
+)
+
+var errRedisPoolExhausted = errors.New("timed out waiting for a redis connection")
+
+// RedisOptions configures how a RedisClient connects to the server.
+type RedisOptions struct {
+	// Password authenticates each connection with AUTH when set.
+	Password string
+
+	// TLSConfig makes connections use TLS when set. The server name defaults
+	// to the host of the address.
+	TLSConfig *tls.Config
+}
+
+// redisClient is a minimal RedisClient speaking the Redis protocol over a
+// small pool of connections. A connection is closed after an error, and
+// dialed again when needed.
+type redisClient struct {
+	address string
+	options RedisOptions
+	// idle holds the connections waiting for a command.
+	idle chan *redisConn
+	// active holds a token for each connection running a command.
+	active chan struct{}
+}
+
+// NewRedisClient creates a RedisClient for the Redis server at the address,
+// as host:port. It connects on first use.
+func NewRedisClient(address string, options RedisOptions) RedisClient {
+	return &redisClient{
+		address: address,
+		options: options,
+		idle:    make(chan *redisConn, redisMaxConns),
+		active:  make(chan struct{}, redisMaxConns),
+	}
+}
+
+func (c *redisClient) SetNX(key string, value string, expiration time.Duration) (bool, error) {
+	ms := int64(expiration / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	reply, err := c.do("SET", key, value, "NX", "PX", strconv.FormatInt(ms, 10))
+	if err != nil {
+		return false, err
+	}
+	// SET NX replies OK when the key is set and nil when it already exists
+	return reply != nil, nil
+}
+
+func (c *redisClient) Del(key string) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+// do sends a command on a pooled connection and returns its reply, nil for a
+// nil reply.
+func (c *redisClient) do(args ...string) (*string, error) {
+	timer := time.NewTimer(redisTimeout)
+	defer timer.Stop()
+	select {
+	case c.active <- struct{}{}:
+	case <-timer.C:
+		return nil, errRedisPoolExhausted
+	}
+	defer func() { <-c.active }()
+
+	var conn *redisConn
+	select {
+	case conn = <-c.idle:
+	default:
+		var err error
+		if conn, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := conn.roundTrip(args)
+	if err != nil {
+		if _, ok := err.(redisError); !ok {
+			// the connection may be out of step with the server
+			conn.Close()
+			return nil, err
+		}
+	}
+	// there are never more idle connections than the pool holds
+	c.idle <- conn
+	return reply, err
+}
+
+// dial opens a connection, authenticated if the client has a password.
+func (c *redisClient) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if c.options.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.options.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{Conn: conn, reader: bufio.NewReader(conn)}
+	if len(c.options.Password) > 0 {
+		if _, err := rc.roundTrip([]string{"AUTH", c.options.Password}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to authenticate to redis: %v", err)
+		}
+	}
+	return rc, nil
+}
+
+// redisConn is a connection to Redis and the reader of its replies.
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *redisConn) roundTrip(args []string) (*string, error) {
+	if err := c.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+	var command bytes.Buffer
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.Write(command.Bytes()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply reads a simple string, error, integer or bulk string reply.
+func (c *redisConn) readReply() (*string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+', ':':
+		return &value, nil
+	case '-':
+		return nil, redisError(value)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		bulk := string(data[:n])
+		return &bulk, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
+
+// redisError is an error reply from the server. The connection remains usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
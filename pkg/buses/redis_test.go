@@ -0,0 +1,338 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedisServer answers AUTH, SET NX and DEL commands from an in-memory map
+// and records the commands it receives.
+type fakeRedisServer struct {
+	listener net.Listener
+	password string
+	keys     map[string]string
+	commands [][]string
+	conns    int
+	mutex    sync.Mutex
+}
+
+func newFakeRedisServer(t *testing.T) *fakeRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error listening: %v", err)
+	}
+	return startFakeRedisServer(listener)
+}
+
+func startFakeRedisServer(listener net.Listener) *fakeRedisServer {
+	s := &fakeRedisServer{listener: listener, keys: map[string]string{}}
+	go s.serve()
+	return s
+}
+
+func (s *fakeRedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns++
+		s.mutex.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *fakeRedisServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := false
+	for {
+		args, err := readRedisCommand(reader)
+		if err != nil {
+			return
+		}
+		if args[0] == "AUTH" {
+			authenticated = len(args) == 2 && args[1] == s.password
+			if !authenticated {
+				io.WriteString(conn, "-ERR invalid password\r\n")
+				continue
+			}
+			io.WriteString(conn, "+OK\r\n")
+			continue
+		}
+		if len(s.password) > 0 && !authenticated {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		io.WriteString(conn, s.reply(args))
+	}
+}
+
+func (s *fakeRedisServer) reply(args []string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.commands = append(s.commands, args)
+	switch args[0] {
+	case "SET":
+		if _, ok := s.keys[args[1]]; ok {
+			return "$-1\r\n"
+		}
+		s.keys[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		if _, ok := s.keys[args[1]]; ok {
+			delete(s.keys, args[1])
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func (s *fakeRedisServer) connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.conns
+}
+
+func (s *fakeRedisServer) lastCommand() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.commands[len(s.commands)-1]
+}
+
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	n, err := readRedisLength(reader, "*")
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		size, err := readRedisLength(reader, "$")
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func readRedisLength(reader *bufio.Reader, prefix string) (int, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, prefix), "\r\n"))
+}
+
+func TestRedisClient(t *testing.T) {
+	server := newFakeRedisServer(t)
+	defer server.listener.Close()
+	client := NewRedisClient(server.listener.Addr().String(), RedisOptions{})
+
+	set, err := client.SetNX("a", "1", 2*time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !set {
+		t.Errorf("Expected a new key to be set")
+	}
+	want := []string{"SET", "a", "1", "NX", "PX", "120000"}
+	if got := server.lastCommand(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected command. want %q, got %q", want, got)
+	}
+
+	if set, _ := client.SetNX("a", "1", time.Minute); set {
+		t.Errorf("Expected an existing key not to be set")
+	}
+
+	if err := client.Del("a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if set, _ := client.SetNX("a", "1", time.Minute); !set {
+		t.Errorf("Expected a deleted key to be set")
+	}
+}
+
+func TestRedisClientErrorReply(t *testing.T) {
+	server := newFakeRedisServer(t)
+	defer server.listener.Close()
+	client := NewRedisClient(server.listener.Addr().String(), RedisOptions{}).(*redisClient)
+
+	if _, err := client.do("PING"); err == nil || err.Error() != "ERR unknown command 'PING'" {
+		t.Errorf("Unexpected error. want the error reply, got %v", err)
+	}
+	// the connection is kept after an error reply
+	if set, err := client.SetNX("a", "1", time.Minute); err != nil || !set {
+		t.Errorf("Unexpected result after an error reply. want true, got %v, %v", set, err)
+	}
+}
+
+func TestRedisClientPool(t *testing.T) {
+	server := newFakeRedisServer(t)
+	defer server.listener.Close()
+	client := NewRedisClient(server.listener.Addr().String(), RedisOptions{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4*redisMaxConns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if set, err := client.SetNX(strconv.Itoa(i), "1", time.Minute); err != nil || !set {
+				t.Errorf("Unexpected result setting %d. want true, got %v, %v", i, set, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	conns := server.connections()
+	if conns > redisMaxConns {
+		t.Errorf("Unexpected connections. want at most %d, got %d", redisMaxConns, conns)
+	}
+
+	// idle connections are reused
+	for i := 0; i < redisMaxConns; i++ {
+		client.Del(strconv.Itoa(i))
+	}
+	if got := server.connections(); got != conns {
+		t.Errorf("Unexpected connections after reuse. want %d, got %d", conns, got)
+	}
+}
+
+func TestRedisClientPassword(t *testing.T) {
+	server := newFakeRedisServer(t)
+	defer server.listener.Close()
+	server.password = "s3cr3t"
+
+	client := NewRedisClient(server.listener.Addr().String(), RedisOptions{Password: "s3cr3t"})
+	if set, err := client.SetNX("a", "1", time.Minute); err != nil || !set {
+		t.Errorf("Unexpected result with the password. want true, got %v, %v", set, err)
+	}
+
+	client = NewRedisClient(server.listener.Addr().String(), RedisOptions{Password: "wrong"})
+	if _, err := client.SetNX("b", "1", time.Minute); err == nil {
+		t.Errorf("Expected an error with the wrong password")
+	}
+}
+
+func TestRedisClientTLS(t *testing.T) {
+	// the test server's certificate is valid for 127.0.0.1
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certServer.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certServer.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("Unexpected error listening: %v", err)
+	}
+	server := startFakeRedisServer(listener)
+	defer server.listener.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(certServer.Certificate())
+
+	client := NewRedisClient(listener.Addr().String(), RedisOptions{TLSConfig: &tls.Config{RootCAs: roots}})
+	if set, err := client.SetNX("a", "1", time.Minute); err != nil || !set {
+		t.Errorf("Unexpected result over TLS. want true, got %v, %v", set, err)
+	}
+}
+
+func TestRedisClientUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error listening: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := NewRedisClient(address, RedisOptions{})
+	if _, err := client.SetNX("a", "1", time.Minute); err == nil {
+		t.Errorf("Expected an error from an unavailable server")
+	}
+}
+
+func TestNewDedupeStoreFromEnv(t *testing.T) {
+	defer os.Unsetenv(EnvDedupeRedisAddress)
+	defer os.Unsetenv(EnvDedupeRedisTLS)
+	for _, test := range []struct {
+		name    string
+		address string
+		tls     string
+		want    reflect.Type
+		wantErr bool
+	}{
+		{
+			name: "in-memory",
+			want: reflect.TypeOf(&lruDedupeStore{}),
+		},
+		{
+			name:    "redis",
+			address: "redis.default:6379",
+			want:    reflect.TypeOf(&redisDedupeStore{}),
+		},
+		{
+			name:    "redis with tls",
+			address: "redis.default:6379",
+			tls:     "true",
+			want:    reflect.TypeOf(&redisDedupeStore{}),
+		},
+		{
+			name:    "invalid address",
+			address: "redis.default",
+			wantErr: true,
+		},
+		{
+			name:    "invalid tls",
+			address: "redis.default:6379",
+			tls:     "yes please",
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv(EnvDedupeRedisAddress, test.address)
+			os.Setenv(EnvDedupeRedisTLS, test.tls)
+			got, err := NewDedupeStoreFromEnv()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error. want error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+			if reflect.TypeOf(got) != test.want {
+				t.Errorf("Unexpected dedupe store. want %v, got %T", test.want, got)
+			}
+		})
+	}
+}
@@ -16,17 +16,62 @@
 
 package buses
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// EnvBusNamespace is the environment variable holding the namespace of the
+	// Bus, it is empty for a ClusterBus.
+	EnvBusNamespace = "BUS_NAMESPACE"
+
+	// EnvBusName is the environment variable holding the name of the Bus or
+	// ClusterBus.
+	EnvBusName = "BUS_NAME"
+
+	// EnvBusRole is the environment variable holding the role of the bus
+	// component, either Dispatcher or Provisioner.
+	EnvBusRole = "BUS_ROLE"
+)
 
 type BusReference struct {
 	Name      string
 	Namespace string
 }
 
+// NewBusReferenceFromEnv creates a reference to the Bus or ClusterBus named by
+// the environment of the bus component.
+func NewBusReferenceFromEnv() *BusReference {
+	return &BusReference{
+		Namespace: os.Getenv(EnvBusNamespace),
+		Name:      os.Getenv(EnvBusName),
+	}
+}
+
 func (r *BusReference) String() string {
 	return fmt.Sprintf("%s/%s", r.Namespace, r.Name)
 }
 
+// busConfigMap returns the namespace and name of a ConfigMap holding state of
+// the bus' components. It is in the POD_NAMESPACE namespace, or the Bus'
+// namespace, and named `<bus|clusterbus>-<bus name>-<suffix>`.
+func busConfigMap(bus *BusReference, suffix string) (string, string, error) {
+	namespace := os.Getenv(EnvPodNamespace)
+	if len(namespace) == 0 {
+		namespace = bus.Namespace
+	}
+	if len(namespace) == 0 {
+		return "", "", fmt.Errorf("%s must be set for a ClusterBus", EnvPodNamespace)
+	}
+
+	kind := "bus"
+	if len(bus.Namespace) == 0 {
+		kind = "clusterbus"
+	}
+	return namespace, fmt.Sprintf("%s-%s-%s", kind, bus.Name, suffix), nil
+}
+
 type ChannelReference struct {
 	Name      string
 	Namespace string
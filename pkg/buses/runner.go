@@ -0,0 +1,526 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/signals"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	threadsPerMonitor = 2
+
+	// dispatchAttempts is how many times delivery of a message to a
+	// subscriber is attempted before the Bus is asked to redeliver it.
+	dispatchAttempts = 3
+	// dispatchBackoff is the delay before the first retry of a delivery, it
+	// doubles with each attempt.
+	dispatchBackoff = 100 * time.Millisecond
+
+	// subscribeBackoff is the delay before a failed subscription is set up
+	// again, it doubles with each failure up to maxSubscribeBackoff.
+	subscribeBackoff    = time.Second
+	maxSubscribeBackoff = time.Minute
+
+	// deliveryTolerance is how long Dispatch waits for a message that is
+	// almost due rather than scheduling it.
+	deliveryTolerance = time.Second
+)
+
+// ErrSubscriptionClosed is returned by Subscriber.Dispatch when the
+// subscription is closed before the message is delivered. The Bus should leave
+// the message for redelivery.
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+var (
+	messagesPublished = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "knative",
+			Subsystem: "bus",
+			Name:      "messages_published_total",
+			Help:      "Number of messages received from publishers and sent to the bus.",
+		},
+		[]string{"namespace", "channel", "result"},
+	)
+	messagesDispatched = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "knative",
+			Subsystem: "bus",
+			Name:      "messages_dispatched_total",
+			Help:      "Number of messages dispatched from the bus to subscribers.",
+		},
+		[]string{"namespace", "channel", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(messagesPublished, messagesDispatched)
+}
+
+// Run runs a bus component for the Bus or ClusterBus named by the BUS_NAME and
+// BUS_NAMESPACE environment variables, in the role named by BUS_ROLE. This
+// function will block until the process receives a shutdown signal.
+//
+// The dispatcher role receives messages from publishers and passes them to
+// the Bus' Publish method, and delivers the messages of each Subscription the
+// Bus is subscribed to. The provisioner role provisions Channels, and
+// Subscriptions if the Bus implements SubscriptionProvisioner, and collects
+// orphaned Channel resources if the Bus implements ChannelResourceCollector.
+// Provisioner replicas elect a leader, only the leader provisions. Dispatcher
+// replicas shard the Subscriptions between them if the Bus implements
+// SubscriptionSharder.
+func Run(bus Bus) {
+	defer glog.Flush()
+
+	var masterURL, kubeconfig string
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.Parse()
+
+	// set up signals so we handle the first shutdown signal gracefully
+	stopCh := signals.SetupSignalHandler()
+
+	ref := NewBusReferenceFromEnv()
+	role := os.Getenv(EnvBusRole)
+	if role != Dispatcher && role != Provisioner {
+		glog.Fatalf("Unknown bus role %q, %s must be %q or %q", role, EnvBusRole, Dispatcher, Provisioner)
+	}
+
+	r := NewRunner(bus, role)
+	r.Start(stopCh)
+	if role == Dispatcher {
+		store, err := NewDedupeStoreFromEnv()
+		if err != nil {
+			glog.Fatalf("Error configuring deduplication: %s", err.Error())
+		}
+		r.SetDedupeStore(store)
+	}
+	component := fmt.Sprintf("%s-%s", ref.Name, role)
+	r.SetMonitor(NewMonitor(component, masterURL, kubeconfig, r.HandlerFuncs()))
+
+	if role == Provisioner {
+		// Provisioners may run several replicas, only the leader provisions.
+		config, err := NewLeaderElectionConfigFromEnv(ref, role)
+		if err != nil {
+			glog.Fatalf("Error configuring leader election: %s", err.Error())
+		}
+		r.monitor.SetLeaderElection(config)
+		if collector, ok := bus.(ChannelResourceCollector); ok {
+			gcConfig, err := NewGarbageCollectionConfigFromEnv(ref)
+			if err != nil {
+				glog.Fatalf("Error configuring garbage collection: %s", err.Error())
+			}
+			r.monitor.SetGarbageCollection(collector, gcConfig)
+		}
+		if err := r.monitor.Run(ref.Namespace, ref.Name, threadsPerMonitor, stopCh); err != nil {
+			glog.Fatalf("Error running monitor: %s", err.Error())
+		}
+		return
+	}
+
+	config, err := NewShardingConfigFromEnv(ref)
+	if err != nil {
+		glog.Fatalf("Error configuring sharding: %s", err.Error())
+	}
+	if config != nil {
+		r.SetSharding(*config)
+	}
+
+	go func() {
+		if err := r.monitor.Run(ref.Namespace, ref.Name, threadsPerMonitor, stopCh); err != nil {
+			glog.Fatalf("Error running monitor: %s", err.Error())
+		}
+	}()
+	r.monitor.WaitForCacheSync(stopCh)
+	r.Receiver().Run(stopCh)
+	r.Close()
+}
+
+// Runner connects a Bus to a Monitor, and in the dispatcher role to a
+// MessageReceiver and MessageDispatcher. Bus components use Run, a Runner is
+// used directly to drive a Bus in tests.
+type Runner struct {
+	bus     Bus
+	role    string
+	monitor *Monitor
+
+	receiver    *MessageReceiver
+	dispatcher  *MessageDispatcher
+	dedupeStore DedupeStore
+	// scheduler holds delayed messages if the Bus can't hold them itself
+	scheduler *memoryScheduler
+
+	subscriptions map[subscriptionKey]*runnerSubscription
+	mutex         *sync.Mutex
+}
+
+// NewRunner creates a Runner for the Bus in the role, either Dispatcher or
+// Provisioner. The Runner must be given a Monitor created with its handler
+// funcs before the Monitor is run.
+func NewRunner(bus Bus, role string) *Runner {
+	r := &Runner{
+		bus:           bus,
+		role:          role,
+		subscriptions: make(map[subscriptionKey]*runnerSubscription),
+		mutex:         &sync.Mutex{},
+	}
+	if role == Dispatcher {
+		r.receiver = NewMessageReceiver(r.publish)
+		r.dispatcher = NewMessageDispatcher()
+		r.dedupeStore = NewLRUDedupeStore(DefaultDedupeStoreSize)
+		r.scheduler = newMemoryScheduler(maxScheduledDeliveries)
+	}
+	return r
+}
+
+// Start starts delivering the messages held in memory for delayed delivery in
+// the dispatcher role, until stopCh is closed. Messages still held then are
+// dropped. Run starts the Runner it creates.
+func (r *Runner) Start(stopCh <-chan struct{}) {
+	if r.scheduler != nil {
+		go r.scheduler.run(stopCh)
+	}
+}
+
+// SetDedupeStore sets the store remembering the keys of received messages in
+// the dispatcher role, replacing the in-memory default. It must be called
+// before SetMonitor.
+func (r *Runner) SetDedupeStore(store DedupeStore) {
+	r.dedupeStore = store
+}
+
+// HandlerFuncs returns the Monitor handler funcs for the runner's role.
+func (r *Runner) HandlerFuncs() MonitorEventHandlerFuncs {
+	if r.role == Provisioner {
+		handler := MonitorEventHandlerFuncs{
+			ProvisionFunc:   r.bus.Provision,
+			UnprovisionFunc: r.bus.Unprovision,
+		}
+		if provisioner, ok := r.bus.(SubscriptionProvisioner); ok {
+			handler.SubscribeFunc = provisioner.ProvisionSubscription
+			handler.UnsubscribeFunc = provisioner.UnprovisionSubscription
+		}
+		return handler
+	}
+	return MonitorEventHandlerFuncs{
+		SubscribeFunc:   r.subscribe,
+		UnsubscribeFunc: r.unsubscribe,
+	}
+}
+
+// SetMonitor sets the Monitor that calls the runner's handler funcs. Channels
+// and their policies are looked up from the Monitor.
+func (r *Runner) SetMonitor(monitor *Monitor) {
+	r.monitor = monitor
+	if r.role == Dispatcher {
+		r.receiver.SetIngressAuthorizer(monitor)
+		r.receiver.SetEventValidator(monitor)
+		r.receiver.SetDeduplicator(monitor, r.dedupeStore)
+		r.dispatcher.SetExpiryPolicySource(monitor)
+	}
+}
+
+// SetSharding shares the Subscriptions between the dispatcher replicas if the
+// Bus implements SubscriptionSharder, each replica then dispatches a share of
+// the Subscriptions. It must be called after SetMonitor and before the Monitor
+// is run.
+func (r *Runner) SetSharding(config ShardingConfig) {
+	sharder, ok := r.bus.(SubscriptionSharder)
+	if !ok || !sharder.ShardsSubscriptions() {
+		glog.Infof("Not sharding subscriptions, every dispatcher replica subscribes to every subscription")
+		return
+	}
+	r.monitor.SetSharding(config)
+	sharder.SetSubscriptionOwner(r.monitor.OwnsSubscription)
+}
+
+// Receiver returns the MessageReceiver that publishes messages to the Bus in
+// the dispatcher role.
+func (r *Runner) Receiver() *MessageReceiver {
+	return r.receiver
+}
+
+// Close closes all subscriptions of the Bus.
+func (r *Runner) Close() {
+	glog.Info("Closing subscriptions")
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key, subscription := range r.subscriptions {
+		subscription.close()
+		delete(r.subscriptions, key)
+	}
+}
+
+// publish sends a message received for a channel to the Bus.
+func (r *Runner) publish(ref *ChannelReference, message *Message) error {
+	channel := r.monitor.Channel(ref.Name, ref.Namespace)
+	if channel == nil {
+		return ErrUnknownChannel
+	}
+	if err := checkDeliverAt(r.bus, message); err != nil {
+		return err
+	}
+	err := r.bus.Publish(channel, message)
+	if err != nil {
+		glog.Warningf("Unable to publish message for channel %q: %v", ref, err)
+		messagesPublished.WithLabelValues(ref.Namespace, ref.Name, "error").Inc()
+		return err
+	}
+	messagesPublished.WithLabelValues(ref.Namespace, ref.Name, "success").Inc()
+	return nil
+}
+
+// subscribe subscribes the Bus to the subscription, replacing any existing
+// subscription with the same name.
+func (r *Runner) subscribe(subscription *channelsv1alpha1.Subscription, parameters ResolvedParameters) error {
+	r.unsubscribe(subscription)
+	if err := checkDeliveryDelay(r.bus, subscription.Spec); err != nil {
+		return err
+	}
+
+	s := &runnerSubscription{
+		runner:       r,
+		subscription: subscription,
+		parameters:   parameters,
+		channel: &ChannelReference{
+			Namespace: subscription.Namespace,
+			Name:      subscription.Spec.Channel,
+		},
+		stopCh: make(chan struct{}),
+		mutex:  &sync.Mutex{},
+	}
+	if policy := BatchingPolicyForSubscription(subscription.Spec); policy != nil {
+		s.batcher = newBatcher(*policy, s.dispatchBatch)
+	}
+	if err := s.start(); err != nil {
+		close(s.stopCh)
+		return err
+	}
+
+	r.mutex.Lock()
+	r.subscriptions[makeSubscriptionKeyFromSubscription(subscription)] = s
+	r.mutex.Unlock()
+	return nil
+}
+
+// unsubscribe closes the subscription's handle, if the Bus is subscribed.
+func (r *Runner) unsubscribe(subscription *channelsv1alpha1.Subscription) error {
+	key := makeSubscriptionKeyFromSubscription(subscription)
+	r.mutex.Lock()
+	s, ok := r.subscriptions[key]
+	delete(r.subscriptions, key)
+	r.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return s.close()
+}
+
+// dispatchScheduled dispatches a message scheduled in memory to the current
+// subscription for the key. The message is dropped if the subscription was
+// removed while the message was held.
+func (r *Runner) dispatchScheduled(key subscriptionKey, message *Message) {
+	r.mutex.Lock()
+	s, ok := r.subscriptions[key]
+	r.mutex.Unlock()
+
+	if !ok {
+		glog.Warningf("Dropping a delayed message for removed subscription %s/%s", key.Namespace, key.Name)
+		return
+	}
+	if err := s.Dispatch(message); err != nil {
+		glog.Warningf("Unable to dispatch a delayed message for channel %q: %v", s.channel, err)
+	}
+}
+
+// runnerSubscription is the Subscriber passed to the Bus for a subscription.
+type runnerSubscription struct {
+	runner       *Runner
+	subscription *channelsv1alpha1.Subscription
+	parameters   ResolvedParameters
+	channel      *ChannelReference
+	// batcher accumulates messages into batches if the subscription is
+	// batched, it is nil otherwise.
+	batcher *batcher
+
+	// stopCh is closed when the subscription is closed to release messages
+	// waiting for delivery.
+	stopCh   chan struct{}
+	handle   SubscriptionHandle
+	failures int
+	closed   bool
+	mutex    *sync.Mutex
+}
+
+func (s *runnerSubscription) start() error {
+	handle, err := s.runner.bus.Subscribe(s.subscription, s.parameters, s)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return handle.Close()
+	}
+	s.handle = handle
+	return nil
+}
+
+func (s *runnerSubscription) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stopCh)
+	if s.handle == nil {
+		return nil
+	}
+	return s.handle.Close()
+}
+
+// Dispatch delivers the message to the subscriber, retrying with a backoff.
+// The messages of a batched subscription are delivered in batches, Dispatch
+// blocks until the message's batch is delivered. Messages which are not
+// CloudEvents can not be batched and are delivered one at a time.
+//
+// A message that is not due yet is scheduled with the Bus if it implements
+// DeliveryScheduler, is held until it is due if the Bus implements
+// DeliveryDelayLimiter, and is otherwise scheduled in memory.
+func (s *runnerSubscription) Dispatch(message *Message) error {
+	at := DeliveryTime(message, s.subscription.Spec)
+	if time.Until(at) > deliveryTolerance {
+		if scheduler, ok := s.runner.bus.(DeliveryScheduler); ok {
+			return scheduler.ScheduleDelivery(s.subscription, message, at)
+		}
+		if _, ok := s.runner.bus.(DeliveryDelayLimiter); !ok {
+			key := makeSubscriptionKeyFromSubscription(s.subscription)
+			err := s.runner.scheduler.schedule(at, func() {
+				s.runner.dispatchScheduled(key, message)
+			})
+			if err != nil {
+				glog.Warningf("Dropping a delayed message for subscription %s/%s: %v", key.Namespace, key.Name, err)
+			}
+			return err
+		}
+	}
+	if !WaitForDelivery(at, s.stopCh) {
+		return ErrSubscriptionClosed
+	}
+	if s.batcher != nil {
+		if _, err := toEvent(message); err == nil {
+			return s.batcher.add(message)
+		}
+	}
+
+	return s.dispatch(1, func(subscriber string) error {
+		return s.runner.dispatcher.DispatchChannelMessage(s.channel, subscriber, s.subscription.Namespace, message)
+	})
+}
+
+// dispatchBatch delivers a batch of messages to the subscriber in one request,
+// retrying with a backoff.
+func (s *runnerSubscription) dispatchBatch(messages []*Message) error {
+	return s.dispatch(len(messages), func(subscriber string) error {
+		return s.runner.dispatcher.DispatchChannelBatch(s.channel, subscriber, s.subscription.Namespace, messages)
+	})
+}
+
+// dispatch sends a number of messages to the subscriber with the send func,
+// retrying with a backoff.
+func (s *runnerSubscription) dispatch(count int, send func(subscriber string) error) error {
+	subscriber := s.subscription.Spec.Subscriber
+	backoff := dispatchBackoff
+	for attempt := 1; ; attempt++ {
+		err := send(subscriber)
+		if err == nil {
+			messagesDispatched.WithLabelValues(s.channel.Namespace, s.channel.Name, "success").Add(float64(count))
+			return nil
+		}
+		if attempt == dispatchAttempts {
+			glog.Warningf("Unable to dispatch %d message(s) for channel %q to %q: %v", count, s.channel, subscriber, err)
+			messagesDispatched.WithLabelValues(s.channel.Namespace, s.channel.Name, "error").Add(float64(count))
+			return err
+		}
+		glog.Infof("Retrying dispatch for channel %q to %q after error: %v", s.channel, subscriber, err)
+		select {
+		case <-time.After(backoff):
+		case <-s.stopCh:
+			return ErrSubscriptionClosed
+		}
+		backoff *= 2
+	}
+}
+
+// Failed closes the Bus' handle for the subscription and subscribes again
+// after a backoff.
+func (s *runnerSubscription) Failed(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+	glog.Errorf("Subscription %s/%s failed: %v", s.subscription.Namespace, s.subscription.Name, err)
+	if s.handle != nil {
+		if err := s.handle.Close(); err != nil {
+			glog.Warningf("Unable to close failed subscription %s/%s: %v", s.subscription.Namespace, s.subscription.Name, err)
+		}
+		s.handle = nil
+	}
+	s.scheduleRestart()
+}
+
+// scheduleRestart subscribes again after a backoff. The mutex must be held.
+func (s *runnerSubscription) scheduleRestart() {
+	backoff := subscribeBackoff << uint(s.failures)
+	if backoff > maxSubscribeBackoff || backoff <= 0 {
+		backoff = maxSubscribeBackoff
+	} else {
+		s.failures++
+	}
+	time.AfterFunc(backoff, func() {
+		s.mutex.Lock()
+		closed := s.closed
+		s.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		if err := s.start(); err != nil {
+			glog.Errorf("Unable to subscribe %s/%s again: %v", s.subscription.Namespace, s.subscription.Name, err)
+			s.mutex.Lock()
+			if !s.closed {
+				s.scheduleRestart()
+			}
+			s.mutex.Unlock()
+		}
+	})
+}
@@ -0,0 +1,320 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeBus records the subscribers it is given.
+type fakeBus struct {
+	subscribers  []Subscriber
+	closed       int
+	subscribeErr error
+	mutex        sync.Mutex
+}
+
+func (b *fakeBus) Provision(*channelsv1alpha1.Channel, ResolvedParameters) error { return nil }
+func (b *fakeBus) Unprovision(*channelsv1alpha1.Channel) error                   { return nil }
+func (b *fakeBus) Publish(*channelsv1alpha1.Channel, *Message) error             { return nil }
+
+func (b *fakeBus) Subscribe(subscription *channelsv1alpha1.Subscription, parameters ResolvedParameters, subscriber Subscriber) (SubscriptionHandle, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribeErr != nil {
+		return nil, b.subscribeErr
+	}
+	b.subscribers = append(b.subscribers, subscriber)
+	return SubscriptionHandleFunc(func() error {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		b.closed++
+		return nil
+	}), nil
+}
+
+func (b *fakeBus) counts() (int, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers), b.closed
+}
+
+// limitingBus holds delayed messages in Dispatch, up to an hour.
+type limitingBus struct {
+	fakeBus
+}
+
+func (b *limitingBus) MaxDeliveryDelay() time.Duration { return time.Hour }
+
+// schedulingBus records the messages it is asked to schedule.
+type schedulingBus struct {
+	fakeBus
+	scheduled []time.Time
+}
+
+func (b *schedulingBus) ScheduleDelivery(subscription *channelsv1alpha1.Subscription, message *Message, at time.Time) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.scheduled = append(b.scheduled, at)
+	return nil
+}
+
+// shardingBus shares its Subscriptions between dispatcher replicas if shards
+// is set, and records the func telling which it owns.
+type shardingBus struct {
+	fakeBus
+	shards bool
+	owns   func(namespace, name string) bool
+}
+
+func (b *shardingBus) ShardsSubscriptions() bool { return b.shards }
+
+func (b *shardingBus) SetSubscriptionOwner(owns func(namespace, name string) bool) {
+	b.owns = owns
+}
+
+func testSubscription(subscriber string) *channelsv1alpha1.Subscription {
+	return &channelsv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "subscription"},
+		Spec: channelsv1alpha1.SubscriptionSpec{
+			Channel:    "channel",
+			Subscriber: subscriber,
+		},
+	}
+}
+
+func TestRunnerDispatchRetries(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		failures int
+		wantErr  bool
+		wantReqs int
+	}{
+		{name: "success", failures: 0, wantReqs: 1},
+		{name: "retried", failures: dispatchAttempts - 1, wantReqs: dispatchAttempts},
+		{name: "exhausted", failures: dispatchAttempts, wantErr: true, wantReqs: dispatchAttempts},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				requests++
+				if requests <= test.failures {
+					res.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			bus := &fakeBus{}
+			r := NewRunner(bus, Dispatcher)
+			if err := r.subscribe(testSubscription(server.URL), nil); err != nil {
+				t.Fatalf("Unexpected subscribe error: %v", err)
+			}
+
+			err := bus.subscribers[0].Dispatch(&Message{})
+			if (err != nil) != test.wantErr {
+				t.Errorf("Unexpected dispatch error. want error %v, got %v", test.wantErr, err)
+			}
+			if requests != test.wantReqs {
+				t.Errorf("Unexpected number of requests. want %d, got %d", test.wantReqs, requests)
+			}
+		})
+	}
+}
+
+func TestRunnerUnsubscribe(t *testing.T) {
+	bus := &limitingBus{}
+	r := NewRunner(bus, Dispatcher)
+	subscription := testSubscription("subscriber")
+	subscription.Spec.DeliveryDelay = &metav1.Duration{Duration: time.Hour}
+
+	if err := r.subscribe(subscription, nil); err != nil {
+		t.Fatalf("Unexpected subscribe error: %v", err)
+	}
+	// subscribing again replaces the existing subscription
+	if err := r.subscribe(subscription, nil); err != nil {
+		t.Fatalf("Unexpected subscribe error: %v", err)
+	}
+	if subscribed, closed := bus.counts(); subscribed != 2 || closed != 1 {
+		t.Errorf("Unexpected subscriptions. want 2 subscribed and 1 closed, got %d and %d", subscribed, closed)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- bus.subscribers[1].Dispatch(&Message{})
+	}()
+	r.unsubscribe(subscription)
+
+	select {
+	case err := <-done:
+		if err != ErrSubscriptionClosed {
+			t.Errorf("Unexpected dispatch error. want %v, got %v", ErrSubscriptionClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the delayed dispatch to be released")
+	}
+	if _, closed := bus.counts(); closed != 2 {
+		t.Errorf("Unexpected closed subscriptions. want 2, got %d", closed)
+	}
+}
+
+func TestRunnerDispatchDelayed(t *testing.T) {
+	requests := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests <- struct{}{}
+	}))
+	defer server.Close()
+
+	deliverAt := func(d time.Duration) *Message {
+		return &Message{Headers: map[string]string{
+			HeaderDeliverAt: time.Now().Add(d).Format(time.RFC3339Nano),
+		}}
+	}
+
+	t.Run("bus scheduler", func(t *testing.T) {
+		bus := &schedulingBus{}
+		r := NewRunner(bus, Dispatcher)
+		defer r.Close()
+		if err := r.subscribe(testSubscription(server.URL), nil); err != nil {
+			t.Fatalf("Unexpected subscribe error: %v", err)
+		}
+		if err := bus.subscribers[0].Dispatch(deliverAt(time.Hour)); err != nil {
+			t.Fatalf("Unexpected dispatch error: %v", err)
+		}
+		if len(bus.scheduled) != 1 {
+			t.Errorf("Unexpected scheduled messages. want 1, got %d", len(bus.scheduled))
+		}
+		select {
+		case <-requests:
+			t.Errorf("Expected the scheduled message not to be delivered")
+		default:
+		}
+	})
+
+	t.Run("in memory", func(t *testing.T) {
+		bus := &fakeBus{}
+		r := NewRunner(bus, Dispatcher)
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		r.Start(stopCh)
+		defer r.Close()
+		if err := r.subscribe(testSubscription(server.URL), nil); err != nil {
+			t.Fatalf("Unexpected subscribe error: %v", err)
+		}
+		start := time.Now()
+		if err := bus.subscribers[0].Dispatch(deliverAt(deliveryTolerance + 200*time.Millisecond)); err != nil {
+			t.Fatalf("Unexpected dispatch error: %v", err)
+		}
+		if time.Since(start) > deliveryTolerance {
+			t.Errorf("Expected Dispatch to return before the message is due")
+		}
+		select {
+		case <-requests:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the delayed message")
+		}
+		if time.Since(start) < deliveryTolerance {
+			t.Errorf("Unexpected early delivery after %v", time.Since(start))
+		}
+	})
+}
+
+func TestRunnerDeliveryDelayLimit(t *testing.T) {
+	r := NewRunner(&limitingBus{}, Dispatcher)
+	defer r.Close()
+	subscription := testSubscription("subscriber")
+	subscription.Spec.DeliveryDelay = &metav1.Duration{Duration: 2 * time.Hour}
+	if err := r.subscribe(subscription, nil); err == nil {
+		t.Errorf("Expected a delay longer than the bus allows to be rejected")
+	}
+	if len(r.subscriptions) != 0 {
+		t.Errorf("Unexpected subscriptions after error: %v", r.subscriptions)
+	}
+}
+
+func TestRunnerSetSharding(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		bus  Bus
+		want bool
+	}{
+		{name: "local delivery", bus: &fakeBus{}, want: false},
+		{name: "sharder", bus: &shardingBus{shards: true}, want: true},
+		{name: "not sharding", bus: &shardingBus{}, want: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRunner(test.bus, Dispatcher)
+			r.monitor = &Monitor{kubeclientset: kubefake.NewSimpleClientset()}
+			r.SetSharding(ShardingConfig{Namespace: "knative-eventing", Service: "bus", Identity: "dispatcher-a"})
+			if got := r.monitor.sharder != nil; got != test.want {
+				t.Errorf("Unexpected sharding. want %v, got %v", test.want, got)
+			}
+			if bus, ok := test.bus.(*shardingBus); ok && (bus.owns != nil) != test.want {
+				t.Errorf("Unexpected subscription owner set. want %v, got %v", test.want, bus.owns != nil)
+			}
+		})
+	}
+}
+
+func TestRunnerSubscribeError(t *testing.T) {
+	bus := &fakeBus{subscribeErr: errors.New("no such topic")}
+	r := NewRunner(bus, Dispatcher)
+	if err := r.subscribe(testSubscription("subscriber"), nil); err != bus.subscribeErr {
+		t.Errorf("Unexpected subscribe error. want %v, got %v", bus.subscribeErr, err)
+	}
+	if len(r.subscriptions) != 0 {
+		t.Errorf("Unexpected subscriptions after error: %v", r.subscriptions)
+	}
+}
+
+func TestRunnerSubscriptionFailed(t *testing.T) {
+	bus := &fakeBus{}
+	r := NewRunner(bus, Dispatcher)
+	subscription := testSubscription("subscriber")
+	if err := r.subscribe(subscription, nil); err != nil {
+		t.Fatalf("Unexpected subscribe error: %v", err)
+	}
+
+	bus.subscribers[0].Failed(errors.New("connection lost"))
+	if _, closed := bus.counts(); closed != 1 {
+		t.Errorf("Expected the failed handle to be closed")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if subscribed, _ := bus.counts(); subscribed == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the subscription to be set up again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r.unsubscribe(subscription)
+	if _, closed := bus.counts(); closed != 2 {
+		t.Errorf("Unexpected closed subscriptions. want 2, got %d", closed)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// EnvDispatcherService is the environment variable holding the name of
+	// the bus dispatcher's Service. Its Endpoints list the dispatcher
+	// replicas Subscriptions are sharded between.
+	EnvDispatcherService = "BUS_DISPATCHER_SERVICE"
+
+	// virtualNodes is how many points each member has on the hash ring. More
+	// points spread Subscriptions more evenly between members.
+	virtualNodes = 100
+)
+
+// ShardingConfig configures sharding of Subscriptions between the replicas of
+// a bus dispatcher.
+type ShardingConfig struct {
+	// Namespace and Service identify the Endpoints listing the replicas.
+	Namespace string
+	Service   string
+
+	// Identity is the name of this replica's pod. Replicas are identified by
+	// the name of the pod an Endpoints address targets, or by its IP if it has
+	// no target.
+	Identity string
+}
+
+// NewShardingConfigFromEnv creates a configuration sharding Subscriptions
+// between the dispatcher replicas of the bus, or returns nil if the
+// dispatcher's Service is not known. Replicas are identified by the POD_NAME
+// environment variable, or the hostname, and listed by the Endpoints named by
+// BUS_DISPATCHER_SERVICE in the POD_NAMESPACE namespace, or the Bus'
+// namespace.
+func NewShardingConfigFromEnv(bus *BusReference) (*ShardingConfig, error) {
+	service := os.Getenv(EnvDispatcherService)
+	if len(service) == 0 {
+		return nil, nil
+	}
+
+	identity := os.Getenv(EnvPodName)
+	if len(identity) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("unable to identify the dispatcher replica: %v", err)
+		}
+		identity = hostname
+	}
+
+	namespace := os.Getenv(EnvPodNamespace)
+	if len(namespace) == 0 {
+		namespace = bus.Namespace
+	}
+	if len(namespace) == 0 {
+		return nil, fmt.Errorf("%s must be set for a ClusterBus", EnvPodNamespace)
+	}
+
+	return &ShardingConfig{
+		Namespace: namespace,
+		Service:   service,
+		Identity:  identity,
+	}, nil
+}
+
+// hashRing assigns keys to members by consistent hashing. When a member joins
+// or leaves, only the keys it gains or loses move.
+type hashRing struct {
+	members []string
+	points  []uint32
+	owners  map[uint32]string
+}
+
+func newHashRing(members []string) *hashRing {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+
+	ring := &hashRing{
+		members: sorted,
+		owners:  make(map[uint32]string, len(sorted)*virtualNodes),
+	}
+	for _, member := range sorted {
+		for i := 0; i < virtualNodes; i++ {
+			point := hashKey(member + "#" + strconv.Itoa(i))
+			if _, ok := ring.owners[point]; ok {
+				// the first member in order keeps a colliding point
+				continue
+			}
+			ring.owners[point] = member
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// owner returns the member the key is assigned to, or an empty string if the
+// ring has no members.
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hasMembers returns true if the ring is made of exactly the members.
+func (r *hashRing) hasMembers(members []string) bool {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+	if len(sorted) != len(r.members) {
+		return false
+	}
+	for i := range sorted {
+		if sorted[i] != r.members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hashKey hashes the key with FNV-1a, mixing the result so keys differing only
+// in their last characters spread around the ring.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// endpointsMembers returns the identities of the ready addresses of the
+// Endpoints. An address is listed in each subset of ports it serves, it is
+// only returned once.
+func endpointsMembers(endpoints *corev1.Endpoints) []string {
+	seen := make(map[string]bool)
+	members := []string{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			member := address.IP
+			if address.TargetRef != nil && len(address.TargetRef.Name) > 0 {
+				member = address.TargetRef.Name
+			}
+			if !seen[member] {
+				seen[member] = true
+				members = append(members, member)
+			}
+		}
+	}
+	return members
+}
+
+// sharder tracks the dispatcher replicas and decides which Subscriptions this
+// replica owns.
+type sharder struct {
+	config   ShardingConfig
+	informer cache.SharedIndexInformer
+	// ring holds the current *hashRing.
+	ring atomic.Value
+}
+
+func newSharder(client kubernetes.Interface, config ShardingConfig, onRebalance func()) *sharder {
+	s := &sharder{
+		config: config,
+		informer: coreinformers.NewFilteredEndpointsInformer(client, config.Namespace, 30*time.Second, cache.Indexers{},
+			func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", config.Service).String()
+			}),
+	}
+	s.ring.Store(newHashRing(nil))
+
+	update := func(obj interface{}) {
+		endpoints, ok := obj.(*corev1.Endpoints)
+		if !ok || endpoints.Name != config.Service {
+			return
+		}
+		if s.setMembers(endpointsMembers(endpoints)) {
+			onRebalance()
+		}
+	}
+	s.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(old, new interface{}) {
+			update(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if endpoints, ok := obj.(*corev1.Endpoints); ok && endpoints.Name == config.Service {
+				if s.setMembers(nil) {
+					onRebalance()
+				}
+			}
+		},
+	})
+	return s
+}
+
+// setMembers replaces the ring if the members changed, returning true if it
+// did.
+func (s *sharder) setMembers(members []string) bool {
+	if s.currentRing().hasMembers(members) {
+		return false
+	}
+	glog.Infof("Sharding subscriptions between dispatcher replicas %v", members)
+	s.ring.Store(newHashRing(members))
+	return true
+}
+
+func (s *sharder) currentRing() *hashRing {
+	return s.ring.Load().(*hashRing)
+}
+
+// owns returns true if this replica dispatches the Subscription. While no
+// replica is listed, as before the Endpoints are populated, this replica owns
+// every Subscription.
+func (s *sharder) owns(key subscriptionKey) bool {
+	ring := s.currentRing()
+	if len(ring.members) == 0 {
+		return true
+	}
+	return ring.owner(key.Namespace+"/"+key.Name) == s.config.Identity
+}
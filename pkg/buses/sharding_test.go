@@ -0,0 +1,183 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHashRingDistribution(t *testing.T) {
+	members := []string{"dispatcher-a", "dispatcher-b", "dispatcher-c"}
+	ring := newHashRing(members)
+
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		counts[ring.owner(fmt.Sprintf("default/subscription-%d", i))]++
+	}
+	for _, member := range members {
+		if counts[member] < 500 {
+			t.Errorf("Expected member %q to own a fair share of keys, owns %d of 3000", member, counts[member])
+		}
+	}
+	if len(counts) != len(members) {
+		t.Errorf("Expected keys to be owned by %v, got %v", members, counts)
+	}
+}
+
+func TestHashRingRebalance(t *testing.T) {
+	before := newHashRing([]string{"dispatcher-a", "dispatcher-b", "dispatcher-c"})
+	after := newHashRing([]string{"dispatcher-c", "dispatcher-a", "dispatcher-b", "dispatcher-d"})
+
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("default/subscription-%d", i)
+		if owner := after.owner(key); owner != before.owner(key) {
+			if owner != "dispatcher-d" {
+				t.Fatalf("Expected key %q to only move to the new member, moved to %q", key, owner)
+			}
+			moved++
+		}
+	}
+	// about a quarter of the keys should move to the new member
+	if moved < 500 || moved > 1500 {
+		t.Errorf("Expected about 1000 of 4000 keys to move, %d moved", moved)
+	}
+}
+
+func TestHashRingEmpty(t *testing.T) {
+	ring := newHashRing(nil)
+	if owner := ring.owner("default/subscription"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, got %q", owner)
+	}
+	if !ring.hasMembers([]string{}) {
+		t.Errorf("Expected empty ring to have no members")
+	}
+}
+
+func TestSharderOwnsAllWithoutMembers(t *testing.T) {
+	s := newSharder(fake.NewSimpleClientset(), ShardingConfig{
+		Namespace: "knative-eventing",
+		Service:   "stub-bus",
+		Identity:  "dispatcher-a",
+	}, func() {})
+
+	// the Endpoints list no replica until they are ready
+	for _, members := range [][]string{nil, {}} {
+		s.setMembers(members)
+		if !s.owns(subscriptionKey{Namespace: "default", Name: "subscription"}) {
+			t.Errorf("Expected the replica to own every subscription without members %v", members)
+		}
+	}
+
+	s.setMembers([]string{"dispatcher-b"})
+	if s.owns(subscriptionKey{Namespace: "default", Name: "subscription"}) {
+		t.Errorf("Expected the replica not to own subscriptions of another member")
+	}
+}
+
+func TestEndpointsMembers(t *testing.T) {
+	endpoints := &corev1.Endpoints{
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{IP: "10.0.0.1", TargetRef: &corev1.ObjectReference{Name: "dispatcher-a"}},
+					{IP: "10.0.0.2"},
+				},
+				NotReadyAddresses: []corev1.EndpointAddress{
+					{IP: "10.0.0.3", TargetRef: &corev1.ObjectReference{Name: "dispatcher-c"}},
+				},
+			},
+			{
+				Addresses: []corev1.EndpointAddress{
+					{IP: "10.0.0.1", TargetRef: &corev1.ObjectReference{Name: "dispatcher-a"}},
+				},
+			},
+		},
+	}
+
+	members := endpointsMembers(endpoints)
+	if !newHashRing(members).hasMembers([]string{"dispatcher-a", "10.0.0.2"}) {
+		t.Errorf("Expected members [dispatcher-a 10.0.0.2], got %v", members)
+	}
+}
+
+func TestSharderRebalancesOnScale(t *testing.T) {
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "knative-eventing", Name: "stub-bus"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{
+				{IP: "10.0.0.1", TargetRef: &corev1.ObjectReference{Name: "dispatcher-a"}},
+			},
+		}},
+	}
+	client := fake.NewSimpleClientset(endpoints)
+
+	rebalanced := make(chan struct{}, 10)
+	s := newSharder(client, ShardingConfig{
+		Namespace: "knative-eventing",
+		Service:   "stub-bus",
+		Identity:  "dispatcher-a",
+	}, func() {
+		rebalanced <- struct{}{}
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go s.informer.Run(stopCh)
+
+	awaitRebalance := func() {
+		t.Helper()
+		select {
+		case <-rebalanced:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for subscriptions to be rebalanced")
+		}
+	}
+	awaitRebalance()
+
+	key := subscriptionKey{Namespace: "default", Name: "subscription"}
+	if !s.owns(key) {
+		t.Fatalf("Expected the only replica to own every subscription")
+	}
+
+	endpoints = endpoints.DeepCopy()
+	for i := 0; i < 10; i++ {
+		endpoints.Subsets[0].Addresses = append(endpoints.Subsets[0].Addresses, corev1.EndpointAddress{
+			IP:        fmt.Sprintf("10.0.1.%d", i),
+			TargetRef: &corev1.ObjectReference{Name: fmt.Sprintf("dispatcher-%d", i)},
+		})
+	}
+	if _, err := client.CoreV1().Endpoints("knative-eventing").Update(endpoints); err != nil {
+		t.Fatalf("Unable to update endpoints: %v", err)
+	}
+	awaitRebalance()
+
+	owned := 0
+	for i := 0; i < 1100; i++ {
+		if s.owns(subscriptionKey{Namespace: "default", Name: fmt.Sprintf("subscription-%d", i)}) {
+			owned++
+		}
+	}
+	if owned == 0 || owned > 400 {
+		t.Errorf("Expected the replica to own about 100 of 1100 subscriptions, owns %d", owned)
+	}
+}
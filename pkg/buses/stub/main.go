@@ -17,23 +17,11 @@
 package main
 
 import (
-	"flag"
-	"fmt"
-	"os"
+	"sync"
 
 	"github.com/golang/glog"
 	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
 	"github.com/knative/eventing/pkg/buses"
-	"github.com/knative/eventing/pkg/signals"
-)
-
-const (
-	threadsPerMonitor = 1
-)
-
-var (
-	masterURL  string
-	kubeconfig string
 )
 
 // StubBus is able to broadcast messages to multiple subscribers, but does not
@@ -42,93 +30,76 @@ var (
 // The stub bus is commonly used in development and testing, but is often not
 // suitable for production environments.
 type StubBus struct {
-	ref        *buses.BusReference
-	monitor    *buses.Monitor
-	receiver   *buses.MessageReceiver
-	dispatcher *buses.MessageDispatcher
+	subscribers map[buses.ChannelReference]map[*stubSubscriber]bool
+	mutex       *sync.Mutex
 }
 
-// NewStubBus creates a stub bus.
-func NewStubBus(ref *buses.BusReference, monitor *buses.Monitor) *StubBus {
-	bus := &StubBus{
-		ref:     ref,
-		monitor: monitor,
-	}
-	bus.dispatcher = buses.NewMessageDispatcher()
-	bus.receiver = buses.NewMessageReceiver(bus.receiveMessage)
-	return bus
+type stubSubscriber struct {
+	name       string
+	subscriber buses.Subscriber
 }
 
-// Run starts the bus's monitor and receiver. This function will block until
-// the stop channel receives a message.
-func (b *StubBus) Run(stopCh <-chan struct{}) {
-	go func() {
-		if err := b.monitor.Run(b.ref.Namespace, b.ref.Name, 1, stopCh); err != nil {
-			glog.Fatalf("Error running monitor: %s", err.Error())
-		}
-	}()
-	b.monitor.WaitForCacheSync(stopCh)
-	b.receiver.Run(stopCh)
+// NewStubBus creates a stub bus.
+func NewStubBus() *StubBus {
+	return &StubBus{
+		subscribers: make(map[buses.ChannelReference]map[*stubSubscriber]bool),
+		mutex:       &sync.Mutex{},
+	}
 }
 
-// receiveMessage receives new messages for the bus from the message receiver,
-// looks up active subscriptions for the channel and dispatches the message to
-// each subscriber.
-func (b *StubBus) receiveMessage(channel *buses.ChannelReference, message *buses.Message) error {
-	subscriptions := b.monitor.Subscriptions(channel.Name, channel.Namespace)
-	if subscriptions == nil {
-		return buses.ErrUnknownChannel
-	}
-	for _, subscription := range *subscriptions {
-		go b.dispatchMessage(subscription, channel, message)
-	}
+// Provision is a no-op, the stub bus has no backing resources.
+func (b *StubBus) Provision(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+	glog.Infof("Provision channel %q\n", channel.Name)
 	return nil
 }
 
-// dispatchMessage dispatches messages for the bus to a channel's subscriber.
-func (b *StubBus) dispatchMessage(subscription channelsv1alpha1.SubscriptionSpec, channel *buses.ChannelReference, message *buses.Message) {
-	subscriber := subscription.Subscriber
-	glog.Infof("Sending to %q for %q", subscriber, channel)
-	b.dispatcher.DispatchMessage(subscriber, channel.Namespace, message)
+// Unprovision is a no-op, the stub bus has no backing resources.
+func (b *StubBus) Unprovision(channel *channelsv1alpha1.Channel) error {
+	glog.Infof("Unprovision channel %q\n", channel.Name)
+	return nil
 }
 
-func main() {
-	defer glog.Flush()
+// Publish dispatches the message to each subscriber of the channel.
+func (b *StubBus) Publish(channel *channelsv1alpha1.Channel, message *buses.Message) error {
+	ref := buses.ChannelReference{Namespace: channel.Namespace, Name: channel.Name}
 
-	flag.Parse()
-
-	// set up signals so we handle the first shutdown signal gracefully
-	stopCh := signals.SetupSignalHandler()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for s := range b.subscribers[ref] {
+		glog.Infof("Sending to %q for %q", s.name, &ref)
+		go s.subscriber.Dispatch(message)
+	}
+	return nil
+}
 
-	busReference := &buses.BusReference{
-		Namespace: os.Getenv("BUS_NAMESPACE"),
-		Name:      os.Getenv("BUS_NAME"),
+// Subscribe adds the subscriber to the channel until the handle is closed.
+func (b *StubBus) Subscribe(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters, subscriber buses.Subscriber) (buses.SubscriptionHandle, error) {
+	glog.Infof("Subscribe %q to %q channel\n", subscription.Spec.Subscriber, subscription.Spec.Channel)
+	ref := buses.ChannelReference{Namespace: subscription.Namespace, Name: subscription.Spec.Channel}
+	s := &stubSubscriber{
+		name:       subscription.Spec.Subscriber,
+		subscriber: subscriber,
 	}
-	component := fmt.Sprintf("%s-%s", busReference.Name, buses.Dispatcher)
 
-	monitor := buses.NewMonitor(component, masterURL, kubeconfig, buses.MonitorEventHandlerFuncs{
-		ProvisionFunc: func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
-			glog.Infof("Provision channel %q\n", channel.Name)
-			return nil
-		},
-		UnprovisionFunc: func(channel *channelsv1alpha1.Channel) error {
-			glog.Infof("Unprovision channel %q\n", channel.Name)
-			return nil
-		},
-		SubscribeFunc: func(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
-			glog.Infof("Subscribe %q to %q channel\n", subscription.Spec.Subscriber, subscription.Spec.Channel)
-			return nil
-		},
-		UnsubscribeFunc: func(subscription *channelsv1alpha1.Subscription) error {
-			glog.Infof("Unsubscribe %q from %q channel\n", subscription.Spec.Subscriber, subscription.Spec.Channel)
-			return nil
-		},
-	})
-	bus := NewStubBus(busReference, monitor)
-	bus.Run(stopCh)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers[ref] == nil {
+		b.subscribers[ref] = make(map[*stubSubscriber]bool)
+	}
+	b.subscribers[ref][s] = true
+
+	return buses.SubscriptionHandleFunc(func() error {
+		glog.Infof("Unsubscribe %q from %q channel\n", subscription.Spec.Subscriber, subscription.Spec.Channel)
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers[ref], s)
+		if len(b.subscribers[ref]) == 0 {
+			delete(b.subscribers, ref)
+		}
+		return nil
+	}), nil
 }
 
-func init() {
-	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
-	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+func main() {
+	buses.Run(NewStubBus())
 }
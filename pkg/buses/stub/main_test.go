@@ -0,0 +1,155 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/knative/eventing/pkg/buses/conformance"
+	bustesting "github.com/knative/eventing/pkg/buses/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.RunTests(t, NewStubBus(), conformance.Config{
+		// failed dispatches are not reattempted
+		NoRedelivery: true,
+	})
+}
+
+// TestReplicas runs two dispatcher replicas configured for sharding and
+// publishes an event through one of them. The stub bus only delivers events
+// to the subscribers of the replica that received them, so each Subscription
+// must be subscribed to by every replica.
+func TestReplicas(t *testing.T) {
+	var mutex sync.Mutex
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received[req.URL.Path]++
+	}))
+	defer server.Close()
+
+	bus := &channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stub"},
+	}
+	channel := &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "channel"},
+		Spec:       channelsv1alpha1.ChannelSpec{Bus: "stub"},
+	}
+	var subscriptions []*channelsv1alpha1.Subscription
+	for i := 0; i < 4; i++ {
+		subscriptions = append(subscriptions, &channelsv1alpha1.Subscription{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: fmt.Sprintf("subscription-%d", i)},
+			Spec: channelsv1alpha1.SubscriptionSpec{
+				Channel:    "channel",
+				Subscriber: fmt.Sprintf("%s/subscriber-%d", server.URL, i),
+			},
+		})
+	}
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "knative-eventing", Name: "stub-bus"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{
+				{IP: "10.0.0.1", TargetRef: &corev1.ObjectReference{Name: "dispatcher-a"}},
+				{IP: "10.0.0.2", TargetRef: &corev1.ObjectReference{Name: "dispatcher-b"}},
+			},
+		}},
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	// the monitors are created before any runs, as creating one registers
+	// the API types with the shared scheme
+	identities := []string{"dispatcher-a", "dispatcher-b"}
+	var runners []*buses.Runner
+	var monitors []*bustesting.FakeMonitor
+	for _, identity := range identities {
+		r := buses.NewRunner(NewStubBus(), buses.Dispatcher)
+		defer r.Close()
+		monitor := bustesting.NewFakeMonitor(bus, r.HandlerFuncs(), channel)
+		if _, err := monitor.KubeClientset.CoreV1().Endpoints(endpoints.Namespace).Create(endpoints); err != nil {
+			t.Fatalf("Unable to create endpoints: %v", err)
+		}
+		r.SetMonitor(monitor.Monitor)
+		r.SetSharding(buses.ShardingConfig{
+			Namespace: endpoints.Namespace,
+			Service:   endpoints.Name,
+			Identity:  identity,
+		})
+		runners = append(runners, r)
+		monitors = append(monitors, monitor)
+	}
+	for i, monitor := range monitors {
+		runners[i].Start(stopCh)
+		if err := monitor.Run(stopCh); err != nil {
+			t.Fatalf("Unable to run monitor: %v", err)
+		}
+		for _, subscription := range subscriptions {
+			if err := monitor.AddSubscription(subscription); err != nil {
+				t.Fatalf("Unable to add subscription: %v", err)
+			}
+		}
+		for _, subscription := range subscriptions {
+			if _, err := monitor.WaitForCall(bustesting.SubscribeCall, subscription.Namespace, subscription.Name, 5*time.Second); err != nil {
+				t.Fatalf("Expected %s to subscribe to every subscription: %v", identities[i], err)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://channel.default.channels.cluster.local/", nil)
+	req.Header.Set("CE-CloudEventsVersion", "0.1")
+	req.Header.Set("CE-EventID", "replicas-1")
+	req.Header.Set("CE-EventType", "dev.knative.test")
+	req.Header.Set("CE-Source", "/test")
+	res := httptest.NewRecorder()
+	runners[0].Receiver().HandleRequest(res, req)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("Unexpected publish status. want %d, got %d", http.StatusAccepted, res.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		got := len(received)
+		mutex.Unlock()
+		if got == len(subscriptions) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected subscribers receiving the event. want %d, got %v", len(subscriptions), received)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	for path, count := range received {
+		if count != 1 {
+			t.Errorf("Unexpected deliveries to %s. want 1, got %d", path, count)
+		}
+	}
+}
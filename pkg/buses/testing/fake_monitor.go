@@ -0,0 +1,430 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package testing provides a fake Monitor that runs against fake clientsets so
+// bus logic can be unit tested without a Kubernetes API server.
+package testing
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	"github.com/knative/eventing/pkg/client/clientset/versioned/fake"
+	informers "github.com/knative/eventing/pkg/client/informers/externalversions"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/watch"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+const (
+	// BusCall records a call to MonitorEventHandlerFuncs.BusFunc.
+	BusCall = "Bus"
+	// ProvisionCall records a call to MonitorEventHandlerFuncs.ProvisionFunc.
+	ProvisionCall = "Provision"
+	// UnprovisionCall records a call to MonitorEventHandlerFuncs.UnprovisionFunc.
+	UnprovisionCall = "Unprovision"
+	// SubscribeCall records a call to MonitorEventHandlerFuncs.SubscribeFunc.
+	SubscribeCall = "Subscribe"
+	// UnsubscribeCall records a call to MonitorEventHandlerFuncs.UnsubscribeFunc.
+	UnsubscribeCall = "Unsubscribe"
+)
+
+var (
+	busesResource         = channelsv1alpha1.SchemeGroupVersion.WithResource("buses")
+	clusterBusesResource  = channelsv1alpha1.SchemeGroupVersion.WithResource("clusterbuses")
+	channelsResource      = channelsv1alpha1.SchemeGroupVersion.WithResource("channels")
+	subscriptionsResource = channelsv1alpha1.SchemeGroupVersion.WithResource("subscriptions")
+)
+
+// HandlerCall is a record of the Monitor calling one of the bus's handler
+// functions.
+type HandlerCall struct {
+	// Func is the handler function that was called, one of BusCall,
+	// ProvisionCall, UnprovisionCall, SubscribeCall or UnsubscribeCall.
+	Func string
+	// Namespace and Name identify the Bus, Channel or Subscription the
+	// function was called with.
+	Namespace string
+	Name      string
+	// Parameters are the resolved parameters passed to ProvisionFunc and
+	// SubscribeFunc.
+	Parameters buses.ResolvedParameters
+	// Err is the error returned by the handler function.
+	Err error
+}
+
+func (c HandlerCall) String() string {
+	return fmt.Sprintf("%s %s/%s", c.Func, c.Namespace, c.Name)
+}
+
+// FakeMonitor is a Monitor whose informers watch fake clientsets. Tests change
+// Buses, Channels and Subscriptions through the FakeMonitor, which writes them
+// directly to the fake object store so the changes are seen by the Monitor
+// but are not recorded as client actions. Writes made by the Monitor, such as
+// status updates, are recorded as actions on Clientset. Channels with
+// finalizers are only deleted once the finalizers are removed, as the API
+// server would.
+type FakeMonitor struct {
+	*buses.Monitor
+
+	// KubeClientset is the fake Kubernetes clientset used by the Monitor.
+	KubeClientset *kubefake.Clientset
+	// Clientset is the fake Channels clientset used by the Monitor.
+	Clientset *fake.Clientset
+
+	bus     channelsv1alpha1.GenericBus
+	tracker clientgotesting.ObjectTracker
+
+	mutex           sync.Mutex
+	calls           []HandlerCall
+	listed          map[schema.GroupVersionResource]bool
+	watched         map[schema.GroupVersionResource]bool
+	resourceVersion int
+	changed         chan struct{}
+}
+
+// NewFakeMonitor creates a FakeMonitor for the bus, which may be a Bus or a
+// ClusterBus. The handler functions are called by the Monitor as they would be
+// for a real bus; nil functions are skipped and not recorded. The objects are
+// added to the fake Channels clientset before the Monitor starts.
+func NewFakeMonitor(bus channelsv1alpha1.GenericBus, handler buses.MonitorEventHandlerFuncs, objects ...runtime.Object) *FakeMonitor {
+	m := &FakeMonitor{
+		KubeClientset: kubefake.NewSimpleClientset(),
+		bus:           bus,
+		listed:        make(map[schema.GroupVersionResource]bool),
+		watched:       make(map[schema.GroupVersionResource]bool),
+		changed:       make(chan struct{}),
+	}
+
+	scheme := runtime.NewScheme()
+	metav1.AddToGroupVersion(scheme, schema.GroupVersion{Version: "v1"})
+	fake.AddToScheme(scheme)
+	codecs := serializer.NewCodecFactory(scheme)
+	m.tracker = clientgotesting.NewObjectTracker(scheme, codecs.UniversalDecoder())
+
+	// Build the clientset around our own tracker, rather than with
+	// fake.NewSimpleClientset, so the FakeMonitor can write to the tracker
+	// without recording actions. The clientset's Discovery is not supported.
+	m.Clientset = &fake.Clientset{}
+	m.Clientset.AddReactor("update", channelsResource.Resource, m.finalizeReaction)
+	m.Clientset.AddReactor("list", "*", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		m.mutex.Lock()
+		m.listed[action.GetResource()] = true
+		m.mutex.Unlock()
+		return false, nil, nil
+	})
+	m.Clientset.AddReactor("*", "*", clientgotesting.ObjectReaction(m.tracker))
+	m.Clientset.AddWatchReactor("*", func(action clientgotesting.Action) (bool, watch.Interface, error) {
+		w, err := m.tracker.Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		m.mutex.Lock()
+		m.watched[action.GetResource()] = true
+		m.notifyLocked()
+		m.mutex.Unlock()
+		return true, w, nil
+	})
+
+	m.mustAdd(bus)
+	for _, obj := range objects {
+		m.mustAdd(obj)
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(m.Clientset, 0)
+	m.Monitor = buses.NewMonitorFromClients("fake-monitor", m.KubeClientset, m.Clientset, informerFactory, m.record(handler))
+	return m
+}
+
+// Run starts the Monitor and blocks until its caches have synced and its
+// informers are watching for changes. An informer has synced once it has
+// listed its resource, but changes are only seen once it watches it, so Run
+// waits for every listed resource to be watched. The Monitor stops when stopCh
+// is closed.
+func (m *FakeMonitor) Run(stopCh <-chan struct{}) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.Monitor.Run(m.bus.GetObjectMeta().GetNamespace(), m.bus.GetObjectMeta().GetName(), 1, stopCh)
+	}()
+	if err := m.Monitor.WaitForCacheSync(stopCh); err != nil {
+		return err
+	}
+	for {
+		m.mutex.Lock()
+		watching := true
+		for resource := range m.listed {
+			watching = watching && m.watched[resource]
+		}
+		changed := m.changed
+		m.mutex.Unlock()
+		if watching {
+			return nil
+		}
+		select {
+		case <-changed:
+		case err := <-errCh:
+			return err
+		case <-stopCh:
+			return fmt.Errorf("stopped before the monitor was watching")
+		}
+	}
+}
+
+// UpdateBus replaces the Bus or ClusterBus the Monitor runs for.
+func (m *FakeMonitor) UpdateBus(bus channelsv1alpha1.GenericBus) error {
+	resource := busesResource
+	if _, ok := bus.(*channelsv1alpha1.ClusterBus); ok {
+		resource = clusterBusesResource
+	}
+	return m.update(resource, bus)
+}
+
+// AddChannel creates a Channel.
+func (m *FakeMonitor) AddChannel(channel *channelsv1alpha1.Channel) error {
+	return m.create(channelsResource, channel)
+}
+
+// UpdateChannel replaces an existing Channel.
+func (m *FakeMonitor) UpdateChannel(channel *channelsv1alpha1.Channel) error {
+	return m.update(channelsResource, channel)
+}
+
+// DeleteChannel deletes a Channel. A Channel with finalizers is marked for
+// deletion instead, and deleted when the last finalizer is removed.
+func (m *FakeMonitor) DeleteChannel(namespace, name string) error {
+	obj, err := m.tracker.Get(channelsResource, namespace, name)
+	if err != nil {
+		return err
+	}
+	channel := obj.(*channelsv1alpha1.Channel)
+	if len(channel.Finalizers) == 0 {
+		return m.tracker.Delete(channelsResource, namespace, name)
+	}
+	now := metav1.Now()
+	channel = channel.DeepCopy()
+	channel.DeletionTimestamp = &now
+	return m.update(channelsResource, channel)
+}
+
+// AddSubscription creates a Subscription.
+func (m *FakeMonitor) AddSubscription(subscription *channelsv1alpha1.Subscription) error {
+	return m.create(subscriptionsResource, subscription)
+}
+
+// UpdateSubscription replaces an existing Subscription.
+func (m *FakeMonitor) UpdateSubscription(subscription *channelsv1alpha1.Subscription) error {
+	return m.update(subscriptionsResource, subscription)
+}
+
+// DeleteSubscription deletes a Subscription.
+func (m *FakeMonitor) DeleteSubscription(namespace, name string) error {
+	return m.tracker.Delete(subscriptionsResource, namespace, name)
+}
+
+// Calls returns the handler calls made by the Monitor, in order.
+func (m *FakeMonitor) Calls() []HandlerCall {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	calls := make([]HandlerCall, len(m.calls))
+	copy(calls, m.calls)
+	return calls
+}
+
+// WaitForCall blocks until the Monitor has called the handler function fn for
+// the named resource, returning the most recent such call. An error is
+// returned if no call is made before the timeout.
+func (m *FakeMonitor) WaitForCall(fn, namespace, name string, timeout time.Duration) (HandlerCall, error) {
+	deadline := time.After(timeout)
+	for {
+		m.mutex.Lock()
+		changed := m.changed
+		for i := len(m.calls) - 1; i >= 0; i-- {
+			call := m.calls[i]
+			if call.Func == fn && call.Namespace == namespace && call.Name == name {
+				m.mutex.Unlock()
+				return call, nil
+			}
+		}
+		m.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return HandlerCall{}, fmt.Errorf("timed out waiting for %s %s/%s, got %v", fn, namespace, name, m.Calls())
+		}
+	}
+}
+
+// ChannelStatusUpdates returns the Channels written back by the Monitor
+// through ChannelsV1alpha1().Channels().Update, in order.
+func (m *FakeMonitor) ChannelStatusUpdates() []*channelsv1alpha1.Channel {
+	var channels []*channelsv1alpha1.Channel
+	for _, obj := range m.updates(channelsResource) {
+		channels = append(channels, obj.(*channelsv1alpha1.Channel))
+	}
+	return channels
+}
+
+// SubscriptionStatusUpdates returns the Subscriptions written back by the
+// Monitor through ChannelsV1alpha1().Subscriptions().Update, in order.
+func (m *FakeMonitor) SubscriptionStatusUpdates() []*channelsv1alpha1.Subscription {
+	var subscriptions []*channelsv1alpha1.Subscription
+	for _, obj := range m.updates(subscriptionsResource) {
+		subscriptions = append(subscriptions, obj.(*channelsv1alpha1.Subscription))
+	}
+	return subscriptions
+}
+
+func (m *FakeMonitor) updates(resource schema.GroupVersionResource) []runtime.Object {
+	var objs []runtime.Object
+	for _, action := range m.Clientset.Actions() {
+		update, ok := action.(clientgotesting.UpdateAction)
+		if !ok || !action.Matches("update", resource.Resource) {
+			continue
+		}
+		objs = append(objs, update.GetObject())
+	}
+	return objs
+}
+
+// finalizeReaction deletes a Channel marked for deletion when an update removes
+// its last finalizer.
+func (m *FakeMonitor) finalizeReaction(action clientgotesting.Action) (bool, runtime.Object, error) {
+	obj := action.(clientgotesting.UpdateAction).GetObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return true, nil, err
+	}
+	if accessor.GetDeletionTimestamp() == nil || len(accessor.GetFinalizers()) > 0 {
+		return false, nil, nil
+	}
+	return true, obj, m.tracker.Delete(channelsResource, accessor.GetNamespace(), accessor.GetName())
+}
+
+func (m *FakeMonitor) mustAdd(obj runtime.Object) {
+	obj = obj.DeepCopyObject()
+	if err := m.setResourceVersion(obj); err != nil {
+		panic(err)
+	}
+	if err := m.tracker.Add(obj); err != nil {
+		panic(err)
+	}
+}
+
+func (m *FakeMonitor) create(resource schema.GroupVersionResource, obj runtime.Object) error {
+	obj = obj.DeepCopyObject()
+	if err := m.setResourceVersion(obj); err != nil {
+		return err
+	}
+	accessor, _ := meta.Accessor(obj)
+	return m.tracker.Create(resource, obj, accessor.GetNamespace())
+}
+
+// update writes the object with a new resource version, as the API server
+// would, so the Monitor's informers do not mistake it for a resync.
+func (m *FakeMonitor) update(resource schema.GroupVersionResource, obj runtime.Object) error {
+	obj = obj.DeepCopyObject()
+	if err := m.setResourceVersion(obj); err != nil {
+		return err
+	}
+	accessor, _ := meta.Accessor(obj)
+	return m.tracker.Update(resource, obj, accessor.GetNamespace())
+}
+
+func (m *FakeMonitor) setResourceVersion(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.resourceVersion++
+	accessor.SetResourceVersion(strconv.Itoa(m.resourceVersion))
+	m.mutex.Unlock()
+	return nil
+}
+
+// notifyLocked wakes goroutines waiting on a change. m.mutex must be held.
+func (m *FakeMonitor) notifyLocked() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func (m *FakeMonitor) addCall(call HandlerCall) {
+	m.mutex.Lock()
+	m.calls = append(m.calls, call)
+	m.notifyLocked()
+	m.mutex.Unlock()
+}
+
+// record wraps the handler functions so each call is recorded.
+func (m *FakeMonitor) record(handler buses.MonitorEventHandlerFuncs) buses.MonitorEventHandlerFuncs {
+	recorded := buses.MonitorEventHandlerFuncs{}
+	if handler.BusFunc != nil {
+		recorded.BusFunc = func(bus channelsv1alpha1.GenericBus) error {
+			err := handler.BusFunc(bus)
+			m.addCall(newHandlerCall(BusCall, bus.GetObjectMeta(), nil, err))
+			return err
+		}
+	}
+	if handler.ProvisionFunc != nil {
+		recorded.ProvisionFunc = func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+			err := handler.ProvisionFunc(channel, parameters)
+			m.addCall(newHandlerCall(ProvisionCall, channel, parameters, err))
+			return err
+		}
+	}
+	if handler.UnprovisionFunc != nil {
+		recorded.UnprovisionFunc = func(channel *channelsv1alpha1.Channel) error {
+			err := handler.UnprovisionFunc(channel)
+			m.addCall(newHandlerCall(UnprovisionCall, channel, nil, err))
+			return err
+		}
+	}
+	if handler.SubscribeFunc != nil {
+		recorded.SubscribeFunc = func(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
+			err := handler.SubscribeFunc(subscription, parameters)
+			m.addCall(newHandlerCall(SubscribeCall, subscription, parameters, err))
+			return err
+		}
+	}
+	if handler.UnsubscribeFunc != nil {
+		recorded.UnsubscribeFunc = func(subscription *channelsv1alpha1.Subscription) error {
+			err := handler.UnsubscribeFunc(subscription)
+			m.addCall(newHandlerCall(UnsubscribeCall, subscription, nil, err))
+			return err
+		}
+	}
+	return recorded
+}
+
+func newHandlerCall(fn string, obj metav1.Object, parameters buses.ResolvedParameters, err error) HandlerCall {
+	return HandlerCall{
+		Func:       fn,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Parameters: parameters,
+		Err:        err,
+	}
+}
@@ -0,0 +1,400 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/buses"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testNamespace = "test-namespace"
+	testBus       = "test-bus"
+	testTimeout   = 5 * time.Second
+)
+
+func TestFakeMonitorProvision(t *testing.T) {
+	defaultPartitions := "1"
+	bus := &channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testBus},
+		Spec: channelsv1alpha1.BusSpec{
+			Parameters: &channelsv1alpha1.BusParameters{
+				Channel: &[]channelsv1alpha1.Parameter{
+					{Name: "partitions", Default: &defaultPartitions},
+				},
+			},
+		},
+	}
+	m := NewFakeMonitor(bus, buses.MonitorEventHandlerFuncs{
+		ProvisionFunc: func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+			return nil
+		},
+		UnprovisionFunc: func(channel *channelsv1alpha1.Channel) error {
+			return nil
+		},
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Run(stopCh); err != nil {
+		t.Fatalf("Error running monitor: %v", err)
+	}
+
+	channel := makeChannel("channel")
+	if err := m.AddChannel(channel); err != nil {
+		t.Fatalf("Error adding channel: %v", err)
+	}
+	call, err := m.WaitForCall(ProvisionCall, testNamespace, "channel", testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := call.Parameters["partitions"]; got != "1" {
+		t.Errorf("Unexpected partitions parameter. want %q, got %q", "1", got)
+	}
+	waitFor(t, func() bool {
+		return m.Channel("channel", testNamespace) != nil
+	})
+	assertChannelProvisioned(t, m, corev1.ConditionTrue)
+
+	channel.Spec.Arguments = &[]channelsv1alpha1.Argument{{Name: "partitions", Value: "3"}}
+	if err := m.UpdateChannel(channel); err != nil {
+		t.Fatalf("Error updating channel: %v", err)
+	}
+	waitFor(t, func() bool {
+		call, err := m.WaitForCall(ProvisionCall, testNamespace, "channel", testTimeout)
+		return err == nil && call.Parameters["partitions"] == "3"
+	})
+
+	if err := m.DeleteChannel(testNamespace, "channel"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	if _, err := m.WaitForCall(UnprovisionCall, testNamespace, "channel", testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	assertChannelProvisioned(t, m, corev1.ConditionFalse)
+
+	var funcs []string
+	for _, call := range m.Calls() {
+		funcs = append(funcs, call.Func)
+	}
+	want := []string{ProvisionCall, ProvisionCall, UnprovisionCall}
+	if len(funcs) != len(want) {
+		t.Fatalf("Unexpected calls. want %v, got %v", want, funcs)
+	}
+	for i := range want {
+		if funcs[i] != want[i] {
+			t.Errorf("Unexpected calls. want %v, got %v", want, funcs)
+		}
+	}
+}
+
+func TestFakeMonitorProvisionError(t *testing.T) {
+	bus := &channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testBus},
+	}
+	provisionErr := errors.New("provision failed")
+	m := NewFakeMonitor(bus, buses.MonitorEventHandlerFuncs{
+		ProvisionFunc: func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+			return provisionErr
+		},
+	}, makeChannel("channel"))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Run(stopCh); err != nil {
+		t.Fatalf("Error running monitor: %v", err)
+	}
+
+	call, err := m.WaitForCall(ProvisionCall, testNamespace, "channel", testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Err != provisionErr {
+		t.Errorf("Unexpected error. want %v, got %v", provisionErr, call.Err)
+	}
+	assertChannelProvisioned(t, m, corev1.ConditionFalse)
+}
+
+func TestFakeMonitorProvisionInvalidArguments(t *testing.T) {
+	minPartitions := "1"
+	bus := &channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testBus},
+		Spec: channelsv1alpha1.BusSpec{
+			Parameters: &channelsv1alpha1.BusParameters{
+				Channel: &[]channelsv1alpha1.Parameter{
+					{Name: "partitions", Type: channelsv1alpha1.ParameterTypeInt, Minimum: &minPartitions},
+				},
+			},
+		},
+	}
+	channel := makeChannel("channel")
+	channel.Spec.Arguments = &[]channelsv1alpha1.Argument{{Name: "partitions", Value: "0"}}
+	m := NewFakeMonitor(bus, buses.MonitorEventHandlerFuncs{
+		ProvisionFunc: func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+			return nil
+		},
+	}, channel)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Run(stopCh); err != nil {
+		t.Fatalf("Error running monitor: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		updates := m.ChannelStatusUpdates()
+		if len(updates) == 0 {
+			return false
+		}
+		cond := updates[len(updates)-1].Status.GetCondition(channelsv1alpha1.ChannelProvisioned)
+		return cond != nil && cond.Status == corev1.ConditionFalse && cond.Reason == "ErrInvalidArguments"
+	})
+	if calls := m.Calls(); len(calls) != 0 {
+		t.Errorf("Unexpected calls for invalid arguments: %v", calls)
+	}
+}
+func TestFakeMonitorSubscribe(t *testing.T) {
+	bus := &channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testBus},
+	}
+	m := NewFakeMonitor(bus, buses.MonitorEventHandlerFuncs{
+		ProvisionFunc: func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+			return nil
+		},
+		SubscribeFunc: func(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
+			return nil
+		},
+		UnsubscribeFunc: func(subscription *channelsv1alpha1.Subscription) error {
+			return nil
+		},
+	}, makeChannel("channel"))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Run(stopCh); err != nil {
+		t.Fatalf("Error running monitor: %v", err)
+	}
+	if _, err := m.WaitForCall(ProvisionCall, testNamespace, "channel", testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription := &channelsv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "subscription"},
+		Spec: channelsv1alpha1.SubscriptionSpec{
+			Channel:    "channel",
+			Subscriber: "subscriber.test-namespace.svc.cluster.local",
+		},
+	}
+	if err := m.AddSubscription(subscription); err != nil {
+		t.Fatalf("Error adding subscription: %v", err)
+	}
+	if _, err := m.WaitForCall(SubscribeCall, testNamespace, "subscription", testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return m.Subscription("subscription", testNamespace) != nil
+	})
+	subscriptions := m.Subscriptions("channel", testNamespace)
+	if subscriptions == nil || len(*subscriptions) != 1 {
+		t.Fatalf("Unexpected subscriptions for channel: %v", subscriptions)
+	}
+
+	if err := m.DeleteSubscription(testNamespace, "subscription"); err != nil {
+		t.Fatalf("Error deleting subscription: %v", err)
+	}
+	if _, err := m.WaitForCall(UnsubscribeCall, testNamespace, "subscription", testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		return len(m.SubscriptionStatusUpdates()) >= 2
+	})
+	updates := m.SubscriptionStatusUpdates()
+	if len(updates) != 2 {
+		t.Fatalf("Unexpected subscription status updates. want 2, got %d", len(updates))
+	}
+	for i, status := range []corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse} {
+		cond := updates[i].Status.GetCondition(channelsv1alpha1.SubscriptionDispatching)
+		if cond == nil || cond.Status != status {
+			t.Errorf("Unexpected Dispatching condition for update %d. want %q, got %v", i, status, cond)
+		}
+	}
+}
+
+func TestFakeMonitorSubscriberRef(t *testing.T) {
+	bus := &channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testBus},
+	}
+	m := NewFakeMonitor(bus, buses.MonitorEventHandlerFuncs{
+		ProvisionFunc: func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+			return nil
+		},
+		SubscribeFunc: func(subscription *channelsv1alpha1.Subscription, parameters buses.ResolvedParameters) error {
+			return nil
+		},
+		UnsubscribeFunc: func(subscription *channelsv1alpha1.Subscription) error {
+			return nil
+		},
+	}, makeChannel("channel"), makeChannel("downstream"))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Run(stopCh); err != nil {
+		t.Fatalf("Error running monitor: %v", err)
+	}
+	waitFor(t, func() bool {
+		return len(m.ChannelStatusUpdates()) >= 2
+	})
+
+	subscription := &channelsv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "subscription"},
+		Spec: channelsv1alpha1.SubscriptionSpec{
+			Channel:       "channel",
+			SubscriberRef: &corev1.ObjectReference{Kind: channelsv1alpha1.ChannelKind, Name: "downstream"},
+		},
+	}
+	if err := m.AddSubscription(subscription); err != nil {
+		t.Fatalf("Error adding subscription: %v", err)
+	}
+	// the subscriber is resolved once the downstream channel has an address
+	waitFor(t, func() bool {
+		updates := m.SubscriptionStatusUpdates()
+		return len(updates) > 0 && updates[0].Status.GetCondition(channelsv1alpha1.SubscriptionSubscriberResolved) != nil
+	})
+	obj, err := m.tracker.Get(channelsResource, testNamespace, "downstream")
+	if err != nil {
+		t.Fatalf("Error getting channel: %v", err)
+	}
+	downstream := obj.(*channelsv1alpha1.Channel).DeepCopy()
+	downstream.Status.DomainInternal = "downstream.test-namespace.channels.cluster.local"
+	if err := m.UpdateChannel(downstream); err != nil {
+		t.Fatalf("Error updating channel: %v", err)
+	}
+	if _, err := m.WaitForCall(SubscribeCall, testNamespace, "subscription", testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return m.Subscription("subscription", testNamespace) != nil
+	})
+	subscriptions := m.Subscriptions("channel", testNamespace)
+	if subscriptions == nil || len(*subscriptions) != 1 || (*subscriptions)[0].Subscriber != downstream.Status.DomainInternal {
+		t.Fatalf("Unexpected subscriptions for channel: %v", subscriptions)
+	}
+
+	// the subscription is unsubscribed when the downstream channel is deleted
+	if err := m.DeleteChannel(testNamespace, "downstream"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	if _, err := m.WaitForCall(UnsubscribeCall, testNamespace, "subscription", testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return m.Subscription("subscription", testNamespace) == nil
+	})
+	if subscriptions := m.Subscriptions("channel", testNamespace); subscriptions == nil || len(*subscriptions) != 0 {
+		t.Errorf("Unexpected subscriptions for channel: %v", subscriptions)
+	}
+}
+
+func TestFakeMonitorChannelFinalizer(t *testing.T) {
+	bus := &channelsv1alpha1.Bus{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testBus},
+	}
+	unprovisionErr := errors.New("unprovision failed")
+	failures := 1
+	m := NewFakeMonitor(bus, buses.MonitorEventHandlerFuncs{
+		ProvisionFunc: func(channel *channelsv1alpha1.Channel, parameters buses.ResolvedParameters) error {
+			return nil
+		},
+		UnprovisionFunc: func(channel *channelsv1alpha1.Channel) error {
+			if failures > 0 {
+				failures--
+				return unprovisionErr
+			}
+			return nil
+		},
+	}, makeChannel("channel"))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Run(stopCh); err != nil {
+		t.Fatalf("Error running monitor: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		obj, err := m.tracker.Get(channelsResource, testNamespace, "channel")
+		if err != nil {
+			return false
+		}
+		finalizers := obj.(*channelsv1alpha1.Channel).Finalizers
+		return len(finalizers) == 1 && finalizers[0] == buses.ChannelFinalizer
+	})
+
+	if err := m.DeleteChannel(testNamespace, "channel"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	// the channel is deleted once it is unprovisioned
+	waitFor(t, func() bool {
+		_, err := m.tracker.Get(channelsResource, testNamespace, "channel")
+		return err != nil
+	})
+
+	var unprovisionErrs []error
+	for _, call := range m.Calls() {
+		if call.Func == UnprovisionCall {
+			unprovisionErrs = append(unprovisionErrs, call.Err)
+		}
+	}
+	if len(unprovisionErrs) != 2 || unprovisionErrs[0] != unprovisionErr || unprovisionErrs[1] != nil {
+		t.Errorf("Expected a failed then a successful unprovision, got %v", unprovisionErrs)
+	}
+}
+
+func makeChannel(name string) *channelsv1alpha1.Channel {
+	return &channelsv1alpha1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name},
+		Spec: channelsv1alpha1.ChannelSpec{
+			Bus: testBus,
+		},
+	}
+}
+
+// assertChannelProvisioned waits for the monitor to write back a Channel
+// status with the given Provisioned condition. The status is written after the
+// handler returns, so it may lag the recorded call.
+func assertChannelProvisioned(t *testing.T, m *FakeMonitor, status corev1.ConditionStatus) {
+	t.Helper()
+	waitFor(t, func() bool {
+		updates := m.ChannelStatusUpdates()
+		if len(updates) == 0 {
+			return false
+		}
+		cond := updates[len(updates)-1].Status.GetCondition(channelsv1alpha1.ChannelProvisioned)
+		return cond != nil && cond.Status == status
+	})
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"fmt"
+	"sync"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ArgumentEventValidation is the Channel argument that enables validation
+	// of received events against the schema of their EventType, looked up by
+	// the name of the event type: an EventType in the Channel's namespace,
+	// otherwise a ClusterEventType. Invalid events are rejected by the
+	// receiver. The value is EventValidationSchema or EventValidationStrict.
+	ArgumentEventValidation = "eventValidation"
+
+	// EventValidationSchema validates the data of events whose EventType has
+	// a schema. Events of other types are accepted.
+	EventValidationSchema = "Schema"
+
+	// EventValidationStrict validates like EventValidationSchema, and also
+	// rejects events whose type has no EventType.
+	EventValidationStrict = "Strict"
+)
+
+var messagesInvalid = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "knative",
+		Subsystem: "bus",
+		Name:      "messages_invalid_total",
+		Help:      "Number of messages rejected by the receiver because they failed validation.",
+	},
+	[]string{"namespace", "channel"},
+)
+
+func init() {
+	prometheus.MustRegister(messagesInvalid)
+}
+
+// InvalidEventError is returned by an EventValidator when a message is
+// rejected.
+type InvalidEventError struct {
+	Reason string
+}
+
+func (e *InvalidEventError) Error() string {
+	return e.Reason
+}
+
+// EventValidator validates the messages received for a channel.
+// ValidateEvent returns nil if the message is accepted, an *InvalidEventError
+// if it is rejected, or another error if it could not be validated.
+type EventValidator interface {
+	ValidateEvent(channel *ChannelReference, message *Message) error
+}
+
+// EventSchemaLookup returns the schema of an event type, or nil if the type
+// has no schema. found is false if there is no EventType for the type.
+type EventSchemaLookup func(eventType string) (schema *event.Schema, found bool, err error)
+
+// EventValidationForChannel reads the validation mode from the Channel's
+// arguments. It returns an empty mode if events are not validated.
+func EventValidationForChannel(channel *channelsv1alpha1.Channel) (string, error) {
+	if channel.Spec.Arguments == nil {
+		return "", nil
+	}
+	for _, arg := range *channel.Spec.Arguments {
+		if arg.Name != ArgumentEventValidation {
+			continue
+		}
+		switch arg.Value {
+		case EventValidationSchema, EventValidationStrict:
+			return arg.Value, nil
+		default:
+			return "", fmt.Errorf("invalid %s argument %q: must be %q or %q", ArgumentEventValidation, arg.Value, EventValidationSchema, EventValidationStrict)
+		}
+	}
+	return "", nil
+}
+
+// ValidateMessage validates a message in a validation mode, looking up the
+// schema of its event type with lookup. Messages which are not CloudEvents
+// are rejected.
+func ValidateMessage(mode string, message *Message, lookup EventSchemaLookup) error {
+	e, err := toEvent(message)
+	if err != nil {
+		return &InvalidEventError{Reason: fmt.Sprintf("not a valid CloudEvent: %v", err)}
+	}
+	eventType := e.Context.EventType
+
+	schema, found, err := lookup(eventType)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if mode == EventValidationStrict {
+			return &InvalidEventError{Reason: fmt.Sprintf("unknown event type %q", eventType)}
+		}
+		return nil
+	}
+	if schema == nil {
+		return nil
+	}
+
+	if !isJSON(e.Context.ContentType) {
+		return &InvalidEventError{Reason: fmt.Sprintf("event of type %q has content type %q, which can not be validated against a JSON schema", eventType, e.Context.ContentType)}
+	}
+	if err := schema.ValidateJSON(e.Data.([]byte)); err != nil {
+		return &InvalidEventError{Reason: fmt.Sprintf("event of type %q is invalid: %v", eventType, err)}
+	}
+	return nil
+}
+
+// schemaCache holds compiled EventType schemas, recompiled when the
+// resource version of the EventType changes.
+type schemaCache struct {
+	entries map[string]cachedSchema
+	mutex   sync.Mutex
+}
+
+type cachedSchema struct {
+	resourceVersion string
+	schema          *event.Schema
+	err             error
+}
+
+func newSchemaCache() *schemaCache {
+	return &schemaCache{entries: make(map[string]cachedSchema)}
+}
+
+// compile returns the compiled schema document of the keyed EventType.
+func (c *schemaCache) compile(key, resourceVersion string, document []byte) (*event.Schema, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, ok := c.entries[key]; ok && entry.resourceVersion == resourceVersion {
+		return entry.schema, entry.err
+	}
+	schema, err := event.CompileSchema(document)
+	c.entries[key] = cachedSchema{
+		resourceVersion: resourceVersion,
+		schema:          schema,
+		err:             err,
+	}
+	return schema, err
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package buses
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/event"
+)
+
+func TestEventValidationForChannel(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		arguments *[]channelsv1alpha1.Argument
+		want      string
+		wantErr   bool
+	}{
+		{
+			name: "no arguments",
+		},
+		{
+			name:      "schema",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentEventValidation, Value: EventValidationSchema}},
+			want:      EventValidationSchema,
+		},
+		{
+			name:      "strict",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentEventValidation, Value: EventValidationStrict}},
+			want:      EventValidationStrict,
+		},
+		{
+			name:      "invalid mode",
+			arguments: &[]channelsv1alpha1.Argument{{Name: ArgumentEventValidation, Value: "sometimes"}},
+			wantErr:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			channel := &channelsv1alpha1.Channel{
+				Spec: channelsv1alpha1.ChannelSpec{Arguments: test.arguments},
+			}
+			got, err := EventValidationForChannel(channel)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error. want error %v, got %v", test.wantErr, err)
+			}
+			if got != test.want {
+				t.Errorf("Unexpected validation mode. want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func eventMessage(eventType, contentType, payload string) *Message {
+	return &Message{
+		Headers: map[string]string{
+			"CE-CloudEventsVersion": event.CloudEventsVersion,
+			"CE-EventID":            "1234",
+			"CE-EventType":          eventType,
+			"CE-Source":             "/test",
+			"Content-Type":          contentType,
+		},
+		Payload: []byte(payload),
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	schema, err := event.CompileSchema([]byte(`{
+		"type": "object",
+		"required": ["id"],
+		"properties": {"id": {"type": "integer"}}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error compiling schema: %v", err)
+	}
+	lookup := func(eventType string) (*event.Schema, bool, error) {
+		switch eventType {
+		case "dev.knative.order":
+			return schema, true, nil
+		case "dev.knative.untyped":
+			return nil, true, nil
+		case "dev.knative.broken":
+			return nil, true, errors.New("invalid schema")
+		}
+		return nil, false, nil
+	}
+
+	for _, test := range []struct {
+		name    string
+		mode    string
+		message *Message
+		invalid bool
+		wantErr bool
+	}{
+		{
+			name:    "valid data",
+			mode:    EventValidationSchema,
+			message: eventMessage("dev.knative.order", "application/json", `{"id": 1}`),
+		},
+		{
+			name:    "invalid data",
+			mode:    EventValidationSchema,
+			message: eventMessage("dev.knative.order", "application/json", `{"id": "one"}`),
+			invalid: true,
+		},
+		{
+			name:    "not JSON",
+			mode:    EventValidationSchema,
+			message: eventMessage("dev.knative.order", "text/plain", `1`),
+			invalid: true,
+		},
+		{
+			name:    "type without schema",
+			mode:    EventValidationStrict,
+			message: eventMessage("dev.knative.untyped", "text/plain", `anything`),
+		},
+		{
+			name:    "unknown type",
+			mode:    EventValidationSchema,
+			message: eventMessage("dev.knative.unknown", "application/json", `{}`),
+		},
+		{
+			name:    "unknown type strict",
+			mode:    EventValidationStrict,
+			message: eventMessage("dev.knative.unknown", "application/json", `{}`),
+			invalid: true,
+		},
+		{
+			name:    "not a CloudEvent",
+			mode:    EventValidationSchema,
+			message: &Message{Headers: map[string]string{}, Payload: []byte(`{}`)},
+			invalid: true,
+		},
+		{
+			name:    "lookup error",
+			mode:    EventValidationSchema,
+			message: eventMessage("dev.knative.broken", "application/json", `{}`),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateMessage(test.mode, test.message, lookup)
+			_, invalid := err.(*InvalidEventError)
+			if invalid != test.invalid {
+				t.Errorf("Unexpected rejection. want %v, got %v", test.invalid, err)
+			}
+			if (err != nil && !invalid) != test.wantErr {
+				t.Errorf("Unexpected error. want error %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+type eventValidatorFunc func(*ChannelReference, *Message) error
+
+func (f eventValidatorFunc) ValidateEvent(channel *ChannelReference, message *Message) error {
+	return f(channel, message)
+}
+
+func TestMessageReceiverValidation(t *testing.T) {
+	received := 0
+	receiver := NewMessageReceiver(func(*ChannelReference, *Message) error {
+		received++
+		return nil
+	})
+	receiver.SetEventValidator(eventValidatorFunc(func(_ *ChannelReference, message *Message) error {
+		switch message.Headers["Ce-Eventtype"] {
+		case "invalid":
+			return &InvalidEventError{Reason: "missing id"}
+		case "error":
+			return errors.New("lister unavailable")
+		}
+		return nil
+	}))
+
+	for _, test := range []struct {
+		eventType string
+		want      int
+		wantBody  string
+	}{
+		{eventType: "valid", want: http.StatusAccepted},
+		{eventType: "invalid", want: http.StatusBadRequest, wantBody: "missing id"},
+		{eventType: "error", want: http.StatusInternalServerError},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://channel.ns.channels.cluster.local/", strings.NewReader("{}"))
+		req.Header.Set("CE-EventType", test.eventType)
+		res := httptest.NewRecorder()
+		receiver.HandleRequest(res, req)
+		if res.Code != test.want {
+			t.Errorf("Unexpected status code for %q. want %d, got %d", test.eventType, test.want, res.Code)
+		}
+		if !strings.Contains(res.Body.String(), test.wantBody) {
+			t.Errorf("Unexpected response body for %q. want %q, got %q", test.eventType, test.wantBody, res.Body.String())
+		}
+	}
+	if received != 1 {
+		t.Errorf("Unexpected number of received messages. want 1, got %d", received)
+	}
+}
@@ -27,6 +27,7 @@ import (
 
 type ChannelsV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	BridgesGetter
 	BusesGetter
 	ChannelsGetter
 	ClusterBusesGetter
@@ -38,6 +39,10 @@ type ChannelsV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *ChannelsV1alpha1Client) Bridges(namespace string) BridgeInterface {
+	return newBridges(c, namespace)
+}
+
 func (c *ChannelsV1alpha1Client) Buses(namespace string) BusInterface {
 	return newBuses(c, namespace)
 }
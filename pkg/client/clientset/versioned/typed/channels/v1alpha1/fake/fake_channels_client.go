@@ -28,6 +28,10 @@ type FakeChannelsV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeChannelsV1alpha1) Bridges(namespace string) v1alpha1.BridgeInterface {
+	return &FakeBridges{c, namespace}
+}
+
 func (c *FakeChannelsV1alpha1) Buses(namespace string) v1alpha1.BusInterface {
 	return &FakeBuses{c, namespace}
 }
@@ -18,6 +18,8 @@ limitations under the License.
 
 package v1alpha1
 
+type BridgeExpansion interface{}
+
 type BusExpansion interface{}
 
 type ChannelExpansion interface{}
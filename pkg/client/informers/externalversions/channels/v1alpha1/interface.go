@@ -24,6 +24,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// Bridges returns a BridgeInformer.
+	Bridges() BridgeInformer
 	// Buses returns a BusInformer.
 	Buses() BusInformer
 	// Channels returns a ChannelInformer.
@@ -45,6 +47,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// Bridges returns a BridgeInformer.
+func (v *version) Bridges() BridgeInformer {
+	return &bridgeInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // Buses returns a BusInformer.
 func (v *version) Buses() BusInformer {
 	return &busInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -55,6 +55,8 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=channels.knative.dev, Version=v1alpha1
+	case v1alpha1.SchemeGroupVersion.WithResource("bridges"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Channels().V1alpha1().Bridges().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("buses"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Channels().V1alpha1().Buses().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("channels"):
@@ -18,6 +18,14 @@ limitations under the License.
 
 package v1alpha1
 
+// BridgeListerExpansion allows custom methods to be added to
+// BridgeLister.
+type BridgeListerExpansion interface{}
+
+// BridgeNamespaceListerExpansion allows custom methods to be added to
+// BridgeNamespaceLister.
+type BridgeNamespaceListerExpansion interface{}
+
 // BusListerExpansion allows custom methods to be added to
 // BusLister.
 type BusListerExpansion interface{}
@@ -0,0 +1,678 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	bridgeruntime "github.com/knative/eventing/pkg/bridge"
+	"github.com/knative/eventing/pkg/controller"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
+	channelscheme "github.com/knative/eventing/pkg/client/clientset/versioned/scheme"
+	informers "github.com/knative/eventing/pkg/client/informers/externalversions"
+	listers "github.com/knative/eventing/pkg/client/listers/channels/v1alpha1"
+
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	servinginformers "github.com/knative/serving/pkg/client/informers/externalversions"
+
+	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/controller/util"
+)
+
+const controllerAgentName = "bridge-controller"
+
+// EnvBridgeImage is the environment variable holding the image of the bridge
+// deployments.
+const EnvBridgeImage = "BRIDGE_IMAGE"
+
+const (
+	// SuccessSynced is used as part of the Event 'reason' when a Bridge is synced
+	SuccessSynced = "Synced"
+	// ErrResourceExists is used as part of the Event 'reason' when a Bridge fails
+	// to sync due to a resource of the same name already existing.
+	ErrResourceExists = "ErrResourceExists"
+
+	// MessageResourceExists is the message used for Events when a resource
+	// fails to sync due to a resource already existing
+	MessageResourceExists = "Resource %q already exists and is not managed by Bridge"
+	// MessageResourceSynced is the message used for an Event fired when a Bridge
+	// is synced successfully
+	MessageResourceSynced = "Bridge synced successfully"
+)
+
+const (
+	// DeploymentSynced is used as part of the condition reason when the bridge deployment is successfully created.
+	DeploymentSynced = "DeploymentSynced"
+	// DeploymentError is used as part of the condition reason when the bridge deployment creation failed.
+	DeploymentError = "DeploymentError"
+	// SubscriptionSynced is used as part of the condition reason when the bridge subscription is successfully created.
+	SubscriptionSynced = "SubscriptionSynced"
+	// SubscriptionError is used as part of the condition reason when the bridge subscription creation failed.
+	SubscriptionError = "SubscriptionError"
+)
+
+// Controller is the controller implementation for Bridge resources
+type Controller struct {
+	// kubeclientset is a standard kubernetes clientset
+	kubeclientset kubernetes.Interface
+	// bridgeclientset is a clientset for our own API group
+	bridgeclientset clientset.Interface
+
+	// image is the image of the bridge deployments
+	image string
+
+	deploymentsLister   appslisters.DeploymentLister
+	deploymentsSynced   cache.InformerSynced
+	servicesLister      corelisters.ServiceLister
+	servicesSynced      cache.InformerSynced
+	subscriptionsLister listers.SubscriptionLister
+	subscriptionsSynced cache.InformerSynced
+	channelsLister      listers.ChannelLister
+	channelsSynced      cache.InformerSynced
+	bridgesLister       listers.BridgeLister
+	bridgesSynced       cache.InformerSynced
+
+	// workqueue is a rate limited work queue. This is used to queue work to be
+	// processed instead of performing it as soon as a change happens. This
+	// means we can ensure we only process a fixed amount of resources at a
+	// time, and makes it easy to ensure we are never processing the same item
+	// simultaneously in two different workers.
+	workqueue workqueue.RateLimitingInterface
+	// recorder is an event recorder for recording Event resources to the
+	// Kubernetes API.
+	recorder record.EventRecorder
+}
+
+// NewController returns a new bridge controller
+func NewController(
+	kubeclientset kubernetes.Interface,
+	bridgeclientset clientset.Interface,
+	servingclientset servingclientset.Interface,
+	restConfig *rest.Config,
+	kubeInformerFactory kubeinformers.SharedInformerFactory,
+	bridgeInformerFactory informers.SharedInformerFactory,
+	routeInformerFactory servinginformers.SharedInformerFactory) controller.Interface {
+
+	// obtain references to shared index informers for the Bridge, Channel,
+	// Subscription, Deployment and Service types.
+	bridgeInformer := bridgeInformerFactory.Channels().V1alpha1().Bridges()
+	channelInformer := bridgeInformerFactory.Channels().V1alpha1().Channels()
+	subscriptionInformer := bridgeInformerFactory.Channels().V1alpha1().Subscriptions()
+	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	serviceInformer := kubeInformerFactory.Core().V1().Services()
+
+	// Create event broadcaster
+	// Add bridge-controller types to the default Kubernetes Scheme so Events can be
+	// logged for bridge-controller types.
+	channelscheme.AddToScheme(scheme.Scheme)
+	glog.V(4).Info("Creating event broadcaster")
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+
+	controller := &Controller{
+		kubeclientset:       kubeclientset,
+		bridgeclientset:     bridgeclientset,
+		image:               os.Getenv(EnvBridgeImage),
+		deploymentsLister:   deploymentInformer.Lister(),
+		deploymentsSynced:   deploymentInformer.Informer().HasSynced,
+		servicesLister:      serviceInformer.Lister(),
+		servicesSynced:      serviceInformer.Informer().HasSynced,
+		subscriptionsLister: subscriptionInformer.Lister(),
+		subscriptionsSynced: subscriptionInformer.Informer().HasSynced,
+		channelsLister:      channelInformer.Lister(),
+		channelsSynced:      channelInformer.Informer().HasSynced,
+		bridgesLister:       bridgeInformer.Lister(),
+		bridgesSynced:       bridgeInformer.Informer().HasSynced,
+		workqueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Bridges"),
+		recorder:            recorder,
+	}
+
+	glog.Info("Setting up event handlers")
+	// Set up an event handler for when Bridge resources change
+	bridgeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueBridge,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueBridge(new)
+		},
+	})
+	// Set up an event handler for when Deployment resources change. This
+	// handler will lookup the owner of the given Deployment, and if it is
+	// owned by a Bridge resource will enqueue that Bridge resource for
+	// processing.
+	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newDeployment := new.(*appsv1.Deployment)
+			oldDeployment := old.(*appsv1.Deployment)
+			if newDeployment.ResourceVersion == oldDeployment.ResourceVersion {
+				// Periodic resync will send update events for all known Deployments.
+				// Two different versions of the same Deployment will always have different RVs.
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+	// Set up an event handler for when Subscription resources are deleted,
+	// so the Bridge's Subscription is recreated.
+	subscriptionInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: controller.handleObject,
+	})
+
+	return controller
+}
+
+// Run will set up the event handlers for types we are interested in, as well
+// as syncing informer caches and starting workers. It will block until stopCh
+// is closed, at which point it will shutdown the workqueue and wait for
+// workers to finish processing their current work items.
+func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer runtime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	// Start the informer factories to begin populating the informer caches
+	glog.Info("Starting Bridge controller")
+
+	// Wait for the caches to be synced before starting workers
+	glog.Info("Waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.servicesSynced, c.subscriptionsSynced, c.channelsSynced, c.bridgesSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	glog.Info("Starting workers")
+	// Launch workers to process Bridge resources
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	glog.Info("Started workers")
+	<-stopCh
+	glog.Info("Shutting down workers")
+
+	return nil
+}
+
+// runWorker is a long-running function that will continually call the
+// processNextWorkItem function in order to read and process a message on the
+// workqueue.
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+// processNextWorkItem will read a single work item off the workqueue and
+// attempt to process it, by calling the syncHandler.
+func (c *Controller) processNextWorkItem() bool {
+	obj, shutdown := c.workqueue.Get()
+
+	if shutdown {
+		return false
+	}
+
+	// We wrap this block in a func so we can defer c.workqueue.Done.
+	err := func(obj interface{}) error {
+		// We call Done here so the workqueue knows we have finished
+		// processing this item. We also must remember to call Forget if we
+		// do not want this work item being re-queued.
+		defer c.workqueue.Done(obj)
+		var key string
+		var ok bool
+		// We expect strings to come off the workqueue. These are of the
+		// form namespace/name.
+		if key, ok = obj.(string); !ok {
+			// As the item in the workqueue is actually invalid, we call
+			// Forget here else we'd go into a loop of attempting to
+			// process a work item that is invalid.
+			c.workqueue.Forget(obj)
+			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+		// Run the syncHandler, passing it the namespace/name string of the
+		// Bridge resource to be synced.
+		if err := c.syncHandler(key); err != nil {
+			return fmt.Errorf("error syncing bridge '%s': %s", key, err.Error())
+		}
+		// Finally, if no error occurs we Forget this item so it does not
+		// get queued again until another change happens.
+		c.workqueue.Forget(obj)
+		glog.Infof("Successfully synced bridge '%s'", key)
+		return nil
+	}(obj)
+
+	if err != nil {
+		runtime.HandleError(err)
+		// requeue with a backoff, the target Channel may not have an
+		// address yet
+		c.workqueue.AddRateLimited(obj)
+		return true
+	}
+
+	return true
+}
+
+// syncHandler compares the actual state with the desired, and attempts to
+// converge the two. It then updates the Status block of the Bridge resource
+// with the current status of the resource.
+func (c *Controller) syncHandler(key string) error {
+	// Convert the namespace/name string into a distinct namespace and name
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	// Get the Bridge resource with this namespace/name
+	bridge, err := c.bridgesLister.Bridges(namespace).Get(name)
+	if err != nil {
+		// The Bridge resource may no longer exist, in which case we stop
+		// processing.
+		if errors.IsNotFound(err) {
+			runtime.HandleError(fmt.Errorf("bridge '%s' in work queue no longer exists", key))
+			return nil
+		}
+
+		return err
+	}
+
+	var deployment *appsv1.Deployment
+	var subscription *channelsv1alpha1.Subscription
+	var deploymentErr, subscriptionErr error
+
+	// Sync Service and Deployment derived from the Bridge
+	deploymentErr = c.syncBridgeService(bridge)
+	if deploymentErr == nil {
+		deployment, deploymentErr = c.syncBridgeDeployment(bridge)
+	}
+	if deploymentErr != nil {
+		_ = c.updateBridgeStatus(bridge, deployment, deploymentErr, subscription, subscriptionErr)
+		return deploymentErr
+	}
+
+	// Sync Subscription derived from the Bridge
+	subscription, subscriptionErr = c.syncBridgeSubscription(bridge)
+	if subscriptionErr != nil {
+		_ = c.updateBridgeStatus(bridge, deployment, deploymentErr, subscription, subscriptionErr)
+		return subscriptionErr
+	}
+
+	// Finally, we update the status block of the Bridge resource to reflect the
+	// current state of the world
+	err = c.updateBridgeStatus(bridge, deployment, deploymentErr, subscription, subscriptionErr)
+	if err != nil {
+		return err
+	}
+
+	c.recorder.Event(bridge, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+	return nil
+}
+
+func (c *Controller) syncBridgeService(bridge *channelsv1alpha1.Bridge) error {
+	// Get the service with the specified service name
+	serviceName := controller.BridgeServiceName(bridge.ObjectMeta.Name)
+	service, err := c.servicesLister.Services(bridge.Namespace).Get(serviceName)
+	// If the resource doesn't exist, we'll create it
+	if errors.IsNotFound(err) {
+		service, err = c.kubeclientset.CoreV1().Services(bridge.Namespace).Create(newService(bridge))
+	}
+
+	// If an error occurs during Get/Create, we'll requeue the item so we can
+	// attempt processing again later.
+	if err != nil {
+		return err
+	}
+
+	// If the Service is not controlled by this Bridge resource, we should log
+	// a warning to the event recorder and return
+	if !metav1.IsControlledBy(service, bridge) {
+		msg := fmt.Sprintf(MessageResourceExists, service.Name)
+		c.recorder.Event(bridge, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(MessageResourceExists, service.Name)
+	}
+
+	return nil
+}
+
+func (c *Controller) syncBridgeDeployment(bridge *channelsv1alpha1.Bridge) (*appsv1.Deployment, error) {
+	if len(c.image) == 0 {
+		return nil, fmt.Errorf("the bridge image is not configured, %s must be set", EnvBridgeImage)
+	}
+	target, err := c.resolveTarget(bridge)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the deployment with the specified deployment name
+	deploymentName := controller.BridgeDeploymentName(bridge.ObjectMeta.Name)
+	deployment, err := c.deploymentsLister.Deployments(bridge.Namespace).Get(deploymentName)
+	// If the resource doesn't exist, we'll create it
+	if errors.IsNotFound(err) {
+		deployment, err = c.kubeclientset.AppsV1().Deployments(bridge.Namespace).Create(newDeployment(bridge, c.image, target))
+	}
+
+	// If an error occurs during Get/Create, we'll requeue the item so we can
+	// attempt processing again later.
+	if err != nil {
+		return nil, err
+	}
+
+	// If the Deployment is not controlled by this Bridge resource, we should log
+	// a warning to the event recorder and return
+	if !metav1.IsControlledBy(deployment, bridge) {
+		msg := fmt.Sprintf(MessageResourceExists, deployment.Name)
+		c.recorder.Event(bridge, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return nil, fmt.Errorf(MessageResourceExists, deployment.Name)
+	}
+
+	// If the Deployment does not match the Bridge's proposed Deployment we should update
+	// the Deployment resource.
+	proposedDeployment := newDeployment(bridge, c.image, target)
+	if !reflect.DeepEqual(proposedDeployment.Spec, deployment.Spec) {
+		glog.V(4).Infof("Bridge %s deployment spec updated", bridge.Name)
+		deployment, err = c.kubeclientset.AppsV1().Deployments(bridge.Namespace).Update(proposedDeployment)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return deployment, nil
+}
+
+func (c *Controller) syncBridgeSubscription(bridge *channelsv1alpha1.Bridge) (*channelsv1alpha1.Subscription, error) {
+	// Get the subscription with the specified subscription name
+	subscriptionName := controller.BridgeSubscriptionName(bridge.ObjectMeta.Name)
+	subscription, err := c.subscriptionsLister.Subscriptions(bridge.Namespace).Get(subscriptionName)
+	// If the resource doesn't exist, we'll create it
+	if errors.IsNotFound(err) {
+		subscription, err = c.bridgeclientset.ChannelsV1alpha1().Subscriptions(bridge.Namespace).Create(newSubscription(bridge))
+	}
+
+	// If an error occurs during Get/Create, we'll requeue the item so we can
+	// attempt processing again later.
+	if err != nil {
+		return nil, err
+	}
+
+	// If the Subscription is not controlled by this Bridge resource, we should log
+	// a warning to the event recorder and return
+	if !metav1.IsControlledBy(subscription, bridge) {
+		msg := fmt.Sprintf(MessageResourceExists, subscription.Name)
+		c.recorder.Event(bridge, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return nil, fmt.Errorf(MessageResourceExists, subscription.Name)
+	}
+
+	return subscription, nil
+}
+
+// resolveTarget returns the address the Bridge republishes messages to.
+func (c *Controller) resolveTarget(bridge *channelsv1alpha1.Bridge) (string, error) {
+	ref := bridge.Spec.Target.Channel
+	if ref == nil {
+		return bridge.Spec.Target.URL, nil
+	}
+
+	namespace := ref.Namespace
+	if len(namespace) == 0 {
+		namespace = bridge.Namespace
+	}
+	channel, err := c.channelsLister.Channels(namespace).Get(ref.Name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", fmt.Errorf("target channel %s/%s does not exist", namespace, ref.Name)
+		}
+		return "", err
+	}
+	if len(channel.Status.DomainInternal) == 0 {
+		return "", fmt.Errorf("target channel %s/%s does not have an address yet", namespace, ref.Name)
+	}
+	return channel.Status.DomainInternal, nil
+}
+
+func (c *Controller) updateBridgeStatus(
+	bridge *channelsv1alpha1.Bridge,
+	deployment *appsv1.Deployment, deploymentErr error,
+	subscription *channelsv1alpha1.Subscription, subscriptionErr error,
+) error {
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	// You can use DeepCopy() to make a deep copy of original object and modify this copy
+	// Or create a copy manually for better performance
+	bridgeCopy := bridge.DeepCopy()
+
+	if deployment != nil {
+		dispatchCondition := util.NewBridgeCondition(channelsv1alpha1.BridgeDispatching, corev1.ConditionTrue, DeploymentSynced, "deployment successfully synced")
+		util.SetBridgeCondition(&bridgeCopy.Status, *dispatchCondition)
+	} else if deploymentErr != nil {
+		dispatchCondition := util.NewBridgeCondition(channelsv1alpha1.BridgeDispatching, corev1.ConditionFalse, DeploymentError, deploymentErr.Error())
+		util.SetBridgeCondition(&bridgeCopy.Status, *dispatchCondition)
+	}
+
+	if subscription != nil {
+		subscribeCondition := util.NewBridgeCondition(channelsv1alpha1.BridgeSubscribed, corev1.ConditionTrue, SubscriptionSynced, "subscription successfully synced")
+		util.SetBridgeCondition(&bridgeCopy.Status, *subscribeCondition)
+	} else if subscriptionErr != nil {
+		subscribeCondition := util.NewBridgeCondition(channelsv1alpha1.BridgeSubscribed, corev1.ConditionFalse, SubscriptionError, subscriptionErr.Error())
+		util.SetBridgeCondition(&bridgeCopy.Status, *subscribeCondition)
+	}
+
+	util.ConsolidateBridgeCondition(bridgeCopy)
+
+	if reflect.DeepEqual(bridge.Status, bridgeCopy.Status) {
+		return nil
+	}
+
+	// If the CustomResourceSubresources feature gate is not enabled,
+	// we must use Update instead of UpdateStatus to update the Status block of the Bridge resource.
+	_, err := c.bridgeclientset.ChannelsV1alpha1().Bridges(bridge.Namespace).Update(bridgeCopy)
+	return err
+}
+
+// enqueueBridge takes a Bridge resource and converts it into a namespace/name
+// string which is then put onto the work queue. This method should *not* be
+// passed resources of any type other than Bridge.
+func (c *Controller) enqueueBridge(obj interface{}) {
+	var key string
+	var err error
+	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.workqueue.AddRateLimited(key)
+}
+
+// handleObject will take any resource implementing metav1.Object and attempt
+// to find the Bridge resource that 'owns' it. It does this by looking at the
+// objects metadata.ownerReferences field for an appropriate OwnerReference.
+// It then enqueues that Bridge resource to be processed. If the object does not
+// have an appropriate OwnerReference, it will simply be skipped.
+func (c *Controller) handleObject(obj interface{}) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+		glog.V(4).Infof("Recovered deleted object '%s' from tombstone", object.GetName())
+	}
+	glog.V(4).Infof("Processing object: %s", object.GetName())
+	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
+		// If this object is not owned by a Bridge, we should not do anything more
+		// with it.
+		if ownerRef.Kind != "Bridge" {
+			return
+		}
+
+		bridge, err := c.bridgesLister.Bridges(object.GetNamespace()).Get(ownerRef.Name)
+		if err != nil {
+			glog.V(4).Infof("ignoring orphaned object '%s' of bridge '%s'", object.GetSelfLink(), ownerRef.Name)
+			return
+		}
+
+		c.enqueueBridge(bridge)
+		return
+	}
+}
+
+func newOwnerReference(bridge *channelsv1alpha1.Bridge) metav1.OwnerReference {
+	return *metav1.NewControllerRef(bridge, schema.GroupVersionKind{
+		Group:   channelsv1alpha1.SchemeGroupVersion.Group,
+		Version: channelsv1alpha1.SchemeGroupVersion.Version,
+		Kind:    "Bridge",
+	})
+}
+
+// newService creates a new Service for a Bridge resource. The Bridge's
+// Subscription delivers messages to the Service.
+func newService(bridge *channelsv1alpha1.Bridge) *corev1.Service {
+	labels := map[string]string{
+		"bridge": bridge.Name,
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            controller.BridgeServiceName(bridge.ObjectMeta.Name),
+			Namespace:       bridge.Namespace,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{newOwnerReference(bridge)},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Port:       80,
+					TargetPort: intstr.FromInt(8080),
+				},
+			},
+		},
+	}
+}
+
+// newDeployment creates a new Deployment for a Bridge resource republishing
+// messages to the target address.
+func newDeployment(bridge *channelsv1alpha1.Bridge, image, target string) *appsv1.Deployment {
+	labels := map[string]string{
+		"bridge": bridge.Name,
+	}
+	replicas := int32(1)
+	maxHops := int32(bridgeruntime.DefaultMaxHops)
+	if bridge.Spec.MaxHops != nil {
+		maxHops = *bridge.Spec.MaxHops
+	}
+	env := []corev1.EnvVar{
+		{
+			Name:  bridgeruntime.EnvTarget,
+			Value: target,
+		},
+		{
+			Name:  bridgeruntime.EnvMaxHops,
+			Value: strconv.Itoa(int(maxHops)),
+		},
+	}
+	if secret := bridge.Spec.Target.CredentialsSecret; secret != nil {
+		env = append(env, corev1.EnvVar{
+			Name: bridgeruntime.EnvToken,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: *secret,
+					Key:                  "token",
+				},
+			},
+		})
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            controller.BridgeDeploymentName(bridge.ObjectMeta.Name),
+			Namespace:       bridge.Namespace,
+			OwnerReferences: []metav1.OwnerReference{newOwnerReference(bridge)},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"sidecar.istio.io/inject": "true",
+					},
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "bridge",
+							Image: image,
+							Args: []string{
+								"-logtostderr",
+								"-stderrthreshold", "INFO",
+							},
+							Env: env,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// newSubscription creates a new Subscription of a Bridge resource's Channel
+// delivering messages to the Bridge's Service.
+func newSubscription(bridge *channelsv1alpha1.Bridge) *channelsv1alpha1.Subscription {
+	return &channelsv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            controller.BridgeSubscriptionName(bridge.ObjectMeta.Name),
+			Namespace:       bridge.Namespace,
+			OwnerReferences: []metav1.OwnerReference{newOwnerReference(bridge)},
+		},
+		Spec: channelsv1alpha1.SubscriptionSpec{
+			Channel:    bridge.Spec.Channel,
+			Subscriber: controller.ServiceHostName(controller.BridgeServiceName(bridge.Name), bridge.Namespace),
+		},
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package bus
 
 import (
+	goerrors "errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -24,6 +25,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/knative/eventing/pkg/controller"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1beta1 "k8s.io/api/rbac/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -37,6 +39,7 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	rbaclisters "k8s.io/client-go/listers/rbac/v1beta1"
 	"k8s.io/client-go/rest"
@@ -92,6 +95,8 @@ type Controller struct {
 	busclientset clientset.Interface
 
 	deploymentsLister         appslisters.DeploymentLister
+	autoscalersLister         autoscalinglisters.HorizontalPodAutoscalerLister
+	autoscalersSynced         cache.InformerSynced
 	deploymentsSynced         cache.InformerSynced
 	servicesLister            corelisters.ServiceLister
 	servicesSynced            cache.InformerSynced
@@ -127,6 +132,7 @@ func NewController(
 	// types.
 	busInformer := busInformerFactory.Channels().V1alpha1().Buses()
 	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	autoscalerInformer := kubeInformerFactory.Autoscaling().V1().HorizontalPodAutoscalers()
 	serviceInformer := kubeInformerFactory.Core().V1().Services()
 	serviceAccountInformer := kubeInformerFactory.Core().V1().ServiceAccounts()
 	clusterRoleBindingInformer := kubeInformerFactory.Rbac().V1beta1().ClusterRoleBindings()
@@ -145,6 +151,8 @@ func NewController(
 		kubeclientset:             kubeclientset,
 		busclientset:              busclientset,
 		deploymentsLister:         deploymentInformer.Lister(),
+		autoscalersLister:         autoscalerInformer.Lister(),
+		autoscalersSynced:         autoscalerInformer.Informer().HasSynced,
 		deploymentsSynced:         deploymentInformer.Informer().HasSynced,
 		servicesLister:            serviceInformer.Lister(),
 		servicesSynced:            serviceInformer.Informer().HasSynced,
@@ -203,7 +211,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.servicesSynced, c.busesSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.autoscalersSynced, c.servicesSynced, c.busesSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -359,6 +367,11 @@ func (c *Controller) syncHandler(key string) error {
 		return dispatcherDeplErr
 	}
 
+	// Sync HorizontalPodAutoscaler derived from the Bus
+	if err := c.syncBusDispatcherAutoscaler(bus); err != nil {
+		return err
+	}
+
 	// Sync Deployment derived from the Bus
 	provisionerDeployment, provisionerDeplError = c.syncBusProvisionerDeployment(bus)
 	if provisionerDeplError != nil {
@@ -441,6 +454,10 @@ func (c *Controller) syncBusDispatcherDeployment(bus *channelsv1alpha1.Bus) (*ap
 	// If the Deployment does not match the Bus's proposed Deployment we should update
 	// the Deployment resource.
 	proposedDeployment := newDispatcherDeployment(bus)
+	if bus.Spec.DispatcherAutoscaling != nil {
+		// the HorizontalPodAutoscaler owns the replica count
+		proposedDeployment.Spec.Replicas = deployment.Spec.Replicas
+	}
 	if !reflect.DeepEqual(proposedDeployment.Spec, deployment.Spec) {
 		glog.V(4).Infof("Bus %s dispatcher spec updated", bus.Name)
 		deployment, err = c.kubeclientset.AppsV1().Deployments(bus.Namespace).Update(proposedDeployment)
@@ -520,6 +537,56 @@ func (c *Controller) syncBusClusterRoleBinding(bus *channelsv1alpha1.Bus) (*rbac
 	return clusterRoleBinding, nil
 }
 
+func (c *Controller) syncBusDispatcherAutoscaler(bus *channelsv1alpha1.Bus) error {
+	// Get the autoscaler with the specified autoscaler name
+	autoscalerName := controller.BusDispatcherDeploymentName(bus.ObjectMeta.Name)
+	autoscaler, err := c.autoscalersLister.HorizontalPodAutoscalers(bus.Namespace).Get(autoscalerName)
+
+	// If the resource shouldn't exists
+	if bus.Spec.DispatcherAutoscaling == nil {
+		// If the resource exists and is ours, we'll delete it
+		if err == nil && metav1.IsControlledBy(autoscaler, bus) {
+			err = c.kubeclientset.AutoscalingV1().HorizontalPodAutoscalers(bus.Namespace).Delete(autoscalerName, nil)
+		}
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// If the resource doesn't exist, we'll create it
+	if errors.IsNotFound(err) {
+		autoscaler, err = c.kubeclientset.AutoscalingV1().HorizontalPodAutoscalers(bus.Namespace).Create(newDispatcherAutoscaler(bus))
+	}
+
+	// If an error occurs during Get/Create, we'll requeue the item so we can
+	// attempt processing again later. This could have been caused by a
+	// temporary network failure, or any other transient reason.
+	if err != nil {
+		return err
+	}
+
+	// If the HorizontalPodAutoscaler is not controlled by this Bus resource, we
+	// should log a warning to the event recorder and return
+	if !metav1.IsControlledBy(autoscaler, bus) {
+		msg := fmt.Sprintf(MessageResourceExists, autoscaler.Name)
+		c.recorder.Event(bus, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return goerrors.New(msg)
+	}
+
+	// If the HorizontalPodAutoscaler does not match the Bus's proposed
+	// HorizontalPodAutoscaler we should update it.
+	proposedAutoscaler := newDispatcherAutoscaler(bus)
+	if !reflect.DeepEqual(proposedAutoscaler.Spec, autoscaler.Spec) {
+		glog.V(4).Infof("Bus %s dispatcher autoscaler spec updated", bus.Name)
+		autoscaler = autoscaler.DeepCopy()
+		autoscaler.Spec = proposedAutoscaler.Spec
+		_, err = c.kubeclientset.AutoscalingV1().HorizontalPodAutoscalers(bus.Namespace).Update(autoscaler)
+	}
+
+	return err
+}
+
 func (c *Controller) syncBusProvisionerDeployment(bus *channelsv1alpha1.Bus) (*appsv1.Deployment, error) {
 	provisioner := bus.Spec.Provisioner
 
@@ -720,7 +787,13 @@ func newDispatcherDeployment(bus *channelsv1alpha1.Bus) *appsv1.Deployment {
 		"bus":  bus.Name,
 		"role": "dispatcher",
 	}
-	one := int32(1)
+	replicas := int32(1)
+	if bus.Spec.DispatcherReplicas != nil {
+		replicas = *bus.Spec.DispatcherReplicas
+	}
+	if autoscaling := bus.Spec.DispatcherAutoscaling; autoscaling != nil && autoscaling.MinReplicas != nil {
+		replicas = *autoscaling.MinReplicas
+	}
 	container := bus.Spec.Dispatcher.DeepCopy()
 	container.Env = append(container.Env,
 		corev1.EnvVar{
@@ -735,6 +808,26 @@ func newDispatcherDeployment(bus *channelsv1alpha1.Bus) *appsv1.Deployment {
 			Name:  "BUS_NAME",
 			Value: bus.Name,
 		},
+		corev1.EnvVar{
+			Name:  "BUS_ROLE",
+			Value: "dispatcher",
+		},
+		corev1.EnvVar{
+			Name:  "BUS_DISPATCHER_SERVICE",
+			Value: controller.BusDispatcherServiceName(bus.Name),
+		},
+		corev1.EnvVar{
+			Name: "POD_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			},
+		},
+		corev1.EnvVar{
+			Name: "POD_NAMESPACE",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+			},
+		},
 	)
 	volumes := []corev1.Volume{}
 	if bus.Spec.Volumes != nil {
@@ -753,7 +846,7 @@ func newDispatcherDeployment(bus *channelsv1alpha1.Bus) *appsv1.Deployment {
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &one,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -776,6 +869,44 @@ func newDispatcherDeployment(bus *channelsv1alpha1.Bus) *appsv1.Deployment {
 	}
 }
 
+// newDispatcherAutoscaler creates a new HorizontalPodAutoscaler for a Bus
+// resource's dispatcher Deployment. It also sets the appropriate OwnerReferences
+// on the resource so handleObject can discover the Bus resource that 'owns' it.
+func newDispatcherAutoscaler(bus *channelsv1alpha1.Bus) *autoscalingv1.HorizontalPodAutoscaler {
+	autoscaling := bus.Spec.DispatcherAutoscaling
+	minReplicas := int32(1)
+	if autoscaling.MinReplicas != nil {
+		minReplicas = *autoscaling.MinReplicas
+	}
+	targetCPUUtilization := int32(80)
+	if autoscaling.TargetCPUUtilizationPercentage != nil {
+		targetCPUUtilization = *autoscaling.TargetCPUUtilizationPercentage
+	}
+	return &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      controller.BusDispatcherDeploymentName(bus.ObjectMeta.Name),
+			Namespace: bus.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(bus, schema.GroupVersionKind{
+					Group:   channelsv1alpha1.SchemeGroupVersion.Group,
+					Version: channelsv1alpha1.SchemeGroupVersion.Version,
+					Kind:    "Bus",
+				}),
+			},
+		},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       controller.BusDispatcherDeploymentName(bus.ObjectMeta.Name),
+			},
+			MinReplicas:                    &minReplicas,
+			MaxReplicas:                    autoscaling.MaxReplicas,
+			TargetCPUUtilizationPercentage: &targetCPUUtilization,
+		},
+	}
+}
+
 // newServiceAccount creates a new ServiceAccount for a Bus resource. It also sets
 // the appropriate OwnerReferences on the resource so handleObject can discover
 // the Bus resource that 'owns' it.
@@ -834,7 +965,10 @@ func newProvisionerDeployment(bus *channelsv1alpha1.Bus) *appsv1.Deployment {
 		"bus":  bus.Name,
 		"role": "provisioner",
 	}
-	one := int32(1)
+	replicas := int32(1)
+	if bus.Spec.ProvisionerReplicas != nil {
+		replicas = *bus.Spec.ProvisionerReplicas
+	}
 	container := bus.Spec.Provisioner.DeepCopy()
 	container.Env = append(container.Env,
 		corev1.EnvVar{
@@ -845,6 +979,22 @@ func newProvisionerDeployment(bus *channelsv1alpha1.Bus) *appsv1.Deployment {
 			Name:  "BUS_NAME",
 			Value: bus.Name,
 		},
+		corev1.EnvVar{
+			Name:  "BUS_ROLE",
+			Value: "provisioner",
+		},
+		corev1.EnvVar{
+			Name: "POD_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			},
+		},
+		corev1.EnvVar{
+			Name: "POD_NAMESPACE",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+			},
+		},
 	)
 	volumes := []corev1.Volume{}
 	if bus.Spec.Volumes != nil {
@@ -863,7 +1013,7 @@ func newProvisionerDeployment(bus *channelsv1alpha1.Bus) *appsv1.Deployment {
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &one,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
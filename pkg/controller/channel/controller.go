@@ -26,6 +26,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -78,6 +79,12 @@ const (
 	VirtualServiceSynced = "VirtualServiceSynced"
 	// VirtualServiceError is used as part of the condition reason when the channel istio virtual service creation failed.
 	VirtualServiceError = "VirtualServiceError"
+	// BusReady is used as part of the condition reason when the bus backing the channel is ready.
+	BusReady = "BusReady"
+	// BusNotReady is used as part of the condition reason when the bus backing the channel is not ready.
+	BusNotReady = "BusNotReady"
+	// BusNotFound is used as part of the condition reason when the bus backing the channel does not exist.
+	BusNotFound = "BusNotFound"
 )
 
 const (
@@ -100,6 +107,10 @@ type Controller struct {
 	servicesSynced        cache.InformerSynced
 	channelsLister        listers.ChannelLister
 	channelsSynced        cache.InformerSynced
+	busesLister           listers.BusLister
+	busesSynced           cache.InformerSynced
+	clusterBusesLister    listers.ClusterBusLister
+	clusterBusesSynced    cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -122,10 +133,13 @@ func NewController(
 	channelInformerFactory informers.SharedInformerFactory,
 	servingInformerFactory servinginformers.SharedInformerFactory) controller.Interface {
 
-	// obtain references to shared index informers for the Service and Channel types.
+	// obtain references to shared index informers for the Service, Channel, Bus
+	// and ClusterBus types.
 	virtualserviceInformer := servingInformerFactory.Networking().V1alpha3().VirtualServices()
 	serviceInformer := kubeInformerFactory.Core().V1().Services()
 	channelInformer := channelInformerFactory.Channels().V1alpha1().Channels()
+	busInformer := channelInformerFactory.Channels().V1alpha1().Buses()
+	clusterBusInformer := channelInformerFactory.Channels().V1alpha1().ClusterBuses()
 
 	// Create event broadcaster
 	// Add channel-controller types to the default Kubernetes Scheme so Events can be
@@ -147,6 +161,10 @@ func NewController(
 		servicesSynced:        serviceInformer.Informer().HasSynced,
 		channelsLister:        channelInformer.Lister(),
 		channelsSynced:        channelInformer.Informer().HasSynced,
+		busesLister:           busInformer.Lister(),
+		busesSynced:           busInformer.Informer().HasSynced,
+		clusterBusesLister:    clusterBusInformer.Lister(),
+		clusterBusesSynced:    clusterBusInformer.Informer().HasSynced,
 		workqueue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Channels"),
 		recorder:              recorder,
 	}
@@ -179,6 +197,21 @@ func NewController(
 		},
 		DeleteFunc: controller.handleObject,
 	})
+	// Set up event handlers for when Bus and ClusterBus resources change. These
+	// handlers enqueue the Channels backed by the bus so their BusReady
+	// condition follows the bus' Ready condition.
+	busHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleBus,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() == new.(metav1.Object).GetResourceVersion() {
+				return
+			}
+			controller.handleBus(new)
+		},
+		DeleteFunc: controller.handleBus,
+	}
+	busInformer.Informer().AddEventHandler(busHandler)
+	clusterBusInformer.Informer().AddEventHandler(busHandler)
 
 	return controller
 }
@@ -196,7 +229,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.servicesSynced, c.channelsSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.servicesSynced, c.channelsSynced, c.busesSynced, c.clusterBusesSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -298,27 +331,38 @@ func (c *Controller) syncHandler(key string) error {
 		return err
 	}
 
+	// A Channel being deleted waits for its provisioner to unprovision it,
+	// which never happens once the Bus is deleted
+	if channel.DeletionTimestamp != nil && hasProvisionerFinalizer(channel) {
+		if _, err := c.getBus(channel); errors.IsNotFound(err) {
+			return c.removeProvisionerFinalizer(channel)
+		}
+	}
+
 	var service *corev1.Service
 	var virtualService *istiov1alpha3.VirtualService
 	var serviceErr, virtualServiceErr error
 
+	// Observe the Bus or ClusterBus backing the Channel
+	busCondition := c.busReadyCondition(channel)
+
 	// Sync Service derived from the Channel
 	service, serviceErr = c.syncChannelService(channel)
-	if err != nil {
-		c.updateChannelStatus(channel, service, serviceErr, virtualService, virtualServiceErr)
-		return err
+	if serviceErr != nil {
+		c.updateChannelStatus(channel, service, serviceErr, virtualService, virtualServiceErr, busCondition)
+		return serviceErr
 	}
 
 	// Sync VirtualService derived from a Channel
 	virtualService, virtualServiceErr = c.syncChannelVirtualService(channel)
 	if virtualServiceErr != nil {
-		c.updateChannelStatus(channel, service, serviceErr, virtualService, virtualServiceErr)
-		return err
+		c.updateChannelStatus(channel, service, serviceErr, virtualService, virtualServiceErr, busCondition)
+		return virtualServiceErr
 	}
 
 	// Finally, we update the status block of the Channel resource to reflect the
 	// current state of the world
-	err = c.updateChannelStatus(channel, service, serviceErr, virtualService, virtualServiceErr)
+	err = c.updateChannelStatus(channel, service, serviceErr, virtualService, virtualServiceErr, busCondition)
 	if err != nil {
 		return err
 	}
@@ -382,9 +426,60 @@ func (c *Controller) syncChannelVirtualService(channel *channelsv1alpha1.Channel
 	return virtualservice, nil
 }
 
+// busReadyCondition returns the BusReady condition of a Channel, reflecting
+// the Ready condition of the Bus or ClusterBus backing it.
+func (c *Controller) busReadyCondition(channel *channelsv1alpha1.Channel) *channelsv1alpha1.ChannelCondition {
+	bus, err := c.getBus(channel)
+	if err != nil {
+		return util.NewChannelCondition(channelsv1alpha1.ChannelBusReady, corev1.ConditionFalse, BusNotFound, err.Error())
+	}
+
+	ready := util.GetBusCondition(*bus.GetStatus(), channelsv1alpha1.BusReady)
+	if ready == nil || ready.Status != corev1.ConditionTrue {
+		message := fmt.Sprintf("bus %q is not ready", bus.GetObjectMeta().GetName())
+		return util.NewChannelCondition(channelsv1alpha1.ChannelBusReady, corev1.ConditionFalse, BusNotReady, message)
+	}
+	return util.NewChannelCondition(channelsv1alpha1.ChannelBusReady, corev1.ConditionTrue, BusReady, "bus is ready")
+}
+
+// getBus returns the Bus or ClusterBus backing a Channel.
+func (c *Controller) getBus(channel *channelsv1alpha1.Channel) (channelsv1alpha1.GenericBus, error) {
+	if len(channel.Spec.ClusterBus) != 0 {
+		return c.clusterBusesLister.Get(channel.Spec.ClusterBus)
+	}
+	return c.busesLister.Buses(channel.Namespace).Get(channel.Spec.Bus)
+}
+
+// removeProvisionerFinalizer removes the bus provisioner's finalizer from a
+// Channel whose bus no longer exists, so its deletion completes. The backing
+// resource of the Channel is left to the middleware.
+func (c *Controller) removeProvisionerFinalizer(channel *channelsv1alpha1.Channel) error {
+	glog.Infof("Removing the provisioner finalizer of channel %s/%s, its bus no longer exists", channel.Namespace, channel.Name)
+	channelCopy := channel.DeepCopy()
+	finalizers := []string{}
+	for _, finalizer := range channelCopy.Finalizers {
+		if finalizer != channelsv1alpha1.ChannelProvisionerFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	channelCopy.Finalizers = finalizers
+	_, err := c.channelclientset.ChannelsV1alpha1().Channels(channel.Namespace).Update(channelCopy)
+	return err
+}
+
+func hasProvisionerFinalizer(channel *channelsv1alpha1.Channel) bool {
+	for _, finalizer := range channel.Finalizers {
+		if finalizer == channelsv1alpha1.ChannelProvisionerFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Controller) updateChannelStatus(channel *channelsv1alpha1.Channel,
 	service *corev1.Service, serviceError error,
-	virtualService *istiov1alpha3.VirtualService, virtualServiceError error) error {
+	virtualService *istiov1alpha3.VirtualService, virtualServiceError error,
+	busCondition *channelsv1alpha1.ChannelCondition) error {
 	// NEVER modify objects from the store. It's a read-only, local cache.
 	// You can use DeepCopy() to make a deep copy of original object and modify this copy
 	// Or create a copy manually for better performance
@@ -406,15 +501,19 @@ func (c *Controller) updateChannelStatus(channel *channelsv1alpha1.Channel,
 		channelCopy.Status.VirtualService = &corev1.LocalObjectReference{Name: virtualService.Name}
 		serviceCondition := util.NewChannelCondition(channelsv1alpha1.ChannelRoutable, corev1.ConditionTrue, VirtualServiceSynced, "virtual service successfully synced")
 		util.SetChannelCondition(&channelCopy.Status, *serviceCondition)
-	} else {
+	} else if virtualServiceError != nil {
 		channelCopy.Status.VirtualService = nil
 		serviceCondition := util.NewChannelCondition(channelsv1alpha1.ChannelRoutable, corev1.ConditionFalse, VirtualServiceError, virtualServiceError.Error())
 		util.SetChannelCondition(&channelCopy.Status, *serviceCondition)
 	}
 
+	util.SetChannelCondition(&channelCopy.Status, *busCondition)
+
 	util.ConsolidateChannelCondition(&channelCopy.Status)
 
-	channelCopy.Status.DomainInternal = controller.ServiceHostName(service.Name, service.Namespace)
+	if service != nil {
+		channelCopy.Status.DomainInternal = controller.ServiceHostName(service.Name, service.Namespace)
+	}
 
 	// If the CustomResourceSubresources feature gate is not enabled,
 	// we must use Update instead of UpdateStatus to update the Status block of the Channel resource.
@@ -477,6 +576,29 @@ func (c *Controller) handleObject(obj interface{}) {
 	}
 }
 
+// handleBus takes a Bus or ClusterBus resource and enqueues the Channels it
+// backs.
+func (c *Controller) handleBus(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	bus, ok := obj.(channelsv1alpha1.GenericBus)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("error decoding bus, invalid type"))
+		return
+	}
+	channels, err := c.channelsLister.Channels(bus.GetObjectMeta().GetNamespace()).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, channel := range channels {
+		if bus.BacksChannel(channel) {
+			c.enqueueChannel(channel)
+		}
+	}
+}
+
 // newService creates a new Service for a Channel resource. It also sets
 // the appropriate OwnerReferences on the resource so handleObject can discover
 // the Channel resource that 'owns' it.
@@ -17,6 +17,7 @@ limitations under the License.
 package clusterbus
 
 import (
+	goerrors "errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -24,6 +25,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/knative/eventing/pkg/controller"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -36,6 +38,7 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -52,6 +55,7 @@ import (
 	servinginformers "github.com/knative/serving/pkg/client/informers/externalversions"
 
 	channelsv1alpha1 "github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/knative/eventing/pkg/controller/util"
 )
 
 const (
@@ -74,6 +78,17 @@ const (
 	MessageResourceSynced = "ClusterBus synced successfully"
 )
 
+const (
+	// ServiceSynced is used as part of the condition reason when the clusterbus (k8s) service is successfully created.
+	ServiceSynced = "ServiceSynced"
+	// ServiceError is used as part of the condition reason when the clusterbus (k8s) service creation failed.
+	ServiceError = "ServiceError"
+	// DeploymentSynced is used as part of the condition reason when a clusterbus deployment is successfully created.
+	DeploymentSynced = "DeploymentSynced"
+	// DeploymentError is used as part of the condition reason when a clusterbus deployment creation failed.
+	DeploymentError = "DeploymentError"
+)
+
 // Controller is the controller implementation for ClusterBus resources
 type Controller struct {
 	// kubeclientset is a standard kubernetes clientset
@@ -82,6 +97,8 @@ type Controller struct {
 	clusterbusclientset clientset.Interface
 
 	deploymentsLister  appslisters.DeploymentLister
+	autoscalersLister  autoscalinglisters.HorizontalPodAutoscalerLister
+	autoscalersSynced  cache.InformerSynced
 	deploymentsSynced  cache.InformerSynced
 	servicesLister     corelisters.ServiceLister
 	servicesSynced     cache.InformerSynced
@@ -113,6 +130,7 @@ func NewController(
 	// types.
 	clusterBusInformer := clusterBusInformerFactory.Channels().V1alpha1().ClusterBuses()
 	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	autoscalerInformer := kubeInformerFactory.Autoscaling().V1().HorizontalPodAutoscalers()
 	serviceInformer := kubeInformerFactory.Core().V1().Services()
 
 	// Create event broadcaster
@@ -129,6 +147,8 @@ func NewController(
 		kubeclientset:       kubeclientset,
 		clusterbusclientset: clusterbusclientset,
 		deploymentsLister:   deploymentInformer.Lister(),
+		autoscalersLister:   autoscalerInformer.Lister(),
+		autoscalersSynced:   autoscalerInformer.Informer().HasSynced,
 		deploymentsSynced:   deploymentInformer.Informer().HasSynced,
 		servicesLister:      serviceInformer.Lister(),
 		servicesSynced:      serviceInformer.Informer().HasSynced,
@@ -183,7 +203,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.servicesSynced, c.clusterBusesSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.autoscalersSynced, c.servicesSynced, c.clusterBusesSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -285,27 +305,51 @@ func (c *Controller) syncHandler(key string) error {
 		return err
 	}
 
+	var dispatcherService *corev1.Service
+	var dispatcherDeployment, provisionerDeployment *appsv1.Deployment
+	var dispatcherServiceErr, dispatcherDeplErr, provisionerDeplErr error
+
 	// Sync Service derived from the ClusterBus
-	dispatcherService, err := c.syncClusterBusDispatcherService(clusterBus)
-	if err != nil {
-		return err
+	dispatcherService, dispatcherServiceErr = c.syncClusterBusDispatcherService(clusterBus)
+	if dispatcherServiceErr != nil {
+		_ = c.updateClusterBusStatus(clusterBus,
+			dispatcherService, dispatcherServiceErr,
+			dispatcherDeployment, dispatcherDeplErr,
+			provisionerDeployment, provisionerDeplErr)
+		return dispatcherServiceErr
 	}
 
 	// Sync Deployment derived from the ClusterBus
-	dispatcherDeployment, err := c.syncClusterBusDispatcherDeployment(clusterBus)
-	if err != nil {
+	dispatcherDeployment, dispatcherDeplErr = c.syncClusterBusDispatcherDeployment(clusterBus)
+	if dispatcherDeplErr != nil {
+		_ = c.updateClusterBusStatus(clusterBus,
+			dispatcherService, dispatcherServiceErr,
+			dispatcherDeployment, dispatcherDeplErr,
+			provisionerDeployment, provisionerDeplErr)
+		return dispatcherDeplErr
+	}
+
+	// Sync HorizontalPodAutoscaler derived from the ClusterBus
+	if err := c.syncClusterBusDispatcherAutoscaler(clusterBus); err != nil {
 		return err
 	}
 
 	// Sync Deployment derived from the ClusterBus
-	provisionerDeployment, err := c.syncClusterBusProvisionerDeployment(clusterBus)
-	if err != nil {
-		return err
+	provisionerDeployment, provisionerDeplErr = c.syncClusterBusProvisionerDeployment(clusterBus)
+	if provisionerDeplErr != nil {
+		_ = c.updateClusterBusStatus(clusterBus,
+			dispatcherService, dispatcherServiceErr,
+			dispatcherDeployment, dispatcherDeplErr,
+			provisionerDeployment, provisionerDeplErr)
+		return provisionerDeplErr
 	}
 
 	// Finally, we update the status block of the ClusterBus resource to reflect the
 	// current state of the world
-	err = c.updateClusterBusStatus(clusterBus, dispatcherService, dispatcherDeployment, provisionerDeployment)
+	err = c.updateClusterBusStatus(clusterBus,
+		dispatcherService, dispatcherServiceErr,
+		dispatcherDeployment, dispatcherDeplErr,
+		provisionerDeployment, provisionerDeplErr)
 	if err != nil {
 		return err
 	}
@@ -368,6 +412,10 @@ func (c *Controller) syncClusterBusDispatcherDeployment(clusterBus *channelsv1al
 	// If the Deployment does not match the ClusterBus's proposed Deployment we should update
 	// the Deployment resource.
 	proposedDeployment := newDispatcherDeployment(clusterBus)
+	if clusterBus.Spec.DispatcherAutoscaling != nil {
+		// the HorizontalPodAutoscaler owns the replica count
+		proposedDeployment.Spec.Replicas = deployment.Spec.Replicas
+	}
 	if !reflect.DeepEqual(proposedDeployment.Spec, deployment.Spec) {
 		glog.V(4).Infof("ClusterBus %s dispatcher spec updated", clusterBus.Name)
 		deployment, err = c.kubeclientset.AppsV1().Deployments(system.Namespace).Update(proposedDeployment)
@@ -380,6 +428,56 @@ func (c *Controller) syncClusterBusDispatcherDeployment(clusterBus *channelsv1al
 	return deployment, nil
 }
 
+func (c *Controller) syncClusterBusDispatcherAutoscaler(clusterBus *channelsv1alpha1.ClusterBus) error {
+	// Get the autoscaler with the specified autoscaler name
+	autoscalerName := controller.ClusterBusDispatcherDeploymentName(clusterBus.ObjectMeta.Name)
+	autoscaler, err := c.autoscalersLister.HorizontalPodAutoscalers(system.Namespace).Get(autoscalerName)
+
+	// If the resource shouldn't exists
+	if clusterBus.Spec.DispatcherAutoscaling == nil {
+		// If the resource exists and is ours, we'll delete it
+		if err == nil && metav1.IsControlledBy(autoscaler, clusterBus) {
+			err = c.kubeclientset.AutoscalingV1().HorizontalPodAutoscalers(system.Namespace).Delete(autoscalerName, nil)
+		}
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// If the resource doesn't exist, we'll create it
+	if errors.IsNotFound(err) {
+		autoscaler, err = c.kubeclientset.AutoscalingV1().HorizontalPodAutoscalers(system.Namespace).Create(newDispatcherAutoscaler(clusterBus))
+	}
+
+	// If an error occurs during Get/Create, we'll requeue the item so we can
+	// attempt processing again later. This could have been caused by a
+	// temporary network failure, or any other transient reason.
+	if err != nil {
+		return err
+	}
+
+	// If the HorizontalPodAutoscaler is not controlled by this ClusterBus resource, we
+	// should log a warning to the event recorder and return
+	if !metav1.IsControlledBy(autoscaler, clusterBus) {
+		msg := fmt.Sprintf(MessageResourceExists, autoscaler.Name)
+		c.recorder.Event(clusterBus, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return goerrors.New(msg)
+	}
+
+	// If the HorizontalPodAutoscaler does not match the ClusterBus's proposed
+	// HorizontalPodAutoscaler we should update it.
+	proposedAutoscaler := newDispatcherAutoscaler(clusterBus)
+	if !reflect.DeepEqual(proposedAutoscaler.Spec, autoscaler.Spec) {
+		glog.V(4).Infof("ClusterBus %s dispatcher autoscaler spec updated", clusterBus.Name)
+		autoscaler = autoscaler.DeepCopy()
+		autoscaler.Spec = proposedAutoscaler.Spec
+		_, err = c.kubeclientset.AutoscalingV1().HorizontalPodAutoscalers(system.Namespace).Update(autoscaler)
+	}
+
+	return err
+}
+
 func (c *Controller) syncClusterBusProvisionerDeployment(clusterBus *channelsv1alpha1.ClusterBus) (*appsv1.Deployment, error) {
 	provisioner := clusterBus.Spec.Provisioner
 
@@ -436,14 +534,51 @@ func (c *Controller) syncClusterBusProvisionerDeployment(clusterBus *channelsv1a
 
 func (c *Controller) updateClusterBusStatus(
 	clusterBus *channelsv1alpha1.ClusterBus,
-	dispatcherService *corev1.Service,
-	dispatcherDeployment *appsv1.Deployment,
-	provisionerDeployment *appsv1.Deployment,
+	dispatcherService *corev1.Service, dispatcherServiceErr error,
+	dispatcherDeployment *appsv1.Deployment, dispatcherDeploymentErr error,
+	provisionerDeployment *appsv1.Deployment, provisionerDeploymentErr error,
 ) error {
 	// NEVER modify objects from the store. It's a read-only, local cache.
 	// You can use DeepCopy() to make a deep copy of original object and modify this copy
 	// Or create a copy manually for better performance
 	clusterBusCopy := clusterBus.DeepCopy()
+
+	// Conditions of resources that were not synced, because an earlier sync
+	// failed, are left unchanged.
+	if dispatcherService != nil {
+		clusterBusCopy.Status.Service = &corev1.LocalObjectReference{Name: dispatcherService.Name}
+		serviceCondition := util.NewBusCondition(channelsv1alpha1.BusServiceable, corev1.ConditionTrue, ServiceSynced, "service successfully synced")
+		util.SetBusCondition(&clusterBusCopy.Status, *serviceCondition)
+	} else if dispatcherServiceErr != nil {
+		clusterBusCopy.Status.Service = nil
+		serviceCondition := util.NewBusCondition(channelsv1alpha1.BusServiceable, corev1.ConditionFalse, ServiceError, dispatcherServiceErr.Error())
+		util.SetBusCondition(&clusterBusCopy.Status, *serviceCondition)
+	}
+
+	if dispatcherDeployment != nil {
+		dispatchCondition := util.NewBusCondition(channelsv1alpha1.BusDispatching, corev1.ConditionTrue, DeploymentSynced, "deployment successfully synced")
+		util.SetBusCondition(&clusterBusCopy.Status, *dispatchCondition)
+	} else if dispatcherDeploymentErr != nil {
+		dispatchCondition := util.NewBusCondition(channelsv1alpha1.BusDispatching, corev1.ConditionFalse, DeploymentError, dispatcherDeploymentErr.Error())
+		util.SetBusCondition(&clusterBusCopy.Status, *dispatchCondition)
+	}
+
+	if provisionerDeployment != nil {
+		provisionCondition := util.NewBusCondition(channelsv1alpha1.BusProvisioning, corev1.ConditionTrue, DeploymentSynced, "deployment successfully synced")
+		util.SetBusCondition(&clusterBusCopy.Status, *provisionCondition)
+	} else if provisionerDeploymentErr != nil {
+		provisionCondition := util.NewBusCondition(channelsv1alpha1.BusProvisioning, corev1.ConditionFalse, DeploymentError, provisionerDeploymentErr.Error())
+		util.SetBusCondition(&clusterBusCopy.Status, *provisionCondition)
+	} else if clusterBus.Spec.Provisioner == nil {
+		util.RemoveBusCondition(&clusterBusCopy.Status, channelsv1alpha1.BusProvisioning)
+	}
+
+	util.ConsolidateBusCondition(clusterBusCopy)
+
+	if reflect.DeepEqual(clusterBus.Status, clusterBusCopy.Status) {
+		return nil
+	}
+
 	// If the CustomResourceSubresources feature gate is not enabled,
 	// we must use Update instead of UpdateStatus to update the Status block of the ClusterBus resource.
 	// UpdateStatus will not allow changes to the Spec of the resource,
@@ -547,7 +682,13 @@ func newDispatcherDeployment(clusterBus *channelsv1alpha1.ClusterBus) *appsv1.De
 		"clusterBus": clusterBus.Name,
 		"role":       "dispatcher",
 	}
-	one := int32(1)
+	replicas := int32(1)
+	if clusterBus.Spec.DispatcherReplicas != nil {
+		replicas = *clusterBus.Spec.DispatcherReplicas
+	}
+	if autoscaling := clusterBus.Spec.DispatcherAutoscaling; autoscaling != nil && autoscaling.MinReplicas != nil {
+		replicas = *autoscaling.MinReplicas
+	}
 	container := clusterBus.Spec.Dispatcher.DeepCopy()
 	container.Env = append(container.Env,
 		corev1.EnvVar{
@@ -558,6 +699,26 @@ func newDispatcherDeployment(clusterBus *channelsv1alpha1.ClusterBus) *appsv1.De
 			Name:  "BUS_NAME",
 			Value: clusterBus.Name,
 		},
+		corev1.EnvVar{
+			Name:  "BUS_ROLE",
+			Value: "dispatcher",
+		},
+		corev1.EnvVar{
+			Name:  "BUS_DISPATCHER_SERVICE",
+			Value: controller.ClusterBusDispatcherServiceName(clusterBus.Name),
+		},
+		corev1.EnvVar{
+			Name: "POD_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			},
+		},
+		corev1.EnvVar{
+			Name: "POD_NAMESPACE",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+			},
+		},
 	)
 	volumes := []corev1.Volume{}
 	if clusterBus.Spec.Volumes != nil {
@@ -576,7 +737,7 @@ func newDispatcherDeployment(clusterBus *channelsv1alpha1.ClusterBus) *appsv1.De
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &one,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -599,6 +760,44 @@ func newDispatcherDeployment(clusterBus *channelsv1alpha1.ClusterBus) *appsv1.De
 	}
 }
 
+// newDispatcherAutoscaler creates a new HorizontalPodAutoscaler for a ClusterBus
+// resource's dispatcher Deployment. It also sets the appropriate OwnerReferences
+// on the resource so handleObject can discover the ClusterBus resource that 'owns' it.
+func newDispatcherAutoscaler(clusterBus *channelsv1alpha1.ClusterBus) *autoscalingv1.HorizontalPodAutoscaler {
+	autoscaling := clusterBus.Spec.DispatcherAutoscaling
+	minReplicas := int32(1)
+	if autoscaling.MinReplicas != nil {
+		minReplicas = *autoscaling.MinReplicas
+	}
+	targetCPUUtilization := int32(80)
+	if autoscaling.TargetCPUUtilizationPercentage != nil {
+		targetCPUUtilization = *autoscaling.TargetCPUUtilizationPercentage
+	}
+	return &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      controller.ClusterBusDispatcherDeploymentName(clusterBus.ObjectMeta.Name),
+			Namespace: system.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(clusterBus, schema.GroupVersionKind{
+					Group:   channelsv1alpha1.SchemeGroupVersion.Group,
+					Version: channelsv1alpha1.SchemeGroupVersion.Version,
+					Kind:    "ClusterBus",
+				}),
+			},
+		},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       controller.ClusterBusDispatcherDeploymentName(clusterBus.ObjectMeta.Name),
+			},
+			MinReplicas:                    &minReplicas,
+			MaxReplicas:                    autoscaling.MaxReplicas,
+			TargetCPUUtilizationPercentage: &targetCPUUtilization,
+		},
+	}
+}
+
 // newProvisionerDeployment creates a new Deployment for a ClusterBus resource. It also sets
 // the appropriate OwnerReferences on the resource so handleObject can discover
 // the ClusterBus resource that 'owns' it.
@@ -607,13 +806,32 @@ func newProvisionerDeployment(clusterBus *channelsv1alpha1.ClusterBus) *appsv1.D
 		"clusterBus": clusterBus.Name,
 		"role":       "provisioner",
 	}
-	one := int32(1)
+	replicas := int32(1)
+	if clusterBus.Spec.ProvisionerReplicas != nil {
+		replicas = *clusterBus.Spec.ProvisionerReplicas
+	}
 	container := clusterBus.Spec.Provisioner.DeepCopy()
 	container.Env = append(container.Env,
 		corev1.EnvVar{
 			Name:  "BUS_NAME",
 			Value: clusterBus.Name,
 		},
+		corev1.EnvVar{
+			Name:  "BUS_ROLE",
+			Value: "provisioner",
+		},
+		corev1.EnvVar{
+			Name: "POD_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			},
+		},
+		corev1.EnvVar{
+			Name: "POD_NAMESPACE",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+			},
+		},
 	)
 	volumes := []corev1.Volume{}
 	if clusterBus.Spec.Volumes != nil {
@@ -632,7 +850,7 @@ func newProvisionerDeployment(clusterBus *channelsv1alpha1.ClusterBus) *appsv1.D
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &one,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
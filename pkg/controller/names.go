@@ -65,3 +65,15 @@ func ChannelHostName(channelName, namespace string) string {
 func ServiceHostName(serviceName, namespace string) string {
 	return fmt.Sprintf("%s.%s.svc.cluster.local", serviceName, namespace)
 }
+
+func BridgeDeploymentName(bridgeName string) string {
+	return fmt.Sprintf("%s-bridge", bridgeName)
+}
+
+func BridgeServiceName(bridgeName string) string {
+	return fmt.Sprintf("%s-bridge", bridgeName)
+}
+
+func BridgeSubscriptionName(bridgeName string) string {
+	return fmt.Sprintf("%s-bridge", bridgeName)
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package util
+
+import (
+	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NewBridgeCondition creates a new bridge condition with the provided values and both times set to now().
+func NewBridgeCondition(condType v1alpha1.BridgeConditionType, status v1.ConditionStatus, reason, message string) *v1alpha1.BridgeCondition {
+	return &v1alpha1.BridgeCondition{
+		Type:               condType,
+		Status:             status,
+		LastUpdateTime:     meta_v1.Now(),
+		LastTransitionTime: meta_v1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// GetBridgeCondition returns the bridge condition with the provided type.
+func GetBridgeCondition(status v1alpha1.BridgeStatus, condType v1alpha1.BridgeConditionType) *v1alpha1.BridgeCondition {
+	for i := range status.Conditions {
+		c := status.Conditions[i]
+		if c.Type == condType {
+			return &c
+		}
+	}
+	return nil
+}
+
+// SetBridgeCondition updates the bridge status to include the provided condition. If the condition that
+// we are about to add already exists and has the same status and reason then no update happens.
+func SetBridgeCondition(status *v1alpha1.BridgeStatus, condition v1alpha1.BridgeCondition) {
+	currentCond := GetBridgeCondition(*status, condition.Type)
+	if currentCond != nil && currentCond.Status == condition.Status && currentCond.Reason == condition.Reason {
+		return
+	}
+	// Do not update lastTransitionTime if the status of the condition doesn't change.
+	if currentCond != nil && currentCond.Status == condition.Status {
+		condition.LastTransitionTime = currentCond.LastTransitionTime
+	}
+	newConditions := filterOutBridgeCondition(status.Conditions, condition.Type)
+	status.Conditions = append(newConditions, condition)
+}
+
+// ConsolidateBridgeCondition computes and sets the overall "Ready" condition of the bridge
+// given all other sub-conditions.
+func ConsolidateBridgeCondition(bridge *v1alpha1.Bridge) {
+	subscribed := GetBridgeCondition(bridge.Status, v1alpha1.BridgeSubscribed)
+	dispatching := GetBridgeCondition(bridge.Status, v1alpha1.BridgeDispatching)
+
+	var cond *v1alpha1.BridgeCondition
+
+	if subscribed != nil && subscribed.Status == v1.ConditionTrue &&
+		dispatching != nil && dispatching.Status == v1.ConditionTrue {
+		cond = NewBridgeCondition(v1alpha1.BridgeReady, v1.ConditionTrue, "", "")
+	} else {
+		cond = NewBridgeCondition(v1alpha1.BridgeReady, v1.ConditionFalse, "", "")
+	}
+	SetBridgeCondition(&bridge.Status, *cond)
+}
+
+// filterOutBridgeCondition returns a new slice of bridge conditions without conditions with the provided type.
+func filterOutBridgeCondition(conditions []v1alpha1.BridgeCondition, condType v1alpha1.BridgeConditionType) []v1alpha1.BridgeCondition {
+	var newConditions []v1alpha1.BridgeCondition
+	for _, c := range conditions {
+		if c.Type == condType {
+			continue
+		}
+		newConditions = append(newConditions, c)
+	}
+	return newConditions
+}
@@ -66,13 +66,14 @@ func RemoveBusCondition(status *v1alpha1.BusStatus, condType v1alpha1.BusConditi
 	status.Conditions = filterOutBusCondition(status.Conditions, condType)
 }
 
-// ConsolidateBusCondition computes and sets the overall "Ready" condition of the bus
-// given all other sub-conditions.
-func ConsolidateBusCondition(bus *v1alpha1.Bus) {
-	dispatching := GetBusCondition(bus.Status, v1alpha1.BusDispatching)
-	provisioning := GetBusCondition(bus.Status, v1alpha1.BusProvisioning)
-	serviceable := GetBusCondition(bus.Status, v1alpha1.BusServiceable)
-	needsProvitioner := bus.Spec.Provisioner != nil
+// ConsolidateBusCondition computes and sets the overall "Ready" condition of the bus or
+// cluster bus given all other sub-conditions.
+func ConsolidateBusCondition(bus v1alpha1.GenericBus) {
+	status := bus.GetStatus()
+	dispatching := GetBusCondition(*status, v1alpha1.BusDispatching)
+	provisioning := GetBusCondition(*status, v1alpha1.BusProvisioning)
+	serviceable := GetBusCondition(*status, v1alpha1.BusServiceable)
+	needsProvitioner := bus.GetSpec().Provisioner != nil
 
 	var cond *v1alpha1.BusCondition
 
@@ -83,7 +84,7 @@ func ConsolidateBusCondition(bus *v1alpha1.Bus) {
 	} else {
 		cond = NewBusCondition(v1alpha1.BusReady, v1.ConditionFalse, "", "")
 	}
-	SetBusCondition(&bus.Status, *cond)
+	SetBusCondition(status, *cond)
 }
 
 // IsBusReady returns whether all readiness conditions of a bus are met, as a boolean.
@@ -72,6 +72,7 @@ func ConsolidateChannelCondition(status *v1alpha1.ChannelStatus) {
 		v1alpha1.ChannelProvisioned,
 		v1alpha1.ChannelRoutable,
 		v1alpha1.ChannelServiceable,
+		v1alpha1.ChannelBusReady,
 	}
 	cond := NewChannelCondition(v1alpha1.ChannelReady, v1.ConditionTrue, "", "")
 	for _, t := range subConditionsTypes {
@@ -0,0 +1,128 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// ContentTypeBatchJSON is the content-type of a batch of events, a JSON array
+// of events in the JSON structured encoding.
+const ContentTypeBatchJSON = "application/cloudevents-batch+json"
+
+// Iterator reads the events of a request one at a time.
+type Iterator interface {
+	// Next decodes the data of the next event into data, which is a pointer
+	// or nil, and returns the event's context. It returns io.EOF after the
+	// last event.
+	Next(data interface{}) (*EventContext, error)
+}
+
+// IsBatch returns true if the request holds a batch of events.
+func IsBatch(r *http.Request) bool {
+	return mediaType(r.Header.Get(HeaderContentType)) == ContentTypeBatchJSON
+}
+
+// NewIterator returns an Iterator over the events of a request. A batch
+// request yields each of its events, any other request yields its single
+// event.
+func NewIterator(r *http.Request) (Iterator, error) {
+	if !IsBatch(r) {
+		return &singleIterator{request: r}, nil
+	}
+
+	var batch []map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("Could not decode batch: %v", err)
+	}
+	envelopes := make([]*structuredEnvelope, len(batch))
+	for i, attributes := range batch {
+		e, err := newStructuredEnvelope(attributes)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode event %d of batch: %v", i, err)
+		}
+		envelopes[i] = e
+	}
+	return &batchIterator{envelopes: envelopes}, nil
+}
+
+// NewBatchRequest creates an HTTP request for a batch of events. Each event is
+// written in the JSON structured encoding, in the version of the spec
+// selected by its context.
+func NewBatchRequest(urlString string, events []Event) (*http.Request, error) {
+	url, err := url.Parse(urlString)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := make([]map[string]interface{}, len(events))
+	for i, event := range events {
+		e, err := Structured.envelope(event.Data, event.Context)
+		if err != nil {
+			return nil, fmt.Errorf("Could not encode event %d of batch: %v", i, err)
+		}
+		batch[i] = e
+	}
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	h := http.Header{}
+	h.Set(HeaderContentType, ContentTypeBatchJSON)
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    url,
+		Header: h,
+		Body:   ioutil.NopCloser(bytes.NewReader(b)),
+	}, nil
+}
+
+// batchIterator iterates the events of a batch request.
+type batchIterator struct {
+	envelopes []*structuredEnvelope
+	next      int
+}
+
+func (i *batchIterator) Next(data interface{}) (*EventContext, error) {
+	if i.next >= len(i.envelopes) {
+		return nil, io.EOF
+	}
+	e := i.envelopes[i.next]
+	i.next++
+	return e.decode(data)
+}
+
+// singleIterator iterates the single event of a request which is not a batch.
+type singleIterator struct {
+	request *http.Request
+	done    bool
+}
+
+func (i *singleIterator) Next(data interface{}) (*EventContext, error) {
+	if i.done {
+		return nil, io.EOF
+	}
+	i.done = true
+	return FromRequest(data, i.request)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+type batchData struct {
+	Value int `json:"value"`
+}
+
+func newBatch(t *testing.T, versions ...string) *http.Request {
+	events := make([]event.Event, len(versions))
+	for i, version := range versions {
+		events[i] = event.Event{
+			Context: event.EventContext{
+				CloudEventsVersion: version,
+				EventID:            string('a' + rune(i)),
+				EventType:          "dev.knative.test",
+				Source:             "tests://batch",
+				ContentType:        "application/json",
+			},
+			Data: batchData{Value: i},
+		}
+	}
+	req, err := event.NewBatchRequest(webhook, events)
+	if err != nil {
+		t.Fatalf("Failed to create batch: %v", err)
+	}
+	return req
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	versions := []string{event.CloudEventsVersion01, event.CloudEventsVersion02, event.CloudEventsVersion10}
+	req := newBatch(t, versions...)
+	if !event.IsBatch(req) {
+		t.Fatalf("Expected a batch request, got content type %q", req.Header.Get(event.HeaderContentType))
+	}
+
+	it, err := event.NewIterator(req)
+	if err != nil {
+		t.Fatalf("Failed to read batch: %v", err)
+	}
+	for i, version := range versions {
+		var data batchData
+		ctx, err := it.Next(&data)
+		if err != nil {
+			t.Fatalf("Failed to read event %d: %v", i, err)
+		}
+		if ctx.CloudEventsVersion != version {
+			t.Errorf("Got wrong version of event %d; wanted=%q; got=%q", i, version, ctx.CloudEventsVersion)
+		}
+		if ctx.EventID != string('a'+rune(i)) || data.Value != i {
+			t.Errorf("Got wrong event %d; id=%q; data=%+v", i, ctx.EventID, data)
+		}
+	}
+	if _, err := it.Next(nil); err != io.EOF {
+		t.Fatalf("Expected io.EOF after the last event, got %v", err)
+	}
+
+	if _, err := event.FromRequest(nil, newBatch(t, event.CloudEventsVersion10)); err == nil {
+		t.Fatal("Expected an error parsing a batch as a single event")
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	var found []batchData
+	var contexts []*event.EventContext
+	handler := event.Handler(func(ctx context.Context, data []batchData) {
+		found = data
+		contexts = event.BatchFromContext(ctx)
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newBatch(t, event.CloudEventsVersion10, event.CloudEventsVersion10))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Got wrong status; wanted=%d; got=%d", http.StatusNoContent, w.Code)
+	}
+	if expected := []batchData{{0}, {1}}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Got wrong data; wanted=%v; got=%v", expected, found)
+	}
+	if len(contexts) != 2 || contexts[0].EventID != "a" || contexts[1].EventID != "b" {
+		t.Fatalf("Got wrong contexts: %+v", contexts)
+	}
+}
+
+func TestIteratorHandler(t *testing.T) {
+	single, err := event.Binary.NewRequest(webhook, batchData{Value: 7}, event.EventContext{
+		EventID:   "single",
+		EventType: "dev.knative.test",
+		Source:    "tests://batch",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		req      *http.Request
+		expected []string
+	}{
+		{
+			name:     "batch",
+			req:      newBatch(t, event.CloudEventsVersion02, event.CloudEventsVersion10, event.CloudEventsVersion10),
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:     "single",
+			req:      single,
+			expected: []string{"single"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var found []string
+			handler := event.Handler(func(ctx context.Context, it event.Iterator) error {
+				for {
+					var data batchData
+					eventContext, err := it.Next(&data)
+					if err == io.EOF {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+					found = append(found, eventContext.EventID)
+				}
+			})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, test.req)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("Got wrong status; wanted=%d; got=%d", http.StatusNoContent, w.Code)
+			}
+			if !reflect.DeepEqual(test.expected, found) {
+				t.Fatalf("Got wrong events; wanted=%v; got=%v", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestBatchNotSupported(t *testing.T) {
+	mux := event.NewMux()
+	mux.Handle("dev.knative.test", func(ctx context.Context, data batchData) {})
+
+	for _, test := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"single data", event.Handler(func(ctx context.Context, data batchData) {})},
+		{"raw data", event.Handler(func(ctx context.Context, data []byte) {})},
+		{"no data", event.Handler(func(ctx context.Context) {})},
+		{"mux", mux},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			test.handler.ServeHTTP(w, newBatch(t, event.CloudEventsVersion10))
+			if w.Code != http.StatusUnsupportedMediaType {
+				t.Fatalf("Got wrong status; wanted=%d; got=%d", http.StatusUnsupportedMediaType, w.Code)
+			}
+		})
+	}
+}
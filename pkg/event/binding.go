@@ -0,0 +1,284 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// KafkaHeaderPrefix is the prefix of the record headers which carry the
+	// context attributes of a binary mode event in the Kafka binding.
+	KafkaHeaderPrefix = "ce_"
+
+	// KafkaHeaderContentType is the record header which carries the content
+	// type of a Kafka record.
+	KafkaHeaderContentType = "content-type"
+
+	// AMQPPropertyPrefix is the prefix of the application properties which
+	// carry the context attributes of a binary mode event in the AMQP binding
+	// since 1.0.
+	AMQPPropertyPrefix = "cloudEvents_"
+
+	// amqpPropertyPrefix02 is the application property prefix of the AMQP
+	// binding before 1.0, which is still accepted.
+	amqpPropertyPrefix02 = "cloudEvents:"
+
+	// extensionPartitionKey is the extension which the Kafka binding carries
+	// as the record key.
+	extensionPartitionKey = "partitionkey"
+)
+
+// BindingMode selects how a protocol binding carries an event in a message.
+type BindingMode int
+
+const (
+	// BinaryMode carries the context attributes of an event in message
+	// headers, or properties, and its data as the message payload.
+	BinaryMode BindingMode = iota
+
+	// StructuredMode carries an event as a JSON envelope in the message
+	// payload.
+	StructuredMode
+)
+
+// binding maps the context attributes of binary mode events to the headers of
+// a protocol.
+type binding struct {
+	// prefix is prepended to attribute names.
+	prefix string
+	// versionPrefixes override prefix for versions of the spec.
+	versionPrefixes map[string]string
+	// legacyPrefixes are accepted in addition to prefix when decoding.
+	legacyPrefixes []string
+}
+
+var (
+	kafkaBinding = binding{prefix: KafkaHeaderPrefix}
+
+	amqpBinding = binding{
+		prefix: amqpPropertyPrefix02,
+		versionPrefixes: map[string]string{
+			CloudEventsVersion10: AMQPPropertyPrefix,
+		},
+		legacyPrefixes: []string{AMQPPropertyPrefix},
+	}
+
+	// MQTT 5 user properties are named like the attributes.
+	mqttBinding = binding{}
+)
+
+// encode returns the headers, content type and payload of an event.
+func (b binding) encode(mode BindingMode, data interface{}, context EventContext) (map[string]string, string, []byte, error) {
+	switch mode {
+	case StructuredMode:
+		e, err := Structured.envelope(data, context)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return map[string]string{}, ContentTypeStructuredJSON, payload, nil
+	case BinaryMode:
+		h, payload, err := Binary.encode(data, context)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		s, err := getSpec(context.CloudEventsVersion)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		prefix := b.prefix
+		if p, ok := b.versionPrefixes[s.version]; ok {
+			prefix = p
+		}
+		headers := make(map[string]string, len(h))
+		for name, values := range h {
+			if strings.EqualFold(name, HeaderContentType) {
+				continue
+			}
+			headers[prefix+strings.ToLower(name[len(headerPrefix):])] = values[0]
+		}
+		return headers, h.Get(HeaderContentType), payload, nil
+	default:
+		return nil, "", nil, fmt.Errorf("unknown binding mode %d", mode)
+	}
+}
+
+// decode parses event data and context from the headers, content type and
+// payload of a message. The mode is selected by the content type.
+func (b binding) decode(headers map[string]string, contentType string, payload []byte, data interface{}) (*EventContext, error) {
+	switch mediaType(contentType) {
+	case ContentTypeStructuredJSON:
+		return Structured.decode(bytes.NewReader(payload), data)
+	case ContentTypeBatchJSON:
+		return nil, errors.New("Cannot parse a batch of events as a single event")
+	}
+
+	h := http.Header{}
+	for name, value := range headers {
+		if attribute, ok := b.attribute(name); ok {
+			h.Set(headerPrefix+attribute, value)
+		}
+	}
+	if contentType != "" {
+		h.Set(HeaderContentType, contentType)
+	}
+	return Binary.decode(h, bytes.NewReader(payload), data)
+}
+
+// attribute returns the attribute name of a header, or false if the header
+// does not carry an attribute.
+func (b binding) attribute(name string) (string, bool) {
+	for _, prefix := range append([]string{b.prefix}, b.legacyPrefixes...) {
+		if len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			return name[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// KafkaRecord is a Kafka record in the CloudEvents Kafka binding. In binary
+// mode the context attributes are carried in headers prefixed with "ce_", and
+// the partitionkey extension as the record key.
+type KafkaRecord struct {
+	Key     []byte
+	Headers map[string][]byte
+	Value   []byte
+}
+
+// NewKafkaRecord creates a Kafka record of an event in the version of the spec
+// selected by the context.
+func NewKafkaRecord(mode BindingMode, data interface{}, context EventContext) (*KafkaRecord, error) {
+	headers, contentType, payload, err := kafkaBinding.encode(mode, data, context)
+	if err != nil {
+		return nil, err
+	}
+	record := &KafkaRecord{
+		Headers: make(map[string][]byte, len(headers)+1),
+		Value:   payload,
+	}
+	for name, value := range headers {
+		record.Headers[name] = []byte(value)
+	}
+	if contentType != "" {
+		record.Headers[KafkaHeaderContentType] = []byte(contentType)
+	}
+	if key, ok := context.Extensions[extensionPartitionKey].(string); ok {
+		record.Key = []byte(key)
+	}
+	return record, nil
+}
+
+// FromKafkaRecord parses event data and context from a Kafka record in either
+// mode.
+func FromKafkaRecord(data interface{}, record *KafkaRecord) (*EventContext, error) {
+	var contentType string
+	headers := make(map[string]string, len(record.Headers))
+	for name, value := range record.Headers {
+		if strings.EqualFold(name, KafkaHeaderContentType) {
+			contentType = string(value)
+			continue
+		}
+		headers[name] = string(value)
+	}
+	return kafkaBinding.decode(headers, contentType, record.Value, data)
+}
+
+// AMQPMessage is an AMQP message in the CloudEvents AMQP binding. In binary
+// mode the context attributes are carried in application properties prefixed
+// with "cloudEvents_", or "cloudEvents:" before version 1.0 of the spec.
+type AMQPMessage struct {
+	ContentType           string
+	ApplicationProperties map[string]interface{}
+	Data                  []byte
+}
+
+// NewAMQPMessage creates an AMQP message of an event in the version of the
+// spec selected by the context. Application properties are strings.
+func NewAMQPMessage(mode BindingMode, data interface{}, context EventContext) (*AMQPMessage, error) {
+	headers, contentType, payload, err := amqpBinding.encode(mode, data, context)
+	if err != nil {
+		return nil, err
+	}
+	message := &AMQPMessage{
+		ContentType:           contentType,
+		ApplicationProperties: make(map[string]interface{}, len(headers)),
+		Data:                  payload,
+	}
+	for name, value := range headers {
+		message.ApplicationProperties[name] = value
+	}
+	return message, nil
+}
+
+// FromAMQPMessage parses event data and context from an AMQP message in either
+// mode. Timestamp properties are read as RFC 3339 times, other properties
+// which are not strings are formatted with fmt.
+func FromAMQPMessage(data interface{}, message *AMQPMessage) (*EventContext, error) {
+	headers := make(map[string]string, len(message.ApplicationProperties))
+	for name, value := range message.ApplicationProperties {
+		switch v := value.(type) {
+		case string:
+			headers[name] = v
+		case []byte:
+			headers[name] = string(v)
+		case time.Time:
+			headers[name] = v.Format(time.RFC3339Nano)
+		default:
+			headers[name] = fmt.Sprint(v)
+		}
+	}
+	return amqpBinding.decode(headers, message.ContentType, message.Data, data)
+}
+
+// MQTTPublish is an MQTT 5 PUBLISH message in the CloudEvents MQTT binding. In
+// binary mode the context attributes are carried in user properties named like
+// the attributes.
+type MQTTPublish struct {
+	ContentType    string
+	UserProperties map[string]string
+	Payload        []byte
+}
+
+// NewMQTTPublish creates an MQTT 5 PUBLISH message of an event in the version
+// of the spec selected by the context.
+func NewMQTTPublish(mode BindingMode, data interface{}, context EventContext) (*MQTTPublish, error) {
+	headers, contentType, payload, err := mqttBinding.encode(mode, data, context)
+	if err != nil {
+		return nil, err
+	}
+	return &MQTTPublish{
+		ContentType:    contentType,
+		UserProperties: headers,
+		Payload:        payload,
+	}, nil
+}
+
+// FromMQTTPublish parses event data and context from an MQTT 5 PUBLISH message
+// in either mode.
+func FromMQTTPublish(data interface{}, publish *MQTTPublish) (*EventContext, error) {
+	return mqttBinding.decode(publish.UserProperties, publish.ContentType, publish.Payload, data)
+}
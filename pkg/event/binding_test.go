@@ -0,0 +1,258 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+type bindingData struct {
+	Value string `json:"value"`
+}
+
+// bindingEvent returns the context of an event in a version of the spec.
+func bindingEvent(version string) event.EventContext {
+	return event.EventContext{
+		CloudEventsVersion: version,
+		EventID:            "1234",
+		EventTime:          time.Date(2018, 9, 1, 12, 30, 0, 0, time.UTC),
+		EventType:          "dev.knative.test",
+		Source:             "tests://binding",
+		ContentType:        "application/json",
+		Extensions:         map[string]interface{}{"partitionkey": "key"},
+	}
+}
+
+// bindingRoundTrip encodes and decodes an event with a protocol binding.
+type bindingRoundTrip func(mode event.BindingMode, data interface{}, context event.EventContext) (*event.EventContext, interface{}, error)
+
+func TestBindingRoundTrip(t *testing.T) {
+	bindings := map[string]bindingRoundTrip{
+		"kafka": func(mode event.BindingMode, data interface{}, context event.EventContext) (*event.EventContext, interface{}, error) {
+			record, err := event.NewKafkaRecord(mode, data, context)
+			if err != nil {
+				return nil, nil, err
+			}
+			var decoded bindingData
+			ctx, err := event.FromKafkaRecord(&decoded, record)
+			return ctx, decoded, err
+		},
+		"amqp": func(mode event.BindingMode, data interface{}, context event.EventContext) (*event.EventContext, interface{}, error) {
+			message, err := event.NewAMQPMessage(mode, data, context)
+			if err != nil {
+				return nil, nil, err
+			}
+			var decoded bindingData
+			ctx, err := event.FromAMQPMessage(&decoded, message)
+			return ctx, decoded, err
+		},
+		"mqtt": func(mode event.BindingMode, data interface{}, context event.EventContext) (*event.EventContext, interface{}, error) {
+			publish, err := event.NewMQTTPublish(mode, data, context)
+			if err != nil {
+				return nil, nil, err
+			}
+			var decoded bindingData
+			ctx, err := event.FromMQTTPublish(&decoded, publish)
+			return ctx, decoded, err
+		},
+	}
+	modes := map[string]event.BindingMode{
+		"binary":     event.BinaryMode,
+		"structured": event.StructuredMode,
+	}
+	data := bindingData{Value: "hello"}
+
+	for bindingName, roundTrip := range bindings {
+		for modeName, mode := range modes {
+			for _, version := range []string{event.CloudEventsVersion01, event.CloudEventsVersion02, event.CloudEventsVersion10} {
+				t.Run(bindingName+"/"+modeName+"/"+version, func(t *testing.T) {
+					want := bindingEvent(version)
+					ctx, decoded, err := roundTrip(mode, data, want)
+					if err != nil {
+						t.Fatalf("Round trip failed: %v", err)
+					}
+					if !reflect.DeepEqual(decoded, data) {
+						t.Errorf("Got wrong data; wanted=%+v; got=%+v", data, decoded)
+					}
+					if ctx.CloudEventsVersion != version {
+						t.Errorf("Got wrong version; wanted=%q; got=%q", version, ctx.CloudEventsVersion)
+					}
+					if ctx.EventID != want.EventID || ctx.EventType != want.EventType || ctx.Source != want.Source {
+						t.Errorf("Got wrong context; wanted=%+v; got=%+v", want, ctx)
+					}
+					if !ctx.EventTime.Equal(want.EventTime) {
+						t.Errorf("Got wrong time; wanted=%v; got=%v", want.EventTime, ctx.EventTime)
+					}
+					if ctx.Extensions["partitionkey"] != "key" && ctx.Extensions["Partitionkey"] != "key" {
+						t.Errorf("Got wrong extensions; wanted partitionkey=%q; got=%v", "key", ctx.Extensions)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestKafkaRecordHeaders(t *testing.T) {
+	record, err := event.NewKafkaRecord(event.BinaryMode, bindingData{Value: "hello"}, bindingEvent(event.CloudEventsVersion10))
+	if err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	for name, want := range map[string]string{
+		"ce_specversion":  "1.0",
+		"ce_id":           "1234",
+		"ce_type":         "dev.knative.test",
+		"ce_source":       "tests://binding",
+		"ce_time":         "2018-09-01T12:30:00Z",
+		"ce_partitionkey": "key",
+		"content-type":    "application/json",
+	} {
+		if got := string(record.Headers[name]); got != want {
+			t.Errorf("Got wrong header %q; wanted=%q; got=%q", name, want, got)
+		}
+	}
+	if got := string(record.Key); got != "key" {
+		t.Errorf("Got wrong key; wanted=%q; got=%q", "key", got)
+	}
+	if got := string(record.Value); got != `{"value":"hello"}` {
+		t.Errorf("Got wrong value; wanted=%q; got=%q", `{"value":"hello"}`, got)
+	}
+
+	record, err = event.NewKafkaRecord(event.StructuredMode, bindingData{Value: "hello"}, bindingEvent(event.CloudEventsVersion10))
+	if err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	if got := string(record.Headers["content-type"]); got != event.ContentTypeStructuredJSON {
+		t.Errorf("Got wrong content type; wanted=%q; got=%q", event.ContentTypeStructuredJSON, got)
+	}
+	if len(record.Headers) != 1 {
+		t.Errorf("Got unexpected headers in structured mode: %v", record.Headers)
+	}
+}
+
+func TestFromKafkaRecord(t *testing.T) {
+	// A record written by another producer of the Kafka binding.
+	record := &event.KafkaRecord{
+		Headers: map[string][]byte{
+			"ce_specversion": []byte("1.0"),
+			"ce_id":          []byte("5678"),
+			"ce_type":        []byte("com.example.order"),
+			"ce_source":      []byte("/orders"),
+			"ce_region":      []byte("eu"),
+			"content-type":   []byte("text/plain"),
+			"traceparent":    []byte("00-abc-def-01"),
+		},
+		Value: []byte("order placed"),
+	}
+	var data string
+	ctx, err := event.FromKafkaRecord(&data, record)
+	if err != nil {
+		t.Fatalf("Failed to parse record: %v", err)
+	}
+	want := &event.EventContext{
+		CloudEventsVersion: "1.0",
+		EventID:            "5678",
+		EventType:          "com.example.order",
+		Source:             "/orders",
+		ContentType:        "text/plain",
+		Extensions:         map[string]interface{}{"region": "eu"},
+	}
+	if !reflect.DeepEqual(ctx, want) {
+		t.Errorf("Got wrong context; wanted=%+v; got=%+v", want, ctx)
+	}
+	if data != "order placed" {
+		t.Errorf("Got wrong data; wanted=%q; got=%q", "order placed", data)
+	}
+
+	if _, err := event.FromKafkaRecord(nil, &event.KafkaRecord{Value: []byte("{}")}); err == nil {
+		t.Errorf("Expected an error for a record without context attributes")
+	}
+	batch := &event.KafkaRecord{Headers: map[string][]byte{"content-type": []byte(event.ContentTypeBatchJSON)}, Value: []byte("[]")}
+	if _, err := event.FromKafkaRecord(nil, batch); err == nil {
+		t.Errorf("Expected an error for a batch record")
+	}
+}
+
+func TestAMQPMessageProperties(t *testing.T) {
+	for _, test := range []struct {
+		version string
+		prefix  string
+	}{
+		{version: event.CloudEventsVersion02, prefix: "cloudEvents:"},
+		{version: event.CloudEventsVersion10, prefix: "cloudEvents_"},
+	} {
+		t.Run(test.version, func(t *testing.T) {
+			message, err := event.NewAMQPMessage(event.BinaryMode, bindingData{Value: "hello"}, bindingEvent(test.version))
+			if err != nil {
+				t.Fatalf("Failed to create message: %v", err)
+			}
+			if got := message.ApplicationProperties[test.prefix+"id"]; got != "1234" {
+				t.Errorf("Got wrong id property; wanted=%q; got=%v", "1234", got)
+			}
+			if message.ContentType != "application/json" {
+				t.Errorf("Got wrong content type; wanted=%q; got=%q", "application/json", message.ContentType)
+			}
+		})
+	}
+
+	// Typed properties are accepted.
+	eventTime := time.Date(2018, 9, 1, 12, 30, 0, 0, time.UTC)
+	message := &event.AMQPMessage{
+		ApplicationProperties: map[string]interface{}{
+			"cloudEvents:specversion": "0.2",
+			"cloudEvents:id":          "1",
+			"cloudEvents:type":        "dev.knative.test",
+			"cloudEvents:source":      "tests://binding",
+			"cloudEvents:time":        eventTime,
+			"cloudEvents:priority":    int64(3),
+		},
+	}
+	ctx, err := event.FromAMQPMessage(nil, message)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if !ctx.EventTime.Equal(eventTime) {
+		t.Errorf("Got wrong time; wanted=%v; got=%v", eventTime, ctx.EventTime)
+	}
+	if got := ctx.Extensions["priority"]; got != "3" {
+		t.Errorf("Got wrong priority extension; wanted=%q; got=%v", "3", got)
+	}
+}
+
+func TestMQTTPublishProperties(t *testing.T) {
+	publish, err := event.NewMQTTPublish(event.BinaryMode, bindingData{Value: "hello"}, bindingEvent(event.CloudEventsVersion10))
+	if err != nil {
+		t.Fatalf("Failed to create publish: %v", err)
+	}
+	want := map[string]string{
+		"specversion":  "1.0",
+		"id":           "1234",
+		"type":         "dev.knative.test",
+		"source":       "tests://binding",
+		"time":         "2018-09-01T12:30:00Z",
+		"partitionkey": "key",
+	}
+	if !reflect.DeepEqual(publish.UserProperties, want) {
+		t.Errorf("Got wrong user properties; wanted=%v; got=%v", want, publish.UserProperties)
+	}
+	if publish.ContentType != "application/json" {
+		t.Errorf("Got wrong content type; wanted=%q; got=%q", "application/json", publish.ContentType)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the timeout of each attempt to send an event by a
+	// Client created with NewClient.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultRetries is the number of times a Client created with NewClient
+	// retries sending an event.
+	DefaultRetries = 3
+
+	// DefaultBackoff is the delay before the first retry of a Client created
+	// with NewClient, it doubles with each retry.
+	DefaultBackoff = 100 * time.Millisecond
+
+	// maxErrorBody is the number of bytes of a failed response's body kept in
+	// a StatusError.
+	maxErrorBody = 1024
+)
+
+// SendFunc sends an HTTP request.
+type SendFunc func(*http.Request) (*http.Response, error)
+
+// ClientMiddleware wraps the sending of each request by a Client, for example
+// to add authorization headers or to trace requests.
+type ClientMiddleware func(next SendFunc) SendFunc
+
+// Client sends CloudEvents over HTTP. The zero value sends events in Binary
+// encoding with http.DefaultClient, once and without a timeout.
+type Client struct {
+	// HTTPClient sends requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// Encoding encodes events into requests, Binary if nil.
+	Encoding HTTPMarshaller
+
+	// Timeout bounds each attempt to send an event, there is no timeout if
+	// zero.
+	Timeout time.Duration
+
+	// Retries is the number of times sending an event is retried after a
+	// transport error or a 5xx or 429 response.
+	Retries int
+
+	// Backoff is the delay before the first retry, it doubles with each
+	// retry.
+	Backoff time.Duration
+
+	// Middleware wraps the sending of each request, the first middleware is
+	// the outermost.
+	Middleware []ClientMiddleware
+}
+
+// StatusError is returned by Client.Send when an event is rejected with a non
+// 2xx response.
+type StatusError struct {
+	// StatusCode is the status of the response.
+	StatusCode int
+	// Body is the start of the body of the response.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary returns true if the event may be accepted when sent again.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// NewClient creates a Client sending events in Binary encoding, with the
+// default timeout and retries.
+func NewClient() *Client {
+	return &Client{
+		Encoding: Binary,
+		Timeout:  DefaultTimeout,
+		Retries:  DefaultRetries,
+		Backoff:  DefaultBackoff,
+	}
+}
+
+// Send sends an event to the target URL. It returns an error if the event
+// could not be encoded, or if it was not accepted with a 2xx response after
+// all retries. A StatusError is returned for rejected events.
+func (c *Client) Send(ctx context.Context, target string, eventContext EventContext, data interface{}) error {
+	// The data is encoded for each attempt, a reader can only be read once.
+	if r, ok := data.(io.Reader); ok {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		data = b
+	}
+
+	send := c.sendFunc()
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, send, target, eventContext, data)
+		if err == nil || attempt >= c.Retries || !isTemporary(err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// send makes one attempt to send an event.
+func (c *Client) send(ctx context.Context, send SendFunc, target string, eventContext EventContext, data interface{}) error {
+	req, err := c.encoding().NewRequest(target, data, eventContext)
+	if err != nil {
+		return &encodingError{err}
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	res, err := send(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	return &StatusError{StatusCode: res.StatusCode, Body: string(body)}
+}
+
+// sendFunc returns the SendFunc of the HTTP client wrapped by the
+// middleware.
+func (c *Client) sendFunc() SendFunc {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	send := SendFunc(httpClient.Do)
+	for i := len(c.Middleware) - 1; i >= 0; i-- {
+		send = c.Middleware[i](send)
+	}
+	return send
+}
+
+func (c *Client) encoding() HTTPMarshaller {
+	if c.Encoding == nil {
+		return Binary
+	}
+	return c.Encoding
+}
+
+// encodingError is returned when an event can not be encoded, which is not
+// retried.
+type encodingError struct {
+	error
+}
+
+// isTemporary returns true if sending an event failed with an error which may
+// not happen again.
+func isTemporary(err error) bool {
+	switch e := err.(type) {
+	case *StatusError:
+		return e.Temporary()
+	case *encodingError:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+func TestClientSend(t *testing.T) {
+	eventContext := event.EventContext{
+		EventID:   "1234",
+		EventType: "dev.eventing.test",
+		Source:    "tests:TestClientSend",
+	}
+	data := map[string]interface{}{"hello": "world"}
+
+	for _, test := range []struct {
+		name         string
+		encoding     event.HTTPMarshaller
+		statuses     []int
+		timeout      time.Duration
+		delay        time.Duration
+		expectedErr  string
+		expectedSent int
+	}{
+		{
+			name:         "binary",
+			encoding:     event.Binary,
+			statuses:     []int{http.StatusAccepted},
+			expectedSent: 1,
+		},
+		{
+			name:         "structured",
+			encoding:     event.Structured,
+			statuses:     []int{http.StatusOK},
+			expectedSent: 1,
+		},
+		{
+			name:         "retry 5xx and 429",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent},
+			expectedSent: 3,
+		},
+		{
+			name:         "retries exhausted",
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			expectedErr:  "unexpected response status 502",
+			expectedSent: 3,
+		},
+		{
+			name:         "no retry 4xx",
+			statuses:     []int{http.StatusBadRequest, http.StatusOK},
+			expectedErr:  "unexpected response status 400",
+			expectedSent: 1,
+		},
+		{
+			name:         "timeout",
+			statuses:     []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			timeout:      10 * time.Millisecond,
+			delay:        100 * time.Millisecond,
+			expectedErr:  "context deadline exceeded",
+			expectedSent: 3,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var sent int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&sent, 1)
+				encoding := event.HTTPMarshaller(event.Binary)
+				if test.encoding != nil {
+					encoding = test.encoding
+				}
+				var found map[string]interface{}
+				if _, err := encoding.FromRequest(&found, r); err != nil {
+					t.Errorf("Failed to parse request: %v", err)
+				}
+				time.Sleep(test.delay)
+				w.WriteHeader(test.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			client := event.NewClient()
+			client.HTTPClient = srv.Client()
+			client.Encoding = test.encoding
+			client.Retries = 2
+			client.Backoff = time.Millisecond
+			if test.timeout != 0 {
+				client.Timeout = test.timeout
+			}
+
+			err := client.Send(context.Background(), srv.URL, eventContext, data)
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("Failed to send event: %v", err)
+			}
+			if test.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), test.expectedErr)) {
+				t.Fatalf("Got wrong error; wanted=%q; got=%v", test.expectedErr, err)
+			}
+			if int(atomic.LoadInt32(&sent)) != test.expectedSent {
+				t.Fatalf("Got wrong number of requests; wanted=%d; got=%d", test.expectedSent, sent)
+			}
+		})
+	}
+}
+
+func TestClientMiddleware(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var traced []int
+	client := event.NewClient()
+	client.HTTPClient = srv.Client()
+	client.Middleware = []event.ClientMiddleware{
+		func(next event.SendFunc) event.SendFunc {
+			return func(r *http.Request) (*http.Response, error) {
+				res, err := next(r)
+				if err == nil {
+					traced = append(traced, res.StatusCode)
+				}
+				return res, err
+			}
+		},
+		func(next event.SendFunc) event.SendFunc {
+			return func(r *http.Request) (*http.Response, error) {
+				r.Header.Set("Authorization", "Bearer token")
+				return next(r)
+			}
+		},
+	}
+
+	err := client.Send(context.Background(), srv.URL, event.EventContext{
+		EventID:   "1234",
+		EventType: "dev.eventing.test",
+		Source:    "tests:TestClientMiddleware",
+	}, strings.NewReader(`{"hello":"world"}`))
+	if err != nil {
+		t.Fatalf("Failed to send event: %v", err)
+	}
+	if len(traced) != 1 || traced[0] != http.StatusOK {
+		t.Fatalf("Middleware did not trace the response; got=%v", traced)
+	}
+}
+
+func TestClientEncodingErrorIsNotRetried(t *testing.T) {
+	var sent int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+	}))
+	defer srv.Close()
+
+	client := event.NewClient()
+	client.HTTPClient = srv.Client()
+	client.Backoff = time.Millisecond
+	if err := client.Send(context.Background(), srv.URL, event.EventContext{}, nil); err == nil {
+		t.Fatal("Expected an error sending an event without required fields")
+	}
+	if sent != 0 {
+		t.Fatalf("Expected no requests, got %d", sent)
+	}
+}
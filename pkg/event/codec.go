@@ -0,0 +1,119 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Codec encodes and decodes event data of a media type.
+type Codec interface {
+	// Encode returns the encoding of data.
+	Encode(data interface{}) ([]byte, error)
+
+	// Decode decodes the data read from reader into data, which is a
+	// pointer.
+	Decode(reader io.Reader, data interface{}) error
+}
+
+// CodecFuncs adapts a pair of funcs to a Codec.
+type CodecFuncs struct {
+	EncodeFunc func(data interface{}) ([]byte, error)
+	DecodeFunc func(reader io.Reader, data interface{}) error
+}
+
+// Encode calls EncodeFunc.
+func (c CodecFuncs) Encode(data interface{}) ([]byte, error) {
+	return c.EncodeFunc(data)
+}
+
+// Decode calls DecodeFunc.
+func (c CodecFuncs) Decode(reader io.Reader, data interface{}) error {
+	return c.DecodeFunc(reader, data)
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = make(map[string]Codec)
+
+	jsonCodec = CodecFuncs{
+		EncodeFunc: json.Marshal,
+		DecodeFunc: func(reader io.Reader, data interface{}) error {
+			return json.NewDecoder(reader).Decode(data)
+		},
+	}
+
+	xmlCodec = CodecFuncs{
+		EncodeFunc: xml.Marshal,
+		DecodeFunc: func(reader io.Reader, data interface{}) error {
+			return xml.NewDecoder(reader).Decode(data)
+		},
+	}
+
+	textCodec = CodecFuncs{
+		EncodeFunc: func(data interface{}) ([]byte, error) {
+			switch d := data.(type) {
+			case string:
+				return []byte(d), nil
+			case *string:
+				return []byte(*d), nil
+			}
+			return nil, errUnsupportedData
+		},
+		DecodeFunc: func(reader io.Reader, data interface{}) error {
+			s, ok := data.(*string)
+			if !ok {
+				return errUnsupportedData
+			}
+			b, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			*s = string(b)
+			return nil
+		},
+	}
+)
+
+func init() {
+	RegisterCodec(contentTypeJSON, jsonCodec)
+	RegisterCodec("text/json", jsonCodec)
+	RegisterCodec(contentTypeXML, xmlCodec)
+	RegisterCodec("text/xml", xmlCodec)
+	RegisterCodec(contentTypeText, textCodec)
+}
+
+// RegisterCodec registers the Codec of event data of a media type, replacing
+// any codec registered before. Media types are matched without parameters,
+// such as charset. Data of a media type without a codec can only be sent and
+// received as []byte or io.Reader.
+func RegisterCodec(mediaTypeName string, codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[mediaType(mediaTypeName)] = codec
+}
+
+// lookupCodec returns the Codec of a content type, or nil if there is none.
+func lookupCodec(contentType string) Codec {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	return codecs[mediaType(contentType)]
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+// csvCodec encodes [][]string as text/csv.
+var csvCodec = event.CodecFuncs{
+	EncodeFunc: func(data interface{}) ([]byte, error) {
+		records, ok := data.([][]string)
+		if !ok {
+			return nil, errors.New("csv data must be [][]string")
+		}
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		if err := w.WriteAll(records); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	},
+	DecodeFunc: func(reader io.Reader, data interface{}) error {
+		records, ok := data.(*[][]string)
+		if !ok {
+			return errors.New("csv data must be *[][]string")
+		}
+		var err error
+		*records, err = csv.NewReader(reader).ReadAll()
+		return err
+	},
+}
+
+func TestRegisterCodec(t *testing.T) {
+	event.RegisterCodec("text/csv", csvCodec)
+
+	records := [][]string{
+		{"name", "handle"},
+		{"inlined", "@inlined"},
+	}
+	for _, version := range []string{event.CloudEventsVersion01, event.CloudEventsVersion10} {
+		for _, encoder := range []struct {
+			name    string
+			encoder event.HTTPMarshaller
+		}{
+			{"binary", event.Binary},
+			{"structured", event.Structured},
+		} {
+			t.Run(version+" "+encoder.name, func(t *testing.T) {
+				context := event.EventContext{
+					CloudEventsVersion: version,
+					EventID:            "1234",
+					EventType:          "dev.knative.test",
+					Source:             "tests://TestRegisterCodec",
+					ContentType:        "text/csv; charset=utf-8",
+				}
+				req, err := encoder.encoder.NewRequest(webhook, records, context)
+				if err != nil {
+					t.Fatalf("Failed to encode event: %v", err)
+				}
+				var found [][]string
+				if _, err := event.FromRequest(&found, req); err != nil {
+					t.Fatalf("Failed to decode event: %v", err)
+				}
+				if !reflect.DeepEqual(records, found) {
+					t.Fatalf("Data was transcoded lossily: expected=%v got=%v", records, found)
+				}
+			})
+		}
+	}
+}
+
+func TestRawData(t *testing.T) {
+	data := []byte{0xde, 0xad, 0xbe, 0xef}
+	for _, test := range []struct {
+		name    string
+		encoder event.HTTPMarshaller
+		version string
+		data    interface{}
+	}{
+		{"binary bytes", event.Binary, event.CloudEventsVersion01, data},
+		{"binary reader", event.Binary, event.CloudEventsVersion10, bytes.NewReader(data)},
+		{"structured base64", event.Structured, event.CloudEventsVersion10, data},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			context := event.EventContext{
+				CloudEventsVersion: test.version,
+				EventID:            "1234",
+				EventType:          "dev.knative.test",
+				Source:             "tests://TestRawData",
+				ContentType:        "application/octet-stream",
+			}
+			req, err := test.encoder.NewRequest(webhook, test.data, context)
+			if err != nil {
+				t.Fatalf("Failed to encode event: %v", err)
+			}
+			var found []byte
+			if _, err := event.FromRequest(&found, req); err != nil {
+				t.Fatalf("Failed to decode event: %v", err)
+			}
+			if !bytes.Equal(data, found) {
+				t.Fatalf("Data was transcoded lossily: expected=%v got=%v", data, found)
+			}
+		})
+	}
+}
+
+func TestBinaryIsDefault(t *testing.T) {
+	req := newRequest(map[string]string{
+		event.HeaderEventID:     "1234",
+		event.HeaderEventType:   "dev.knative.test",
+		event.HeaderSource:      "tests://TestBinaryIsDefault",
+		event.HeaderContentType: "text/plain",
+	}, "hello, world")
+
+	var text string
+	context, err := event.FromRequest(&text, req)
+	if err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	if context.ContentType != "text/plain" {
+		t.Fatalf("Got wrong content type; wanted=%q; got=%q", "text/plain", context.ContentType)
+	}
+	if text != "hello, world" {
+		t.Fatalf("Got wrong data; wanted=%q; got=%q", "hello, world", text)
+	}
+}
+
+func TestUnknownContentType(t *testing.T) {
+	header := map[string]string{
+		event.HeaderEventID:     "1234",
+		event.HeaderEventType:   "dev.knative.test",
+		event.HeaderSource:      "tests://TestUnknownContentType",
+		event.HeaderContentType: "application/x-unknown",
+	}
+
+	var reader io.Reader
+	if _, err := event.FromRequest(&reader, newRequest(header, "opaque")); err != nil {
+		t.Fatalf("Failed to parse request as io.Reader: %v", err)
+	}
+	b, err := ioutil.ReadAll(reader)
+	if err != nil || string(b) != "opaque" {
+		t.Fatalf("Got wrong data; wanted=%q; got=%q (%v)", "opaque", string(b), err)
+	}
+
+	var decoded map[string]interface{}
+	_, err = event.FromRequest(&decoded, newRequest(header, "opaque"))
+	if err == nil || !strings.Contains(err.Error(), "application/x-unknown") {
+		t.Fatalf("Expected an error decoding an unknown content type, got %v", err)
+	}
+}
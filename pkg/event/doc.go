@@ -15,8 +15,15 @@ limitations under the License.
 */
 
 // Package event implements utilities for handling CloudEvents.
-// For information on the spec, see
+// Versions 0.1, 0.2 and 1.0 of the spec are supported. For information on the
+// spec, see
 // https://github.com/cloudevents/spec/blob/v0.1/http-transport-binding.md
 // and
 // https://github.com/cloudevents/spec/blob/v0.1/spec.md
+// or the same documents of the v0.2 and v1.0 releases. Batches of events in
+// the JSON batch format are read with NewIterator and written with
+// NewBatchRequest. Events are carried over Kafka, AMQP and MQTT 5 in binary or
+// structured mode by the protocol bindings, such as NewKafkaRecord and
+// FromKafkaRecord. Package eventtest helps test code which sends or handles
+// events.
 package event
@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -56,6 +57,32 @@ const (
 	// HeaderSource is the header for the source which emitted this event.
 	HeaderSource = "CE-Source"
 
+	// HeaderSpecVersion is the header for the version of Cloud Events used
+	// since 0.2.
+	HeaderSpecVersion = "CE-SpecVersion"
+
+	// HeaderID is the header for the unique ID of this event since 0.2.
+	HeaderID = "CE-ID"
+
+	// HeaderTime is the OPTIONAL header for the time at which an event
+	// occurred since 0.2.
+	HeaderTime = "CE-Time"
+
+	// HeaderType is the header for type of event represented since 0.2.
+	HeaderType = "CE-Type"
+
+	// HeaderDataSchema is the OPTIONAL header for the schema of the event data
+	// since 1.0.
+	HeaderDataSchema = "CE-DataSchema"
+
+	// HeaderSubject is the OPTIONAL header for the subject of the event since
+	// 1.0.
+	HeaderSubject = "CE-Subject"
+
+	// headerPrefix is the prefix of context attribute headers since 0.2,
+	// including extensions.
+	headerPrefix = "CE-"
+
 	// HeaderExtensions is the OPTIONAL header prefix for CloudEvents extensions
 	headerExtensionsPrefix = "CE-X-"
 
@@ -65,37 +92,65 @@ const (
 
 type binary int
 
-// FromRequest parses event data and context from an HTTP request.
-func (binary) FromRequest(data interface{}, r *http.Request) (*EventContext, error) {
+// FromRequest parses event data and context from an HTTP request. The version
+// of the event is read from the CE-SpecVersion header, or is 0.1 if it is
+// missing.
+func (b binary) FromRequest(data interface{}, r *http.Request) (*EventContext, error) {
+	return b.decode(r.Header, r.Body, data)
+}
+
+// decode parses event data and context from the headers and body of a binary
+// event.
+func (binary) decode(header http.Header, body io.Reader, data interface{}) (*EventContext, error) {
+	version := header.Get(HeaderSpecVersion)
+	if version == "" {
+		version = CloudEventsVersion01
+	}
+	s, err := getSpec(version)
+	if err != nil {
+		return nil, err
+	}
+
 	var ctx EventContext
-	err := anyError(
-		getRequiredHeader(r.Header, HeaderEventID, &ctx.EventID),
-		getRequiredHeader(r.Header, HeaderEventType, &ctx.EventType),
-		getRequiredHeader(r.Header, HeaderSource, &ctx.Source),
-		getRequiredHeader(r.Header, HeaderContentType, &ctx.ContentType))
+	err = anyError(
+		getRequiredHeader(header, headerPrefix+s.eventID, &ctx.EventID),
+		getRequiredHeader(header, headerPrefix+s.eventType, &ctx.EventType),
+		getRequiredHeader(header, headerPrefix+s.source, &ctx.Source))
 	if err != nil {
 		return nil, err
 	}
 
-	ctx.CloudEventsVersion = r.Header.Get(HeaderCloudEventsVersion)
-	if timeStr := r.Header.Get(HeaderEventTime); timeStr != "" {
+	// The data of a request without a content type is decoded as JSON.
+	ctx.ContentType = header.Get(HeaderContentType)
+
+	ctx.CloudEventsVersion = getAttributeHeader(header, s.specVersion)
+	if timeStr := getAttributeHeader(header, s.eventTime); timeStr != "" {
 		if ctx.EventTime, err = time.Parse(time.RFC3339Nano, timeStr); err != nil {
 			return nil, err
 		}
 	}
-	ctx.EventTypeVersion = r.Header.Get(HeaderEventTypeVersion)
-	ctx.SchemaURL = r.Header.Get(HeaderSchemaURL)
-	if ctx.CloudEventsVersion != CloudEventsVersion {
+	ctx.EventTypeVersion = getAttributeHeader(header, s.eventTypeVersion)
+	ctx.SchemaURL = getAttributeHeader(header, s.schemaURL)
+	ctx.Subject = getAttributeHeader(header, s.subject)
+	if s.version == CloudEventsVersion01 && ctx.CloudEventsVersion != CloudEventsVersion01 {
 		log.Printf("Received CloudEvent version %q; parsing as version %q",
-			ctx.CloudEventsVersion, CloudEventsVersion)
+			ctx.CloudEventsVersion, CloudEventsVersion01)
 	}
 
 	ctx.Extensions = make(map[string]interface{})
-	for k, v := range r.Header {
-		if strings.ToUpper(k)[:len(headerExtensionsPrefix)] != headerExtensionsPrefix {
+	for k, v := range header {
+		if len(k) < len(s.extensionsPrefix) || !strings.EqualFold(k[:len(s.extensionsPrefix)], s.extensionsPrefix) {
+			continue
+		}
+		name := k[len(s.extensionsPrefix):]
+		if !s.nestedExtensions {
+			// Since 0.2 extension headers share the prefix of the other
+			// attributes, and their values are strings.
+			if !s.isAttribute(name) {
+				ctx.Extensions[strings.ToLower(name)] = v[0]
+			}
 			continue
 		}
-		name := k[len(headerExtensionsPrefix):]
 		var val interface{}
 		if err := json.Unmarshal([]byte(v[0]), &val); err != nil {
 			// If this is not a JSON object, treat it as a string.
@@ -105,70 +160,96 @@ func (binary) FromRequest(data interface{}, r *http.Request) (*EventContext, err
 			ctx.Extensions[name] = val
 		}
 	}
+	s.fromExtensions(&ctx)
 
-	if err := unmarshalEventData(ctx.ContentType, r.Body, data); err != nil {
+	if err := unmarshalEventData(ctx.ContentType, body, data); err != nil {
 		return nil, err
 	}
 
 	return &ctx, nil
 }
 
-// NewRequest creates an HTTP request for Binary content encoding.
-func (binary) NewRequest(urlString string, data interface{}, context EventContext) (*http.Request, error) {
+// NewRequest creates an HTTP request for Binary content encoding, in the
+// version of the spec selected by the context.
+func (b binary) NewRequest(urlString string, data interface{}, context EventContext) (*http.Request, error) {
 	url, err := url.Parse(urlString)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := ensureRequiredFields(context); err != nil {
+	h, body, err := b.encode(data, context)
+	if err != nil {
 		return nil, err
 	}
-	// Defaultable values:
-	ceVersion := context.CloudEventsVersion
-	if ceVersion == "" {
-		ceVersion = CloudEventsVersion
+
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    url,
+		Header: h,
+		Body:   ioutil.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
+
+// encode returns the headers and body of an event in Binary content encoding.
+func (binary) encode(data interface{}, context EventContext) (http.Header, []byte, error) {
+	if err := ensureRequiredFields(context); err != nil {
+		return nil, nil, err
+	}
+	s, err := getSpec(context.CloudEventsVersion)
+	if err != nil {
+		return nil, nil, err
 	}
-	contentType := context.ContentType
+	ctx, err := ConvertContext(context, s.version)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.toExtensions(ctx)
+
+	// Defaultable values:
+	contentType := ctx.ContentType
 	if contentType == "" {
 		contentType = contentTypeJSON
 	}
 
 	// non-string values:
 	eventTime := ""
-	if !context.EventTime.IsZero() {
-		eventTime = context.EventTime.Format(time.RFC3339Nano)
+	if !ctx.EventTime.IsZero() {
+		eventTime = ctx.EventTime.Format(time.RFC3339Nano)
 	}
 
 	h := http.Header{}
-	setHeader(h, HeaderCloudEventsVersion, ceVersion)
-	setHeader(h, HeaderEventID, context.EventID)
-	setHeader(h, HeaderEventTime, eventTime)
-	setHeader(h, HeaderEventType, context.EventType)
-	setHeader(h, HeaderEventTypeVersion, context.EventTypeVersion)
-	setHeader(h, HeaderSchemaURL, context.SchemaURL)
+	setAttributeHeader(h, s.specVersion, s.version)
+	setAttributeHeader(h, s.eventID, ctx.EventID)
+	setAttributeHeader(h, s.eventTime, eventTime)
+	setAttributeHeader(h, s.eventType, ctx.EventType)
+	setAttributeHeader(h, s.eventTypeVersion, ctx.EventTypeVersion)
+	setAttributeHeader(h, s.schemaURL, ctx.SchemaURL)
+	setAttributeHeader(h, s.source, ctx.Source)
+	setAttributeHeader(h, s.subject, ctx.Subject)
 	setHeader(h, HeaderContentType, contentType)
-	setHeader(h, HeaderSource, context.Source)
-	for name, value := range context.Extensions {
+	for name, value := range ctx.Extensions {
+		if str, ok := value.(string); ok && !s.nestedExtensions {
+			h.Set(s.extensionsPrefix+name, str)
+			continue
+		}
 		encoded, err := json.Marshal(value)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		h[headerExtensionsPrefix+name] = []string{
+		if !s.nestedExtensions {
+			h.Set(s.extensionsPrefix+name, string(encoded))
+			continue
+		}
+		h[s.extensionsPrefix+name] = []string{
 			string(encoded),
 		}
 	}
 
-	b, err := marshalEventData(contentType, data)
+	body, err := marshalEventData(contentType, data)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	return &http.Request{
-		Method: http.MethodPost,
-		URL:    url,
-		Header: h,
-		Body:   ioutil.NopCloser(bytes.NewReader(b)),
-	}, nil
+	return h, body, nil
 }
 
 // TODO(inlined) URI encoding/decoding of headers
@@ -181,6 +262,24 @@ func setHeader(h http.Header, name string, value string) {
 		h.Set(name, value)
 	}
 }
+
+// getAttributeHeader returns the header of a context attribute, or "" if the
+// attribute is not defined by the version.
+func getAttributeHeader(h http.Header, attribute string) string {
+	if attribute == "" {
+		return ""
+	}
+	return getHeader(h, headerPrefix+attribute)
+}
+
+// setAttributeHeader sets the header of a context attribute if the attribute
+// is defined by the version.
+func setAttributeHeader(h http.Header, attribute string, value string) {
+	if attribute != "" {
+		setHeader(h, headerPrefix+attribute, value)
+	}
+}
+
 func getRequiredHeader(h http.Header, name string, value *string) error {
 	if *value = getHeader(h, name); *value == "" {
 		return fmt.Errorf("missing required header %q", name)
@@ -18,6 +18,7 @@ package event
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -25,81 +26,205 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	// Structured implements the JSON structured encoding/decoding
 	Structured structured = 0
+
+	fieldData       = "data"
+	fieldDataBase64 = "data_base64"
+	fieldExtensions = "extensions"
 )
 
 type structured int
 
+// structuredEnvelope holds the attributes of a structured event of a version
+// of the spec.
 type structuredEnvelope struct {
-	EventContext
-	RawData json.RawMessage `json:"data"`
+	spec       *spec
+	attributes map[string]json.RawMessage
+}
+
+// get returns the raw value of an attribute. The 0.1 envelope was decoded
+// case insensitively, which is kept for compatibility.
+func (e *structuredEnvelope) get(name string) (json.RawMessage, bool) {
+	if name == "" {
+		return nil, false
+	}
+	if v, ok := e.attributes[name]; ok {
+		return v, true
+	}
+	if e.spec.nestedExtensions {
+		for k, v := range e.attributes {
+			if strings.EqualFold(k, name) {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (e *structuredEnvelope) getString(name string, value *string) error {
+	v, ok := e.get(name)
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(v, value); err != nil {
+		return fmt.Errorf("Could not decode attribute %q: %v", name, err)
+	}
+	return nil
 }
 
-// FromRequest parses a CloudEvent from structured content encoding.
-func (structured) FromRequest(data interface{}, r *http.Request) (*EventContext, error) {
-	var e structuredEnvelope
-	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+func (e *structuredEnvelope) context() (*EventContext, error) {
+	s := e.spec
+	ctx := EventContext{
+		Extensions: make(map[string]interface{}),
+	}
+	var eventTime string
+	err := anyError(
+		e.getString(s.specVersion, &ctx.CloudEventsVersion),
+		e.getString(s.eventID, &ctx.EventID),
+		e.getString(s.eventTime, &eventTime),
+		e.getString(s.eventType, &ctx.EventType),
+		e.getString(s.eventTypeVersion, &ctx.EventTypeVersion),
+		e.getString(s.schemaURL, &ctx.SchemaURL),
+		e.getString(s.contentType, &ctx.ContentType),
+		e.getString(s.source, &ctx.Source),
+		e.getString(s.subject, &ctx.Subject))
+	if err != nil {
 		return nil, err
 	}
+	if eventTime != "" {
+		if ctx.EventTime, err = time.Parse(time.RFC3339Nano, eventTime); err != nil {
+			return nil, err
+		}
+	}
 
-	contentType := e.EventContext.ContentType
-	if contentType == "" {
-		contentType = contentTypeJSON
+	if s.nestedExtensions {
+		if v, ok := e.get(fieldExtensions); ok {
+			if err := json.Unmarshal(v, &ctx.Extensions); err != nil {
+				return nil, fmt.Errorf("Could not decode extensions: %v", err)
+			}
+			if ctx.Extensions == nil {
+				ctx.Extensions = make(map[string]interface{})
+			}
+		}
+	} else {
+		for name, v := range e.attributes {
+			if name == fieldData || name == fieldDataBase64 || s.isAttribute(name) {
+				continue
+			}
+			var val interface{}
+			if err := json.Unmarshal(v, &val); err != nil {
+				return nil, fmt.Errorf("Could not decode extension %q: %v", name, err)
+			}
+			ctx.Extensions[name] = val
+		}
+	}
+	s.fromExtensions(&ctx)
+	return &ctx, nil
+}
+
+// data returns a reader of the event data, decoded from the envelope.
+func (e *structuredEnvelope) data(contentType string) (io.Reader, error) {
+	if v, ok := e.get(fieldDataBase64); ok && e.spec.version == CloudEventsVersion10 {
+		var encoded string
+		if err := json.Unmarshal(v, &encoded); err != nil {
+			return nil, fmt.Errorf("Could not decode %q: %v", fieldDataBase64, err)
+		}
+		b, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode %q: %v", fieldDataBase64, err)
+		}
+		return bytes.NewReader(b), nil
+	}
+
+	rawData, ok := e.get(fieldData)
+	if !ok {
+		return bytes.NewReader(nil), nil
 	}
-	var reader io.Reader
 	if !isJSONEncoding(contentType) {
 		var jsonDecoded string
-		if err := json.Unmarshal(e.RawData, &jsonDecoded); err != nil {
-			return nil, fmt.Errorf("Could not JSON decode %q value %q", contentType, string(e.RawData))
+		if err := json.Unmarshal(rawData, &jsonDecoded); err != nil {
+			return nil, fmt.Errorf("Could not JSON decode %q value %q", contentType, string(rawData))
 		}
-		reader = strings.NewReader(jsonDecoded)
-	} else {
-		reader = bytes.NewReader(e.RawData)
+		return strings.NewReader(jsonDecoded), nil
 	}
-	if e.EventContext.Extensions == nil {
-		e.EventContext.Extensions = make(map[string]interface{}, 0)
+	return bytes.NewReader(rawData), nil
+}
+
+// FromRequest parses a CloudEvent from structured content encoding. The
+// version of the event is read from its specversion attribute, or is 0.1 if
+// it is missing.
+func (s structured) FromRequest(data interface{}, r *http.Request) (*EventContext, error) {
+	return s.decode(r.Body, data)
+}
+
+// decode parses event data and context from a structured envelope.
+func (structured) decode(body io.Reader, data interface{}) (*EventContext, error) {
+	var attributes map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&attributes); err != nil {
+		return nil, err
 	}
-	if err := unmarshalEventData(contentType, reader, data); err != nil {
+	e, err := newStructuredEnvelope(attributes)
+	if err != nil {
 		return nil, err
 	}
-	return &e.EventContext, nil
+	return e.decode(data)
 }
 
-// NewRequest creates an HTTP request for Structured content encoding.
-func (structured) NewRequest(urlString string, data interface{}, context EventContext) (*http.Request, error) {
-	url, err := url.Parse(urlString)
+// newStructuredEnvelope creates the envelope of a structured event from its
+// decoded attributes, in the version of the spec of its specversion attribute.
+func newStructuredEnvelope(attributes map[string]json.RawMessage) (*structuredEnvelope, error) {
+	version := CloudEventsVersion01
+	if v, ok := attributes[specs[CloudEventsVersion02].specVersion]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, fmt.Errorf("Could not decode CloudEvents version: %v", err)
+		}
+	}
+	s, err := getSpec(version)
 	if err != nil {
 		return nil, err
 	}
+	return &structuredEnvelope{spec: s, attributes: attributes}, nil
+}
 
-	if err := ensureRequiredFields(context); err != nil {
+// decode returns the context of the event and decodes its data into data.
+func (e *structuredEnvelope) decode(data interface{}) (*EventContext, error) {
+	ctx, err := e.context()
+	if err != nil {
 		return nil, err
 	}
 
-	contentType := context.ContentType
+	contentType := ctx.ContentType
 	if contentType == "" {
 		contentType = contentTypeJSON
 	}
-	e := structuredEnvelope{
-		EventContext: context,
-	}
-	dataBytes, err := marshalEventData(contentType, data)
+	reader, err := e.data(contentType)
 	if err != nil {
 		return nil, err
 	}
-	if isJSONEncoding(contentType) {
-		e.RawData = json.RawMessage(dataBytes)
-	} else {
-		e.RawData, err = json.Marshal(string(dataBytes))
-		if err != nil {
-			return nil, err
-		}
+	if err := unmarshalEventData(contentType, reader, data); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// NewRequest creates an HTTP request for Structured content encoding, in the
+// version of the spec selected by the context.
+func (structured) NewRequest(urlString string, data interface{}, context EventContext) (*http.Request, error) {
+	url, err := url.Parse(urlString)
+	if err != nil {
+		return nil, err
 	}
 
+	e, err := Structured.envelope(data, context)
+	if err != nil {
+		return nil, err
+	}
 	b, err := json.Marshal(e)
 	if err != nil {
 		return nil, err
@@ -114,3 +239,80 @@ func (structured) NewRequest(urlString string, data interface{}, context EventCo
 		Body:   ioutil.NopCloser(bytes.NewReader(b)),
 	}, nil
 }
+
+// envelope returns the structured envelope of an event, in the version of the
+// spec selected by the context.
+func (structured) envelope(data interface{}, context EventContext) (map[string]interface{}, error) {
+	if err := ensureRequiredFields(context); err != nil {
+		return nil, err
+	}
+	s, err := getSpec(context.CloudEventsVersion)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := ConvertContext(context, s.version)
+	if err != nil {
+		return nil, err
+	}
+	s.toExtensions(ctx)
+
+	contentType := ctx.ContentType
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+	eventTime := ""
+	if !ctx.EventTime.IsZero() {
+		eventTime = ctx.EventTime.Format(time.RFC3339Nano)
+	}
+
+	// The 0.1 envelope only carries the version if the context selects it.
+	specVersion := s.version
+	if s.version == CloudEventsVersion01 {
+		specVersion = context.CloudEventsVersion
+	}
+
+	e := make(map[string]interface{})
+	setAttribute(e, s.specVersion, specVersion)
+	setAttribute(e, s.eventID, ctx.EventID)
+	setAttribute(e, s.eventTime, eventTime)
+	setAttribute(e, s.eventType, ctx.EventType)
+	setAttribute(e, s.eventTypeVersion, ctx.EventTypeVersion)
+	setAttribute(e, s.schemaURL, ctx.SchemaURL)
+	setAttribute(e, s.contentType, ctx.ContentType)
+	setAttribute(e, s.source, ctx.Source)
+	setAttribute(e, s.subject, ctx.Subject)
+	if s.nestedExtensions {
+		if len(ctx.Extensions) > 0 {
+			e[fieldExtensions] = ctx.Extensions
+		}
+	} else {
+		for name, value := range ctx.Extensions {
+			e[name] = value
+		}
+	}
+
+	dataBytes, err := marshalEventData(contentType, data)
+	if err != nil {
+		return nil, err
+	}
+	// Empty data is omitted. Since 1.0 data which is not text is base64
+	// encoded.
+	switch {
+	case len(dataBytes) == 0:
+	case isJSONEncoding(contentType):
+		e[fieldData] = json.RawMessage(dataBytes)
+	case s.version == CloudEventsVersion10 && !utf8.Valid(dataBytes):
+		e[fieldDataBase64] = base64.StdEncoding.EncodeToString(dataBytes)
+	default:
+		e[fieldData] = string(dataBytes)
+	}
+	return e, nil
+}
+
+// setAttribute sets a context attribute of a structured envelope if the
+// attribute is defined by the version and the value is set.
+func setAttribute(e map[string]interface{}, attribute string, value string) {
+	if attribute != "" && value != "" {
+		e[attribute] = value
+	}
+}
@@ -18,19 +18,20 @@ package event
 
 import (
 	"context"
-	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"reflect"
+	"strings"
 	"time"
 )
 
 const (
-	// CloudEventsVersion is the version of the CloudEvents spec targeted
-	// by this library.
-	CloudEventsVersion = "0.1"
+	// CloudEventsVersion is the version of the CloudEvents spec emitted by
+	// this library when an EventContext does not select one.
+	CloudEventsVersion = CloudEventsVersion01
 
 	// ContentTypeStructuredJSON is the content-type for "Structured" encoding
 	// where an event envelope is written in JSON and the body is arbitrary
@@ -41,9 +42,9 @@ const (
 	// the event context is in HTTP headers and the body is a JSON event data.
 	ContentTypeBinaryJSON = "application/json"
 
-	// TODO(inlined) what about charset additions?
 	contentTypeJSON = "application/json"
 	contentTypeXML  = "application/xml"
+	contentTypeText = "text/plain"
 
 	// HeaderContentType is the standard HTTP header "Content-Type"
 	HeaderContentType = "Content-Type"
@@ -57,9 +58,13 @@ const (
 
 // EventContext holds standard metadata about an event. See
 // https://github.com/cloudevents/spec/blob/v0.1/spec.md#context-attributes for
-// details on these fields.
+// details on these fields. The fields are independent of the version of the
+// spec; attributes which a version does not define are carried as extensions
+// of that version.
 type EventContext struct {
-	// The version of the CloudEvents specification used by the event.
+	// The version of the CloudEvents specification used by the event. Events
+	// are read with the version they were sent with and sent with the version
+	// selected here, CloudEventsVersion if empty.
 	CloudEventsVersion string `json:"cloudEventsVersion,omitempty"`
 	// ID of the event; must be non-empty and unique within the scope of the producer.
 	EventID string `json:"eventID"`
@@ -76,10 +81,19 @@ type EventContext struct {
 	ContentType string `json:"contentType,omitempty"`
 	// A URI describing the event producer.
 	Source string `json:"source"`
+	// The subject of the event in the context of the producer, added in 1.0.
+	Subject string `json:"subject,omitempty"`
 	// Additional metadata without a well-defined structure.
 	Extensions map[string]interface{} `json:"extensions,omitempty"`
 }
 
+// Event is a CloudEvent, its context and data. Handlers return an Event to
+// respond with a CloudEvent.
+type Event struct {
+	Context EventContext
+	Data    interface{}
+}
+
 // HTTPMarshaller implements a scheme for decoding CloudEvents over HTTP.
 // Implementations are Binary, Structured, and Any
 type HTTPMarshaller interface {
@@ -118,13 +132,30 @@ func require(name string, value string) error {
 // HTTP body itself allows for cross-encoding of the "data" field.
 // This method is only intended for checking that inner JSON encoding type.
 func isJSONEncoding(encoding string) bool {
+	encoding = mediaType(encoding)
 	return encoding == contentTypeJSON || encoding == "text/json"
 }
 
-func isXMLEncoding(encoding string) bool {
-	return encoding == contentTypeXML || encoding == "text/xml"
+// mediaType returns the media type of a content type without parameters such
+// as charset.
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
 }
 
+var (
+	errUnsupportedData = errors.New("unsupported data type")
+
+	readerPtrType = reflect.TypeOf((*io.Reader)(nil))
+	bytesPtrType  = reflect.TypeOf((*[]byte)(nil))
+)
+
+// unmarshalEventData decodes event data into data, which is a pointer. Data of
+// any content type may be read as *io.Reader or *[]byte, other types are
+// decoded by the Codec registered for the content type. An empty content type
+// is decoded as JSON.
 func unmarshalEventData(encoding string, reader io.Reader, data interface{}) error {
 	// The Handler tools allow developers to not ask for event data;
 	// in this case, just don't unmarshal anything
@@ -134,52 +165,72 @@ func unmarshalEventData(encoding string, reader io.Reader, data interface{}) err
 
 	// If someone tried to marshal an event into an io.Reader, just assign our existing reader.
 	// (This is used by event.Mux to determine which type to unmarshal as)
-	readerPtrType := reflect.TypeOf((*io.Reader)(nil))
-	if reflect.TypeOf(data).ConvertibleTo(readerPtrType) {
+	dataType := reflect.TypeOf(data)
+	if dataType.ConvertibleTo(readerPtrType) {
 		reflect.ValueOf(data).Elem().Set(reflect.ValueOf(reader))
 		return nil
 	}
-	if isJSONEncoding(encoding) || encoding == "" {
-		return json.NewDecoder(reader).Decode(&data)
+	if dataType.ConvertibleTo(bytesPtrType) {
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		reflect.ValueOf(data).Elem().SetBytes(b)
+		return nil
 	}
 
-	if isXMLEncoding(encoding) {
-		return xml.NewDecoder(reader).Decode(&data)
+	if encoding == "" {
+		encoding = contentTypeJSON
 	}
-
-	return fmt.Errorf("Cannot decode content type %q", encoding)
+	codec := lookupCodec(encoding)
+	if codec == nil {
+		return fmt.Errorf("Cannot decode content type %q", encoding)
+	}
+	if err := codec.Decode(reader, data); err != nil {
+		if err == errUnsupportedData {
+			return fmt.Errorf("Cannot decode content type %q into %T", encoding, data)
+		}
+		return err
+	}
+	return nil
 }
 
+// marshalEventData encodes event data. Data of any content type may be passed
+// as []byte or io.Reader, other types are encoded by the Codec registered for
+// the content type. Nil data without a Codec is encoded as an empty body.
 func marshalEventData(encoding string, data interface{}) ([]byte, error) {
-	var b []byte
-	var err error
-
-	if isJSONEncoding(encoding) {
-		b, err = json.Marshal(data)
-	} else if isXMLEncoding(encoding) {
-		b, err = xml.Marshal(data)
-	} else {
-		err = fmt.Errorf("Cannot encode content type %q", encoding)
+	switch d := data.(type) {
+	case []byte:
+		return d, nil
+	case io.Reader:
+		return ioutil.ReadAll(d)
 	}
 
-	if err != nil {
-		return nil, err
+	codec := lookupCodec(encoding)
+	if codec == nil {
+		if data == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Cannot encode content type %q", encoding)
+	}
+	b, err := codec.Encode(data)
+	if err == errUnsupportedData {
+		return nil, fmt.Errorf("Cannot encode %T as content type %q", data, encoding)
 	}
-	return b, nil
+	return b, err
 }
 
-// FromRequest parses a CloudEvent from any known encoding.
+// FromRequest parses a CloudEvent from any known encoding. Requests with the
+// structured content type are parsed as structured events, any other request
+// is parsed as a binary event. Batches are read with NewIterator.
 func FromRequest(data interface{}, r *http.Request) (*EventContext, error) {
-	switch r.Header.Get(HeaderContentType) {
+	switch mediaType(r.Header.Get(HeaderContentType)) {
 	case ContentTypeStructuredJSON:
 		return Structured.FromRequest(data, r)
-	case ContentTypeBinaryJSON:
-		return Binary.FromRequest(data, r)
+	case ContentTypeBatchJSON:
+		return nil, errors.New("Cannot parse a batch of events as a single event")
 	default:
-		// TODO: assume binary content mode
-		// (https://github.com/cloudevents/spec/blob/v0.1/http-transport-binding.md#3-http-message-mapping)
-		// and that data is ??? (io.Reader?, byte array?)
-		return nil, fmt.Errorf("Cannot handle encoding %q", r.Header.Get("Content-Type"))
+		return Binary.FromRequest(data, r)
 	}
 }
 
@@ -188,12 +239,25 @@ func NewRequest(urlString string, data interface{}, context EventContext) (*http
 	return Structured.NewRequest(urlString, data, context)
 }
 
-// Opaque key type used to store EventContexts in a context.Context
+// Opaque key types used to store EventContexts in a context.Context
 type contextKeyType struct{}
+type batchContextKeyType struct{}
 
-var contextKey = contextKeyType{}
+var (
+	contextKey      = contextKeyType{}
+	batchContextKey = batchContextKeyType{}
+)
 
-// FromContext loads an EventContext from a normal context.Context
+// FromContext loads an EventContext from a normal context.Context. It returns
+// nil for a batch of events.
 func FromContext(ctx context.Context) *EventContext {
-	return ctx.Value(contextKey).(*EventContext)
+	eventContext, _ := ctx.Value(contextKey).(*EventContext)
+	return eventContext
+}
+
+// BatchFromContext loads the EventContexts of a batch of events from a normal
+// context.Context, in the order of the events of the batch.
+func BatchFromContext(ctx context.Context) []*EventContext {
+	eventContexts, _ := ctx.Value(batchContextKey).([]*EventContext)
+	return eventContexts
 }
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventtest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Matcher matches recorded events.
+type Matcher interface {
+	// Match returns true if the event matches.
+	Match(e Event) bool
+
+	// String describes the events which match, for test failures.
+	String() string
+}
+
+type matcherFunc struct {
+	description string
+	match       func(e Event) bool
+}
+
+func (m matcherFunc) Match(e Event) bool {
+	return m.match(e)
+}
+
+func (m matcherFunc) String() string {
+	return m.description
+}
+
+// MatcherFunc creates a Matcher from a func and a description of the events
+// it matches.
+func MatcherFunc(description string, match func(e Event) bool) Matcher {
+	return matcherFunc{description: description, match: match}
+}
+
+// HasType matches events of a type.
+func HasType(eventType string) Matcher {
+	return MatcherFunc(fmt.Sprintf("with type %q", eventType), func(e Event) bool {
+		return e.Context.EventType == eventType
+	})
+}
+
+// HasSource matches events from a source.
+func HasSource(source string) Matcher {
+	return MatcherFunc(fmt.Sprintf("with source %q", source), func(e Event) bool {
+		return e.Context.Source == source
+	})
+}
+
+// HasID matches the event with an ID.
+func HasID(eventID string) Matcher {
+	return MatcherFunc(fmt.Sprintf("with ID %q", eventID), func(e Event) bool {
+		return e.Context.EventID == eventID
+	})
+}
+
+// HasExtension matches events with an extension of a value. Extension names
+// are compared case insensitively, as HTTP headers are.
+func HasExtension(name string, value interface{}) Matcher {
+	return MatcherFunc(fmt.Sprintf("with extension %s=%v", name, value), func(e Event) bool {
+		for k, v := range e.Context.Extensions {
+			if strings.EqualFold(k, name) && reflect.DeepEqual(v, value) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func filter(events []Event, matchers []Matcher) []Event {
+	var matched []Event
+	for _, e := range events {
+		if matches(e, matchers) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+func matches(e Event, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.Match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func describe(matchers []Matcher) string {
+	if len(matchers) == 0 {
+		return "of any kind"
+	}
+	descriptions := make([]string, len(matchers))
+	for i, m := range matchers {
+		descriptions[i] = m.String()
+	}
+	return strings.Join(descriptions, " and ")
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+const (
+	// DefaultEventType is the type of events built without one.
+	DefaultEventType = "dev.knative.eventtest"
+
+	// DefaultSource is the source of events built without one.
+	DefaultSource = "tests://eventtest"
+
+	// target is the URL of built requests.
+	target = "http://example.com/"
+)
+
+var eventIDs int64
+
+// NewContext returns the context of a valid event of a type, with a unique ID
+// and the current time.
+func NewContext(eventType string) event.EventContext {
+	return Complete(event.EventContext{EventType: eventType})
+}
+
+// Complete returns a copy of the context with the required attributes set,
+// defaulting to a unique ID, DefaultEventType and DefaultSource, and the
+// current time if it is not set.
+func Complete(context event.EventContext) event.EventContext {
+	if context.EventID == "" {
+		context.EventID = fmt.Sprintf("eventtest-%d", atomic.AddInt64(&eventIDs, 1))
+	}
+	if context.EventType == "" {
+		context.EventType = DefaultEventType
+	}
+	if context.Source == "" {
+		context.Source = DefaultSource
+	}
+	if context.EventTime.IsZero() {
+		context.EventTime = time.Now().UTC()
+	}
+	return context
+}
+
+// BinaryRequest builds a request of an event in binary content mode. The
+// context is completed with Complete. The request is an incoming server
+// request, as built by httptest.NewRequest, to be passed to a handler.
+func BinaryRequest(t testing.TB, context event.EventContext, data interface{}) *http.Request {
+	t.Helper()
+	return serverRequest(t, event.Binary, context, data)
+}
+
+// StructuredRequest builds a request of an event in structured content mode.
+// The context is completed with Complete. The request is an incoming server
+// request, as built by httptest.NewRequest, to be passed to a handler.
+func StructuredRequest(t testing.TB, context event.EventContext, data interface{}) *http.Request {
+	t.Helper()
+	return serverRequest(t, event.Structured, context, data)
+}
+
+func serverRequest(t testing.TB, encoding event.HTTPMarshaller, context event.EventContext, data interface{}) *http.Request {
+	t.Helper()
+	req, err := encoding.NewRequest(target, data, Complete(context))
+	if err != nil {
+		t.Fatalf("Failed to build event request: %v", err)
+	}
+	server := httptest.NewRequest(req.Method, target, req.Body)
+	for name, values := range req.Header {
+		server.Header[name] = values
+	}
+	return server
+}
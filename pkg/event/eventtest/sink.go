@@ -0,0 +1,241 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package eventtest provides utilities for testing CloudEvents handlers,
+// senders and buses: a Sink server which records the events it receives,
+// assertions on the recorded events, and builders of event requests.
+package eventtest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+// Event is an event received by a Sink.
+type Event struct {
+	Context event.EventContext
+	// Data is the encoded data of the event.
+	Data []byte
+	// Header holds the headers of the request which carried the event.
+	Header http.Header
+	// Structured is set if the event was received in structured content
+	// mode or in a batch.
+	Structured bool
+}
+
+// DecodeData decodes the data of the event by its content type into data,
+// which is a pointer.
+func (e Event) DecodeData(data interface{}) error {
+	req, err := event.Binary.NewRequest("", e.Data, e.Context)
+	if err != nil {
+		return err
+	}
+	_, err = event.Binary.FromRequest(data, req)
+	return err
+}
+
+// Sink is an HTTP server which records the CloudEvents it receives, including
+// each event of a batch. Requests which are not CloudEvents are rejected with
+// a StatusBadRequest response and counted. Events are accepted with a
+// StatusAccepted response unless other statuses are set with Respond.
+type Sink struct {
+	// URL is the address of the server.
+	URL string
+
+	server   *httptest.Server
+	mutex    sync.Mutex
+	events   []Event
+	rejected int
+	statuses []int
+	// received is closed and replaced whenever an event is recorded.
+	received chan struct{}
+}
+
+// NewSink starts a Sink. The caller should call Close when finished, to shut
+// it down.
+func NewSink() *Sink {
+	s := &Sink{received: make(chan struct{})}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Sink) Close() {
+	s.server.Close()
+}
+
+// Client returns an HTTP client configured for the server.
+func (s *Sink) Client() *http.Client {
+	return s.server.Client()
+}
+
+// ServeHTTP records the events of a request.
+func (s *Sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	events, err := readEvents(r)
+	if err != nil {
+		s.mutex.Lock()
+		s.rejected++
+		s.mutex.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	status := http.StatusAccepted
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	// Events are only recorded when they are accepted, like a subscriber
+	// which failed to process them.
+	if status < 300 {
+		s.events = append(s.events, events...)
+		close(s.received)
+		s.received = make(chan struct{})
+	}
+	s.mutex.Unlock()
+	w.WriteHeader(status)
+}
+
+func readEvents(r *http.Request) ([]Event, error) {
+	structured := strings.HasPrefix(strings.ToLower(r.Header.Get(event.HeaderContentType)), event.ContentTypeStructuredJSON)
+	it, err := event.NewIterator(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for {
+		var data []byte
+		ctx, err := it.Next(&data)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, Event{
+			Context:    *ctx,
+			Data:       data,
+			Header:     r.Header,
+			Structured: structured || event.IsBatch(r),
+		})
+	}
+}
+
+// Respond sets the statuses of the responses to the next requests which are
+// CloudEvents, in turn. Events are only recorded when the status is
+// successful.
+func (s *Sink) Respond(statuses ...int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.statuses = append(s.statuses, statuses...)
+}
+
+// Events returns the recorded events which match all of the matchers, in the
+// order they were received.
+func (s *Sink) Events(matchers ...Matcher) []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return filter(s.events, matchers)
+}
+
+// Rejected returns the number of requests which were not CloudEvents.
+func (s *Sink) Rejected() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rejected
+}
+
+// Reset forgets the recorded events and rejected requests.
+func (s *Sink) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = nil
+	s.rejected = 0
+}
+
+// Await waits until an event which matches all of the matchers is recorded,
+// and returns the first such event. An error is returned if none is recorded
+// before the timeout.
+func (s *Sink) Await(timeout time.Duration, matchers ...Matcher) (Event, error) {
+	events, err := s.AwaitCount(1, timeout, matchers...)
+	if err != nil {
+		return Event{}, err
+	}
+	return events[0], nil
+}
+
+// AwaitCount waits until n events which match all of the matchers are
+// recorded, and returns the matching events. An error is returned if fewer
+// are recorded before the timeout.
+func (s *Sink) AwaitCount(n int, timeout time.Duration, matchers ...Matcher) ([]Event, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mutex.Lock()
+		events := filter(s.events, matchers)
+		received := s.received
+		s.mutex.Unlock()
+		if len(events) >= n {
+			return events, nil
+		}
+
+		select {
+		case <-received:
+		case <-timer.C:
+			return nil, fmt.Errorf("timed out after %v waiting for %d events %s, got %d", timeout, n, describe(matchers), len(events))
+		}
+	}
+}
+
+// AwaitEvent waits like Await, and fails the test if no event is recorded.
+func (s *Sink) AwaitEvent(t testing.TB, timeout time.Duration, matchers ...Matcher) Event {
+	t.Helper()
+	e, err := s.Await(timeout, matchers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// AssertReceived fails the test if no recorded event matches all of the
+// matchers. It returns the first matching event.
+func (s *Sink) AssertReceived(t testing.TB, matchers ...Matcher) Event {
+	t.Helper()
+	events := s.Events(matchers...)
+	if len(events) == 0 {
+		t.Errorf("Expected an event %s among %d events", describe(matchers), len(s.Events()))
+		return Event{}
+	}
+	return events[0]
+}
+
+// AssertNotReceived fails the test if a recorded event matches all of the
+// matchers.
+func (s *Sink) AssertNotReceived(t testing.TB, matchers ...Matcher) {
+	t.Helper()
+	if events := s.Events(matchers...); len(events) > 0 {
+		t.Errorf("Unexpected event %s: %+v", describe(matchers), events[0].Context)
+	}
+}
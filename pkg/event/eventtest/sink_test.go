@@ -0,0 +1,203 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventtest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/event"
+	"github.com/knative/eventing/pkg/event/eventtest"
+)
+
+type order struct {
+	ID int `json:"id"`
+}
+
+func TestSinkRecordsEvents(t *testing.T) {
+	sink := eventtest.NewSink()
+	defer sink.Close()
+
+	for _, test := range []struct {
+		name       string
+		req        *http.Request
+		structured bool
+	}{
+		{
+			name: "binary",
+			req:  eventtest.BinaryRequest(t, event.EventContext{EventType: "dev.knative.order", Extensions: map[string]interface{}{"region": "eu"}}, order{ID: 1}),
+		},
+		{
+			name:       "structured",
+			req:        eventtest.StructuredRequest(t, event.EventContext{EventType: "dev.knative.order", Source: "tests://shop"}, order{ID: 2}),
+			structured: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			sink.ServeHTTP(w, test.req)
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("Got wrong status; wanted=%d; got=%d", http.StatusAccepted, w.Code)
+			}
+		})
+	}
+
+	events := sink.Events(eventtest.HasType("dev.knative.order"))
+	if len(events) != 2 {
+		t.Fatalf("Got wrong number of events; wanted=2; got=%d", len(events))
+	}
+	for i, e := range events {
+		var data order
+		if err := e.DecodeData(&data); err != nil {
+			t.Fatalf("Failed to decode data of event %d: %v", i, err)
+		}
+		if data.ID != i+1 {
+			t.Errorf("Got wrong data of event %d; wanted=%d; got=%d", i, i+1, data.ID)
+		}
+		if e.Structured != (i == 1) {
+			t.Errorf("Got wrong mode of event %d; structured=%v", i, e.Structured)
+		}
+	}
+
+	sink.AssertReceived(t, eventtest.HasExtension("Region", "eu"), eventtest.HasSource(eventtest.DefaultSource))
+	sink.AssertReceived(t, eventtest.HasSource("tests://shop"))
+	sink.AssertNotReceived(t, eventtest.HasType("dev.knative.refund"))
+	sink.AssertNotReceived(t, eventtest.HasSource("tests://shop"), eventtest.HasExtension("region", "eu"))
+}
+
+func TestSinkBatch(t *testing.T) {
+	sink := eventtest.NewSink()
+	defer sink.Close()
+
+	req, err := event.NewBatchRequest(sink.URL, []event.Event{
+		{Context: eventtest.NewContext("dev.knative.a"), Data: order{ID: 1}},
+		{Context: eventtest.NewContext("dev.knative.b"), Data: order{ID: 2}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create batch: %v", err)
+	}
+	res, err := sink.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send batch: %v", err)
+	}
+	res.Body.Close()
+
+	sink.AssertReceived(t, eventtest.HasType("dev.knative.a"))
+	sink.AssertReceived(t, eventtest.HasType("dev.knative.b"))
+	if got := len(sink.Events()); got != 2 {
+		t.Errorf("Got wrong number of events; wanted=2; got=%d", got)
+	}
+}
+
+func TestSinkRejectsNonEvents(t *testing.T) {
+	sink := eventtest.NewSink()
+	defer sink.Close()
+
+	res, err := sink.Client().Post(sink.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Got wrong status; wanted=%d; got=%d", http.StatusBadRequest, res.StatusCode)
+	}
+	if sink.Rejected() != 1 || len(sink.Events()) != 0 {
+		t.Errorf("Got wrong records; rejected=%d; events=%d", sink.Rejected(), len(sink.Events()))
+	}
+
+	sink.Reset()
+	if sink.Rejected() != 0 {
+		t.Errorf("Expected no rejected requests after a reset; got %d", sink.Rejected())
+	}
+}
+
+func TestSinkRespond(t *testing.T) {
+	sink := eventtest.NewSink()
+	defer sink.Close()
+	sink.Respond(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	client := event.NewClient()
+	client.HTTPClient = sink.Client()
+	client.Backoff = time.Millisecond
+	eventContext := eventtest.NewContext("dev.knative.retry")
+	if err := client.Send(context.Background(), sink.URL, eventContext, order{ID: 1}); err != nil {
+		t.Fatalf("Failed to send event: %v", err)
+	}
+
+	// Only the accepted delivery is recorded.
+	if got := len(sink.Events(eventtest.HasID(eventContext.EventID))); got != 1 {
+		t.Errorf("Got wrong number of events; wanted=1; got=%d", got)
+	}
+}
+
+func TestSinkAwait(t *testing.T) {
+	sink := eventtest.NewSink()
+	defer sink.Close()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+			sink.ServeHTTP(httptest.NewRecorder(), eventtest.BinaryRequest(t, eventtest.NewContext("dev.knative.tick"), nil))
+		}
+	}()
+
+	events, err := sink.AwaitCount(3, 5*time.Second, eventtest.HasType("dev.knative.tick"))
+	if err != nil {
+		t.Fatalf("Failed to await events: %v", err)
+	}
+	if len(events) != 3 {
+		t.Errorf("Got wrong number of events; wanted=3; got=%d", len(events))
+	}
+	sink.AwaitEvent(t, time.Second, eventtest.HasType("dev.knative.tick"))
+
+	_, err = sink.Await(20*time.Millisecond, eventtest.HasType("dev.knative.missing"))
+	if err == nil || !strings.Contains(err.Error(), `with type "dev.knative.missing"`) {
+		t.Errorf("Got wrong error; wanted a timeout; got=%v", err)
+	}
+}
+
+func TestRequestBuilders(t *testing.T) {
+	var got []*event.EventContext
+	handler := event.Handler(func(ctx context.Context, data order) error {
+		got = append(got, event.FromContext(ctx))
+		return nil
+	})
+
+	for _, req := range []*http.Request{
+		eventtest.BinaryRequest(t, event.EventContext{}, order{ID: 1}),
+		eventtest.StructuredRequest(t, event.EventContext{CloudEventsVersion: event.CloudEventsVersion10}, order{ID: 2}),
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code >= 300 {
+			t.Fatalf("Got wrong status; wanted success; got=%d; body=%s", w.Code, w.Body.String())
+		}
+	}
+	if len(got) != 2 {
+		t.Fatalf("Got wrong number of events; wanted=2; got=%d", len(got))
+	}
+	if got[0].EventID == "" || got[0].EventID == got[1].EventID {
+		t.Errorf("Expected unique event IDs; got %q and %q", got[0].EventID, got[1].EventID)
+	}
+	if got[1].EventType != eventtest.DefaultEventType || got[1].Source != eventtest.DefaultSource {
+		t.Errorf("Got wrong defaults; type=%q; source=%q", got[1].EventType, got[1].Source)
+	}
+}
@@ -20,12 +20,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"io"
+	"log"
 	"net/http"
 	"reflect"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/google/uuid"
 )
 
 type handler struct {
@@ -45,7 +47,11 @@ type errAndHandler interface {
 
 const (
 	inParamUsage  = "Expected a function taking either no parameters, a context.Context, or (context.Context, any)"
-	outParamUsage = "Expected a function returning either nothing, an error, or (any, error)"
+	outParamUsage = "Expected a function returning either nothing, an error, (any, error), or (EventContext, any, error)"
+
+	// ExtensionCausationID is the extension of a CloudEvent returned by a
+	// handler which holds the ID of the event the handler received.
+	ExtensionCausationID = "causationid"
 )
 
 var (
@@ -57,8 +63,10 @@ var (
 	// it leaves this stack frame. The workaround is to pass a pointer to an interface and then
 	// get the type of its reference.
 	// For example, see: https://play.golang.org/p/_dxLvdkvqvg
-	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
-	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+	eventContextType = reflect.TypeOf(EventContext{})
+	iteratorType     = reflect.TypeOf((*Iterator)(nil)).Elem()
 )
 
 // Verifies that the inputs to a function have a valid signature; panics otherwise.
@@ -82,9 +90,14 @@ func validateInParamSignature(fnType reflect.Type) error {
 
 // Verifies that the outputs of a function have a valid signature; panics otherwise.
 // Valid output signatures:
-// (), (error), (any, error)
+// (), (error), (any, error), (EventContext, any, error)
 func validateOutParamSignature(fnType reflect.Type) error {
 	switch fnType.NumOut() {
+	case 3:
+		if fnType.Out(0) != eventContextType {
+			return fmt.Errorf("%s; cannot convert return type 0 from %s to event.EventContext", outParamUsage, fnType.Out(0))
+		}
+		fallthrough
 	case 2:
 		fallthrough
 	case 1:
@@ -151,14 +164,25 @@ func unwrapReturnValues(res []reflect.Value) (interface{}, error) {
 		}
 		// Should be a safe cast due to assertEventHandler()
 		return nil, res[1].Interface().(error)
+	case 3:
+		if res[2].IsNil() {
+			return &Event{
+				// Should be a safe cast due to assertEventHandler()
+				Context: res[0].Interface().(EventContext),
+				Data:    res[1].Interface(),
+			}, nil
+		}
+		// Should be a safe cast due to assertEventHandler()
+		return nil, res[2].Interface().(error)
 	default:
 		// Should never happen due to assertEventHandler()
-		panic("Cannot unmarshal more than 2 return values")
+		panic("Cannot unmarshal more than 3 return values")
 	}
 }
 
-// Accepts the results from a handler functions and translates them to an HTTP response
-func respondHTTP(outparams []reflect.Value, w http.ResponseWriter) {
+// Accepts the results from a handler functions and translates them to an HTTP response.
+// The inbound context is that of the event passed to the handler, if any.
+func respondHTTP(outparams []reflect.Value, w http.ResponseWriter, inbound *EventContext) {
 	res, err := unwrapReturnValues(outparams)
 
 	if err != nil {
@@ -168,6 +192,15 @@ func respondHTTP(outparams []reflect.Value, w http.ResponseWriter) {
 		return
 	}
 
+	if e, ok := res.(*Event); ok {
+		if e == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		respondEvent(e, w, inbound)
+		return
+	}
+
 	if res != nil {
 		json, err := json.Marshal(res)
 		if err != nil {
@@ -183,6 +216,50 @@ func respondHTTP(outparams []reflect.Value, w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Renders an event returned by a handler as a Binary CloudEvent response.
+func respondEvent(e *Event, w http.ResponseWriter, inbound *EventContext) {
+	h, body, err := Binary.encode(e.Data, responseContext(e.Context, inbound))
+	if err != nil {
+		log.Printf("Failed to marshal returned event %+v: %s", e.Context, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`Internal server error`))
+		return
+	}
+
+	for k, v := range h {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// responseContext defaults the context of an event returned by a handler. A
+// new ID and the current time are used unless set, and the event is sent with
+// the version of the inbound event and a causation extension pointing at it.
+func responseContext(context EventContext, inbound *EventContext) EventContext {
+	if context.EventID == "" {
+		context.EventID = uuid.New().String()
+	}
+	if context.EventTime.IsZero() {
+		context.EventTime = time.Now().UTC()
+	}
+	if inbound == nil {
+		return context
+	}
+	if context.CloudEventsVersion == "" {
+		context.CloudEventsVersion = inbound.CloudEventsVersion
+	}
+	if _, ok := context.Extensions[ExtensionCausationID]; !ok {
+		extensions := make(map[string]interface{}, len(context.Extensions)+1)
+		for k, v := range context.Extensions {
+			extensions[k] = v
+		}
+		extensions[ExtensionCausationID] = inbound.EventID
+		context.Extensions = extensions
+	}
+	return context
+}
+
 // Handler creates an EventHandler that implements http.Handler
 // If the fn parameter is not a valid type, will produce an http.Handler that also conforms
 // to error and will respond to all HTTP requests with that error. Valid types of fn are:
@@ -196,27 +273,47 @@ func respondHTTP(outparams []reflect.Value, w http.ResponseWriter) {
 // * func(context.Context, anything)
 // * func(context.Context, anything) error
 // * func(context.Context, anything) (anything, error)
+// * func(context.Context, anything) (EventContext, anything, error)
 //
 // CloudEvent contexts are available from the context.Context parameter
 // CloudEvent data will be deserialized into the "anything" parameter.
-// The library supports native decoding with both XML and JSON encoding.
-// To accept another advanced type, pass an io.Reader as the input parameter.
+// The library supports native decoding with XML, JSON and text encoding, and
+// with the codecs registered with RegisterCodec. To accept data of any other
+// type, pass a []byte or an io.Reader as the input parameter.
+//
+// Batches of events are accepted by functions taking a slice or an Iterator.
+// The data of each event of a batch is deserialized into an element of the
+// slice, and the contexts of the events are available from BatchFromContext.
+// An Iterator reads the events of a batch, or the single event of any other
+// request, one at a time. Other functions reject batches with a
+// StatusUnsupportedMediaType response.
 //
 // HTTP responses are generated based on the return value of fn:
 // * any error return value will cause a StatusInternalServerError response
 // * a function with no return type or a function returning nil will cause a StatusNoContent response
+// * a function that returns an EventContext and data, or an *Event, will cause a StatusOK and render the response as a Binary CloudEvent
 // * a function that returns a value will cause a StatusOK and render the response as JSON
+//
+// The ID and time of a returned CloudEvent default to a new ID and the current time, and its
+// causationid extension holds the ID of the handled event.
+//
+// Handlers may be wrapped in Middleware with Chain, for example to recover from panics with
+// Recovery.
 func Handler(fn interface{}) http.Handler {
 	fnType := reflect.TypeOf(fn)
 	err := validateFunction(fnType)
 	if err != nil {
 		return err
 	}
+	return newHandler(fn)
+}
+
+func newHandler(fn interface{}) *handler {
+	fnType := reflect.TypeOf(fn)
 	var dataType reflect.Type
 	if fnType.NumIn() == 2 {
 		dataType = fnType.In(1)
 	}
-
 	return &handler{
 		numIn:    fnType.NumIn(),
 		dataType: dataType,
@@ -224,13 +321,30 @@ func Handler(fn interface{}) http.Handler {
 	}
 }
 
+// acceptsBatch returns true if the handler's data parameter is a slice other
+// than raw []byte data.
+func (h *handler) acceptsBatch() bool {
+	return h.dataType != nil && h.dataType.Kind() == reflect.Slice && h.dataType.Elem().Kind() != reflect.Uint8
+}
+
 // ServeHTTP implements http.Handler
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case h.dataType == iteratorType:
+		h.serveIterator(w, r)
+		return
+	case IsBatch(r):
+		h.serveBatch(w, r)
+		return
+	}
+
 	args := make([]reflect.Value, 0, 2)
 
+	var eventContext *EventContext
 	if h.numIn > 0 {
+		var err error
 		dataPtr, dataArg := allocate(h.dataType)
-		eventContext, err := FromRequest(dataPtr, r)
+		eventContext, err = FromRequest(dataPtr, r)
 		if err != nil {
 			log.Printf("Failed to handle request %s; error %s", spew.Sdump(r), err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -238,6 +352,7 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		setRequestEvent(r, eventContext)
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, contextKey, eventContext)
 		args = append(args, reflect.ValueOf(ctx))
@@ -248,106 +363,70 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res := h.fnValue.Call(args)
-	respondHTTP(res, w)
-}
-
-func (h failedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Print("Failed to handle event: ", h.Error())
-	w.WriteHeader(http.StatusNotImplemented)
-	w.Write([]byte(`Internal server error`))
-}
-
-func (h failedHandler) Error() string {
-	return h.err.Error()
-}
-
-// Mux allows developers to handle logically related groups of
-// functionality multiplexed based on the event type.
-// TOOD: Consider dropping Mux or figure out how to handle non-JSON encoding.
-type Mux map[string]*handler
-
-// NewMux creates a new Mux
-func NewMux() Mux {
-	return make(map[string]*handler)
-}
-
-// Handle adds a new handler for a specific event type
-// If the fn parameter is not a valid type, the endpoint will respond to all HTTP requests
-// with that error. Valid types of fn are:
-//
-// * func()
-// * func() error
-// * func() (anything, error)
-// * func(context.Context)
-// * func(context.Context) error
-// * func(context.Context) (anything, error)
-// * func(context.Context, anything)
-// * func(context.Context, anything) error
-// * func(context.Context, anything) (anything, error)
-//
-// CloudEvent contexts are available from the context.Context parameter
-// CloudEvent data will be deserialized into the "anything" parameter.
-// The library supports native decoding with both XML and JSON encoding.
-// To accept another advanced type, pass an io.Reader as the input parameter.
-//
-// HTTP responses are generated based on the return value of fn:
-// * any error return value will cause a StatusInternalServerError response
-// * a function with no return type or a function returning nil will cause a StatusNoContent response
-// * a function that returns a value will cause a StatusOK and render the response as JSON
-func (m Mux) Handle(eventType string, fn interface{}) error {
-	fnType := reflect.TypeOf(fn)
-	err := validateFunction(fnType)
-	if err != nil {
-		return err
-	}
-	var dataType reflect.Type
-	if fnType.NumIn() == 2 {
-		dataType = fnType.In(1)
-	}
-	m[eventType] = &handler{
-		numIn:    fnType.NumIn(),
-		dataType: dataType,
-		fnValue:  reflect.ValueOf(fn),
-	}
-	return nil
+	respondHTTP(res, w, eventContext)
 }
 
-// ServeHTTP implements http.Handler
-func (m Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var rawData io.Reader
-	eventContext, err := FromRequest(&rawData, r)
+// serveIterator calls a handler taking an Iterator over the events of the
+// request.
+func (h *handler) serveIterator(w http.ResponseWriter, r *http.Request) {
+	it, err := NewIterator(r)
 	if err != nil {
-		log.Printf("Failed to handle request: %s %s", err, spew.Sdump(r))
+		log.Printf("Failed to handle request %s; error %s", spew.Sdump(r), err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(`Invalid request`))
 		return
 	}
 
-	h := m[eventContext.EventType]
-	if h == nil {
-		log.Print("Cloud not find handler for event type", eventContext.EventType)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("Event type %q is not supported", eventContext.EventType)))
+	res := h.fnValue.Call([]reflect.Value{reflect.ValueOf(r.Context()), reflect.ValueOf(&it).Elem()})
+	respondHTTP(res, w, nil)
+}
+
+// serveBatch calls a handler taking a slice with the data of each event of a
+// batch request.
+func (h *handler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if !h.acceptsBatch() {
+		log.Print("Failed to handle request; the handler does not accept batches of events")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(`Batches of events are not supported`))
 		return
 	}
 
-	args := make([]reflect.Value, 0, 2)
-	if h.numIn > 0 {
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, contextKey, eventContext)
-		args = append(args, reflect.ValueOf(ctx))
+	it, err := NewIterator(r)
+	if err != nil {
+		log.Printf("Failed to handle request %s; error %s", spew.Sdump(r), err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`Invalid request`))
+		return
 	}
-	if h.numIn == 2 {
-		dataPtr, dataArg := allocate(h.dataType)
-		if err := unmarshalEventData(eventContext.ContentType, rawData, dataPtr); err != nil {
-			log.Print("Failed to parse event data", err)
+	data := reflect.MakeSlice(h.dataType, 0, 0)
+	var eventContexts []*EventContext
+	for {
+		dataPtr, dataArg := allocate(h.dataType.Elem())
+		eventContext, err := it.Next(dataPtr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Print("Failed to parse event data of batch ", err)
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(`Invalid request`))
 			return
 		}
-		args = append(args, dataArg)
+		eventContexts = append(eventContexts, eventContext)
+		data = reflect.Append(data, dataArg)
 	}
 
-	res := h.fnValue.Call(args)
-	respondHTTP(res, w)
+	ctx := context.WithValue(r.Context(), batchContextKey, eventContexts)
+	res := h.fnValue.Call([]reflect.Value{reflect.ValueOf(ctx), data})
+	respondHTTP(res, w, nil)
+}
+
+func (h failedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Print("Failed to handle event: ", h.Error())
+	w.WriteHeader(http.StatusNotImplemented)
+	w.Write([]byte(`Internal server error`))
+}
+
+func (h failedHandler) Error() string {
+	return h.err.Error()
 }
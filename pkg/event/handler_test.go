@@ -56,14 +56,21 @@ func TestHandlerTypeErrors(t *testing.T) {
 			err:   "Expected a function taking either no parameters, a context.Context, or (context.Context, any); cannot convert parameter 0 from int to context.Context",
 		},
 		{
-			name:  "wrong return count",
-			param: func() (interface{}, error, interface{}) { return nil, nil, nil },
-			err:   "Expected a function returning either nothing, an error, or (any, error); function has too many return types (3)",
+			name: "wrong return count",
+			param: func() (event.EventContext, interface{}, error, interface{}) {
+				return event.EventContext{}, nil, nil, nil
+			},
+			err: "Expected a function returning either nothing, an error, (any, error), or (EventContext, any, error); function has too many return types (4)",
 		},
 		{
 			name:  "invalid return type",
 			param: func() interface{} { return nil },
-			err:   "Expected a function returning either nothing, an error, or (any, error); cannot convert return type 0 from interface {} to error",
+			err:   "Expected a function returning either nothing, an error, (any, error), or (EventContext, any, error); cannot convert return type 0 from interface {} to error",
+		},
+		{
+			name:  "invalid event context return type",
+			param: func() (interface{}, interface{}, error) { return nil, nil, nil },
+			err:   "Expected a function returning either nothing, an error, (any, error), or (EventContext, any, error); cannot convert return type 0 from interface {} to event.EventContext",
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
@@ -132,6 +139,14 @@ func TestHandlerValidTypes(t *testing.T) {
 		}, {
 			name: "two in, two out",
 			f:    func(context.Context, io.Reader) (interface{}, error) { return nil, nil },
+		}, {
+			name: "two in, event out",
+			f:    func(context.Context, io.Reader) (*event.Event, error) { return nil, nil },
+		}, {
+			name: "two in, three out",
+			f: func(context.Context, io.Reader) (event.EventContext, interface{}, error) {
+				return event.EventContext{}, nil, nil
+			},
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
@@ -459,3 +474,111 @@ func TestMux(t *testing.T) {
 		t.Fatal("Hanlder for eventB never called")
 	}
 }
+
+func TestEventResponse(t *testing.T) {
+	inbound := event.EventContext{
+		CloudEventsVersion: event.CloudEventsVersion02,
+		EventID:            "1234",
+		EventType:          "dev.eventing.test",
+		Source:             "tests:TestEventResponse",
+		ContentType:        "application/json",
+	}
+	reply := map[string]interface{}{"hello": "world"}
+
+	for _, test := range []struct {
+		name     string
+		handler  http.Handler
+		expected func(t *testing.T, ctx *event.EventContext)
+	}{
+		{
+			name: "context and data",
+			handler: event.Handler(func(ctx context.Context, data map[string]interface{}) (event.EventContext, interface{}, error) {
+				return event.EventContext{
+					EventType: "dev.eventing.reply",
+					Source:    "tests:reply",
+				}, reply, nil
+			}),
+			expected: func(t *testing.T, ctx *event.EventContext) {
+				if ctx.EventID == "" || ctx.EventID == inbound.EventID {
+					t.Errorf("Expected a new event ID, got %q", ctx.EventID)
+				}
+				if ctx.EventTime.IsZero() {
+					t.Error("Expected an event time")
+				}
+				if ctx.CloudEventsVersion != inbound.CloudEventsVersion {
+					t.Errorf("Expected the inbound version %q, got %q", inbound.CloudEventsVersion, ctx.CloudEventsVersion)
+				}
+			},
+		},
+		{
+			name: "event",
+			handler: event.Handler(func(ctx context.Context) (*event.Event, error) {
+				return &event.Event{
+					Context: event.EventContext{
+						CloudEventsVersion: event.CloudEventsVersion10,
+						EventID:            "5678",
+						EventType:          "dev.eventing.reply",
+						Source:             "tests:reply",
+					},
+					Data: reply,
+				}, nil
+			}),
+			expected: func(t *testing.T, ctx *event.EventContext) {
+				if ctx.EventID != "5678" {
+					t.Errorf("Expected the returned event ID %q, got %q", "5678", ctx.EventID)
+				}
+				if ctx.CloudEventsVersion != event.CloudEventsVersion10 {
+					t.Errorf("Expected the returned version %q, got %q", event.CloudEventsVersion10, ctx.CloudEventsVersion)
+				}
+			},
+		},
+		{
+			name: "mux",
+			handler: func() http.Handler {
+				mux := event.NewMux()
+				mux.Handle("dev.eventing.test", func(ctx context.Context, data map[string]interface{}) (event.EventContext, interface{}, error) {
+					return event.EventContext{
+						EventType: "dev.eventing.reply",
+						Source:    "tests:reply",
+					}, reply, nil
+				})
+				return mux
+			}(),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(test.handler)
+			defer srv.Close()
+			req, err := event.Binary.NewRequest(srv.URL, map[string]interface{}{}, inbound)
+			if err != nil {
+				t.Fatal("Failed to marshal request ", err)
+			}
+			res, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal("Failed to send request")
+			}
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("Wrong status code from event handler; wanted=%d; got=%d", http.StatusOK, res.StatusCode)
+			}
+
+			var data map[string]interface{}
+			ctx, err := event.Binary.FromRequest(&data, &http.Request{Header: res.Header, Body: res.Body})
+			if err != nil {
+				t.Fatal("Failed to parse response event", err)
+			}
+			if ctx.EventType != "dev.eventing.reply" || ctx.Source != "tests:reply" {
+				t.Errorf("Got wrong type or source; got=%q %q", ctx.EventType, ctx.Source)
+			}
+			if ctx.Extensions[event.ExtensionCausationID] != inbound.EventID {
+				t.Errorf("Expected causation extension %q, got %v", inbound.EventID, ctx.Extensions)
+			}
+			if !reflect.DeepEqual(reply, data) {
+				t.Errorf("Got wrong data; wanted=%v; got=%v", reply, data)
+			}
+			if test.expected != nil {
+				test.expected(t, ctx)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Middleware wraps an http.Handler serving events, such as a Handler, a Mux or
+// a route of a Mux. The event being handled by a route is available from
+// FromContext.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps a handler in middleware, the first middleware is the outermost.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// requestEvent holds the context of the event of a request once it is parsed
+// by a Handler or Mux, so middleware wrapping them can report it.
+type requestEvent struct {
+	context *EventContext
+}
+
+type requestEventKeyType struct{}
+
+var requestEventKey = requestEventKeyType{}
+
+// withRequestEvent returns a request carrying a holder for the context of its
+// event, reusing the holder of outer middleware.
+func withRequestEvent(r *http.Request) (*http.Request, *requestEvent) {
+	if e, ok := r.Context().Value(requestEventKey).(*requestEvent); ok {
+		return r, e
+	}
+	e := &requestEvent{}
+	return r.WithContext(context.WithValue(r.Context(), requestEventKey, e)), e
+}
+
+// setRequestEvent records the context of the event of a request for the
+// middleware wrapping the handler.
+func setRequestEvent(r *http.Request, eventContext *EventContext) {
+	if e, ok := r.Context().Value(requestEventKey).(*requestEvent); ok {
+		e.context = eventContext
+	}
+}
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// recordResponse returns a recorder of the response, reusing the recorder of
+// outer middleware.
+func recordResponse(w http.ResponseWriter) *responseRecorder {
+	if rec, ok := w.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// statusCode returns the status of the response, StatusOK if the handler did
+// not write one.
+func (w *responseRecorder) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Recovery recovers from panics of the handler. The panic and its stack are
+// logged and, unless the handler already started the response, a
+// StatusInternalServerError response is written. AccessLog and LatencyMetrics
+// only observe the response if they wrap Recovery.
+func Recovery() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := recordResponse(w)
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("Recovered from panic handling event: %v\n%s", err, debug.Stack())
+					if rec.status == 0 {
+						rec.WriteHeader(http.StatusInternalServerError)
+						rec.Write([]byte(`Internal server error`))
+					}
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// AccessLogEntry describes a request handled by a handler wrapped by
+// AccessLog. The event fields are empty if the request was not parsed as an
+// event.
+type AccessLogEntry struct {
+	Method    string
+	Path      string
+	Status    int
+	Size      int
+	Duration  time.Duration
+	EventID   string
+	EventType string
+	Source    string
+}
+
+// String formats the entry as space separated key=value pairs.
+func (e AccessLogEntry) String() string {
+	return fmt.Sprintf("method=%s path=%q status=%d size=%d duration=%s eventid=%q eventtype=%q source=%q",
+		e.Method, e.Path, e.Status, e.Size, e.Duration, e.EventID, e.EventType, e.Source)
+}
+
+// AccessLog logs each request with the ID, type and source of its event. The
+// entries are passed to logf, or logged with the standard logger if it is
+// nil.
+func AccessLog(logf func(AccessLogEntry)) Middleware {
+	if logf == nil {
+		logf = func(e AccessLogEntry) {
+			log.Print(e)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, event := withRequestEvent(r)
+			rec := recordResponse(w)
+			next.ServeHTTP(rec, r)
+
+			entry := AccessLogEntry{
+				Method:   r.Method,
+				Path:     r.URL.Path,
+				Status:   rec.statusCode(),
+				Size:     rec.size,
+				Duration: time.Since(start),
+			}
+			if event.context != nil {
+				entry.EventID = event.context.EventID
+				entry.EventType = event.context.EventType
+				entry.Source = event.context.Source
+			}
+			logf(entry)
+		})
+	}
+}
+
+// NewLatencyHistogram creates a histogram of the latency of handling events,
+// labeled by event type and response status as LatencyMetrics requires. It
+// must be registered, for example with prometheus.MustRegister.
+func NewLatencyHistogram() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "knative",
+			Subsystem: "event",
+			Name:      "handler_latency_seconds",
+			Help:      "Latency of handling events, by event type and response status.",
+		},
+		[]string{"event_type", "status"},
+	)
+}
+
+// LatencyMetrics observes the latency of each request in a histogram with
+// "event_type" and "status" labels, such as the one created by
+// NewLatencyHistogram.
+func LatencyMetrics(histogram *prometheus.HistogramVec) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, event := withRequestEvent(r)
+			rec := recordResponse(w)
+			next.ServeHTTP(rec, r)
+
+			eventType := ""
+			if event.context != nil {
+				eventType = event.context.EventType
+			}
+			histogram.WithLabelValues(eventType, strconv.Itoa(rec.statusCode())).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// MaxBodySize rejects requests with a body larger than limit bytes with a
+// StatusRequestEntityTooLarge response. The body is read before the handler
+// is called.
+func MaxBodySize(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				rejectBodySize(w, limit)
+				return
+			}
+			if r.Body != nil {
+				body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+				r.Body.Close()
+				if err != nil {
+					log.Print("Failed to read request body: ", err)
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`Invalid request`))
+					return
+				}
+				if int64(len(body)) > limit {
+					rejectBodySize(w, limit)
+					return
+				}
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rejectBodySize(w http.ResponseWriter, limit int64) {
+	log.Printf("Rejected request with a body larger than %d bytes", limit)
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write([]byte(fmt.Sprintf("Request body is larger than %d bytes", limit)))
+}
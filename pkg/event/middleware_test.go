@@ -0,0 +1,156 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knative/eventing/pkg/event"
+	"github.com/knative/eventing/pkg/event/eventtest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func middlewareRequest(t *testing.T, eventType string, data interface{}) *http.Request {
+	return eventtest.BinaryRequest(t, event.EventContext{
+		EventID:   "1234",
+		EventType: eventType,
+		Source:    "tests://middleware",
+	}, data)
+}
+
+func TestRecovery(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		handler  http.Handler
+		expected int
+	}{
+		{
+			name:     "panic",
+			handler:  event.Handler(func() { panic("boom") }),
+			expected: http.StatusInternalServerError,
+		},
+		{
+			name: "panic after response",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("boom")
+			}),
+			expected: http.StatusAccepted,
+		},
+		{
+			name:     "no panic",
+			handler:  event.Handler(func() {}),
+			expected: http.StatusNoContent,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			event.Chain(test.handler, event.Recovery()).ServeHTTP(w, middlewareRequest(t, "dev.knative.test", nil))
+			if w.Code != test.expected {
+				t.Fatalf("Got wrong status; wanted=%d; got=%d", test.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	mux := event.NewMux()
+	mux.Handle("dev.knative.test", func() { panic("boom") })
+
+	for _, test := range []struct {
+		name      string
+		handler   http.Handler
+		eventType string
+		status    int
+	}{
+		{"handler", event.Handler(func(context.Context) {}), "dev.knative.test", http.StatusNoContent},
+		{"mux", mux, "dev.knative.test", http.StatusInternalServerError},
+		{"unmatched", mux, "dev.knative.other", http.StatusNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []event.AccessLogEntry
+			h := event.Chain(test.handler, event.AccessLog(func(e event.AccessLogEntry) {
+				entries = append(entries, e)
+			}), event.Recovery())
+
+			h.ServeHTTP(httptest.NewRecorder(), middlewareRequest(t, test.eventType, nil))
+			if len(entries) != 1 {
+				t.Fatalf("Expected one entry, got %v", entries)
+			}
+			e := entries[0]
+			if e.Status != test.status || e.EventID != "1234" || e.EventType != test.eventType || e.Source != "tests://middleware" {
+				t.Fatalf("Got wrong entry: %s", e)
+			}
+			if !strings.Contains(e.String(), `eventtype="`+test.eventType+`"`) {
+				t.Fatalf("Entry is missing the event type: %s", e)
+			}
+		})
+	}
+}
+
+func TestLatencyMetrics(t *testing.T) {
+	histogram := event.NewLatencyHistogram()
+	h := event.Chain(event.Handler(func(context.Context) {}), event.LatencyMetrics(histogram))
+	h.ServeHTTP(httptest.NewRecorder(), middlewareRequest(t, "dev.knative.test", nil))
+	h.ServeHTTP(httptest.NewRecorder(), middlewareRequest(t, "dev.knative.test", nil))
+
+	var m dto.Metric
+	if err := histogram.WithLabelValues("dev.knative.test", "204").(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Failed to read histogram: %v", err)
+	}
+	if count := m.GetHistogram().GetSampleCount(); count != 2 {
+		t.Fatalf("Got wrong sample count; wanted=2; got=%d", count)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		data          string
+		contentLength bool
+		expected      int
+	}{
+		{"within limit", "small", true, http.StatusNoContent},
+		{"content length", strings.Repeat("x", 100), true, http.StatusRequestEntityTooLarge},
+		{"chunked", strings.Repeat("x", 100), false, http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var found string
+			h := event.Chain(event.Handler(func(ctx context.Context, data []byte) { found = string(data) }), event.MaxBodySize(16))
+
+			req := middlewareRequest(t, "dev.knative.test", []byte(test.data))
+			if test.contentLength {
+				req.ContentLength = int64(len(test.data))
+			} else {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != test.expected {
+				t.Fatalf("Got wrong status; wanted=%d; got=%d", test.expected, w.Code)
+			}
+			if test.expected == http.StatusNoContent && found != test.data {
+				t.Fatalf("Got wrong data; wanted=%q; got=%q", test.data, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// Route selects the events handled by a handler of a Mux. Patterns may use
+// '*' to match any sequence of characters, for example "com.github.*" matches
+// every event type starting with "com.github.".
+type Route struct {
+	// EventType is a pattern matching the type of the events, any type if
+	// empty.
+	EventType string
+
+	// Source is a pattern matching the source of the events, any source if
+	// empty.
+	Source string
+
+	// EventTypeVersion is the version of the event type of the events, any
+	// version if empty.
+	EventTypeVersion string
+}
+
+// matches returns true if an event matches the route.
+func (r Route) matches(eventContext *EventContext) bool {
+	return matchPattern(r.EventType, eventContext.EventType) &&
+		matchPattern(r.Source, eventContext.Source) &&
+		(r.EventTypeVersion == "" || r.EventTypeVersion == eventContext.EventTypeVersion)
+}
+
+// specificity ranks routes matching the same event, the route with the most
+// literal characters is the most specific.
+func (r Route) specificity() int {
+	n := len(r.EventType) - strings.Count(r.EventType, "*") +
+		len(r.Source) - strings.Count(r.Source, "*")
+	if r.EventTypeVersion != "" {
+		n++
+	}
+	return n
+}
+
+// matchPattern returns true if the value matches a pattern in which '*'
+// matches any sequence of characters. An empty pattern matches any value.
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+type route struct {
+	Route
+	handler http.Handler
+}
+
+// Mux allows developers to handle logically related groups of
+// functionality multiplexed based on the event type, source and event type
+// version.
+//
+// Each event is handled by the most specific matching route, the one with the
+// most literal characters in its patterns. Routes which are equally specific
+// are tried in the order they were added. Events which match no route are
+// handled by the fallback handler. Without a fallback, events of a type no
+// route matches are rejected with a StatusNotFound response, events of a
+// routed type whose source or version is not routed are rejected with a
+// StatusUnprocessableEntity response. Neither status is retried by buses.
+//
+// A Mux must be created with NewMux, the zero Mux has no routes to add to.
+// Copies of a Mux share its routes.
+type Mux struct {
+	*muxRoutes
+}
+
+// muxRoutes holds the routes shared by the copies of a Mux.
+type muxRoutes struct {
+	routes   []*route
+	fallback http.Handler
+	mutex    sync.RWMutex
+}
+
+// NewMux creates a Mux without routes, ready for Handle.
+func NewMux() Mux {
+	return Mux{&muxRoutes{}}
+}
+
+// Handle adds a new handler for the event types matching a pattern, replacing
+// the handler of any route added before for the same pattern. The middleware
+// wraps the handler, the first middleware is the outermost.
+// If the fn parameter is not a valid type, an error is returned and no handler
+// is added. Valid types of fn are:
+//
+// * func()
+// * func() error
+// * func() (anything, error)
+// * func(context.Context)
+// * func(context.Context) error
+// * func(context.Context) (anything, error)
+// * func(context.Context, anything)
+// * func(context.Context, anything) error
+// * func(context.Context, anything) (anything, error)
+// * func(context.Context, anything) (EventContext, anything, error)
+//
+// CloudEvent contexts are available from the context.Context parameter
+// CloudEvent data will be deserialized into the "anything" parameter.
+// The library supports native decoding with XML, JSON and text encoding, and
+// with the codecs registered with RegisterCodec. To accept data of any other
+// type, pass a []byte or an io.Reader as the input parameter.
+//
+// A Mux does not accept batches of events.
+//
+// HTTP responses are generated based on the return value of fn:
+// * any error return value will cause a StatusInternalServerError response
+// * a function with no return type or a function returning nil will cause a StatusNoContent response
+// * a function that returns an EventContext and data, or an *Event, will cause a StatusOK and render the response as a Binary CloudEvent
+// * a function that returns a value will cause a StatusOK and render the response as JSON
+//
+// The ID and time of a returned CloudEvent default to a new ID and the current time, and its
+// causationid extension holds the ID of the handled event.
+func (m Mux) Handle(eventType string, fn interface{}, middleware ...Middleware) error {
+	return m.HandleRoute(Route{EventType: eventType}, fn, middleware...)
+}
+
+// HandleRoute adds a new handler for the events matching a route, replacing
+// the handler of any route added before with the same patterns. Valid types
+// of fn are those of Handle.
+func (m Mux) HandleRoute(r Route, fn interface{}, middleware ...Middleware) error {
+	h, err := newRouteHandler(fn, middleware)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, existing := range m.routes {
+		if existing.Route == r {
+			existing.handler = h
+			return nil
+		}
+	}
+	m.routes = append(m.routes, &route{Route: r, handler: h})
+	return nil
+}
+
+// Fallback sets the handler of events which match no route. Valid types of fn
+// are those of Handle.
+func (m Mux) Fallback(fn interface{}, middleware ...Middleware) error {
+	h, err := newRouteHandler(fn, middleware)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.fallback = h
+	return nil
+}
+
+// newRouteHandler creates the http.Handler of a route, which serves requests
+// parsed by the Mux.
+func newRouteHandler(fn interface{}, middleware []Middleware) (http.Handler, error) {
+	if err := validateFunction(reflect.TypeOf(fn)); err != nil {
+		return nil, err
+	}
+	return Chain(&routeHandler{newHandler(fn)}, middleware...), nil
+}
+
+// match returns the handler of the most specific route matching an event. If
+// no route matches, it returns the status of the rejection of the event.
+func (m Mux) match(eventContext *EventContext) (http.Handler, int) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var best *route
+	typeRouted := false
+	for _, r := range m.routes {
+		if !matchPattern(r.EventType, eventContext.EventType) {
+			continue
+		}
+		typeRouted = true
+		if r.matches(eventContext) && (best == nil || r.specificity() > best.specificity()) {
+			best = r
+		}
+	}
+	switch {
+	case best != nil:
+		return best.handler, 0
+	case m.fallback != nil:
+		return m.fallback, 0
+	case typeRouted:
+		return nil, http.StatusUnprocessableEntity
+	default:
+		return nil, http.StatusNotFound
+	}
+}
+
+// Opaque key type used to pass the data of an event parsed by a Mux to the
+// handler of its route.
+type muxDataKeyType struct{}
+
+var muxDataKey = muxDataKeyType{}
+
+// ServeHTTP implements http.Handler
+func (m Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if IsBatch(r) {
+		log.Print("Failed to handle request; Mux does not accept batches of events")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(`Batches of events are not supported`))
+		return
+	}
+
+	var rawData io.Reader
+	eventContext, err := FromRequest(&rawData, r)
+	if err != nil {
+		log.Printf("Failed to handle request: %s %s", err, spew.Sdump(r))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`Invalid request`))
+		return
+	}
+	setRequestEvent(r, eventContext)
+
+	h, status := m.match(eventContext)
+	if h == nil {
+		log.Printf("Could not find handler for event type %q from source %q", eventContext.EventType, eventContext.Source)
+		w.WriteHeader(status)
+		w.Write([]byte(fmt.Sprintf("Event type %q from source %q is not supported", eventContext.EventType, eventContext.Source)))
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), contextKey, eventContext)
+	ctx = context.WithValue(ctx, muxDataKey, rawData)
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// routeHandler calls a handler with an event parsed by a Mux.
+type routeHandler struct {
+	*handler
+}
+
+// ServeHTTP implements http.Handler
+func (h *routeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	eventContext := FromContext(ctx)
+	rawData, _ := ctx.Value(muxDataKey).(io.Reader)
+
+	args := make([]reflect.Value, 0, 2)
+	if h.numIn > 0 {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	if h.numIn == 2 {
+		dataPtr, dataArg := allocate(h.dataType)
+		if err := unmarshalEventData(eventContext.ContentType, rawData, dataPtr); err != nil {
+			log.Print("Failed to parse event data", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`Invalid request`))
+			return
+		}
+		args = append(args, dataArg)
+	}
+
+	res := h.fnValue.Call(args)
+	respondHTTP(res, w, eventContext)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+func TestMuxRouting(t *testing.T) {
+	routes := []struct {
+		name  string
+		route event.Route
+	}{
+		{"push", event.Route{EventType: "com.github.push"}},
+		{"github", event.Route{EventType: "com.github.*"}},
+		{"push v2", event.Route{EventType: "com.github.push", EventTypeVersion: "v2"}},
+		{"knative repos", event.Route{EventType: "com.github.*", Source: "https://github.com/knative/*"}},
+		{"storage", event.Route{EventType: "com.google.storage.*", Source: "//storage.googleapis.com/*"}},
+	}
+
+	for _, test := range []struct {
+		name     string
+		context  event.EventContext
+		fallback bool
+		expected string
+		status   int
+	}{
+		{
+			name:     "exact",
+			context:  event.EventContext{EventType: "com.github.push", Source: "https://github.com/example/repo"},
+			expected: "push",
+			status:   http.StatusNoContent,
+		},
+		{
+			name:     "prefix",
+			context:  event.EventContext{EventType: "com.github.pull_request", Source: "https://github.com/example/repo"},
+			expected: "github",
+			status:   http.StatusNoContent,
+		},
+		{
+			name:     "event type version",
+			context:  event.EventContext{EventType: "com.github.push", EventTypeVersion: "v2", Source: "https://github.com/example/repo"},
+			expected: "push v2",
+			status:   http.StatusNoContent,
+		},
+		{
+			name:     "source",
+			context:  event.EventContext{EventType: "com.github.issue", Source: "https://github.com/knative/eventing"},
+			expected: "knative repos",
+			status:   http.StatusNoContent,
+		},
+		{
+			name:    "unknown type",
+			context: event.EventContext{EventType: "com.example.unknown", Source: "https://example.com"},
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "unknown source",
+			context: event.EventContext{EventType: "com.google.storage.finalize", Source: "https://example.com"},
+			status:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "fallback",
+			context:  event.EventContext{EventType: "com.example.unknown", Source: "https://example.com"},
+			fallback: true,
+			expected: "fallback",
+			status:   http.StatusNoContent,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var found string
+			handle := func(name string) func() {
+				return func() { found = name }
+			}
+			mux := event.NewMux()
+			for _, r := range routes {
+				if err := mux.HandleRoute(r.route, handle(r.name)); err != nil {
+					t.Fatalf("Failed to add route %q: %v", r.name, err)
+				}
+			}
+			if test.fallback {
+				if err := mux.Fallback(handle("fallback")); err != nil {
+					t.Fatalf("Failed to set fallback: %v", err)
+				}
+			}
+
+			test.context.EventID = "1234"
+			req, err := event.Binary.NewRequest(webhook, nil, test.context)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != test.status {
+				t.Errorf("Got wrong status; wanted=%d; got=%d", test.status, w.Code)
+			}
+			if found != test.expected {
+				t.Errorf("Got wrong route; wanted=%q; got=%q", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestMuxHandleReplaces(t *testing.T) {
+	var found string
+	mux := event.NewMux()
+	mux.Handle("dev.knative.test", func() { found = "first" })
+	mux.Handle("dev.knative.test", func() { found = "second" })
+
+	req, err := event.Binary.NewRequest(webhook, nil, event.EventContext{
+		EventID:   "1234",
+		EventType: "dev.knative.test",
+		Source:    "tests://TestMuxHandleReplaces",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if found != "second" {
+		t.Fatalf("Got wrong handler; wanted=%q; got=%q", "second", found)
+	}
+}
+
+func TestMuxCopiesShareRoutes(t *testing.T) {
+	var found bool
+	// NewMux returns a Mux value, as it did when Mux was a map
+	mux := event.NewMux()
+	var handler http.Handler = mux
+	mux.Handle("dev.knative.test", func() { found = true })
+
+	req, err := event.Binary.NewRequest(webhook, nil, event.EventContext{
+		EventID:   "1234",
+		EventType: "dev.knative.test",
+		Source:    "tests://TestMuxCopiesShareRoutes",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !found {
+		t.Fatal("Expected the copy of the Mux to use the route added after copying")
+	}
+}
+
+func TestMuxMiddleware(t *testing.T) {
+	var calls []string
+	middleware := func(name string) event.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" "+event.FromContext(r.Context()).EventType)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	mux := event.NewMux()
+	err := mux.Handle("dev.knative.test", func(ctx context.Context, data map[string]string) {
+		calls = append(calls, "handler "+data["hello"])
+	}, middleware("outer"), middleware("inner"))
+	if err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	if err := mux.Handle("dev.knative.other", func(int) {}); err == nil {
+		t.Fatal("Expected an error adding an invalid handler")
+	}
+
+	req, err := event.Binary.NewRequest(webhook, map[string]string{"hello": "world"}, event.EventContext{
+		EventID:   "1234",
+		EventType: "dev.knative.test",
+		Source:    "tests://TestMuxMiddleware",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Got wrong status; wanted=%d; got=%d", http.StatusNoContent, w.Code)
+	}
+	expected := []string{"outer dev.knative.test", "inner dev.knative.test", "handler world"}
+	if !reflect.DeepEqual(expected, calls) {
+		t.Fatalf("Got wrong calls; wanted=%v; got=%v", expected, calls)
+	}
+}
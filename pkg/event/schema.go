@@ -0,0 +1,758 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema validating event data. The draft-07
+// keywords describing the structure of JSON values are supported: type, enum,
+// const, the numeric, string, array and object constraints, allOf, anyOf,
+// oneOf, not and local $ref pointers such as "#/definitions/name". Other
+// keywords, such as format, are ignored.
+type Schema struct {
+	root *schemaNode
+}
+
+// SchemaError describes why data does not match a Schema.
+type SchemaError struct {
+	// Path locates the invalid value in the data, such as "data.items[0]".
+	Path string
+	// Message describes the constraint the value breaks.
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+type schemaNode struct {
+	// location is the JSON pointer of the node in the schema document.
+	location string
+	// always is set for the boolean schemas true and false.
+	always *bool
+	ref    *schemaNode
+
+	types    []string
+	enum     []interface{}
+	constant interface{}
+	hasConst bool
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	items           *schemaNode
+	tupleItems      []*schemaNode
+	additionalItems *schemaNode
+	minItems        *int
+	maxItems        *int
+	uniqueItems     bool
+
+	properties           map[string]*schemaNode
+	patternProperties    map[string]*schemaNode
+	patterns             map[string]*regexp.Regexp
+	additionalProperties *schemaNode
+	required             []string
+	minProperties        *int
+	maxProperties        *int
+
+	allOf, anyOf, oneOf []*schemaNode
+	not                 *schemaNode
+}
+
+// schemaCompiler compiles the nodes of a schema document. Nodes referenced
+// with $ref are compiled once, which allows recursive schemas.
+type schemaCompiler struct {
+	document interface{}
+	refs     map[string]*schemaNode
+}
+
+// CompileSchema compiles a JSON Schema document.
+func CompileSchema(document []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, fmt.Errorf("Could not decode schema: %v", err)
+	}
+	c := &schemaCompiler{document: doc, refs: make(map[string]*schemaNode)}
+	root, err := c.compile(doc, "#")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCycles(root); err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+func (c *schemaCompiler) compile(value interface{}, location string) (*schemaNode, error) {
+	if b, ok := value.(bool); ok {
+		return &schemaNode{location: location, always: &b}, nil
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid schema at %s: must be an object or a boolean", location)
+	}
+
+	n := &schemaNode{location: location}
+	if ref, ok := m["$ref"].(string); ok {
+		// Keywords beside $ref are ignored.
+		target, err := c.resolve(ref)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schema at %s: %v", location, err)
+		}
+		n.ref = target
+		return n, nil
+	}
+
+	switch t := m["type"].(type) {
+	case string:
+		n.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid schema at %s/type: must be a string or an array of strings", location)
+			}
+			n.types = append(n.types, s)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("Invalid schema at %s/type: must be a string or an array of strings", location)
+	}
+	if enum, ok := m["enum"].([]interface{}); ok {
+		n.enum = enum
+	}
+	n.constant, n.hasConst = m["const"]
+	n.uniqueItems, _ = m["uniqueItems"].(bool)
+
+	var err error
+	numbers := map[string]**float64{
+		"minimum":          &n.minimum,
+		"maximum":          &n.maximum,
+		"exclusiveMinimum": &n.exclusiveMinimum,
+		"exclusiveMaximum": &n.exclusiveMaximum,
+		"multipleOf":       &n.multipleOf,
+	}
+	for keyword, field := range numbers {
+		if v, ok := m[keyword]; ok {
+			f, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("Invalid schema at %s/%s: must be a number", location, keyword)
+			}
+			*field = &f
+		}
+	}
+	counts := map[string]**int{
+		"minLength":     &n.minLength,
+		"maxLength":     &n.maxLength,
+		"minItems":      &n.minItems,
+		"maxItems":      &n.maxItems,
+		"minProperties": &n.minProperties,
+		"maxProperties": &n.maxProperties,
+	}
+	for keyword, field := range counts {
+		if v, ok := m[keyword]; ok {
+			f, ok := v.(float64)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fmt.Errorf("Invalid schema at %s/%s: must be a non-negative integer", location, keyword)
+			}
+			i := int(f)
+			*field = &i
+		}
+	}
+	if p, ok := m["pattern"].(string); ok {
+		if n.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("Invalid schema at %s/pattern: %v", location, err)
+		}
+	}
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, v := range required {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid schema at %s/required: must be an array of strings", location)
+			}
+			n.required = append(n.required, s)
+		}
+	}
+
+	switch items := m["items"].(type) {
+	case []interface{}:
+		if n.tupleItems, err = c.compileList(items, location+"/items"); err != nil {
+			return nil, err
+		}
+	case nil:
+	default:
+		if n.items, err = c.compile(items, location+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	subschemas := map[string]**schemaNode{
+		"additionalItems":      &n.additionalItems,
+		"additionalProperties": &n.additionalProperties,
+		"not":                  &n.not,
+	}
+	for keyword, field := range subschemas {
+		if v, ok := m[keyword]; ok {
+			if *field, err = c.compile(v, location+"/"+keyword); err != nil {
+				return nil, err
+			}
+		}
+	}
+	lists := map[string]*[]*schemaNode{
+		"allOf": &n.allOf,
+		"anyOf": &n.anyOf,
+		"oneOf": &n.oneOf,
+	}
+	for keyword, field := range lists {
+		if v, ok := m[keyword]; ok {
+			list, ok := v.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("Invalid schema at %s/%s: must be a non-empty array", location, keyword)
+			}
+			if *field, err = c.compileList(list, location+"/"+keyword); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if n.properties, err = c.compileMap(m["properties"], location+"/properties"); err != nil {
+		return nil, err
+	}
+	if n.patternProperties, err = c.compileMap(m["patternProperties"], location+"/patternProperties"); err != nil {
+		return nil, err
+	}
+	if len(n.patternProperties) > 0 {
+		n.patterns = make(map[string]*regexp.Regexp, len(n.patternProperties))
+		for p := range n.patternProperties {
+			if n.patterns[p], err = regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("Invalid schema at %s/patternProperties: %v", location, err)
+			}
+		}
+	}
+	return n, nil
+}
+
+func (c *schemaCompiler) compileList(values []interface{}, location string) ([]*schemaNode, error) {
+	nodes := make([]*schemaNode, len(values))
+	for i, v := range values {
+		n, err := c.compile(v, location+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return nodes, nil
+}
+
+func (c *schemaCompiler) compileMap(value interface{}, location string) (map[string]*schemaNode, error) {
+	if value == nil {
+		return nil, nil
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid schema at %s: must be an object", location)
+	}
+	nodes := make(map[string]*schemaNode, len(m))
+	for name, v := range m {
+		n, err := c.compile(v, location+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		nodes[name] = n
+	}
+	return nodes, nil
+}
+
+// resolve compiles the node referenced by a local JSON pointer.
+func (c *schemaCompiler) resolve(ref string) (*schemaNode, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q, only local references are supported", ref)
+	}
+	if n, ok := c.refs[ref]; ok {
+		return n, nil
+	}
+
+	value := c.document
+	if pointer := strings.TrimPrefix(ref, "#"); pointer != "" {
+		for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			switch v := value.(type) {
+			case map[string]interface{}:
+				value = v[token]
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(v) {
+					return nil, fmt.Errorf("$ref %q not found", ref)
+				}
+				value = v[i]
+			default:
+				value = nil
+			}
+			if value == nil {
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+		}
+	}
+
+	// The node is registered before it is compiled so recursive references
+	// resolve to it.
+	n := &schemaNode{}
+	c.refs[ref] = n
+	compiled, err := c.compile(value, ref)
+	if err != nil {
+		return nil, err
+	}
+	*n = *compiled
+	return n, nil
+}
+
+// checkCycles rejects schemas with a cycle of $ref or combinator keywords
+// which validates the same value again without descending into it, such as
+// {"$ref": "#"}. Validating with such a schema would never terminate.
+func checkCycles(root *schemaNode) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*schemaNode]int)
+	var visit func(n *schemaNode) error
+	visit = func(n *schemaNode) error {
+		switch state[n] {
+		case visiting:
+			return fmt.Errorf("Invalid schema at %s: $ref cycle never descends into the data", n.location)
+		case visited:
+			return nil
+		}
+		state[n] = visiting
+		for _, s := range n.sameValue() {
+			if err := visit(s); err != nil {
+				return err
+			}
+		}
+		state[n] = visited
+		return nil
+	}
+
+	// Every node is a possible start of a cycle, not only the root.
+	seen := map[*schemaNode]bool{root: true}
+	pending := []*schemaNode{root}
+	for len(pending) > 0 {
+		n := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if err := visit(n); err != nil {
+			return err
+		}
+		for _, s := range append(n.sameValue(), n.children()...) {
+			if !seen[s] {
+				seen[s] = true
+				pending = append(pending, s)
+			}
+		}
+	}
+	return nil
+}
+
+// sameValue returns the subschemas validating the same value as the node.
+func (n *schemaNode) sameValue() []*schemaNode {
+	var nodes []*schemaNode
+	if n.ref != nil {
+		nodes = append(nodes, n.ref)
+	}
+	if n.not != nil {
+		nodes = append(nodes, n.not)
+	}
+	nodes = append(nodes, n.allOf...)
+	nodes = append(nodes, n.anyOf...)
+	return append(nodes, n.oneOf...)
+}
+
+// children returns the subschemas validating the items or properties of the
+// value validated by the node.
+func (n *schemaNode) children() []*schemaNode {
+	nodes := append([]*schemaNode(nil), n.tupleItems...)
+	for _, s := range []*schemaNode{n.items, n.additionalItems, n.additionalProperties} {
+		if s != nil {
+			nodes = append(nodes, s)
+		}
+	}
+	for _, s := range n.properties {
+		nodes = append(nodes, s)
+	}
+	for _, s := range n.patternProperties {
+		nodes = append(nodes, s)
+	}
+	return nodes
+}
+
+// Validate validates data decoded from JSON with encoding/json, such as an
+// interface{} or map[string]interface{}. A *SchemaError is returned if the
+// data does not match the schema.
+func (s *Schema) Validate(data interface{}) error {
+	return s.root.validate(data, "data")
+}
+
+// ValidateJSON validates JSON encoded data.
+func (s *Schema) ValidateJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return &SchemaError{Path: "data", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	return s.Validate(value)
+}
+
+func schemaErrorf(path string, format string, args ...interface{}) error {
+	return &SchemaError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// jsonType returns the JSON Schema type of a value decoded by encoding/json.
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (n *schemaNode) validate(value interface{}, path string) error {
+	if n.ref != nil {
+		return n.ref.validate(value, path)
+	}
+	if n.always != nil {
+		if !*n.always {
+			return schemaErrorf(path, "no value is allowed")
+		}
+		return nil
+	}
+
+	if len(n.types) > 0 {
+		t := jsonType(value)
+		matched := false
+		for _, expected := range n.types {
+			if expected == t || (expected == "number" && t == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return schemaErrorf(path, "expected %s, got %s", strings.Join(n.types, " or "), t)
+		}
+	}
+	if n.enum != nil {
+		matched := false
+		for _, v := range n.enum {
+			if reflect.DeepEqual(v, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return schemaErrorf(path, "value is not one of the allowed values")
+		}
+	}
+	if n.hasConst && !reflect.DeepEqual(n.constant, value) {
+		return schemaErrorf(path, "value does not equal the constant")
+	}
+
+	var err error
+	switch v := value.(type) {
+	case float64:
+		err = n.validateNumber(v, path)
+	case json.Number:
+		f, _ := v.Float64()
+		err = n.validateNumber(f, path)
+	case string:
+		err = n.validateString(v, path)
+	case []interface{}:
+		err = n.validateArray(v, path)
+	case map[string]interface{}:
+		err = n.validateObject(v, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, s := range n.allOf {
+		if err := s.validate(value, path); err != nil {
+			return err
+		}
+	}
+	if n.anyOf != nil {
+		matched := false
+		for _, s := range n.anyOf {
+			if s.validate(value, path) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return schemaErrorf(path, "value does not match any of the schemas of anyOf")
+		}
+	}
+	if n.oneOf != nil {
+		matched := 0
+		for _, s := range n.oneOf {
+			if s.validate(value, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return schemaErrorf(path, "value matches %d of the schemas of oneOf, expected exactly one", matched)
+		}
+	}
+	if n.not != nil && n.not.validate(value, path) == nil {
+		return schemaErrorf(path, "value matches the schema of not")
+	}
+	return nil
+}
+
+func (n *schemaNode) validateNumber(v float64, path string) error {
+	switch {
+	case n.minimum != nil && v < *n.minimum:
+		return schemaErrorf(path, "%v is less than the minimum %v", v, *n.minimum)
+	case n.maximum != nil && v > *n.maximum:
+		return schemaErrorf(path, "%v is greater than the maximum %v", v, *n.maximum)
+	case n.exclusiveMinimum != nil && v <= *n.exclusiveMinimum:
+		return schemaErrorf(path, "%v is not greater than the exclusive minimum %v", v, *n.exclusiveMinimum)
+	case n.exclusiveMaximum != nil && v >= *n.exclusiveMaximum:
+		return schemaErrorf(path, "%v is not less than the exclusive maximum %v", v, *n.exclusiveMaximum)
+	case n.multipleOf != nil && *n.multipleOf != 0:
+		if q := v / *n.multipleOf; q != math.Trunc(q) {
+			return schemaErrorf(path, "%v is not a multiple of %v", v, *n.multipleOf)
+		}
+	}
+	return nil
+}
+
+func (n *schemaNode) validateString(v string, path string) error {
+	length := utf8.RuneCountInString(v)
+	switch {
+	case n.minLength != nil && length < *n.minLength:
+		return schemaErrorf(path, "string is shorter than %d characters", *n.minLength)
+	case n.maxLength != nil && length > *n.maxLength:
+		return schemaErrorf(path, "string is longer than %d characters", *n.maxLength)
+	case n.pattern != nil && !n.pattern.MatchString(v):
+		return schemaErrorf(path, "string does not match the pattern %q", n.pattern.String())
+	}
+	return nil
+}
+
+func (n *schemaNode) validateArray(v []interface{}, path string) error {
+	switch {
+	case n.minItems != nil && len(v) < *n.minItems:
+		return schemaErrorf(path, "array has fewer than %d items", *n.minItems)
+	case n.maxItems != nil && len(v) > *n.maxItems:
+		return schemaErrorf(path, "array has more than %d items", *n.maxItems)
+	}
+	if n.uniqueItems {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if reflect.DeepEqual(v[i], v[j]) {
+					return schemaErrorf(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	for i, item := range v {
+		s := n.items
+		if n.tupleItems != nil {
+			s = n.additionalItems
+			if i < len(n.tupleItems) {
+				s = n.tupleItems[i]
+			}
+		}
+		if s == nil {
+			continue
+		}
+		if err := s.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *schemaNode) validateObject(v map[string]interface{}, path string) error {
+	switch {
+	case n.minProperties != nil && len(v) < *n.minProperties:
+		return schemaErrorf(path, "object has fewer than %d properties", *n.minProperties)
+	case n.maxProperties != nil && len(v) > *n.maxProperties:
+		return schemaErrorf(path, "object has more than %d properties", *n.maxProperties)
+	}
+	for _, name := range n.required {
+		if _, ok := v[name]; !ok {
+			return schemaErrorf(path, "missing required property %q", name)
+		}
+	}
+
+	// Properties are validated in order so the reported error is stable.
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertyPath := path + "." + name
+		matched := false
+		if s, ok := n.properties[name]; ok {
+			matched = true
+			if err := s.validate(v[name], propertyPath); err != nil {
+				return err
+			}
+		}
+		for p, re := range n.patterns {
+			if re.MatchString(name) {
+				matched = true
+				if err := n.patternProperties[p].validate(v[name], propertyPath); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched && n.additionalProperties != nil {
+			if err := n.additionalProperties.validate(v[name], propertyPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SchemaRegistry holds the schemas of the data of event types.
+type SchemaRegistry struct {
+	schemas map[string]*Schema
+	mutex   sync.RWMutex
+}
+
+// NewSchemaRegistry creates an empty SchemaRegistry.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{schemas: make(map[string]*Schema)}
+}
+
+// Register registers the schema of the data of an event type, replacing any
+// schema registered before.
+func (r *SchemaRegistry) Register(eventType string, schema *Schema) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.schemas[eventType] = schema
+}
+
+// Lookup returns the schema of an event type, or nil if there is none.
+func (r *SchemaRegistry) Lookup(eventType string) *Schema {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.schemas[eventType]
+}
+
+// ValidateData validates the data of an event against the schema registered
+// for its type. Events of types without a schema are valid, events with a
+// schema must have JSON data.
+func (r *SchemaRegistry) ValidateData(eventContext *EventContext, data []byte) error {
+	schema := r.Lookup(eventContext.EventType)
+	if schema == nil {
+		return nil
+	}
+	if eventContext.ContentType != "" && !isJSONEncoding(eventContext.ContentType) {
+		return &SchemaError{Path: "data", Message: fmt.Sprintf("content type %q can not be validated against a JSON schema", eventContext.ContentType)}
+	}
+	return schema.ValidateJSON(data)
+}
+
+// ValidateSchemas validates the data of each event of a request against the
+// schema registered for its type before the handler is called. Requests with
+// invalid data, and requests which are not events, are rejected with a
+// StatusBadRequest response.
+func ValidateSchemas(registry *SchemaRegistry) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				log.Print("Failed to read request body: ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`Invalid request`))
+				return
+			}
+
+			if err := validateRequest(registry, r, body); err != nil {
+				log.Print("Rejected event with invalid data: ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validateRequest validates the events of a request with the given body.
+func validateRequest(registry *SchemaRegistry, r *http.Request, body []byte) error {
+	req := *r
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	it, err := NewIterator(&req)
+	if err != nil {
+		return err
+	}
+	for {
+		var data []byte
+		eventContext, err := it.Next(&data)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := registry.ValidateData(eventContext, data); err != nil {
+			return fmt.Errorf("Event %q of type %q is invalid: %v", eventContext.EventID, eventContext.EventType, err)
+		}
+	}
+}
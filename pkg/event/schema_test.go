@@ -0,0 +1,222 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+const personSchema = `{
+	"type": "object",
+	"required": ["name"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+		"role": {"enum": ["admin", "user"]},
+		"manager": {"$ref": "#"}
+	},
+	"additionalProperties": false
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := event.CompileSchema([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("Failed to compile schema: %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		data string
+		path string
+	}{
+		{name: "valid", data: `{"name":"Ada","age":36,"email":"ada@example.com","tags":["a","b"],"role":"admin"}`},
+		{name: "recursive", data: `{"name":"Ada","manager":{"name":"Charles"}}`},
+		{name: "not an object", data: `"Ada"`, path: "data"},
+		{name: "missing required", data: `{"age":36}`, path: "data"},
+		{name: "wrong type", data: `{"name":"Ada","age":"old"}`, path: "data.age"},
+		{name: "not an integer", data: `{"name":"Ada","age":36.5}`, path: "data.age"},
+		{name: "minimum", data: `{"name":"Ada","age":-1}`, path: "data.age"},
+		{name: "min length", data: `{"name":""}`, path: "data.name"},
+		{name: "pattern", data: `{"name":"Ada","email":"ada"}`, path: "data.email"},
+		{name: "item type", data: `{"name":"Ada","tags":["a",1]}`, path: "data.tags[1]"},
+		{name: "unique items", data: `{"name":"Ada","tags":["a","a"]}`, path: "data.tags"},
+		{name: "max items", data: `{"name":"Ada","tags":["a","b","c","d"]}`, path: "data.tags"},
+		{name: "enum", data: `{"name":"Ada","role":"guest"}`, path: "data.role"},
+		{name: "additional property", data: `{"name":"Ada","extra":true}`, path: "data.extra"},
+		{name: "invalid recursive", data: `{"name":"Ada","manager":{}}`, path: "data.manager"},
+		{name: "invalid json", data: `{`, path: "data"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := schema.ValidateJSON([]byte(test.data))
+			if test.path == "" {
+				if err != nil {
+					t.Fatalf("Expected valid data, got %v", err)
+				}
+				return
+			}
+			schemaErr, ok := err.(*event.SchemaError)
+			if !ok {
+				t.Fatalf("Expected a *SchemaError, got %v", err)
+			}
+			if schemaErr.Path != test.path {
+				t.Fatalf("Got wrong path; wanted=%q; got=%q (%v)", test.path, schemaErr.Path, err)
+			}
+		})
+	}
+}
+
+func TestSchemaCombinators(t *testing.T) {
+	schema, err := event.CompileSchema([]byte(`{
+		"definitions": {"positive": {"type": "number", "exclusiveMinimum": 0}},
+		"oneOf": [{"$ref": "#/definitions/positive"}, {"type": "string"}],
+		"not": {"const": "forbidden"}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to compile schema: %v", err)
+	}
+	for data, valid := range map[string]bool{
+		`1.5`:         true,
+		`"text"`:      true,
+		`0`:           false,
+		`true`:        false,
+		`"forbidden"`: false,
+	} {
+		if err := schema.ValidateJSON([]byte(data)); (err == nil) != valid {
+			t.Errorf("Got wrong result for %s; wanted valid=%v; got %v", data, valid, err)
+		}
+	}
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	for _, schema := range []string{
+		`[]`,
+		`{"type": 1}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "http://example.com/schema.json"}`,
+		`{"anyOf": []}`,
+	} {
+		if _, err := event.CompileSchema([]byte(schema)); err == nil {
+			t.Errorf("Expected an error compiling %s", schema)
+		}
+	}
+}
+
+func TestCompileSchemaRefCycles(t *testing.T) {
+	for name, schema := range map[string]string{
+		"self":     `{"$ref": "#"}`,
+		"mutual":   `{"definitions": {"a": {"$ref": "#/definitions/b"}, "b": {"$ref": "#/definitions/a"}}, "$ref": "#/definitions/a"}`,
+		"allOf":    `{"definitions": {"a": {"allOf": [{"$ref": "#/definitions/a"}]}}, "properties": {"x": {"$ref": "#/definitions/a"}}}`,
+		"property": `{"properties": {"x": {"$ref": "#/properties/x"}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := event.CompileSchema([]byte(schema))
+			if err == nil || !strings.Contains(err.Error(), "cycle") {
+				t.Fatalf("Expected a $ref cycle error compiling %s, got %v", schema, err)
+			}
+		})
+	}
+
+	// A cycle descending into the data terminates with the data.
+	schema, err := event.CompileSchema([]byte(`{"definitions": {"a": {"items": {"$ref": "#/definitions/b"}}, "b": {"$ref": "#/definitions/a"}}, "$ref": "#/definitions/a"}`))
+	if err != nil {
+		t.Fatalf("Failed to compile schema: %v", err)
+	}
+	if err := schema.ValidateJSON([]byte(`[[[]], []]`)); err != nil {
+		t.Fatalf("Expected valid data, got %v", err)
+	}
+}
+
+func TestValidateSchemas(t *testing.T) {
+	schema, err := event.CompileSchema([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("Failed to compile schema: %v", err)
+	}
+	registry := event.NewSchemaRegistry()
+	registry.Register("dev.knative.person", schema)
+
+	newEvent := func(eventType, contentType string, data interface{}) event.Event {
+		return event.Event{
+			Context: event.EventContext{
+				CloudEventsVersion: event.CloudEventsVersion10,
+				EventID:            "1234",
+				EventType:          eventType,
+				Source:             "tests://TestValidateSchemas",
+				ContentType:        contentType,
+			},
+			Data: data,
+		}
+	}
+	valid := newEvent("dev.knative.person", "application/json", map[string]interface{}{"name": "Ada"})
+	invalid := newEvent("dev.knative.person", "application/json", map[string]interface{}{"age": 36})
+
+	for _, test := range []struct {
+		name     string
+		events   []event.Event
+		batch    bool
+		expected int
+	}{
+		{"valid", []event.Event{valid}, false, http.StatusNoContent},
+		{"invalid", []event.Event{invalid}, false, http.StatusBadRequest},
+		{"no schema", []event.Event{newEvent("dev.knative.other", "application/json", map[string]interface{}{"age": 36})}, false, http.StatusNoContent},
+		{"not json", []event.Event{newEvent("dev.knative.person", "text/plain", "Ada")}, false, http.StatusBadRequest},
+		{"valid batch", []event.Event{valid, valid}, true, http.StatusNoContent},
+		{"invalid batch", []event.Event{valid, invalid}, true, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			var h http.Handler
+			if test.batch {
+				h = event.Handler(func(ctx context.Context, data []map[string]interface{}) { called = true })
+			} else {
+				h = event.Handler(func(ctx context.Context, data interface{}) { called = true })
+			}
+			h = event.Chain(h, event.ValidateSchemas(registry))
+
+			var req *http.Request
+			var err error
+			if test.batch {
+				req, err = event.NewBatchRequest(webhook, test.events)
+			} else {
+				req, err = event.Binary.NewRequest(webhook, test.events[0].Data, test.events[0].Context)
+			}
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != test.expected {
+				t.Fatalf("Got wrong status; wanted=%d; got=%d (%s)", test.expected, w.Code, w.Body.String())
+			}
+			if called != (test.expected == http.StatusNoContent) {
+				t.Fatalf("Handler called=%v for status %d", called, w.Code)
+			}
+			if test.expected == http.StatusBadRequest && !strings.Contains(w.Body.String(), "1234") {
+				t.Fatalf("Expected the response to name the invalid event, got %q", w.Body.String())
+			}
+		})
+	}
+}
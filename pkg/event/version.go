@@ -0,0 +1,208 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// CloudEventsVersion01 is version 0.1 of the CloudEvents spec.
+	CloudEventsVersion01 = "0.1"
+
+	// CloudEventsVersion02 is version 0.2 of the CloudEvents spec.
+	CloudEventsVersion02 = "0.2"
+
+	// CloudEventsVersion10 is version 1.0 of the CloudEvents spec.
+	CloudEventsVersion10 = "1.0"
+
+	// extensionEventTypeVersion carries EventTypeVersion in versions of the
+	// spec which have no such attribute.
+	extensionEventTypeVersion = "eventtypeversion"
+
+	// extensionSubject carries Subject in versions of the spec which have no
+	// such attribute.
+	extensionSubject = "subject"
+)
+
+// spec names the context attributes of a version of the CloudEvents spec as
+// they appear in a structured envelope. Binary headers are the attribute names
+// prefixed with "CE-". Attributes which are empty are not defined by the
+// version and are carried as extensions.
+type spec struct {
+	version          string
+	specVersion      string
+	eventID          string
+	eventTime        string
+	eventType        string
+	eventTypeVersion string
+	schemaURL        string
+	contentType      string
+	source           string
+	subject          string
+
+	// extensionsPrefix is the binary header prefix of extensions.
+	extensionsPrefix string
+	// nestedExtensions is set when extensions are nested in a structured
+	// envelope instead of being top level attributes.
+	nestedExtensions bool
+}
+
+var specs = map[string]*spec{
+	CloudEventsVersion01: {
+		version:          CloudEventsVersion01,
+		specVersion:      "cloudEventsVersion",
+		eventID:          "eventID",
+		eventTime:        "eventTime",
+		eventType:        "eventType",
+		eventTypeVersion: "eventTypeVersion",
+		schemaURL:        "schemaURL",
+		contentType:      "contentType",
+		source:           "source",
+		extensionsPrefix: headerExtensionsPrefix,
+		nestedExtensions: true,
+	},
+	CloudEventsVersion02: {
+		version:          CloudEventsVersion02,
+		specVersion:      "specversion",
+		eventID:          "id",
+		eventTime:        "time",
+		eventType:        "type",
+		schemaURL:        "schemaurl",
+		contentType:      "contenttype",
+		source:           "source",
+		extensionsPrefix: headerPrefix,
+	},
+	CloudEventsVersion10: {
+		version:          CloudEventsVersion10,
+		specVersion:      "specversion",
+		eventID:          "id",
+		eventTime:        "time",
+		eventType:        "type",
+		schemaURL:        "dataschema",
+		contentType:      "datacontenttype",
+		source:           "source",
+		subject:          "subject",
+		extensionsPrefix: headerPrefix,
+	},
+}
+
+// attributes returns the names of the context attributes of the version,
+// other than extensions.
+func (s *spec) attributes() []string {
+	var names []string
+	for _, name := range []string{s.specVersion, s.eventID, s.eventTime, s.eventType,
+		s.eventTypeVersion, s.schemaURL, s.contentType, s.source, s.subject} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isAttribute returns true if name is a context attribute of the version.
+func (s *spec) isAttribute(name string) bool {
+	for _, a := range s.attributes() {
+		if strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// toExtensions moves the fields of the context which the version has no
+// attribute for into its extensions.
+func (s *spec) toExtensions(context *EventContext) {
+	if s.eventTypeVersion == "" && context.EventTypeVersion != "" {
+		context.Extensions[extensionEventTypeVersion] = context.EventTypeVersion
+		context.EventTypeVersion = ""
+	}
+	if s.subject == "" && context.Subject != "" {
+		context.Extensions[extensionSubject] = context.Subject
+		context.Subject = ""
+	}
+}
+
+// fromExtensions moves the extensions set by toExtensions back into the
+// fields of the context.
+func (s *spec) fromExtensions(context *EventContext) {
+	if s.eventTypeVersion == "" {
+		if v, ok := context.Extensions[extensionEventTypeVersion].(string); ok {
+			context.EventTypeVersion = v
+			delete(context.Extensions, extensionEventTypeVersion)
+		}
+	}
+	if s.subject == "" {
+		if v, ok := context.Extensions[extensionSubject].(string); ok {
+			context.Subject = v
+			delete(context.Extensions, extensionSubject)
+		}
+	}
+}
+
+// getSpec returns the spec of a version, defaulting to CloudEventsVersion.
+func getSpec(version string) (*spec, error) {
+	if version == "" {
+		version = CloudEventsVersion
+	}
+	s, ok := specs[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported CloudEvents version %q", version)
+	}
+	return s, nil
+}
+
+// ConvertContext returns a copy of the EventContext for another version of the
+// CloudEvents spec. Versions after 0.1 require extension names to be lower
+// case alphanumeric; names are lower cased and an error is returned if they
+// are not alphanumeric or collide.
+func ConvertContext(context EventContext, version string) (*EventContext, error) {
+	s, err := getSpec(version)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := context
+	converted.CloudEventsVersion = s.version
+	converted.Extensions = make(map[string]interface{}, len(context.Extensions))
+	for name, value := range context.Extensions {
+		if !s.nestedExtensions {
+			name = strings.ToLower(name)
+			if !isAlphanumeric(name) {
+				return nil, fmt.Errorf("invalid extension name %q for CloudEvents version %q", name, s.version)
+			}
+			if _, ok := converted.Extensions[name]; ok {
+				return nil, fmt.Errorf("duplicate extension name %q for CloudEvents version %q", name, s.version)
+			}
+		}
+		converted.Extensions[name] = value
+	}
+	return &converted, nil
+}
+
+func isAlphanumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
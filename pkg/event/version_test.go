@@ -0,0 +1,387 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/event"
+)
+
+func newRequest(header map[string]string, body string) *http.Request {
+	h := http.Header{}
+	for k, v := range header {
+		h.Set(k, v)
+	}
+	return &http.Request{
+		Header: h,
+		Body:   ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// The examples of the CloudEvents spec and its HTTP transport binding.
+func TestSpecExamples(t *testing.T) {
+	eventTime := time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name    string
+		req     *http.Request
+		context *event.EventContext
+		data    string
+	}{
+		{
+			name: "0.2 binary",
+			req: newRequest(map[string]string{
+				"ce-specversion":          "0.2",
+				"ce-type":                 "com.example.someevent",
+				"ce-time":                 "2018-04-05T17:31:00Z",
+				"ce-id":                   "1234-1234-1234",
+				"ce-source":               "/mycontext/subcontext",
+				"ce-comexampleextension1": "value",
+				"Content-Type":            "application/json; charset=utf-8",
+			}, `{"hello": "world"}`),
+			context: &event.EventContext{
+				CloudEventsVersion: "0.2",
+				EventID:            "1234-1234-1234",
+				EventTime:          eventTime,
+				EventType:          "com.example.someevent",
+				ContentType:        "application/json; charset=utf-8",
+				Source:             "/mycontext/subcontext",
+				Extensions: map[string]interface{}{
+					"comexampleextension1": "value",
+				},
+			},
+			data: `{"hello": "world"}`,
+		},
+		{
+			name: "0.2 structured",
+			req: newRequest(map[string]string{
+				"Content-Type": "application/cloudevents+json; charset=utf-8",
+			}, `{
+				"specversion" : "0.2",
+				"type" : "com.example.someevent",
+				"source" : "/mycontext",
+				"id" : "A234-1234-1234",
+				"time" : "2018-04-05T17:31:00Z",
+				"comexampleextension1" : "value",
+				"comexampleextension2" : {"otherValue": 5},
+				"contenttype" : "text/xml",
+				"data" : "<much wow=\"xml\"/>"
+			}`),
+			context: &event.EventContext{
+				CloudEventsVersion: "0.2",
+				EventID:            "A234-1234-1234",
+				EventTime:          eventTime,
+				EventType:          "com.example.someevent",
+				ContentType:        "text/xml",
+				Source:             "/mycontext",
+				Extensions: map[string]interface{}{
+					"comexampleextension1": "value",
+					"comexampleextension2": map[string]interface{}{"otherValue": float64(5)},
+				},
+			},
+			data: `<much wow="xml"/>`,
+		},
+		{
+			name: "1.0 binary",
+			req: newRequest(map[string]string{
+				"ce-specversion": "1.0",
+				"ce-type":        "com.example.someevent",
+				"ce-time":        "2018-04-05T17:31:00Z",
+				"ce-id":          "1234-1234-1234",
+				"ce-source":      "/mycontext/subcontext",
+				"ce-subject":     "123",
+				"ce-dataschema":  "http://example.com/schema",
+				"Content-Type":   "application/json; charset=utf-8",
+			}, `{"hello": "world"}`),
+			context: &event.EventContext{
+				CloudEventsVersion: "1.0",
+				EventID:            "1234-1234-1234",
+				EventTime:          eventTime,
+				EventType:          "com.example.someevent",
+				SchemaURL:          "http://example.com/schema",
+				ContentType:        "application/json; charset=utf-8",
+				Source:             "/mycontext/subcontext",
+				Subject:            "123",
+				Extensions:         map[string]interface{}{},
+			},
+			data: `{"hello": "world"}`,
+		},
+		{
+			name: "1.0 structured",
+			req: newRequest(map[string]string{
+				"Content-Type": "application/cloudevents+json; charset=utf-8",
+			}, `{
+				"specversion" : "1.0",
+				"type" : "com.github.pull_request.opened",
+				"source" : "https://github.com/cloudevents/spec/pull",
+				"subject" : "123",
+				"id" : "A234-1234-1234",
+				"time" : "2018-04-05T17:31:00Z",
+				"comexampleextension1" : "value",
+				"comexampleothervalue" : 5,
+				"datacontenttype" : "text/xml",
+				"data" : "<much wow=\"xml\"/>"
+			}`),
+			context: &event.EventContext{
+				CloudEventsVersion: "1.0",
+				EventID:            "A234-1234-1234",
+				EventTime:          eventTime,
+				EventType:          "com.github.pull_request.opened",
+				ContentType:        "text/xml",
+				Source:             "https://github.com/cloudevents/spec/pull",
+				Subject:            "123",
+				Extensions: map[string]interface{}{
+					"comexampleextension1": "value",
+					"comexampleothervalue": float64(5),
+				},
+			},
+			data: `<much wow="xml"/>`,
+		},
+		{
+			name: "1.0 structured base64",
+			req: newRequest(map[string]string{
+				"Content-Type": "application/cloudevents+json",
+			}, `{
+				"specversion" : "1.0",
+				"type" : "com.example.someevent",
+				"source" : "/mycontext",
+				"id" : "A234-1234-1234",
+				"datacontenttype" : "application/octet-stream",
+				"data_base64" : "aGVsbG8="
+			}`),
+			context: &event.EventContext{
+				CloudEventsVersion: "1.0",
+				EventID:            "A234-1234-1234",
+				EventType:          "com.example.someevent",
+				ContentType:        "application/octet-stream",
+				Source:             "/mycontext",
+				Extensions:         map[string]interface{}{},
+			},
+			data: "hello",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var reader io.Reader
+			context, err := event.FromRequest(&reader, test.req)
+			if err != nil {
+				t.Fatalf("Failed to parse request: %v", err)
+			}
+			if !reflect.DeepEqual(test.context, context) {
+				t.Fatalf("Got wrong context; wanted=%+v; got=%+v", test.context, context)
+			}
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Failed to read data: %v", err)
+			}
+			if string(data) != test.data {
+				t.Fatalf("Got wrong data; wanted=%q; got=%q", test.data, string(data))
+			}
+		})
+	}
+}
+
+func TestVersionRoundTrips(t *testing.T) {
+	context := event.EventContext{
+		EventID:          "1234",
+		EventTime:        time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC),
+		EventType:        "dev.knative.test",
+		EventTypeVersion: "v1",
+		SchemaURL:        "http://example.com/schema",
+		ContentType:      "application/json",
+		Source:           "tests://TestVersionRoundTrips",
+		Subject:          "subject",
+		Extensions: map[string]interface{}{
+			"purpose": "tbd",
+		},
+	}
+	data := map[string]interface{}{
+		"hello": "world",
+	}
+
+	for _, version := range []string{event.CloudEventsVersion01, event.CloudEventsVersion02, event.CloudEventsVersion10} {
+		for _, encoder := range []struct {
+			name    string
+			encoder event.HTTPMarshaller
+		}{
+			{"binary", event.Binary},
+			{"structured", event.Structured},
+		} {
+			t.Run(version+" "+encoder.name, func(t *testing.T) {
+				sent, err := event.ConvertContext(context, version)
+				if err != nil {
+					t.Fatalf("Failed to convert context: %v", err)
+				}
+				req, err := encoder.encoder.NewRequest(webhook, data, *sent)
+				if err != nil {
+					t.Fatalf("Failed to encode event: %v", err)
+				}
+
+				var foundData map[string]interface{}
+				foundContext, err := event.FromRequest(&foundData, req)
+				if err != nil {
+					t.Fatalf("Failed to decode event: %v", err)
+				}
+				if !reflect.DeepEqual(sent, foundContext) {
+					t.Fatalf("Context was transcoded lossily: expected=%+v got=%+v", sent, foundContext)
+				}
+				if !reflect.DeepEqual(data, foundData) {
+					t.Fatalf("Data was transcoded lossily: expected=%+v got=%+v", data, foundData)
+				}
+			})
+		}
+	}
+}
+
+func TestSelectedVersionHeaders(t *testing.T) {
+	context := event.EventContext{
+		CloudEventsVersion: event.CloudEventsVersion10,
+		EventID:            "1234",
+		EventType:          "dev.knative.test",
+		Source:             "tests://TestSelectedVersionHeaders",
+	}
+	req, err := event.Binary.NewRequest(webhook, nil, context)
+	if err != nil {
+		t.Fatalf("Failed to encode event: %v", err)
+	}
+	for header, expected := range map[string]string{
+		event.HeaderSpecVersion: "1.0",
+		event.HeaderID:          "1234",
+		event.HeaderType:        "dev.knative.test",
+		event.HeaderEventID:     "",
+		event.HeaderEventType:   "",
+	} {
+		if actual := req.Header.Get(header); actual != expected {
+			t.Errorf("Got wrong header %q; wanted=%q; got=%q", header, expected, actual)
+		}
+	}
+}
+
+func TestConvertContext(t *testing.T) {
+	context := event.EventContext{
+		CloudEventsVersion: event.CloudEventsVersion01,
+		EventID:            "1234",
+		EventType:          "dev.knative.test",
+		Source:             "tests://TestConvertContext",
+		Extensions: map[string]interface{}{
+			"Purpose": "tbd",
+		},
+	}
+	for _, test := range []struct {
+		name       string
+		version    string
+		extensions map[string]interface{}
+		expected   *event.EventContext
+		err        string
+	}{
+		{
+			name:    "to 0.1",
+			version: event.CloudEventsVersion01,
+			expected: &event.EventContext{
+				CloudEventsVersion: event.CloudEventsVersion01,
+				EventID:            "1234",
+				EventType:          "dev.knative.test",
+				Source:             "tests://TestConvertContext",
+				Extensions: map[string]interface{}{
+					"Purpose": "tbd",
+				},
+			},
+		},
+		{
+			name:    "to 1.0",
+			version: event.CloudEventsVersion10,
+			expected: &event.EventContext{
+				CloudEventsVersion: event.CloudEventsVersion10,
+				EventID:            "1234",
+				EventType:          "dev.knative.test",
+				Source:             "tests://TestConvertContext",
+				Extensions: map[string]interface{}{
+					"purpose": "tbd",
+				},
+			},
+		},
+		{
+			name:    "default version",
+			version: "",
+			expected: &event.EventContext{
+				CloudEventsVersion: event.CloudEventsVersion,
+				EventID:            "1234",
+				EventType:          "dev.knative.test",
+				Source:             "tests://TestConvertContext",
+				Extensions: map[string]interface{}{
+					"Purpose": "tbd",
+				},
+			},
+		},
+		{
+			name:       "invalid extension name",
+			version:    event.CloudEventsVersion02,
+			extensions: map[string]interface{}{"my-extension": "value"},
+			err:        `invalid extension name "my-extension" for CloudEvents version "0.2"`,
+		},
+		{
+			name:       "duplicate extension name",
+			version:    event.CloudEventsVersion10,
+			extensions: map[string]interface{}{"ext": "a", "Ext": "b"},
+			err:        `duplicate extension name "ext" for CloudEvents version "1.0"`,
+		},
+		{
+			name:    "unsupported version",
+			version: "0.3",
+			err:     `unsupported CloudEvents version "0.3"`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context
+			if test.extensions != nil {
+				c.Extensions = test.extensions
+			}
+			converted, err := event.ConvertContext(c, test.version)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("Got wrong error; wanted=%q; got=%v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to convert context: %v", err)
+			}
+			if !reflect.DeepEqual(test.expected, converted) {
+				t.Fatalf("Got wrong context; wanted=%+v; got=%+v", test.expected, converted)
+			}
+		})
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	req := newRequest(map[string]string{
+		"ce-specversion": "0.3",
+		"ce-type":        "com.example.someevent",
+		"ce-id":          "1234-1234-1234",
+		"ce-source":      "/mycontext/subcontext",
+		"Content-Type":   "application/json",
+	}, `{}`)
+	var data interface{}
+	if _, err := event.FromRequest(&data, req); err == nil {
+		t.Fatal("Expected an error for an unsupported version")
+	}
+}
@@ -3,9 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 
 	// Imports the Google Cloud Pub/Sub client package.
@@ -52,10 +50,11 @@ func main() {
 	}
 
 	sub := client.Subscription(subscriptionName)
+	eventClient := event.NewClient()
 
 	err = sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
 		log.Printf("Got message: %s", m.Data)
-		err = postMessage(target, source, m)
+		err = postMessage(ctx, eventClient, target, source, m)
 		if err != nil {
 			log.Printf("Failed to post message: %s", err)
 			m.Nack()
@@ -68,30 +67,16 @@ func main() {
 	}
 }
 
-func postMessage(target string, source string, m *pubsub.Message) error {
+func postMessage(ctx context.Context, client *event.Client, target string, source string, m *pubsub.Message) error {
 	URL := fmt.Sprintf("http://%s/", target)
-	ctx := event.EventContext{
+	eventContext := event.EventContext{
 		CloudEventsVersion: event.CloudEventsVersion,
 		EventType:          "google.pubsub.topic.publish",
 		EventID:            m.ID,
 		EventTime:          m.PublishTime,
 		Source:             source,
 	}
-	req, err := event.Binary.NewRequest(URL, m, ctx)
-	if err != nil {
-		log.Printf("Failed to marshal the message: %+v : %s", m, err)
-		return err
-	}
 
 	log.Printf("Posting to %q", URL)
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	log.Printf("response Status: %s", resp.Status)
-	body, _ := ioutil.ReadAll(resp.Body)
-	log.Printf("response Body: %s", string(body))
-	return nil
+	return client.Send(ctx, URL, eventContext, m)
 }
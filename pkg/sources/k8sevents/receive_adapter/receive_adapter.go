@@ -17,11 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"strings"
 
@@ -44,10 +43,14 @@ const (
 
 type EventWatcher struct {
 	target string
+	client *event.Client
 }
 
 func NewEventWatcher(target string) *EventWatcher {
-	return &EventWatcher{target: target}
+	return &EventWatcher{
+		target: target,
+		client: event.NewClient(),
+	}
 }
 
 func (e *EventWatcher) updateEvent(old, new interface{}) {
@@ -57,7 +60,9 @@ func (e *EventWatcher) updateEvent(old, new interface{}) {
 func (e *EventWatcher) addEvent(new interface{}) {
 	event := new.(*corev1.Event)
 	log.Printf("GOT EVENT: %+v", event)
-	postMessage(e.target, event)
+	if err := postMessage(e.client, e.target, event); err != nil {
+		log.Printf("Failed to post event: %v", err)
+	}
 }
 
 func main() {
@@ -167,28 +172,12 @@ func cloudEventsContext(m *corev1.Event) *event.EventContext {
 	}
 }
 
-func postMessage(target string, m *corev1.Event) error {
+func postMessage(client *event.Client, target string, m *corev1.Event) error {
 	ctx := cloudEventsContext(m)
 
 	URL := fmt.Sprintf("http://%s/", target)
 	log.Printf("Posting to %q", URL)
-	// Explicitly using Binary encoding so that Istio, et. al. can better inspect
-	// event metadata.
-	req, err := event.Binary.NewRequest(URL, m, *ctx)
-	if err != nil {
-		log.Printf("Failed to create http request: %s", err)
-		return err
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Failed to do POST: %v", err)
-		return err
-	}
-	defer resp.Body.Close()
-	log.Printf("response Status: %s", resp.Status)
-	body, _ := ioutil.ReadAll(resp.Body)
-	log.Printf("response Body: %s", string(body))
-	return nil
+	// The client uses Binary encoding so that Istio, et. al. can better
+	// inspect event metadata.
+	return client.Send(context.Background(), URL, *ctx, m)
 }
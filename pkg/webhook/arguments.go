@@ -0,0 +1,118 @@
+/*
+Copyright 2018 The Knative Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validateChannelArguments checks the Channel's Arguments against the
+// Channel Parameters of its Bus or ClusterBus. Channels are admitted if the
+// bus cannot be found, its provisioner checks the Arguments again.
+func validateChannelArguments(client clientset.Interface, channel *v1alpha1.Channel) error {
+	bus, err := getBus(client, channel.Namespace, channel.Spec.Bus, channel.Spec.ClusterBus)
+	if err != nil || bus == nil {
+		return nil
+	}
+	var parameters *[]v1alpha1.Parameter
+	if bus.GetSpec().Parameters != nil {
+		parameters = bus.GetSpec().Parameters.Channel
+	}
+	return validateArguments(parameters, channel.Spec.Arguments)
+}
+
+// validateSubscriptionArguments checks the Subscription's Arguments against
+// the Subscription Parameters of the bus of its Channel. Subscriptions are
+// admitted if the Channel or bus cannot be found, its dispatcher checks the
+// Arguments again.
+func validateSubscriptionArguments(client clientset.Interface, subscription *v1alpha1.Subscription) error {
+	if client == nil {
+		return nil
+	}
+	channel, err := client.ChannelsV1alpha1().Channels(subscription.Namespace).Get(subscription.Spec.Channel, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			glog.Warningf("Unable to get Channel %s/%s to validate arguments: %v", subscription.Namespace, subscription.Spec.Channel, err)
+		}
+		return nil
+	}
+	bus, err := getBus(client, channel.Namespace, channel.Spec.Bus, channel.Spec.ClusterBus)
+	if err != nil || bus == nil {
+		return nil
+	}
+	var parameters *[]v1alpha1.Parameter
+	if bus.GetSpec().Parameters != nil {
+		parameters = bus.GetSpec().Parameters.Subscription
+	}
+	return validateArguments(parameters, subscription.Spec.Arguments)
+}
+
+// getBus returns the Bus or ClusterBus of a Channel, or nil if it does not
+// exist.
+func getBus(client clientset.Interface, namespace, busName, clusterBusName string) (v1alpha1.GenericBus, error) {
+	if client == nil {
+		return nil, nil
+	}
+	var bus v1alpha1.GenericBus
+	var err error
+	if len(busName) != 0 {
+		bus, err = client.ChannelsV1alpha1().Buses(namespace).Get(busName, metav1.GetOptions{})
+	} else {
+		bus, err = client.ChannelsV1alpha1().ClusterBuses().Get(clusterBusName, metav1.GetOptions{})
+	}
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		glog.Warningf("Unable to get bus to validate arguments: %v", err)
+		return nil, err
+	}
+	return bus, nil
+}
+
+// validateArguments checks that each Parameter without a default has an
+// Argument and that the Arguments follow their Parameter's rules. Arguments
+// without a Parameter are ignored, as the bus does.
+func validateArguments(parameters *[]v1alpha1.Parameter, arguments *[]v1alpha1.Argument) error {
+	if parameters == nil {
+		return nil
+	}
+	values := make(map[string]string)
+	if arguments != nil {
+		for _, arg := range *arguments {
+			values[arg.Name] = arg.Value
+		}
+	}
+	for _, param := range *parameters {
+		value, ok := values[param.Name]
+		if !ok {
+			if param.Default == nil {
+				return fmt.Errorf("missing required argument Spec.Arguments.%s", param.Name)
+			}
+			continue
+		}
+		if err := param.Validate(value); err != nil {
+			return fmt.Errorf("invalid argument Spec.Arguments.%s: %v", param.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2018 The Knative Authors. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	fakeclientset "github.com/knative/eventing/pkg/client/clientset/versioned/fake"
+)
+
+func newArgumentsTestClient() *fakeclientset.Clientset {
+	partitions := "1"
+	offset := "Newest"
+	bus := createBus(testBusName, "foobar/dispatcher")
+	bus.Spec.Parameters.Channel = &[]v1alpha1.Parameter{
+		{Name: "partitions", Type: v1alpha1.ParameterTypeInt, Minimum: &partitions, Default: &partitions},
+	}
+	bus.Spec.Parameters.Subscription = &[]v1alpha1.Parameter{
+		{Name: "initialOffset", Type: v1alpha1.ParameterTypeEnum, AllowedValues: []string{"Oldest", "Newest"}, Default: &offset},
+	}
+	clusterBus := createClusterBus(testClusterBusName, "foobar/dispatcher")
+	clusterBus.Spec.Parameters.Channel = &[]v1alpha1.Parameter{
+		{Name: "retention", Type: v1alpha1.ParameterTypeDuration},
+	}
+	channel := createChannel(testChannelName, testBusName, "")
+	return fakeclientset.NewSimpleClientset(&bus, &clusterBus, &channel)
+}
+
+func TestChannelArguments(t *testing.T) {
+	client := newArgumentsTestClient()
+	for _, test := range []struct {
+		name       string
+		bus        string
+		clusterBus string
+		arguments  []v1alpha1.Argument
+		err        string
+	}{
+		{
+			name: "defaulted",
+			bus:  testBusName,
+		},
+		{
+			name:      "valid",
+			bus:       testBusName,
+			arguments: []v1alpha1.Argument{{Name: "partitions", Value: "3"}},
+		},
+		{
+			name:      "unknown argument",
+			bus:       testBusName,
+			arguments: []v1alpha1.Argument{{Name: "ttl", Value: "1h"}},
+		},
+		{
+			name:      "not an int",
+			bus:       testBusName,
+			arguments: []v1alpha1.Argument{{Name: "partitions", Value: "three"}},
+			err:       "invalid argument Spec.Arguments.partitions",
+		},
+		{
+			name:      "below minimum",
+			bus:       testBusName,
+			arguments: []v1alpha1.Argument{{Name: "partitions", Value: "0"}},
+			err:       "invalid argument Spec.Arguments.partitions",
+		},
+		{
+			name:       "cluster bus",
+			clusterBus: testClusterBusName,
+			arguments:  []v1alpha1.Argument{{Name: "retention", Value: "24h"}},
+		},
+		{
+			name:       "cluster bus missing argument",
+			clusterBus: testClusterBusName,
+			err:        "missing required argument Spec.Arguments.retention",
+		},
+		{
+			name:      "unknown bus",
+			bus:       "unknown",
+			arguments: []v1alpha1.Argument{{Name: "partitions", Value: "three"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := createChannel(testChannelName, test.bus, test.clusterBus)
+			c.Spec.Arguments = &test.arguments
+			err := ValidateChannel(testCtx, client)(nil, nil, &c)
+			expectArgumentsError(t, test.err, err)
+		})
+	}
+}
+
+func TestSubscriptionArguments(t *testing.T) {
+	client := newArgumentsTestClient()
+	for _, test := range []struct {
+		name      string
+		channel   string
+		arguments []v1alpha1.Argument
+		err       string
+	}{
+		{
+			name:    "defaulted",
+			channel: testChannelName,
+		},
+		{
+			name:      "valid",
+			channel:   testChannelName,
+			arguments: []v1alpha1.Argument{{Name: "initialOffset", Value: "Oldest"}},
+		},
+		{
+			name:      "not allowed",
+			channel:   testChannelName,
+			arguments: []v1alpha1.Argument{{Name: "initialOffset", Value: "foo"}},
+			err:       "invalid argument Spec.Arguments.initialOffset",
+		},
+		{
+			name:      "unknown channel",
+			channel:   "unknown",
+			arguments: []v1alpha1.Argument{{Name: "initialOffset", Value: "foo"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := createSubscription(testSubscriptionName, test.channel)
+			s.Spec.Arguments = &test.arguments
+			err := ValidateSubscription(testCtx, client)(nil, nil, &s)
+			expectArgumentsError(t, test.err, err)
+		})
+	}
+}
+
+func expectArgumentsError(t *testing.T, expected string, err error) {
+	t.Helper()
+	if expected == "" {
+		if err != nil {
+			t.Errorf("Expected success, but failed with: %s", err)
+		}
+		return
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("Expected error starting with %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 The Knative Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/golang/glog"
+	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	"github.com/mattbaird/jsonpatch"
+)
+
+var (
+	errInvalidBridgeInput             = errors.New("failed to convert input into Bridge")
+	errInvalidBridgeChannelMissing    = errors.New("the Bridge must reference a Channel")
+	errInvalidBridgeChannelMutation   = errors.New("the Bridge's Channel may not change")
+	errInvalidBridgeTargetExclusivity = errors.New("the Bridge's Target must reference either a Channel or URL, not both")
+	errInvalidBridgeTargetMissing     = errors.New("the Bridge's Target must reference a Channel or URL")
+	errInvalidBridgeTargetChannelKind = errors.New("the Bridge's Target Channel must reference a Channel")
+	errInvalidBridgeTargetChannelName = errors.New("the Bridge's Target Channel must have a name")
+	errInvalidBridgeTargetURL         = errors.New("the Bridge's Target URL must be an absolute https URL")
+	errInvalidBridgeCredentialsTarget = errors.New("the Bridge's CredentialsSecret may only be used with a Target URL")
+	errInvalidBridgeMaxHops           = errors.New("the Bridge's MaxHops must be positive")
+)
+
+// ValidateBridge is Bridge resource specific validation and mutation handler
+func ValidateBridge(ctx context.Context) ResourceCallback {
+	return func(patches *[]jsonpatch.JsonPatchOperation, old GenericCRD, new GenericCRD) error {
+		oldBridge, newBridge, err := unmarshalBridges(ctx, old, new, "ValidateBridge")
+		if err != nil {
+			return err
+		}
+
+		return validateBridge(oldBridge, newBridge)
+	}
+}
+
+func validateBridge(old, new *v1alpha1.Bridge) error {
+	if len(new.Spec.Channel) == 0 {
+		return errInvalidBridgeChannelMissing
+	}
+	if old != nil && old.Spec.Channel != new.Spec.Channel {
+		return errInvalidBridgeChannelMutation
+	}
+	target := new.Spec.Target
+	if target.Channel != nil && len(target.URL) != 0 {
+		return errInvalidBridgeTargetExclusivity
+	}
+	if ref := target.Channel; ref != nil {
+		if len(ref.Kind) != 0 && ref.Kind != v1alpha1.ChannelKind {
+			return errInvalidBridgeTargetChannelKind
+		}
+		if len(ref.Name) == 0 {
+			return errInvalidBridgeTargetChannelName
+		}
+		if target.CredentialsSecret != nil {
+			return errInvalidBridgeCredentialsTarget
+		}
+	} else if len(target.URL) != 0 {
+		u, err := url.Parse(target.URL)
+		if err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+			return errInvalidBridgeTargetURL
+		}
+	} else {
+		return errInvalidBridgeTargetMissing
+	}
+	if hops := new.Spec.MaxHops; hops != nil && *hops < 1 {
+		return errInvalidBridgeMaxHops
+	}
+	return nil
+}
+
+func unmarshalBridges(
+	ctx context.Context, old, new GenericCRD, fnName string) (*v1alpha1.Bridge, *v1alpha1.Bridge, error) {
+	var oldBridge *v1alpha1.Bridge
+	if old != nil {
+		var ok bool
+		oldBridge, ok = old.(*v1alpha1.Bridge)
+		if !ok {
+			return nil, nil, errInvalidBridgeInput
+		}
+	}
+	glog.Infof("%s: OLD Bridge is\n%+v", fnName, oldBridge)
+
+	newBridge, ok := new.(*v1alpha1.Bridge)
+	if !ok {
+		return nil, nil, errInvalidBridgeInput
+	}
+	glog.Infof("%s: NEW Bridge is\n%+v", fnName, newBridge)
+
+	return oldBridge, newBridge, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 The Knative Authors. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testBridgeName = "test-bridge"
+
+func TestNewBridge(t *testing.T) {
+	for _, target := range []v1alpha1.BridgeTarget{
+		{Channel: &corev1.ObjectReference{Kind: "Channel", Name: "downstream"}},
+		{Channel: &corev1.ObjectReference{Name: "downstream", Namespace: "other"}},
+		{URL: "https://ingress.example.com/"},
+		{URL: "https://ingress.example.com/", CredentialsSecret: &corev1.LocalObjectReference{Name: "remote"}},
+	} {
+		b := createBridge(testBridgeName, testChannelName, target)
+		if err := ValidateBridge(testCtx)(nil, nil, &b); err != nil {
+			t.Errorf("Expected success for %+v, but failed with: %s", target, err)
+		}
+	}
+}
+
+func TestBridgeChannelMutation(t *testing.T) {
+	target := v1alpha1.BridgeTarget{URL: "https://ingress.example.com/"}
+	old := createBridge(testBridgeName, "hello", target)
+	new := createBridge(testBridgeName, "goodbye", target)
+	err := ValidateBridge(testCtx)(nil, &old, &new)
+	if e, a := errInvalidBridgeChannelMutation, err; e != a {
+		t.Errorf("Expected %s got %s", e, a)
+	}
+}
+
+func TestInvalidBridge(t *testing.T) {
+	zero := int32(0)
+	for _, test := range []struct {
+		name    string
+		channel string
+		target  v1alpha1.BridgeTarget
+		maxHops *int32
+		err     error
+	}{
+		{
+			name:   "missing channel",
+			target: v1alpha1.BridgeTarget{URL: "https://ingress.example.com/"},
+			err:    errInvalidBridgeChannelMissing,
+		},
+		{
+			name:    "missing target",
+			channel: testChannelName,
+			err:     errInvalidBridgeTargetMissing,
+		},
+		{
+			name:    "channel and url",
+			channel: testChannelName,
+			target: v1alpha1.BridgeTarget{
+				Channel: &corev1.ObjectReference{Kind: "Channel", Name: "downstream"},
+				URL:     "https://ingress.example.com/",
+			},
+			err: errInvalidBridgeTargetExclusivity,
+		},
+		{
+			name:    "unsupported kind",
+			channel: testChannelName,
+			target:  v1alpha1.BridgeTarget{Channel: &corev1.ObjectReference{Kind: "Service", Name: "downstream"}},
+			err:     errInvalidBridgeTargetChannelKind,
+		},
+		{
+			name:    "missing channel name",
+			channel: testChannelName,
+			target:  v1alpha1.BridgeTarget{Channel: &corev1.ObjectReference{Kind: "Channel"}},
+			err:     errInvalidBridgeTargetChannelName,
+		},
+		{
+			name:    "credentials for channel",
+			channel: testChannelName,
+			target: v1alpha1.BridgeTarget{
+				Channel:           &corev1.ObjectReference{Kind: "Channel", Name: "downstream"},
+				CredentialsSecret: &corev1.LocalObjectReference{Name: "remote"},
+			},
+			err: errInvalidBridgeCredentialsTarget,
+		},
+		{
+			name:    "http url",
+			channel: testChannelName,
+			target:  v1alpha1.BridgeTarget{URL: "http://ingress.example.com/"},
+			err:     errInvalidBridgeTargetURL,
+		},
+		{
+			name:    "relative url",
+			channel: testChannelName,
+			target:  v1alpha1.BridgeTarget{URL: "ingress.example.com"},
+			err:     errInvalidBridgeTargetURL,
+		},
+		{
+			name:    "zero max hops",
+			channel: testChannelName,
+			target:  v1alpha1.BridgeTarget{URL: "https://ingress.example.com/"},
+			maxHops: &zero,
+			err:     errInvalidBridgeMaxHops,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := createBridge(testBridgeName, test.channel, test.target)
+			b.Spec.MaxHops = test.maxHops
+			err := ValidateBridge(testCtx)(nil, nil, &b)
+			if e, a := test.err, err; e != a {
+				t.Errorf("Expected %s got %s", e, a)
+			}
+		})
+	}
+}
+
+func createBridge(bridgeName, channelName string, target v1alpha1.BridgeTarget) v1alpha1.Bridge {
+	return v1alpha1.Bridge{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      bridgeName,
+		},
+		Spec: v1alpha1.BridgeSpec{
+			Channel: channelName,
+			Target:  target,
+		},
+	}
+}
@@ -31,6 +31,10 @@ import (
 var (
 	errInvalidBusInput = errors.New("failed to convert input into Bus or ClusterBus")
 	errInternalNilBus  = errors.New("unexpected internal error: nil Bus or ClusterBus")
+
+	errInvalidBusProvisionerReplicas = errors.New("the Bus' ProvisionerReplicas must be at least 1")
+	errInvalidBusDispatcherReplicas  = errors.New("the Bus' DispatcherReplicas must be at least 1")
+	errInvalidBusAutoscalingReplicas = errors.New("the Bus' DispatcherAutoscaling MaxReplicas must be at least MinReplicas and at least 1")
 )
 
 // ValidateBus is Bus resource specific validation and mutation handler
@@ -46,6 +50,21 @@ func ValidateBus(ctx context.Context) ResourceCallback {
 }
 
 func validateBus(old, new v1alpha1.GenericBus) error {
+	if replicas := new.GetSpec().ProvisionerReplicas; replicas != nil && *replicas < 1 {
+		return errInvalidBusProvisionerReplicas
+	}
+	if replicas := new.GetSpec().DispatcherReplicas; replicas != nil && *replicas < 1 {
+		return errInvalidBusDispatcherReplicas
+	}
+	if autoscaling := new.GetSpec().DispatcherAutoscaling; autoscaling != nil {
+		minReplicas := int32(1)
+		if autoscaling.MinReplicas != nil {
+			minReplicas = *autoscaling.MinReplicas
+		}
+		if minReplicas < 1 || autoscaling.MaxReplicas < minReplicas {
+			return errInvalidBusAutoscalingReplicas
+		}
+	}
 	if new.GetSpec().Parameters != nil {
 		if new.GetSpec().Parameters.Channel != nil {
 			for _, p := range *new.GetSpec().Parameters.Channel {
@@ -54,6 +73,9 @@ func validateBus(old, new v1alpha1.GenericBus) error {
 					return fmt.Errorf("invalid parameter name Spec.Parameters.Channel.%s: %s", p.Name,
 						strings.Join(errs, ", "))
 				}
+				if err := p.ValidateSchema(); err != nil {
+					return fmt.Errorf("invalid parameter Spec.Parameters.Channel.%s: %v", p.Name, err)
+				}
 			}
 		}
 		if new.GetSpec().Parameters.Subscription != nil {
@@ -63,6 +85,9 @@ func validateBus(old, new v1alpha1.GenericBus) error {
 					return fmt.Errorf("invalid parameter name Spec.Parameters.Subscription.%s: %s", p.Name,
 						strings.Join(errs, ", "))
 				}
+				if err := p.ValidateSchema(); err != nil {
+					return fmt.Errorf("invalid parameter Spec.Parameters.Subscription.%s: %v", p.Name, err)
+				}
 			}
 		}
 	}
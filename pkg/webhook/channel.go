@@ -21,6 +21,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
 	"github.com/mattbaird/jsonpatch"
 )
 
@@ -32,15 +33,19 @@ var (
 	errInvalidChannelClusterBusMutation = errors.New("the Channel's ClusterBus may not change")
 )
 
-// ValidateChannel is Channel resource specific validation and mutation handler
-func ValidateChannel(ctx context.Context) ResourceCallback {
+// ValidateChannel is Channel resource specific validation and mutation handler.
+// The Channel's Arguments are checked against the Parameters of its bus.
+func ValidateChannel(ctx context.Context, client clientset.Interface) ResourceCallback {
 	return func(patches *[]jsonpatch.JsonPatchOperation, old GenericCRD, new GenericCRD) error {
 		oldChannel, newChannel, err := unmarshalChannels(ctx, old, new, "ValidateChannel")
 		if err != nil {
 			return err
 		}
 
-		return validateChannel(oldChannel, newChannel)
+		if err := validateChannel(oldChannel, newChannel); err != nil {
+			return err
+		}
+		return validateChannelArguments(client, newChannel)
 	}
 }
 
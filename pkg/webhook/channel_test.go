@@ -21,21 +21,21 @@ import (
 
 func TestNewChannelNSBus(t *testing.T) {
 	c := createChannel(testChannelName, testBusName, "")
-	if err := ValidateChannel(testCtx)(nil, nil, &c); err != nil {
+	if err := ValidateChannel(testCtx, nil)(nil, nil, &c); err != nil {
 		t.Errorf("Expected success, but failed with: %s", err)
 	}
 }
 
 func TestNewChannelClusterBus(t *testing.T) {
 	c := createChannel(testChannelName, "", testClusterBusName)
-	if err := ValidateChannel(testCtx)(nil, nil, &c); err != nil {
+	if err := ValidateChannel(testCtx, nil)(nil, nil, &c); err != nil {
 		t.Errorf("Expected success, but failed with: %s", err)
 	}
 }
 
 func TestNewEmptyChannel(t *testing.T) {
 	c := createChannel(testChannelName, "", "")
-	err := ValidateChannel(testCtx)(nil, nil, &c)
+	err := ValidateChannel(testCtx, nil)(nil, nil, &c)
 	if err == nil {
 		t.Errorf("Expected failure, but succeeded with: %+v", c)
 	}
@@ -46,7 +46,7 @@ func TestNewEmptyChannel(t *testing.T) {
 
 func TestNewExclusiveChannel(t *testing.T) {
 	c := createChannel(testChannelName, testBusName, testClusterBusName)
-	err := ValidateChannel(testCtx)(nil, nil, &c)
+	err := ValidateChannel(testCtx, nil)(nil, nil, &c)
 	if err == nil {
 		t.Errorf("Expected failure, but succeeded with: %+v", c)
 	}
@@ -57,7 +57,7 @@ func TestNewExclusiveChannel(t *testing.T) {
 
 func TestChannelNoopMutation(t *testing.T) {
 	c := createChannel(testChannelName, testBusName, "")
-	if err := ValidateChannel(testCtx)(nil, &c, &c); err != nil {
+	if err := ValidateChannel(testCtx, nil)(nil, &c, &c); err != nil {
 		t.Errorf("Expected success, but failed with: %s", err)
 	}
 }
@@ -65,7 +65,7 @@ func TestChannelNoopMutation(t *testing.T) {
 func TestChannelNSBusMutation(t *testing.T) {
 	old := createChannel(testChannelName, "stub", "")
 	new := createChannel(testChannelName, "pubsub", "")
-	err := ValidateChannel(testCtx)(nil, &old, &new)
+	err := ValidateChannel(testCtx, nil)(nil, &old, &new)
 	if err == nil {
 		t.Errorf("Expected failure, but succeeded with: %+v %+v", old, new)
 	}
@@ -77,7 +77,7 @@ func TestChannelNSBusMutation(t *testing.T) {
 func TestChannelClusterBusMutation(t *testing.T) {
 	old := createChannel(testChannelName, "", "stub")
 	new := createChannel(testChannelName, "", "pubsub")
-	err := ValidateChannel(testCtx)(nil, &old, &new)
+	err := ValidateChannel(testCtx, nil)(nil, &old, &new)
 	if err == nil {
 		t.Errorf("Expected failure, but succeeded with: %+v %+v", old, new)
 	}
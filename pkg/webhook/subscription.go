@@ -21,6 +21,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
 	"github.com/mattbaird/jsonpatch"
 )
 
@@ -28,17 +29,29 @@ var (
 	errInvalidSubscriptionInput           = errors.New("failed to convert input into Subscription")
 	errInvalidSubscriptionChannelMissing  = errors.New("the Subscription must reference a Channel")
 	errInvalidSubscriptionChannelMutation = errors.New("the Subscription's Channel may not change")
+	errInvalidSubscriberExclusivity       = errors.New("the Subscription must reference either a Subscriber or SubscriberRef, not both")
+	errInvalidSubscriberMissing           = errors.New("the Subscription must reference a Subscriber or SubscriberRef")
+	errInvalidSubscriberRefKind           = errors.New("the Subscription's SubscriberRef must reference a Channel")
+	errInvalidSubscriberRefNameMissing    = errors.New("the Subscription's SubscriberRef must have a name")
+	errInvalidSubscriberRefNamespace      = errors.New("the Subscription's SubscriberRef must be in the Subscription's namespace")
+	errInvalidSubscriptionDeliveryDelay   = errors.New("the Subscription's DeliveryDelay may not be negative")
+	errInvalidSubscriptionBatching        = errors.New("the Subscription's Batching MaxEvents and MaxDelay may not be negative")
 )
 
-// ValidateSubscription is Subscription resource specific validation and mutation handler
-func ValidateSubscription(ctx context.Context) ResourceCallback {
+// ValidateSubscription is Subscription resource specific validation and mutation
+// handler. The Subscription's Arguments are checked against the Parameters of
+// its Channel's bus.
+func ValidateSubscription(ctx context.Context, client clientset.Interface) ResourceCallback {
 	return func(patches *[]jsonpatch.JsonPatchOperation, old GenericCRD, new GenericCRD) error {
 		oldSubscription, newSubscription, err := unmarshalSubscriptions(ctx, old, new, "ValidateSubscription")
 		if err != nil {
 			return err
 		}
 
-		return validateSubscription(oldSubscription, newSubscription)
+		if err := validateSubscription(oldSubscription, newSubscription); err != nil {
+			return err
+		}
+		return validateSubscriptionArguments(client, newSubscription)
 	}
 }
 
@@ -49,6 +62,30 @@ func validateSubscription(old, new *v1alpha1.Subscription) error {
 	if old != nil && old.Spec.Channel != new.Spec.Channel {
 		return errInvalidSubscriptionChannelMutation
 	}
+	if ref := new.Spec.SubscriberRef; ref != nil {
+		if len(new.Spec.Subscriber) != 0 {
+			return errInvalidSubscriberExclusivity
+		}
+		if ref.Kind != v1alpha1.ChannelKind {
+			return errInvalidSubscriberRefKind
+		}
+		if len(ref.Name) == 0 {
+			return errInvalidSubscriberRefNameMissing
+		}
+		if len(ref.Namespace) != 0 && ref.Namespace != new.Namespace {
+			return errInvalidSubscriberRefNamespace
+		}
+	} else if len(new.Spec.Subscriber) == 0 {
+		return errInvalidSubscriberMissing
+	}
+	if delay := new.Spec.DeliveryDelay; delay != nil && delay.Duration < 0 {
+		return errInvalidSubscriptionDeliveryDelay
+	}
+	if batching := new.Spec.Batching; batching != nil {
+		if batching.MaxEvents < 0 || (batching.MaxDelay != nil && batching.MaxDelay.Duration < 0) {
+			return errInvalidSubscriptionBatching
+		}
+	}
 	return nil
 }
 
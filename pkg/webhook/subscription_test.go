@@ -17,18 +17,23 @@ package webhook
 
 import (
 	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestNewSubscription(t *testing.T) {
 	s := createSubscription(testSubscriptionName, testChannelName)
-	if err := ValidateSubscription(testCtx)(nil, nil, &s); err != nil {
+	if err := ValidateSubscription(testCtx, nil)(nil, nil, &s); err != nil {
 		t.Errorf("Expected success, but failed with: %s", err)
 	}
 }
 
 func TestNewEmptySubscription(t *testing.T) {
 	s := createSubscription(testSubscriptionName, "")
-	err := ValidateSubscription(testCtx)(nil, nil, &s)
+	err := ValidateSubscription(testCtx, nil)(nil, nil, &s)
 	if err == nil {
 		t.Errorf("Expected failure, but succeeded with: %+v", s)
 	}
@@ -39,7 +44,7 @@ func TestNewEmptySubscription(t *testing.T) {
 
 func TestSubscriptionMutation(t *testing.T) {
 	s := createSubscription(testSubscriptionName, testChannelName)
-	if err := ValidateSubscription(testCtx)(nil, &s, &s); err != nil {
+	if err := ValidateSubscription(testCtx, nil)(nil, &s, &s); err != nil {
 		t.Errorf("Expected success, but failed with: %s", err)
 	}
 }
@@ -47,7 +52,7 @@ func TestSubscriptionMutation(t *testing.T) {
 func TestSubscriptionChannelMutation(t *testing.T) {
 	old := createSubscription(testSubscriptionName, "hello")
 	new := createSubscription(testSubscriptionName, "goodbye")
-	err := ValidateSubscription(testCtx)(nil, &old, &new)
+	err := ValidateSubscription(testCtx, nil)(nil, &old, &new)
 	if err == nil {
 		t.Errorf("Expected failure, but succeeded with: %+v %+v", old, new)
 	}
@@ -55,3 +60,101 @@ func TestSubscriptionChannelMutation(t *testing.T) {
 		t.Errorf("Expected %s got %s", e, a)
 	}
 }
+
+func TestSubscriptionSubscriberRef(t *testing.T) {
+	s := createSubscription(testSubscriptionName, testChannelName)
+	s.Spec.Subscriber = ""
+	s.Spec.SubscriberRef = &corev1.ObjectReference{Kind: "Channel", Name: "downstream"}
+	if err := ValidateSubscription(testCtx, nil)(nil, nil, &s); err != nil {
+		t.Errorf("Expected success, but failed with: %s", err)
+	}
+	s.Spec.SubscriberRef.Namespace = testNamespace
+	if err := ValidateSubscription(testCtx, nil)(nil, nil, &s); err != nil {
+		t.Errorf("Expected success, but failed with: %s", err)
+	}
+}
+
+func TestSubscriptionInvalidSubscriberRef(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		subscriber string
+		ref        *corev1.ObjectReference
+		err        error
+	}{
+		{
+			name:       "subscriber and subscriberRef",
+			subscriber: "subscriber",
+			ref:        &corev1.ObjectReference{Kind: "Channel", Name: "downstream"},
+			err:        errInvalidSubscriberExclusivity,
+		},
+		{
+			name: "neither subscriber nor subscriberRef",
+			err:  errInvalidSubscriberMissing,
+		},
+		{
+			name: "unsupported kind",
+			ref:  &corev1.ObjectReference{Kind: "Service", Name: "downstream"},
+			err:  errInvalidSubscriberRefKind,
+		},
+		{
+			name: "missing name",
+			ref:  &corev1.ObjectReference{Kind: "Channel"},
+			err:  errInvalidSubscriberRefNameMissing,
+		},
+		{
+			name: "other namespace",
+			ref:  &corev1.ObjectReference{Kind: "Channel", Namespace: "other", Name: "downstream"},
+			err:  errInvalidSubscriberRefNamespace,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := createSubscription(testSubscriptionName, testChannelName)
+			s.Spec.Subscriber = test.subscriber
+			s.Spec.SubscriberRef = test.ref
+			err := ValidateSubscription(testCtx, nil)(nil, nil, &s)
+			if e, a := test.err, err; e != a {
+				t.Errorf("Expected %s got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestSubscriptionDeliveryDelay(t *testing.T) {
+	s := createSubscription(testSubscriptionName, testChannelName)
+	s.Spec.DeliveryDelay = &metav1.Duration{Duration: 5 * time.Minute}
+	err := ValidateSubscription(testCtx, nil)(nil, nil, &s)
+	if err != nil {
+		t.Fatalf("Expected success, but failed with: %s", err)
+	}
+}
+
+func TestSubscriptionNegativeDeliveryDelay(t *testing.T) {
+	s := createSubscription(testSubscriptionName, testChannelName)
+	s.Spec.DeliveryDelay = &metav1.Duration{Duration: -time.Second}
+	err := ValidateSubscription(testCtx, nil)(nil, nil, &s)
+	if e, a := errInvalidSubscriptionDeliveryDelay, err; e != a {
+		t.Errorf("Expected %s got %s", e, a)
+	}
+}
+
+func TestSubscriptionBatching(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		batching *v1alpha1.SubscriptionBatching
+		err      error
+	}{
+		{"defaults", &v1alpha1.SubscriptionBatching{}, nil},
+		{"limits", &v1alpha1.SubscriptionBatching{MaxEvents: 10, MaxDelay: &metav1.Duration{Duration: time.Second}}, nil},
+		{"negative max events", &v1alpha1.SubscriptionBatching{MaxEvents: -1}, errInvalidSubscriptionBatching},
+		{"negative max delay", &v1alpha1.SubscriptionBatching{MaxDelay: &metav1.Duration{Duration: -time.Second}}, errInvalidSubscriptionBatching},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := createSubscription(testSubscriptionName, testChannelName)
+			s.Spec.Batching = test.batching
+			err := ValidateSubscription(testCtx, nil)(nil, nil, &s)
+			if e, a := test.err, err; e != a {
+				t.Errorf("Expected %v got %v", e, a)
+			}
+		})
+	}
+}
@@ -33,6 +33,7 @@ import (
 
 	"github.com/knative/eventing/pkg/apis/channels"
 	"github.com/knative/eventing/pkg/apis/channels/v1alpha1"
+	clientset "github.com/knative/eventing/pkg/client/clientset/versioned"
 
 	"github.com/mattbaird/jsonpatch"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
@@ -196,12 +197,18 @@ func getOrGenerateKeyCertsFromSecret(ctx context.Context, client kubernetes.Inte
 }
 
 // NewAdmissionController creates a new instance of the admission webhook controller.
-func NewAdmissionController(client kubernetes.Interface, options ControllerOptions) (*AdmissionController, error) {
+// The channels client is used to look up the Parameters of buses when
+// validating Arguments.
+func NewAdmissionController(client kubernetes.Interface, channelsClient clientset.Interface, options ControllerOptions) (*AdmissionController, error) {
 	ctx := context.TODO()
 	return &AdmissionController{
 		client:  client,
 		options: options,
 		handlers: map[string]GenericCRDHandler{
+			"Bridge": {
+				Factory:   &v1alpha1.Bridge{},
+				Validator: ValidateBridge(ctx),
+			},
 			"Bus": {
 				Factory:   &v1alpha1.Bus{},
 				Validator: ValidateBus(ctx),
@@ -212,11 +219,11 @@ func NewAdmissionController(client kubernetes.Interface, options ControllerOptio
 			},
 			"Channel": {
 				Factory:   &v1alpha1.Channel{},
-				Validator: ValidateChannel(ctx),
+				Validator: ValidateChannel(ctx, channelsClient),
 			},
 			"Subscription": {
 				Factory:   &v1alpha1.Subscription{},
-				Validator: ValidateSubscription(ctx),
+				Validator: ValidateSubscription(ctx, channelsClient),
 			},
 		},
 	}, nil
@@ -298,7 +305,7 @@ func (ac *AdmissionController) unregister(
 
 func (ac *AdmissionController) register(
 	ctx context.Context, client clientadmissionregistrationv1beta1.MutatingWebhookConfigurationInterface, caCert []byte) error { // nolint: lll
-	resources := []string{"buses", "clusterbuses", "channels", "subscriptions"}
+	resources := []string{"bridges", "buses", "clusterbuses", "channels", "subscriptions"}
 
 	webhook := &admissionregistrationv1beta1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
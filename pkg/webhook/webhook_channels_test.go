@@ -32,6 +32,7 @@ const (
 	testClusterBusName   = "test-clusterbus"
 	testChannelName      = "test-channel"
 	testSubscriptionName = "test-subscription"
+	testSubscriberName   = "test-subscriber"
 )
 
 func TestValidBusParameterNamePasses(t *testing.T) {
@@ -340,7 +341,8 @@ func createSubscription(subscriptionName string, channelName string) v1alpha1.Su
 			Name:      subscriptionName,
 		},
 		Spec: v1alpha1.SubscriptionSpec{
-			Channel: channelName,
+			Channel:    channelName,
+			Subscriber: testSubscriberName,
 		},
 	}
 }
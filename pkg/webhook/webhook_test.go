@@ -23,6 +23,7 @@ import (
 	"strings"
 	"testing"
 
+	fakeclientset "github.com/knative/eventing/pkg/client/clientset/versioned/fake"
 	"github.com/knative/eventing/pkg/system"
 
 	"github.com/mattbaird/jsonpatch"
@@ -58,7 +59,7 @@ func newRunningTestAdmissionController(t *testing.T, options ControllerOptions)
 	// Create fake clients
 	kubeClient = fakekubeclientset.NewSimpleClientset()
 
-	ac, err := NewAdmissionController(kubeClient, options)
+	ac, err := NewAdmissionController(kubeClient, fakeclientset.NewSimpleClientset(), options)
 	if err != nil {
 		t.Fatalf("Failed to create new admission controller: %s", err)
 	}
@@ -78,7 +79,7 @@ func newNonRunningTestAdmissionController(t *testing.T, options ControllerOption
 	// Create fake clients
 	kubeClient = fakekubeclientset.NewSimpleClientset()
 
-	ac, err := NewAdmissionController(kubeClient, options)
+	ac, err := NewAdmissionController(kubeClient, fakeclientset.NewSimpleClientset(), options)
 	if err != nil {
 		t.Fatalf("Failed to create new admission controller: %s", err)
 	}